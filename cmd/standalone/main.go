@@ -0,0 +1,102 @@
+// Standalone server mounting all functions on a single port, for self-hosting without OpenFaaS.
+//
+// The functions are configured with the same environment variables as their OpenFaaS counterparts.
+// The server itself is configured with the following flags, each of them defaulting to an environment variable :
+//
+//	-addr              (LISTEN_ADDR)        Address to listen on. Default ":8080"
+//	-read-timeout      (READ_TIMEOUT)       Max duration to read a request. Default "10s"
+//	-write-timeout     (WRITE_TIMEOUT)      Max duration to write a response, scrapping included, streams excepted. Default "2m"
+//	-idle-timeout      (IDLE_TIMEOUT)       Max duration of an idle keep-alive connection. Default "1m"
+//	-shutdown-timeout  (SHUTDOWN_TIMEOUT)   Max duration to wait for in-flight requests on shutdown. Default "30s"
+//	-watch-interval    (WATCH_INTERVAL)     Interval between checks of the watched campaigns. Default "0s", disabled
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	config, err := parseConfig(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Invalid configuration : %s\n", err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err = run(ctx, config); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// Serve until the context is done, then let in-flight requests end
+func run(ctx context.Context, config *Config) error {
+	server := newServer(config)
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on %s\n", config.Addr)
+		serverErr <- server.ListenAndServe()
+	}()
+	if config.WatchInterval > 0 {
+		go watch(ctx, config.WatchInterval)
+	}
+
+	select {
+	case err := <-serverErr:
+		return err
+	case <-ctx.Done():
+	}
+	log.Println("Shutting down, waiting for in-flight requests")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("Graceful shutdown failed : %s", err)
+	}
+	if err := <-serverErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Read the configuration from the command line, falling back on env variables then on defaults
+func parseConfig(args []string) (*Config, error) {
+	config := &Config{}
+	flags := flag.NewFlagSet("standalone", flag.ContinueOnError)
+	flags.StringVar(&config.Addr, "addr", envOr("LISTEN_ADDR", ":8080"), "Address to listen on")
+	durations := []struct {
+		target              *time.Duration
+		name, env, fallback string
+		usage               string
+	}{
+		{&config.ReadTimeout, "read-timeout", "READ_TIMEOUT", "10s", "Max duration to read a request"},
+		{&config.WriteTimeout, "write-timeout", "WRITE_TIMEOUT", "2m", "Max duration to write a response, scrapping included, streams excepted"},
+		{&config.IdleTimeout, "idle-timeout", "IDLE_TIMEOUT", "1m", "Max duration of an idle keep-alive connection"},
+		{&config.ShutdownTimeout, "shutdown-timeout", "SHUTDOWN_TIMEOUT", "30s", "Max duration to wait for in-flight requests on shutdown"},
+		{&config.WatchInterval, "watch-interval", "WATCH_INTERVAL", "0s", "Interval between checks of the watched campaigns, 0 to disable them"},
+	}
+	for _, d := range durations {
+		value, err := time.ParseDuration(envOr(d.env, d.fallback))
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a valid duration : %s", d.env, err)
+		}
+		flags.DurationVar(d.target, d.name, value, d.usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func envOr(key string, fallback string) string {
+	if value, isSet := os.LookupEnv(key); isSet {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseConfigDefaults(t *testing.T) {
+	for _, key := range []string{"LISTEN_ADDR", "READ_TIMEOUT", "WRITE_TIMEOUT", "IDLE_TIMEOUT", "SHUTDOWN_TIMEOUT", "WATCH_INTERVAL"} {
+		os.Unsetenv(key)
+	}
+	config, err := parseConfig([]string{})
+	assert.Nil(t, err)
+	assert.Equal(t, &Config{
+		Addr:            ":8080",
+		ReadTimeout:     10 * time.Second,
+		WriteTimeout:    2 * time.Minute,
+		IdleTimeout:     time.Minute,
+		ShutdownTimeout: 30 * time.Second,
+	}, config)
+}
+
+// Flags take precedence over env variables
+func TestParseConfigOverrides(t *testing.T) {
+	os.Setenv("LISTEN_ADDR", ":9000")
+	os.Setenv("WRITE_TIMEOUT", "5m")
+	os.Setenv("SHUTDOWN_TIMEOUT", "1m")
+	defer os.Unsetenv("LISTEN_ADDR")
+	defer os.Unsetenv("WRITE_TIMEOUT")
+	defer os.Unsetenv("SHUTDOWN_TIMEOUT")
+	config, err := parseConfig([]string{"-addr", "127.0.0.1:9001", "-read-timeout", "3s", "-shutdown-timeout", "5s", "-watch-interval", "5m"})
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:9001", config.Addr)
+	assert.Equal(t, 3*time.Second, config.ReadTimeout)
+	assert.Equal(t, 5*time.Minute, config.WriteTimeout)
+	assert.Equal(t, 5*time.Second, config.ShutdownTimeout)
+	assert.Equal(t, 5*time.Minute, config.WatchInterval)
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	os.Setenv("READ_TIMEOUT", "forever")
+	_, err := parseConfig([]string{})
+	assert.Error(t, err)
+	os.Unsetenv("READ_TIMEOUT")
+
+	_, err = parseConfig([]string{"-write-timeout", "forever"})
+	assert.Error(t, err)
+	_, err = parseConfig([]string{"-unknown"})
+	assert.Error(t, err)
+}
+
+// The server stops once the context is done
+func TestRunGracefulShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- run(ctx, &Config{Addr: "127.0.0.1:0", ShutdownTimeout: time.Second})
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("The server didn't shut down")
+	}
+}
+
+func TestRunInvalidAddr(t *testing.T) {
+	err := run(context.Background(), &Config{Addr: "invalid:addr:1", ShutdownTimeout: time.Second})
+	assert.Error(t, err)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"handler/function/pkg/metrics"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	get_campaign "roll20-scrapper/get-campaign"
+	get_characters "roll20-scrapper/get-characters"
+	get_messages "roll20-scrapper/get-messages"
+	get_players "roll20-scrapper/get-players"
+	get_roster_history "roll20-scrapper/get-roster-history"
+	get_summary "roll20-scrapper/get-summary"
+	healthz "roll20-scrapper/healthz"
+	job_result "roll20-scrapper/job-result"
+	job_status "roll20-scrapper/job-status"
+	join_game "roll20-scrapper/join-game"
+	leave_game "roll20-scrapper/leave-game"
+	list_campaigns "roll20-scrapper/list-campaigns"
+	readyz "roll20-scrapper/readyz"
+	start_scrape "roll20-scrapper/start-scrape"
+	tail_messages "roll20-scrapper/tail-messages"
+	watch_messages "roll20-scrapper/watch-messages"
+	"strconv"
+	"time"
+)
+
+// Same signature as the Handle function of every OpenFaaS function
+type handleFunc func(req handler2.Request) (handler2.Response, error)
+
+// A function mounted on the server
+type route struct {
+	path   string
+	handle handleFunc
+}
+
+// All functions, each one mounted on its OpenFaaS name as it would be behind the gateway
+var routes = []route{
+	{"/get-campaign", get_campaign.Handle},
+	{"/get-characters", get_characters.Handle},
+	{"/get-messages", get_messages.Handle},
+	{"/get-players", get_players.Handle},
+	{"/get-roster-history", get_roster_history.Handle},
+	{"/get-summary", get_summary.Handle},
+	{"/healthz", healthz.Handle},
+	{"/job-result", job_result.Handle},
+	{"/job-status", job_status.Handle},
+	{"/join-game", join_game.Handle},
+	{"/leave-game", leave_game.Handle},
+	{"/list-campaigns", list_campaigns.Handle},
+	{"/readyz", readyz.Handle},
+	{"/start-scrape", start_scrape.Handle},
+	{"/tail-messages", tail_messages.Handle},
+	{"/watch-messages", watch_messages.Handle},
+}
+
+// Functions able to stream their response, which the gateway can't do. Mounted instead of their Handle
+var streams = map[string]http.HandlerFunc{
+	"/tail-messages": tail_messages.Stream,
+}
+
+// Duration of the function calls. Streams are left out, as they last as long as their client
+var handlerLatency = metrics.Default.NewHistogramVec("http_request_duration_seconds", "Duration of the function calls, by handler and status code", metrics.DEFAULT_BUCKETS, "handler", "code")
+
+// Max size of a request body. No function is expecting one anyway
+const maxBodySize = 1 << 20
+
+// Config Runtime configuration of the standalone server
+type Config struct {
+	// Address to listen on. Ex ":8080"
+	Addr string
+	// Max duration to read a whole request
+	ReadTimeout time.Duration
+	// Max duration to write a whole response, scrapping included. Streams are exempted
+	WriteTimeout time.Duration
+	// Max duration an idle keep-alive connection is kept open
+	IdleTimeout time.Duration
+	// Max duration to wait for in-flight requests on shutdown
+	ShutdownTimeout time.Duration
+	// Interval between checks of the watched campaigns. 0 disables them
+	WatchInterval time.Duration
+}
+
+// Build the HTTP server mounting all functions
+func newServer(config *Config) *http.Server {
+	mux := http.NewServeMux()
+	for _, r := range routes {
+		if stream, ok := streams[r.path]; ok {
+			mux.Handle(r.path, stream)
+			continue
+		}
+		mux.Handle(r.path, instrument(r.path, adapt(r.handle)))
+	}
+	// Only served here, an OpenFaaS function being a process of its own
+	mux.Handle("/metrics", metrics.Handler(metrics.Default))
+	// Streams only end when their client leaves, so shutting down cancels the context of every request
+	requestsCtx, cancel := context.WithCancel(context.Background())
+	server := &http.Server{
+		Addr:        config.Addr,
+		Handler:     withWriteTimeout(config.WriteTimeout, mux),
+		ReadTimeout: config.ReadTimeout,
+		// WriteTimeout would cut streams, the deadline is set by withWriteTimeout instead
+		IdleTimeout: config.IdleTimeout,
+		BaseContext: func(net.Listener) context.Context { return requestsCtx },
+		ConnContext: withConn,
+	}
+	server.RegisterOnShutdown(cancel)
+	return server
+}
+
+// Key of the connection of a request in its context
+type connKey struct{}
+
+// Keep c in the context of its requests
+func withConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// Bound the time to write a response to timeout, as the WriteTimeout of the server would, streams excepted.
+// Go 1.18 has no http.ResponseController to lift the deadline from a stream, so it is set on the connection of each
+// request instead. It is set for streams too, to clear the one of a previous request on the same connection
+func withWriteTimeout(timeout time.Duration, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var deadline time.Time
+		if _, isStream := streams[r.URL.Path]; !isStream && timeout > 0 {
+			deadline = time.Now().Add(timeout)
+		}
+		if conn, ok := r.Context().Value(connKey{}).(net.Conn); ok {
+			conn.SetWriteDeadline(deadline)
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// Turn an OpenFaaS function into a net/http handler, the same way the golang-http template does
+func adapt(handle handleFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body []byte
+		if r.Body != nil {
+			defer r.Body.Close()
+			var err error
+			body, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+			if err != nil {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+		}
+		req := handler2.Request{
+			Body:        body,
+			Header:      r.Header,
+			QueryString: r.URL.RawQuery,
+			Method:      r.Method,
+			Host:        r.Host,
+		}
+		req.WithContext(r.Context())
+
+		res, err := handle(req)
+		if err != nil {
+			log.Printf("%s %s : %s\n", r.Method, r.URL.Path, err)
+		}
+		for key, values := range res.Header {
+			w.Header()[key] = values
+		}
+		statusCode := res.StatusCode
+		if statusCode == 0 {
+			statusCode = http.StatusOK
+		}
+		w.WriteHeader(statusCode)
+		if len(res.Body) > 0 {
+			w.Write(res.Body)
+		}
+	}
+}
+
+// Keeps the status code written by the handler
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (r *statusRecorder) WriteHeader(statusCode int) {
+	r.statusCode = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Time every call to the handler mounted on path
+func instrument(path string, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		handlerLatency.Observe(time.Since(start).Seconds(), path, strconv.Itoa(recorder.statusCode))
+	}
+}
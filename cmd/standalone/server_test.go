@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"github.com/stretchr/testify/assert"
+	"handler/function/pkg/scrapper"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Mock Roll20, answering with a sample campaign page
+func SetupRoll20Server(campaignDataPath string) *httptest.Server {
+	_, filename, _, _ := runtime.Caller(0)
+	dir := path.Dir(filename)
+	sampleData, _ := ioutil.ReadFile(path.Join(dir, "../..", campaignDataPath))
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/campaigns/details/") {
+			w.Write(sampleData)
+		} else {
+			w.WriteHeader(200)
+		}
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	return mockServer
+}
+
+func SetupStandaloneServer() *httptest.Server {
+	return httptest.NewServer(newServer(&Config{Addr: ":0"}).Handler)
+}
+
+// Every function is reachable on its own path
+func TestRoutes(t *testing.T) {
+	roll20 := SetupRoll20Server("assets/sample_campaign_page.html")
+	server := SetupStandaloneServer()
+
+	res, err := http.Get(server.URL + "/get-summary?gameId=1")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "application/json", res.Header.Get("Content-type"))
+	var summary scrapper.Summary
+	err = json.NewDecoder(res.Body).Decode(&summary)
+	assert.Nil(t, err)
+	res.Body.Close()
+
+	res, err = http.Get(server.URL + "/get-players?link=https://app.roll20.net/campaigns/details/1/my-campaign")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	res.Body.Close()
+
+	// Validation errors are forwarded as is
+	res, err = http.Get(server.URL + "/join-game?gameId=1")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	res.Body.Close()
+
+	// Probes aren't authenticated, and reach the process itself
+	res, err = http.Get(server.URL + "/healthz")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	res.Body.Close()
+
+	for _, r := range routes {
+		res, err = http.Get(server.URL + r.path + "?gameId=sss")
+		assert.Nil(t, err)
+		assert.NotEqual(t, http.StatusNotFound, res.StatusCode, r.path)
+		res.Body.Close()
+	}
+
+	server.Close()
+	roll20.Close()
+}
+
+func TestUnknownRoute(t *testing.T) {
+	server := SetupStandaloneServer()
+	res, err := http.Get(server.URL + "/get-nothing")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	res.Body.Close()
+	server.Close()
+}
+
+// The net/http request is converted just as the OpenFaaS template would
+func TestAdapt(t *testing.T) {
+	var received handler2.Request
+	handler := adapt(func(req handler2.Request) (handler2.Response, error) {
+		received = req
+		return handler2.Response{
+			StatusCode: http.StatusCreated,
+			Body:       []byte("created"),
+			Header:     map[string][]string{"X-Test": {"ok"}},
+		}, nil
+	})
+	req := httptest.NewRequest("POST", "http://roll20.local/join-game?gameId=1&gameCode=a", strings.NewReader("body"))
+	req.Header.Set("X-Callback-Url", "http://callback")
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, "POST", received.Method)
+	assert.Equal(t, "gameId=1&gameCode=a", received.QueryString)
+	assert.Equal(t, "roll20.local", received.Host)
+	assert.Equal(t, "body", string(received.Body))
+	assert.Equal(t, "http://callback", received.Header.Get("X-Callback-Url"))
+	assert.NotNil(t, received.Context())
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "ok", w.Header().Get("X-Test"))
+	assert.Equal(t, "created", w.Body.String())
+}
+
+// As with OpenFaaS, no status code means 200, and errors don't prevent the response from being sent
+func TestAdaptDefaults(t *testing.T) {
+	handler := adapt(func(req handler2.Request) (handler2.Response, error) {
+		return handler2.Response{Body: []byte("{}")}, nil
+	})
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/get-summary", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	handler = adapt(func(req handler2.Request) (handler2.Response, error) {
+		return handler2.Response{StatusCode: http.StatusInternalServerError, Body: []byte("{}")}, os.ErrNotExist
+	})
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/get-summary", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "{}", w.Body.String())
+}
+
+func TestAdaptBodyTooLarge(t *testing.T) {
+	called := false
+	handler := adapt(func(req handler2.Request) (handler2.Response, error) {
+		called = true
+		return handler2.Response{}, nil
+	})
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/get-summary", strings.NewReader(strings.Repeat("a", maxBodySize+1))))
+	assert.False(t, called)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+// Open streams are ended by a shutdown, instead of delaying it until the timeout
+func TestShutdownEndsStreams(t *testing.T) {
+	_, filename, _, _ := runtime.Caller(0)
+	archive, _ := ioutil.ReadFile(path.Join(path.Dir(filename), "../../assets/sample_campaign_chat_archive.html"))
+	roll20 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/campaigns/chatarchive/") {
+			w.Write(archive)
+		}
+	}))
+	defer roll20.Close()
+	t.Setenv("ROLL20_BASE_URL", roll20.URL)
+	t.Setenv("ROLL20_USERNAME", "mock")
+	t.Setenv("ROLL20_PASSWORD", "mock")
+	t.Setenv("TAIL_POLL_INTERVAL", "10ms")
+	server := httptest.NewUnstartedServer(nil)
+	server.Config = newServer(&Config{Addr: ":0"})
+	server.Start()
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/tail-messages?gameId=1")
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+	line, err := bufio.NewReader(res.Body).ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "retry: 10\n", line)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Nil(t, server.Config.Shutdown(ctx))
+}
+
+// Streams stay open past the write timeout, even on a connection reused from a response having a deadline
+func TestStreamsOutliveWriteTimeout(t *testing.T) {
+	_, filename, _, _ := runtime.Caller(0)
+	archive, _ := ioutil.ReadFile(path.Join(path.Dir(filename), "../../assets/sample_campaign_chat_archive.html"))
+	roll20 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/campaigns/chatarchive/") {
+			w.Write(archive)
+		}
+	}))
+	defer roll20.Close()
+	t.Setenv("ROLL20_BASE_URL", roll20.URL)
+	t.Setenv("ROLL20_USERNAME", "mock")
+	t.Setenv("ROLL20_PASSWORD", "mock")
+	t.Setenv("TAIL_POLL_INTERVAL", "20ms")
+	const writeTimeout = 100 * time.Millisecond
+	server := httptest.NewUnstartedServer(nil)
+	server.Config = newServer(&Config{Addr: ":0", WriteTimeout: writeTimeout})
+	server.Start()
+	defer server.Close()
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 1}}
+
+	// The connection is kept alive, with the deadline of this response
+	res, err := client.Get(server.URL + "/metrics")
+	assert.Nil(t, err)
+	ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	time.Sleep(2 * writeTimeout)
+
+	res, err = client.Get(server.URL + "/tail-messages?gameId=1")
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	reader := bufio.NewReader(res.Body)
+	opened := time.Now()
+	for time.Since(opened) < 5*writeTimeout {
+		_, err = reader.ReadString('\n')
+		if !assert.Nil(t, err) {
+			break
+		}
+	}
+}
+
+// Other responses are still bounded by the write timeout
+func TestWriteTimeout(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("too late"))
+	})
+	server := httptest.NewUnstartedServer(nil)
+	server.Config = &http.Server{Handler: withWriteTimeout(50*time.Millisecond, slow), ConnContext: withConn}
+	server.Start()
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/get-summary")
+	if err == nil {
+		_, err = ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+	assert.Error(t, err)
+}
+
+// The calls to the functions and to Roll20 are exposed to Prometheus
+func TestMetrics(t *testing.T) {
+	roll20 := SetupRoll20Server("assets/sample_campaign_page.html")
+	defer roll20.Close()
+	server := SetupStandaloneServer()
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/get-summary?gameId=1")
+	assert.Nil(t, err)
+	res.Body.Close()
+
+	res, err = http.Get(server.URL + "/metrics")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, res.Header.Get("Content-Type"), "text/plain; version=0.0.4")
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Contains(t, string(body), `http_request_duration_seconds_bucket{handler="/get-summary",code="200",le="+Inf"}`)
+	assert.Contains(t, string(body), `roll20_requests_total{route="/campaigns/details/:id",status="200"}`)
+	assert.Contains(t, string(body), `roll20_logins_total{outcome="success"}`)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	config_parser "handler/function/pkg/config-parser"
+	"handler/function/pkg/scrapper"
+	"handler/function/pkg/watcher"
+	"log"
+	"time"
+)
+
+// Check the watched campaigns every interval, until the context is done.
+// Checks don't overlap, a slow one delays the next
+func watch(ctx context.Context, interval time.Duration) {
+	log.Printf("Checking the watched campaigns every %s\n", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkWatched()
+		}
+	}
+}
+
+// Deliver the new messages of the watched campaigns, as the watch-messages function does
+func checkWatched() {
+	values, err := config_parser.ParseEnv([]string{"ROLL20_USERNAME", "ROLL20_PASSWORD", "ROLL20_BASE_URL"})
+	if err != nil {
+		log.Printf("Watch skipped, invalid env : %s\n", err)
+		return
+	}
+	w, err := watcher.FromEnv()
+	if err != nil {
+		log.Printf("Watch skipped, invalid watcher configuration : %s\n", err)
+		return
+	}
+	if len(w.Campaigns()) == 0 {
+		return
+	}
+	s, err := scrapper.NewScrapper(values["ROLL20_BASE_URL"], &scrapper.Roll20Account{Login: values["ROLL20_USERNAME"], Password: values["ROLL20_PASSWORD"]}, nil)
+	if err != nil {
+		log.Printf("Watch skipped, the bot account couldn't log in to Roll20 : %s\n", err)
+		return
+	}
+	for _, outcome := range w.Check(s) {
+		if len(outcome.Error) != 0 {
+			log.Printf("Campaign %s couldn't be checked : %s\n", outcome.GameId, outcome.Error)
+		}
+	}
+}
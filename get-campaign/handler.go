@@ -0,0 +1,141 @@
+package function
+
+import (
+	"fmt"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"handler/function/pkg/auth"
+	"handler/function/pkg/callback"
+	config_parser "handler/function/pkg/config-parser"
+	http_helpers "handler/function/pkg/http-helpers"
+	link_parser "handler/function/pkg/link-parser"
+	"handler/function/pkg/scrapper"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Query parameters of get-campaign
+type campaignQuery struct {
+	link_parser.GameQuery
+	http_helpers.FormatQuery
+	Messages uint `qs:"messages" default:"0"`
+}
+
+// Path of the function, used as the instance of its errors
+const FUNCTION_PATH = "/get-campaign"
+
+// swagger:route GET /get-campaign Summary get-campaign
+//
+// Retrieve the summary, players and GMs of a roll20 campaign in a single call
+//
+// The campaign details page is only fetched once. The latest messages of the chat can optionally be included
+//     Produces:
+//     - application/json
+//     - application/x-ndjson
+//     - text/csv
+//     - application/yaml
+//     - application/problem+json
+//     Parameters:
+//       + name: gameId
+//         in: query
+//         description: Roll20 ID of the game to parse. For link https://app.roll20.net/join/1/59lzQg --> Game ID is "1"
+//         required: false
+//         type: integer
+//         format: int32
+//       + name: link
+//         in: query
+//         description: Roll20 link of the game, accepted instead of gameId. Either a join link (https://app.roll20.net/join/1/59lzQg), a campaign details or editor URL, or a bare game ID
+//         required: false
+//         type: string
+//       + name: messages
+//         in: query
+//         description: Number of latest messages to include, oldest first. Default is 0, no messages
+//         required: false
+//         type: integer
+//         format: uint
+//       + name: format
+//         in: query
+//         description: Format of the response, overriding the Accept header. One of "json", "ndjson", "csv" or "yaml". Default is "json"
+//         required: false
+//         type: string
+//       + name: If-None-Match
+//         in: header
+//         description: ETag of a previous response. If the response would be the same, a 304 without body is answered instead
+//         required: false
+//         type: string
+//       + name: X-Callback-Url
+//         in: header
+//         description: Set by OpenFaaS on async invocations. The response is also POSTed to this URL once the function is done, signed with the callback-key secret. Only accepted from authenticated callers when that secret exists, and never used for a 401 or 403
+//         required: false
+//         type: string
+// responses:
+//  200: Campaign Complete campaign
+//  207: Campaign Campaign with an incomplete list of players
+//  304: description: The caller already holds the current representation
+//	400: ErrorTemplate Missing or invalid QS or X-Callback-Url provided, or callbacks refused
+//  401: ErrorTemplate Authentication is enabled, and the caller didn't provide valid credentials
+//  403: ErrorTemplate The caller isn't allowed to read the players of this game, or its messages
+//  406: ErrorTemplate None of the accepted media types is supported
+//  500: ErrorTemplate Configuration error, either env variables missing or provided roll20 credentials invalid
+func Handle(req handler2.Request) (handler2.Response, error) {
+	// Async invocations also get the response POSTed to their callback URL
+	return callback.Respond(req, FUNCTION_PATH, handle)
+}
+
+// Answer req, made by identity, whether the invocation is async or not
+func handle(req handler2.Request, identity *auth.Identity) (handler2.Response, error) {
+	log.Println("Get campaign handler has been woken up")
+	// Retrieve runtime values from env & QS
+	var keys = []string{"ROLL20_USERNAME", "ROLL20_PASSWORD", "ROLL20_BASE_URL"}
+	values, err := config_parser.ParseEnv(keys)
+	if err != nil {
+		log.Printf("Invalid env : %s\n", err)
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.MissingConfiguration, "Some environment variables are missing"), err
+	}
+	var query campaignQuery
+	if err = http_helpers.BindQuery(req.QueryString, &query); err != nil {
+		log.Printf("Invalid QS : %s\n", err)
+		return http_helpers.NewBindingProblem(req, FUNCTION_PATH, err), err
+	}
+	format, ok := http_helpers.NegotiateFormat(req, query.Format)
+	if !ok {
+		return http_helpers.NewNotAcceptableProblem(req, FUNCTION_PATH), nil
+	}
+	gameId := query.Game().GameId
+	if err = auth.Authorize(identity, auth.Players, gameId); err != nil {
+		log.Printf("Forbidden call : %s\n", err)
+		return auth.NewAuthProblem(req, FUNCTION_PATH, err), err
+	}
+	// The latest messages are only included on demand
+	if query.Messages > 0 {
+		if err = auth.Authorize(identity, auth.Messages, gameId); err != nil {
+			log.Printf("Forbidden call : %s\n", err)
+			return auth.NewAuthProblem(req, FUNCTION_PATH, err), err
+		}
+	}
+
+	// Number of messages to include, default is none
+	messagesLimit := query.Messages
+	log.Println("Now fetching campaign " + gameId)
+
+	// Scrap the whole campaign
+	s, err := scrapper.NewScrapper(values["ROLL20_BASE_URL"], &scrapper.Roll20Account{Login: values["ROLL20_USERNAME"], Password: values["ROLL20_PASSWORD"]}, nil)
+	if err != nil {
+		log.Printf("The scrapper instance couldn't be initialized. Error %s\n", err)
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.Roll20LoginFailed, "The bot account couldn't log in to Roll20"), err
+	}
+	statusCode := http.StatusOK
+	campaign, err := s.GetCampaign(gameId, messagesLimit, nil)
+	if err != nil {
+		// If the scrapper did not succeed with all the players, indicate it
+		re, ok := err.(*scrapper.IncompleteError)
+		if !ok {
+			log.Printf("Unexpected error : %s\n", err.Error())
+			return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.ScrappingFailed, fmt.Sprintf("Roll20 couldn't be scrapped for game %s", gameId)), err
+		}
+		log.Println(re.Error())
+		statusCode = http.StatusMultiStatus
+	}
+	log.Println("Campaign has been successfully scrapped " + gameId)
+	return http_helpers.NewConditionalResponse(req, statusCode, format, campaign, time.Time{})
+}
@@ -0,0 +1,325 @@
+package function
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"github.com/stretchr/testify/assert"
+	"handler/function/pkg/auth"
+	"handler/function/pkg/callback"
+	"handler/function/pkg/scrapper"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Read a sample file from the assets
+func readSample(samplePath string) []byte {
+	_, filename, _, _ := runtime.Caller(0)
+	dir := path.Dir(filename)
+	var sample *os.File
+	// Open provided path
+	sample, err := os.Open(path.Join(dir, samplePath))
+	// On CI, the path may be wrong because the import path is different
+	if err != nil {
+		sample, err = os.Open(path.Join(dir, "../", samplePath))
+	}
+	sampleData, _ := ioutil.ReadAll(sample)
+	return sampleData
+}
+
+func SetupTestServer(campaignDataPath string) *httptest.Server {
+	campaignData := readSample(campaignDataPath)
+	archiveData := readSample("assets/sample_campaign_chat_archive.html")
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/campaigns/details/") {
+			w.Write(campaignData)
+		} else if strings.Contains(r.URL.Path, "/campaigns/chatarchive/") {
+			w.Write(archiveData)
+		} else {
+			w.WriteHeader(200)
+		}
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	return mockServer
+
+}
+
+// Server only allowing the scrapper to log in
+func SetupLoginOnlyServer() *httptest.Server {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/campaigns/details/") {
+			w.WriteHeader(500)
+		} else {
+			w.WriteHeader(200)
+		}
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	return mockServer
+}
+
+//wrong user provider argument
+func TestWrongGameID(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_page.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=sss",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	mockServer.Close()
+
+}
+
+//wrong messages count
+func TestWrongMessagesCount(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_page.html")
+	var tests = []string{"gameId=1&messages=-1", "gameId=1&messages=ss", "gameId=1&messages="}
+	for _, qs := range tests {
+		req := handler2.Request{
+			Body:        nil,
+			Header:      nil,
+			QueryString: qs,
+			Method:      "GET",
+			Host:        "",
+		}
+		res, err := Handle(req)
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	}
+	mockServer.Close()
+
+}
+
+// Summary, players and GMs
+func TestCompleteAnswer(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_page.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var campaign scrapper.Campaign
+	err = json.Unmarshal(res.Body, &campaign)
+	assert.Nil(t, err)
+	assert.Equal(t, "Les Contes du Continent", campaign.Summary.Name)
+	assert.Len(t, campaign.Players, 7)
+	assert.Len(t, campaign.Gms, 1)
+	assert.Len(t, campaign.Messages, 0)
+	mockServer.Close()
+
+}
+
+// Latest messages included
+func TestWithMessages(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_page.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1&messages=5",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var campaign scrapper.Campaign
+	err = json.Unmarshal(res.Body, &campaign)
+	assert.Nil(t, err)
+	assert.Len(t, campaign.Messages, 5)
+	mockServer.Close()
+
+}
+
+// Not all players were parsed
+func TestIncompleteAnswer(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_missing_id.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusMultiStatus, res.StatusCode)
+	var campaign scrapper.Campaign
+	err = json.Unmarshal(res.Body, &campaign)
+	assert.Nil(t, err)
+	assert.Len(t, campaign.Players, 6)
+	mockServer.Close()
+
+}
+// The game can be designated by a link instead of its ID
+func TestGameLink(t *testing.T) {
+	var tests = []struct {
+		qs         string
+		statusCode int
+	}{
+		{"link=1", http.StatusOK},
+		{"link=https://app.roll20.net/join/1/59lzQg", http.StatusOK},
+		{"link=https://app.roll20.net/campaigns/details/1/my-campaign", http.StatusOK},
+		{"gameId=1&link=https://app.roll20.net/editor/setcampaign/1", http.StatusOK},
+		{"link=https://example.com/join/1/59lzQg", http.StatusBadRequest},
+		{"link=https://app.roll20.net/users/2", http.StatusBadRequest},
+		{"gameId=2&link=https://app.roll20.net/join/1/59lzQg", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.qs, func(t *testing.T) {
+			mockServer := SetupTestServer("assets/sample_campaign_page.html")
+			req := handler2.Request{
+				Body:        nil,
+				Header:      nil,
+				QueryString: tt.qs,
+				Method:      "GET",
+				Host:        "",
+			}
+			res, _ := Handle(req)
+			assert.Equal(t, tt.statusCode, res.StatusCode)
+			mockServer.Close()
+		})
+	}
+}
+
+
+// No env variables defined
+func TestNoEnv(t *testing.T) {
+	os.Unsetenv("ROLL20_BASE_URL")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, _ := Handle(req)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	fmt.Println(string(res.Body))
+
+}
+
+// QS is somehow wrong. Fuzz attack ?
+func TestInvalidQS(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_page.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "wrong=;;;",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, _ := Handle(req)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	fmt.Println(string(res.Body))
+	mockServer.Close()
+}
+
+// End gracefully when the scrapper itself fails
+func TestScrapperLoginError(t *testing.T) {
+	// env defined but wrong, the scrapper won't be able to login
+	os.Setenv("ROLL20_BASE_URL", "wrong")
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	fmt.Println(string(res.Body))
+
+}
+
+// End gracefully when the scrapper itself fails
+func TestScrappingError(t *testing.T) {
+	mockServer := SetupLoginOnlyServer()
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	fmt.Println(string(res.Body))
+	mockServer.Close()
+
+}
+
+// Async invocations get the campaign POSTed to their callback URL
+func TestCallback(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_page.html")
+	defer mockServer.Close()
+	dir := t.TempDir()
+	assert.Nil(t, ioutil.WriteFile(path.Join(dir, callback.CALLBACK_KEY_SECRET), []byte("s3cr3t"), 0o600))
+	assert.Nil(t, ioutil.WriteFile(path.Join(dir, auth.API_KEYS_SECRET), []byte("team-a:key-a"), 0o600))
+	t.Setenv("AUTH_SECRETS_DIR", dir)
+	var received *http.Request
+	var receivedBody []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		receivedBody, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer receiver.Close()
+
+	req := handler2.Request{
+		Body:        nil,
+		Header:      http.Header{callback.CallbackUrlHeader: {receiver.URL + "/done"}, "X-Call-Id": {"call-1"}, auth.ApiKeyHeader: {"key-a"}},
+		QueryString: "gameId=1&messages=5",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.NotNil(t, received)
+	assert.Equal(t, http.MethodPost, received.Method)
+	assert.Equal(t, res.Body, receivedBody)
+	assert.Equal(t, "call-1", received.Header.Get(callback.CorrelationIdHeader))
+	assert.Equal(t, "200", received.Header.Get(callback.FunctionStatusHeader))
+	signature := auth.Sign([]byte("s3cr3t"), http.MethodPost, "/done", "", received.Header.Get(auth.TimestampHeader), receivedBody)
+	assert.Equal(t, "sha256="+hex.EncodeToString(signature), received.Header.Get(auth.SignatureHeader))
+	var campaign scrapper.Campaign
+	assert.Nil(t, json.Unmarshal(receivedBody, &campaign))
+	assert.Len(t, campaign.Messages, 5)
+
+	// Errors are delivered as well
+	req.QueryString = "gameId=sss"
+	res, err = Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Equal(t, "400", received.Header.Get(callback.FunctionStatusHeader))
+	assert.Equal(t, res.Body, receivedBody)
+
+	req.Header.Set(callback.CallbackUrlHeader, "/relative")
+	res, err = Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
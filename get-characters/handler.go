@@ -0,0 +1,114 @@
+package function
+
+import (
+	"fmt"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"handler/function/pkg/auth"
+	config_parser "handler/function/pkg/config-parser"
+	http_helpers "handler/function/pkg/http-helpers"
+	link_parser "handler/function/pkg/link-parser"
+	"handler/function/pkg/scrapper"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Query parameters of get-characters
+type charactersQuery struct {
+	link_parser.GameQuery
+	http_helpers.FormatQuery
+}
+
+// Path of the function, used as the instance of its errors
+const FUNCTION_PATH = "/get-characters"
+
+// swagger:route GET /get-characters Players get-characters
+//
+// Retrieve all characters played in a specific roll20 game, grouped by player.
+//
+// Characters are deduced from the chat archive, so a character who never spoke won't be listed.
+// Whispers are only counted for callers allowed to read them
+//     Produces:
+//     - application/json
+//     - application/x-ndjson
+//     - text/csv
+//     - application/yaml
+//     - application/problem+json
+//     Parameters:
+//       + name: gameId
+//         in: query
+//         description: Roll20 ID of the game to parse. For link https://app.roll20.net/join/1/59lzQg --> Game ID is "1"
+//         required: false
+//         type: integer
+//         format: int32
+//       + name: link
+//         in: query
+//         description: Roll20 link of the game, accepted instead of gameId. Either a join link (https://app.roll20.net/join/1/59lzQg), a campaign details or editor URL, or a bare game ID
+//         required: false
+//         type: string
+//       + name: format
+//         in: query
+//         description: Format of the response, overriding the Accept header. One of "json", "ndjson", "csv" or "yaml". Default is "json"
+//         required: false
+//         type: string
+//       + name: If-None-Match
+//         in: header
+//         description: ETag of a previous response. If the response would be the same, a 304 without body is answered instead
+//         required: false
+//         type: string
+// responses:
+//  200: []PlayerCharacters Characters of each player of the requested game
+//  304: description: The caller already holds the current representation
+//	400: ErrorTemplate Missing or invalid game ID or link provided
+//  401: ErrorTemplate Authentication is enabled, and the caller didn't provide valid credentials
+//  403: ErrorTemplate The caller isn't allowed to read the messages of this game
+//  406: ErrorTemplate None of the accepted media types is supported
+//  500: ErrorTemplate Configuration error, either env variables missing or provided roll20 credentials invalid
+func Handle(req handler2.Request) (handler2.Response, error) {
+	log.Println("Get characters handler has been woken up")
+	var err error
+	// Callers are authenticated before anything else
+	identity, err := auth.Authenticate(req, FUNCTION_PATH)
+	if err != nil {
+		log.Printf("Unauthenticated call : %s\n", err)
+		return auth.NewAuthProblem(req, FUNCTION_PATH, err), err
+	}
+	// Retrieve runtime values from env & QS
+	var keys = []string{"ROLL20_USERNAME", "ROLL20_PASSWORD", "ROLL20_BASE_URL"}
+	values, err := config_parser.ParseEnv(keys)
+	if err != nil {
+		log.Printf("Invalid env : %s\n", err)
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.MissingConfiguration, "Some environment variables are missing"), err
+	}
+	var query charactersQuery
+	if err = http_helpers.BindQuery(req.QueryString, &query); err != nil {
+		log.Printf("Invalid QS : %s\n", err)
+		return http_helpers.NewBindingProblem(req, FUNCTION_PATH, err), err
+	}
+	format, ok := http_helpers.NegotiateFormat(req, query.Format)
+	if !ok {
+		return http_helpers.NewNotAcceptableProblem(req, FUNCTION_PATH), nil
+	}
+	gameId := query.Game().GameId
+	if err = auth.Authorize(identity, auth.Messages, gameId); err != nil {
+		log.Printf("Forbidden call : %s\n", err)
+		return auth.NewAuthProblem(req, FUNCTION_PATH, err), err
+	}
+	// Whispers give away who whispered and how often, they are left out for callers who can't read them
+	includeWhispers := auth.Authorize(identity, auth.Whispers, gameId) == nil
+	log.Println("Now fetching characters for campaign " + gameId)
+
+	// Scrap the characters from the game chat archive
+	s, err := scrapper.NewScrapper(values["ROLL20_BASE_URL"], &scrapper.Roll20Account{Login: values["ROLL20_USERNAME"], Password: values["ROLL20_PASSWORD"]}, nil)
+	if err != nil {
+		log.Printf("The scrapper instance couldn't be initialized. Error %s\n", err)
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.Roll20LoginFailed, "The bot account couldn't log in to Roll20"), err
+	}
+	characters, err := s.GetCharacters(gameId, includeWhispers)
+	if err != nil {
+		log.Printf("Unexpected error : %s\n", err.Error())
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.ScrappingFailed, fmt.Sprintf("Roll20 couldn't be scrapped for game %s", gameId)), err
+	}
+	log.Println("All characters have been successfully scrapped from campaign " + gameId)
+	return http_helpers.NewConditionalResponse(req, http.StatusOK, format, characters, time.Time{})
+}
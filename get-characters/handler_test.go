@@ -0,0 +1,263 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"github.com/stretchr/testify/assert"
+	"handler/function/pkg/scrapper"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func SetupTestServer(campaignDataPath string) *httptest.Server {
+	_, filename, _, _ := runtime.Caller(0)
+	dir := path.Dir(filename)
+	var sample *os.File
+	// Open provided path
+	sample, err := os.Open(path.Join(dir, campaignDataPath))
+	// On CI, the path may be wrong because the import path is different
+	if err != nil {
+		sample, err = os.Open(path.Join(dir, "../", campaignDataPath))
+	}
+	sampleData, _ := ioutil.ReadAll(sample)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/campaigns/chatarchive/") {
+			w.Write(sampleData)
+		} else {
+			w.WriteHeader(200)
+		}
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	return mockServer
+
+}
+
+// Server only allowing the scrapper to log in
+func SetupLoginOnlyServer() *httptest.Server {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/campaigns/chatarchive/") {
+			w.WriteHeader(500)
+		} else {
+			w.WriteHeader(200)
+		}
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	return mockServer
+}
+
+//wrong user provider argument
+func TestWrongGameID(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=sss",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	mockServer.Close()
+
+}
+
+//No user provided argument
+func TestMissingGameID(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "r=dd",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	mockServer.Close()
+
+}
+
+// All characters are grouped by player
+func TestCompleteAnswer(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var players []scrapper.PlayerCharacters
+	err = json.Unmarshal(res.Body, &players)
+	assert.Nil(t, err)
+	assert.Len(t, players, 4)
+	characterCount := 0
+	for _, p := range players {
+		characterCount += len(p.Characters)
+	}
+	assert.Equal(t, 8, characterCount)
+	mockServer.Close()
+
+}
+// The game can be designated by a link instead of its ID
+func TestGameLink(t *testing.T) {
+	var tests = []struct {
+		qs         string
+		statusCode int
+	}{
+		{"link=1", http.StatusOK},
+		{"link=https://app.roll20.net/join/1/59lzQg", http.StatusOK},
+		{"link=https://app.roll20.net/campaigns/details/1/my-campaign", http.StatusOK},
+		{"gameId=1&link=https://app.roll20.net/editor/setcampaign/1", http.StatusOK},
+		{"link=https://example.com/join/1/59lzQg", http.StatusBadRequest},
+		{"link=https://app.roll20.net/users/2", http.StatusBadRequest},
+		{"gameId=2&link=https://app.roll20.net/join/1/59lzQg", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.qs, func(t *testing.T) {
+			mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+			req := handler2.Request{
+				Body:        nil,
+				Header:      nil,
+				QueryString: tt.qs,
+				Method:      "GET",
+				Host:        "",
+			}
+			res, _ := Handle(req)
+			assert.Equal(t, tt.statusCode, res.StatusCode)
+			mockServer.Close()
+		})
+	}
+}
+
+
+// No env variables defined
+func TestNoEnv(t *testing.T) {
+	os.Unsetenv("ROLL20_BASE_URL")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, _ := Handle(req)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	fmt.Println(string(res.Body))
+
+}
+
+// QS is somehow wrong. Fuzz attack ?
+func TestInvalidQS(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "wrong=;;;",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, _ := Handle(req)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	fmt.Println(string(res.Body))
+	mockServer.Close()
+}
+
+// End gracefully when the scrapper itself fails
+func TestScrapperLoginError(t *testing.T) {
+	// env defined but wrong, the scrapper won't be able to login
+	os.Setenv("ROLL20_BASE_URL", "wrong")
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	fmt.Println(string(res.Body))
+
+}
+
+// End gracefully when the scrapper itself fails
+func TestScrappingError(t *testing.T) {
+	mockServer := SetupLoginOnlyServer()
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	fmt.Println(string(res.Body))
+	mockServer.Close()
+
+}
+
+// Whispers tell who whispered and how often, they are only counted for callers allowed to read them
+func TestAccessControl(t *testing.T) {
+	dir := t.TempDir()
+	ioutil.WriteFile(path.Join(dir, "api-keys"), []byte("team-a:key-a\ntrusted:key-t\n"), 0o600)
+	ioutil.WriteFile(path.Join(dir, "acl"), []byte(`{"team-a": {"campaigns": ["1"], "capabilities": ["messages"]}, "trusted": {"campaigns": ["*"], "capabilities": ["messages", "whispers"]}}`), 0o600)
+	t.Setenv("AUTH_SECRETS_DIR", dir)
+	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+	defer mockServer.Close()
+	var tests = []struct {
+		key        string
+		qs         string
+		statusCode int
+		// Messages of the GM as "GM", 2 whispers a page out of 7 messages
+		gmMessages uint
+	}{
+		{"key-a", "gameId=1", http.StatusOK, 3 * 5},
+		{"key-t", "gameId=1", http.StatusOK, 3 * 7},
+		{"key-a", "gameId=2", http.StatusForbidden, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key+" "+tt.qs, func(t *testing.T) {
+			req := handler2.Request{
+				Body:        nil,
+				Header:      http.Header{"X-Api-Key": {tt.key}},
+				QueryString: tt.qs,
+				Method:      "GET",
+				Host:        "",
+			}
+			res, _ := Handle(req)
+			assert.Equal(t, tt.statusCode, res.StatusCode)
+			if tt.statusCode != http.StatusOK {
+				return
+			}
+			var players []scrapper.PlayerCharacters
+			err := json.Unmarshal(res.Body, &players)
+			assert.Nil(t, err)
+			assert.Equal(t, "-Mgamemaster", players[0].PlayerId)
+			assert.Equal(t, "GM", players[0].Characters[0].Name)
+			assert.Equal(t, tt.gmMessages, players[0].Characters[0].MessageCount)
+		})
+	}
+}
@@ -1,23 +1,69 @@
 package function
 
 import (
-	"encoding/json"
 	"fmt"
 	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"handler/function/pkg/auth"
+	"handler/function/pkg/callback"
 	config_parser "handler/function/pkg/config-parser"
 	http_helpers "handler/function/pkg/http-helpers"
+	link_parser "handler/function/pkg/link-parser"
 	"handler/function/pkg/scrapper"
 	"log"
 	"net/http"
-	"net/url"
-	"strconv"
+	"strings"
+	"time"
 )
 
-const QS_GAME_URL_NAME = "gameId"
-const LIMIT_URL_NAME = "limit"
-const WHISPER_URL_NAME = "includeWhispers"
-const ROLLS_URL_NAME = "includeRolls"
-const CHAT_URL_NAME = "includeChats"
+// Query parameters of get-messages. Defaults are the ones of scrapper.NewMessageOptions
+type messagesQuery struct {
+	link_parser.GamesQuery
+	http_helpers.FormatQuery
+	Limit           *uint  `qs:"limit"`
+	IncludeWhispers bool   `qs:"includeWhispers" default:"false"`
+	IncludeRolls    bool   `qs:"includeRolls" default:"true"`
+	IncludeChat     bool   `qs:"includeChat" alias:"includeChats" default:"true"`
+	PageSize        *uint  `qs:"pageSize" min:"1" max:"1000"`
+	Cursor          string `qs:"cursor"`
+	cursor          *scrapper.MessageCursor
+}
+
+// Number of messages per page when only a cursor is provided
+const DEFAULT_PAGE_SIZE = 100
+
+// Validate Resolve the games and the cursor. Paginating and limiting messages are exclusive,
+// and only a single game can be paginated
+func (q *messagesQuery) Validate(errs *http_helpers.ValidationError) {
+	q.GamesQuery.Validate(errs)
+	if q.Limit != nil && q.isPaginated() {
+		errs.Add("limit", "Can't be used along with pageSize or cursor")
+	}
+	if q.IsBatch() && q.isPaginated() {
+		errs.Add("gameId", "Messages can only be paginated for a single game")
+		return
+	}
+	if len(q.Cursor) == 0 {
+		return
+	}
+	cursor, err := scrapper.DecodeMessageCursor(q.Cursor)
+	if err != nil {
+		errs.Add("cursor", err.Error())
+		return
+	}
+	if games := q.Games(); len(games) == 1 && games[0].GameId != cursor.CampaignId {
+		errs.Add("cursor", "The cursor was issued for another game")
+		return
+	}
+	q.cursor = cursor
+}
+
+// Whether a page of messages is requested, rather than all of them
+func (q *messagesQuery) isPaginated() bool {
+	return q.PageSize != nil || len(q.Cursor) != 0
+}
+
+// Path of the function, used as the instance of its errors
+const FUNCTION_PATH = "/get-messages"
 
 // swagger:route GET /get-messages Players get-messages
 //
@@ -26,13 +72,22 @@ const CHAT_URL_NAME = "includeChats"
 // The player can either be GMs or not. There can be multiple GMs in a single game
 //     Produces:
 //     - application/json
+//     - application/x-ndjson
+//     - text/csv
+//     - application/yaml
+//     - application/problem+json
 //     Parameters:
 //       + name: gameId
 //         in: query
-//         description: Roll20 ID of the game to parse. For link https://app.roll20.net/join/1/59lzQg --> Game ID is "1"
-//         required: true
+//         description: Roll20 ID of the game to parse. For link https://app.roll20.net/join/1/59lzQg --> Game ID is "1". Several games can be requested at once by repeating gameId or link, or as comma separated lists, the response then being a map of CampaignResult keyed by game ID
+//         required: false
 //         type: integer
 //         format: int32
+//       + name: link
+//         in: query
+//         description: Roll20 link of the game, accepted instead of gameId. Either a join link (https://app.roll20.net/join/1/59lzQg), a campaign details or editor URL, or a bare game ID
+//         required: false
+//         type: string
 //       + name: limit
 //         in: query
 //         description: Max number of messages to parse. Default is all available
@@ -51,103 +106,151 @@ const CHAT_URL_NAME = "includeChats"
 //         type: boolean
 //       + name: includeChat
 //         in: query
-//         description: Include general chat messages. Default is true
+//         description: Include general chat messages. Default is true. Its former name, includeChats, is still accepted
 //         required: false
 //         type: boolean
+//       + name: pageSize
+//         in: query
+//         description: Number of messages per page. Setting it paginates the messages, the response then being a MessagesPage. Default is 100 when only a cursor is provided
+//         required: false
+//         type: integer
+//         format: uint
+//         minimum: 1
+//         maximum: 1000
+//       + name: cursor
+//         in: query
+//         description: Opaque cursor of the page to retrieve, as given by the next or prev field of a MessagesPage, or by the Link header. Can't be used with limit
+//         required: false
+//         type: string
+//       + name: format
+//         in: query
+//         description: Format of the response, overriding the Accept header. One of "json", "ndjson", "csv" or "yaml". Default is "json"
+//         required: false
+//         type: string
+//       + name: If-None-Match
+//         in: header
+//         description: ETag of a previous response. If the response would be the same, a 304 without body is answered instead. If-Modified-Since is also supported, against the Last-Modified header telling when the newest message was sent
+//         required: false
+//         type: string
+//       + name: X-Callback-Url
+//         in: header
+//         description: Set by OpenFaaS on async invocations. The response is also POSTed to this URL once the function is done, signed with the callback-key secret. Only accepted from authenticated callers when that secret exists, and never used for a 401 or 403
+//         required: false
+//         type: string
 // responses:
-//  200: []Message Complete list of players for the requested game
-//	400: ErrorTemplate Missing or invalid QS provided
+//  200: []Message Complete list of messages for the requested game. When paginating, a MessagesPage, next and previous pages also being linked in the Link header
+//  207: map[string]CampaignResult Several games were requested, and some of them couldn't be scrapped
+//  304: description: The caller already holds the current representation
+//	400: ErrorTemplate Missing or invalid QS or X-Callback-Url provided, or callbacks refused
+//  401: ErrorTemplate Authentication is enabled, and the caller didn't provide valid credentials
+//  403: ErrorTemplate The caller isn't allowed to read the messages of these games, or their whispers
+//  406: ErrorTemplate None of the accepted media types is supported
 //  500: ErrorTemplate Configuration error, either env variables missing or provided roll20 credentials invalid
 func Handle(req handler2.Request) (handler2.Response, error) {
+	// Async invocations also get the response POSTed to their callback URL
+	return callback.Respond(req, FUNCTION_PATH, handle)
+}
+
+// Answer req, made by identity, whether the invocation is async or not
+func handle(req handler2.Request, identity *auth.Identity) (handler2.Response, error) {
 	log.Println("Get messages handler has been woken up")
-	var err error
 	// Retrieve runtime values from env & QS
 	var keys = []string{"ROLL20_USERNAME", "ROLL20_PASSWORD", "ROLL20_BASE_URL"}
 	values, err := config_parser.ParseEnv(keys)
 	if err != nil {
 		log.Printf("Invalid env : %s\n", err)
-		return handler2.Response{StatusCode: http.StatusInternalServerError, Body: http_helpers.FormatError("Unexpected error while parsing env")}, err
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.MissingConfiguration, "Some environment variables are missing"), err
 	}
-	qs, err := url.ParseQuery(req.QueryString)
-	if err != nil {
-		log.Printf("Invalid QS : %s. Error : %s \n", qs, err)
-		return handler2.Response{StatusCode: http.StatusBadRequest, Body: http_helpers.FormatError("Unexpected error while parsing qs")}, err
+	var query messagesQuery
+	if err = http_helpers.BindQuery(req.QueryString, &query); err != nil {
+		log.Printf("Invalid QS : %s\n", err)
+		return http_helpers.NewBindingProblem(req, FUNCTION_PATH, err), err
 	}
-	gameId := qs.Get(QS_GAME_URL_NAME)
-	if _, err = strconv.Atoi(gameId); len(gameId) == 0 || err != nil {
-		log.Printf("Wrong gameid provided: %s. Error : %s \n", gameId, err)
-		errMessage := fmt.Sprintf("The provided gameId is invalid %s\n", gameId)
-		return handler2.Response{StatusCode: http.StatusBadRequest, Body: http_helpers.FormatError(errMessage)}, err
+	format, ok := http_helpers.NegotiateFormat(req, query.Format)
+	if !ok {
+		return http_helpers.NewNotAcceptableProblem(req, FUNCTION_PATH), nil
 	}
-
-	// Parse limit. This is an optional argument, default is UINT_MAX
-	limit := ^uint(0)
-	if qs.Has(LIMIT_URL_NAME) {
-		limitQs := qs.Get(LIMIT_URL_NAME)
-		// Cannot parse directly to an uint
-		var limitInt int
-		if limitInt, err = strconv.Atoi(limitQs); len(limitQs) == 0 || err != nil || limitInt < 0 {
-			log.Printf("Wrong limit provided: %d. Error : %s \n", limitInt, err)
-			errMessage := fmt.Sprintf("The provided limit is invalid %s. It should be a postive integer.\n", gameId)
-			return handler2.Response{StatusCode: http.StatusBadRequest, Body: http_helpers.FormatError(errMessage)}, err
-		}
-		limit = uint(limitInt)
+	gameIds := query.Ids()
+	if err = auth.Authorize(identity, auth.Messages, gameIds...); err != nil {
+		log.Printf("Forbidden call : %s\n", err)
+		return auth.NewAuthProblem(req, FUNCTION_PATH, err), err
 	}
-
-	// options
-	opt := scrapper.NewMessageOptions()
-	if qs.Has(WHISPER_URL_NAME) {
-		value := qs.Get(WHISPER_URL_NAME)
-		enabled, err := strconv.ParseBool(value)
-		if err != nil {
-			log.Printf("Wrong value provided: %s. Should be eitehr true or false \n", value)
-			errMessage := fmt.Sprintf("Wrong value provided: %s. Should be eitehr true or false \n", value)
-			return handler2.Response{StatusCode: http.StatusBadRequest, Body: http_helpers.FormatError(errMessage)}, err
+	// Whispers are private, only trusted callers can read them
+	if query.IncludeWhispers {
+		if err = auth.Authorize(identity, auth.Whispers, gameIds...); err != nil {
+			log.Printf("Forbidden call : %s\n", err)
+			return auth.NewAuthProblem(req, FUNCTION_PATH, err), err
 		}
-		opt.IncludeWhispers = enabled
 	}
-	if qs.Has(CHAT_URL_NAME) {
-		value := qs.Get(CHAT_URL_NAME)
-		enabled, err := strconv.ParseBool(value)
-		if err != nil {
-			log.Printf("Wrong value provided: %s. Should be eitehr true or false \n", value)
-			errMessage := fmt.Sprintf("Wrong value provided: %s. Should be eitehr true or false \n", value)
-			return handler2.Response{StatusCode: http.StatusBadRequest, Body: http_helpers.FormatError(errMessage)}, err
-		}
-		opt.IncludeChat = enabled
-	}
-	if qs.Has(ROLLS_URL_NAME) {
-		value := qs.Get(ROLLS_URL_NAME)
-		enabled, err := strconv.ParseBool(value)
-		if err != nil {
-			log.Printf("Wrong value provided: %s. Should be eitehr true or false \n", value)
-			errMessage := fmt.Sprintf("Wrong value provided: %s. Should be eitehr true or false \n", value)
-			return handler2.Response{StatusCode: http.StatusBadRequest, Body: http_helpers.FormatError(errMessage)}, err
-		}
-		opt.IncludeRolls = enabled
+	// This is an optional argument, default is UINT_MAX
+	limit := ^uint(0)
+	if query.Limit != nil {
+		limit = *query.Limit
 	}
+	opt := &scrapper.MessageOptions{IncludeRolls: query.IncludeRolls, IncludeChat: query.IncludeChat, IncludeWhispers: query.IncludeWhispers}
 
-	log.Println("Now fetching messages for campaign " + gameId)
+	log.Printf("Now fetching messages for campaigns %s\n", strings.Join(gameIds, ","))
 
-	// Scrap the messages from the game
+	// Scrap the messages from the games, over a single Roll20 session
 	s, err := scrapper.NewScrapper(values["ROLL20_BASE_URL"], &scrapper.Roll20Account{Login: values["ROLL20_USERNAME"], Password: values["ROLL20_PASSWORD"]}, nil)
 	if err != nil {
 		log.Printf("The scrapper instance couldn't be initialized. Error %s\n", err)
-		return handler2.Response{StatusCode: http.StatusInternalServerError, Body: http_helpers.FormatError("Unexpected error")}, err
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.Roll20LoginFailed, "The bot account couldn't log in to Roll20"), err
 	}
+	if query.isPaginated() {
+		gameId := gameIds[0]
+		pageSize := uint(DEFAULT_PAGE_SIZE)
+		if query.PageSize != nil {
+			pageSize = *query.PageSize
+		}
+		page, err := s.GetMessagesPage(gameId, query.cursor, pageSize, opt)
+		if err != nil {
+			log.Printf("Unexpected error : %s\n", err.Error())
+			return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.ScrappingFailed, fmt.Sprintf("Roll20 couldn't be scrapped for game %s", gameId)), err
+		}
+		log.Printf("A page of %d messages has been scrapped from campaign %s\n", len(page.Messages), gameId)
+		res, err := http_helpers.NewConditionalResponse(req, http.StatusOK, format, page, scrapper.LastSentAt(page.Messages))
+		if links := http_helpers.PageLinks(req.QueryString, "cursor", page.Next, page.Prev); len(links) != 0 {
+			res.Header["Link"] = []string{links}
+		}
+		return res, err
+	}
+	if query.IsBatch() {
+		batch := http_helpers.NewBatch(gameIds)
+		s.ForEachCampaign(gameIds, func(gameId string) {
+			result, _ := getMessages(s, gameId, limit, opt)
+			batch.Set(gameId, result)
+		})
+		return http_helpers.NewConditionalResponse(req, batch.Status(), format, batch, lastSentAt(batch))
+	}
+	result, err := getMessages(s, gameIds[0], limit, opt)
+	if result.Error != nil {
+		return http_helpers.NewProblemResponse(req, result.Error), err
+	}
+	return http_helpers.NewConditionalResponse(req, result.Status, format, result.Data, lastSentAt(http_helpers.Batch{gameIds[0]: result}))
+}
+
+// When the newest message of the batch was sent, the zero time if there are none
+func lastSentAt(batch http_helpers.Batch) time.Time {
+	var newest time.Time
+	for _, result := range batch {
+		if messages, ok := result.Data.(*[]scrapper.Message); ok && messages != nil {
+			if sentAt := scrapper.LastSentAt(*messages); sentAt.After(newest) {
+				newest = sentAt
+			}
+		}
+	}
+	return newest
+}
+
+// Scrap the messages of a single game
+func getMessages(s *scrapper.Scrapper, gameId string, limit uint, opt *scrapper.MessageOptions) (*http_helpers.CampaignResult, error) {
 	messages, err := s.GetMessages(gameId, limit, opt)
 	if err != nil {
 		log.Printf("Unexpected error : %s\n", err.Error())
-		return handler2.Response{StatusCode: http.StatusInternalServerError, Body: http_helpers.FormatError(err.Error())}, err
+		problem := http_helpers.Problem(FUNCTION_PATH, http.StatusInternalServerError, http_helpers.ScrappingFailed, fmt.Sprintf("Roll20 couldn't be scrapped for game %s", gameId))
+		return &http_helpers.CampaignResult{Status: problem.Status, Error: problem}, err
 	}
 	log.Println("All messages have been successfully scrapped from campaign " + gameId)
-	// If all messages have been picked up, send them back with a 200
-	messagesJson, err := json.Marshal(messages)
-	return handler2.Response{
-		StatusCode: http.StatusOK,
-		Body:       messagesJson,
-		Header: map[string][]string{
-			"Content-type": {"application/json"},
-		},
-	}, err
+	return &http_helpers.CampaignResult{Status: http.StatusOK, Data: messages}, nil
 }
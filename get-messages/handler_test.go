@@ -5,6 +5,8 @@ import (
 	"fmt"
 	handler2 "github.com/openfaas/templates-sdk/go-http"
 	"github.com/stretchr/testify/assert"
+	"handler/function/pkg/callback"
+	http_helpers "handler/function/pkg/http-helpers"
 	"handler/function/pkg/scrapper"
 	"io/ioutil"
 	"net/http"
@@ -128,40 +130,138 @@ func TestWithLimit(t *testing.T) {
 
 }
 
-func TestWithInvalidLimit(t *testing.T) {
+// Follow the Link header through the whole archive, 3 pages of 65 messages without whispers
+func TestPagination(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+	qs := "gameId=1&pageSize=100"
+	total, pages := 0, 0
+	for len(qs) != 0 {
+		req := handler2.Request{
+			Body:        nil,
+			Header:      nil,
+			QueryString: qs,
+			Method:      "GET",
+			Host:        "",
+		}
+		res, err := Handle(req)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		var page scrapper.MessagesPage
+		err = json.Unmarshal(res.Body, &page)
+		assert.Nil(t, err)
+		assert.LessOrEqual(t, len(page.Messages), 100)
+		total += len(page.Messages)
+		pages++
+		qs = ""
+		if len(page.Next) != 0 {
+			link := res.Header.Get("Link")
+			assert.Contains(t, link, `rel="next"`)
+			assert.Contains(t, link, "cursor="+page.Next)
+			qs = "gameId=1&pageSize=100&cursor=" + page.Next
+		}
+		if pages > 1 {
+			assert.NotEmpty(t, page.Prev)
+			assert.Contains(t, res.Header.Get("Link"), `rel="prev"`)
+		}
+	}
+	assert.Equal(t, 195, total)
+	assert.Equal(t, 2, pages)
+	mockServer.Close()
+}
+
+// In CSV, only the messages are written, cursors are in the Link header
+func TestPaginationCSV(t *testing.T) {
 	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
 	req := handler2.Request{
 		Body:        nil,
 		Header:      nil,
-		QueryString: "gameId=1&limit=-3",
+		QueryString: "gameId=1&pageSize=10&format=csv",
 		Method:      "GET",
 		Host:        "",
 	}
 	res, err := Handle(req)
 	assert.Nil(t, err)
-	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
-	var messages []scrapper.Message
-	err = json.Unmarshal(res.Body, &messages)
-	assert.Error(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Len(t, strings.Split(strings.TrimSpace(string(res.Body)), "\n"), 11)
+	assert.Contains(t, res.Header.Get("Link"), `rel="next"`)
 	mockServer.Close()
+}
 
+func TestInvalidPagination(t *testing.T) {
+	var tests = []struct {
+		qs    string
+		field string
+	}{
+		{"gameId=1&pageSize=0", "pageSize"},
+		{"gameId=1&pageSize=1001", "pageSize"},
+		{"gameId=1&pageSize=10&limit=3", "limit"},
+		{"gameId=1&cursor=meh", "cursor"},
+		{"gameId=1,2&pageSize=10", "gameId"},
+		{"gameId=2&cursor=" + (&scrapper.MessageCursor{CampaignId: "1", Page: 1}).Encode(), "cursor"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.qs, func(t *testing.T) {
+			mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+			req := handler2.Request{
+				Body:        nil,
+				Header:      nil,
+				QueryString: tt.qs,
+				Method:      "GET",
+				Host:        "",
+			}
+			res, _ := Handle(req)
+			assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+			et := http_helpers.ErrorTemplate{}
+			err := json.Unmarshal(res.Body, &et)
+			assert.Nil(t, err)
+			assert.Len(t, et.Errors, 1)
+			assert.Equal(t, tt.field, et.Errors[0].Field)
+			mockServer.Close()
+		})
+	}
 }
 
-func TestExcludingAll(t *testing.T) {
+func TestWithInvalidLimit(t *testing.T) {
 	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
 	req := handler2.Request{
 		Body:        nil,
 		Header:      nil,
-		QueryString: "gameId=1&includeChats=false&includeWhispers=false&includeRolls=false",
+		QueryString: "gameId=1&limit=-3",
 		Method:      "GET",
 		Host:        "",
 	}
 	res, err := Handle(req)
-	assert.Nil(t, err)
-	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
 	var messages []scrapper.Message
 	err = json.Unmarshal(res.Body, &messages)
-	assert.Len(t, messages, 0)
+	assert.Error(t, err)
+	var et http_helpers.ErrorTemplate
+	err = json.Unmarshal(res.Body, &et)
+	assert.Nil(t, err)
+	assert.Equal(t, []http_helpers.FieldError{{Field: "limit", Message: "Invalid value \"-3\". Should be a positive integer"}}, et.Errors)
+	mockServer.Close()
+
+}
+
+// includeChats is the former name of includeChat, still accepted
+func TestExcludingAll(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+	for _, chat := range []string{"includeChat", "includeChats"} {
+		req := handler2.Request{
+			Body:        nil,
+			Header:      nil,
+			QueryString: "gameId=1&" + chat + "=false&includeWhispers=false&includeRolls=false",
+			Method:      "GET",
+			Host:        "",
+		}
+		res, err := Handle(req)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		var messages []scrapper.Message
+		err = json.Unmarshal(res.Body, &messages)
+		assert.Len(t, messages, 0, chat)
+	}
 	mockServer.Close()
 
 }
@@ -170,7 +270,7 @@ func TestIncludingAll(t *testing.T) {
 	req := handler2.Request{
 		Body:        nil,
 		Header:      nil,
-		QueryString: "gameId=1&includeChats=true&includeWhispers=true&includeRolls=true",
+		QueryString: "gameId=1&includeChat=true&includeWhispers=true&includeRolls=true",
 		Method:      "GET",
 		Host:        "",
 	}
@@ -229,6 +329,62 @@ func TestWrongOptionsRolls(t *testing.T) {
 	mockServer.Close()
 
 }
+// The game can be designated by a link instead of its ID
+func TestGameLink(t *testing.T) {
+	var tests = []struct {
+		qs         string
+		statusCode int
+	}{
+		{"link=1", http.StatusOK},
+		{"link=https://app.roll20.net/join/1/59lzQg", http.StatusOK},
+		{"link=https://app.roll20.net/campaigns/details/1/my-campaign", http.StatusOK},
+		{"gameId=1&link=https://app.roll20.net/editor/setcampaign/1", http.StatusOK},
+		{"link=https://example.com/join/1/59lzQg", http.StatusBadRequest},
+		{"link=https://app.roll20.net/users/2", http.StatusBadRequest},
+		{"gameId=2&link=https://app.roll20.net/join/1/59lzQg", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.qs, func(t *testing.T) {
+			mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+			req := handler2.Request{
+				Body:        nil,
+				Header:      nil,
+				QueryString: tt.qs,
+				Method:      "GET",
+				Host:        "",
+			}
+			res, _ := Handle(req)
+			assert.Equal(t, tt.statusCode, res.StatusCode)
+			mockServer.Close()
+		})
+	}
+}
+
+
+// Every invalid parameter is reported at once
+func TestMultipleInvalidParams(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=sss&limit=a&includeChats=dd&includeRolls=1&includeWhispers=no",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	var et http_helpers.ErrorTemplate
+	err = json.Unmarshal(res.Body, &et)
+	assert.Nil(t, err)
+	fields := []string{}
+	for _, fe := range et.Errors {
+		fields = append(fields, fe.Field)
+	}
+	assert.ElementsMatch(t, []string{"gameId", "limit", "includeChats", "includeWhispers"}, fields)
+	mockServer.Close()
+}
 
 // No env variables defined
 func TestNoEnv(t *testing.T) {
@@ -315,3 +471,157 @@ func TestScrappingError(t *testing.T) {
 	mockServer.Close()
 
 }
+
+// Several games at once
+func TestBatch(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1&gameId=2&limit=3",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var batch map[string]struct {
+		Status int                `json:"status"`
+		Data   []scrapper.Message `json:"data"`
+	}
+	err = json.Unmarshal(res.Body, &batch)
+	assert.Nil(t, err)
+	assert.Len(t, batch, 2)
+	for _, id := range []string{"1", "2"} {
+		assert.Equal(t, http.StatusOK, batch[id].Status)
+		assert.Len(t, batch[id].Data, 3)
+	}
+	mockServer.Close()
+}
+
+// Unchanged messages aren't sent again
+func TestConditionalGet(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var messages []scrapper.Message
+	err = json.Unmarshal(res.Body, &messages)
+	assert.Nil(t, err)
+	lastModified := scrapper.LastSentAt(messages).Format(http.TimeFormat)
+	assert.Equal(t, []string{lastModified}, res.Header["Last-Modified"])
+	assert.Len(t, res.Header["ETag"], 1)
+	etag := res.Header["ETag"][0]
+
+	req.Header = http.Header{"If-None-Match": {etag}}
+	res, err = Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotModified, res.StatusCode)
+	assert.Empty(t, res.Body)
+	assert.Equal(t, []string{etag}, res.Header["ETag"])
+
+	req.Header = http.Header{"If-Modified-Since": {lastModified}}
+	res, err = Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotModified, res.StatusCode)
+
+	// Another representation, or other messages, have another tag
+	for _, qs := range []string{"gameId=1&format=csv", "gameId=1&limit=3"} {
+		req.QueryString = qs
+		req.Header = http.Header{"If-None-Match": {etag}}
+		res, err = Handle(req)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.NotEqual(t, []string{etag}, res.Header["ETag"])
+	}
+	mockServer.Close()
+}
+
+// Whispers and other games are refused to callers lacking the permission
+func TestAccessControl(t *testing.T) {
+	dir := t.TempDir()
+	ioutil.WriteFile(path.Join(dir, "api-keys"), []byte("team-a:key-a\ntrusted:key-t\n"), 0o600)
+	ioutil.WriteFile(path.Join(dir, "acl"), []byte(`{"team-a": {"campaigns": ["1"], "capabilities": ["messages"]}, "trusted": {"campaigns": ["*"], "capabilities": ["messages", "whispers"]}}`), 0o600)
+	os.Setenv("AUTH_SECRETS_DIR", dir)
+	defer os.Unsetenv("AUTH_SECRETS_DIR")
+	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+	var tests = []struct {
+		key        string
+		qs         string
+		statusCode int
+	}{
+		{"key-a", "gameId=1", http.StatusOK},
+		{"key-a", "gameId=1&includeWhispers=true", http.StatusForbidden},
+		{"key-a", "gameId=1,2", http.StatusForbidden},
+		{"key-t", "gameId=2&includeWhispers=true", http.StatusOK},
+		{"key-b", "gameId=1", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key+" "+tt.qs, func(t *testing.T) {
+			req := handler2.Request{
+				Body:        nil,
+				Header:      http.Header{"X-Api-Key": {tt.key}},
+				QueryString: tt.qs,
+				Method:      "GET",
+				Host:        "",
+			}
+			res, _ := Handle(req)
+			assert.Equal(t, tt.statusCode, res.StatusCode)
+			if tt.statusCode == http.StatusForbidden {
+				var problem http_helpers.ErrorTemplate
+				json.Unmarshal(res.Body, &problem)
+				assert.Equal(t, http_helpers.Forbidden, problem.Code)
+			}
+		})
+	}
+	mockServer.Close()
+}
+
+// Async invocations get the messages POSTed to their callback URL, retried until the receiver accepts them
+func TestCallback(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+	defer mockServer.Close()
+	dir := t.TempDir()
+	assert.Nil(t, ioutil.WriteFile(path.Join(dir, callback.CALLBACK_KEY_SECRET), []byte("s3cr3t"), 0o600))
+	assert.Nil(t, ioutil.WriteFile(path.Join(dir, "api-keys"), []byte("team-a:key-a"), 0o600))
+	t.Setenv("AUTH_SECRETS_DIR", dir)
+	t.Setenv("CALLBACK_BACKOFF", "1ms")
+	var attempts []string
+	var receivedBody []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts = append(attempts, r.Header.Get(callback.CorrelationIdHeader))
+		if len(attempts) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		receivedBody, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer receiver.Close()
+
+	req := handler2.Request{
+		Body:        nil,
+		Header:      http.Header{callback.CallbackUrlHeader: {receiver.URL}, "X-Api-Key": {"key-a"}},
+		QueryString: "gameId=1&limit=3",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	// Both attempts share the generated correlation ID
+	assert.Len(t, attempts, 2)
+	assert.NotEmpty(t, attempts[0])
+	assert.Equal(t, attempts[0], attempts[1])
+	assert.Equal(t, res.Body, receivedBody)
+	var messages []scrapper.Message
+	assert.Nil(t, json.Unmarshal(receivedBody, &messages))
+	assert.Len(t, messages, 3)
+}
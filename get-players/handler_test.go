@@ -5,6 +5,7 @@ import (
 	"fmt"
 	handler2 "github.com/openfaas/templates-sdk/go-http"
 	"github.com/stretchr/testify/assert"
+	http_helpers "handler/function/pkg/http-helpers"
 	"handler/function/pkg/scrapper"
 	"io/ioutil"
 	"net/http"
@@ -13,6 +14,7 @@ import (
 	"path"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"testing"
 )
 
@@ -41,6 +43,54 @@ func SetupTestServer(campaignDataPath string) *httptest.Server {
 
 }
 
+// Server also answering with a sample profile, unless the profiles are broken
+func SetupProfilesTestServer(campaignDataPath string, brokenProfiles bool) *httptest.Server {
+	_, filename, _, _ := runtime.Caller(0)
+	dir := path.Dir(filename)
+	sampleData, _ := ioutil.ReadFile(path.Join(dir, "../", campaignDataPath))
+	profileData, _ := ioutil.ReadFile(path.Join(dir, "../assets/sample_user_profile.html"))
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/campaigns/details/") {
+			w.Write(sampleData)
+		} else if strings.Contains(r.URL.Path, "/users/") && brokenProfiles {
+			w.WriteHeader(500)
+		} else if strings.Contains(r.URL.Path, "/users/") {
+			w.Write(profileData)
+		} else {
+			w.WriteHeader(200)
+		}
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	return mockServer
+}
+
+// Server answering with the sample details page for every game, but game 2
+func SetupBatchServer(campaignDataPath string) *httptest.Server {
+	_, filename, _, _ := runtime.Caller(0)
+	dir := path.Dir(filename)
+	sampleData, err := ioutil.ReadFile(path.Join(dir, campaignDataPath))
+	// On CI, the path may be wrong because the import path is different
+	if err != nil {
+		sampleData, _ = ioutil.ReadFile(path.Join(dir, "../", campaignDataPath))
+	}
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/campaigns/details/2"):
+			w.WriteHeader(500)
+		case strings.Contains(r.URL.Path, "/campaigns/details/"):
+			w.Write(sampleData)
+		default:
+			w.WriteHeader(200)
+		}
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	return mockServer
+}
+
 // Server only allowing the scrapper to log in
 func SetupLoginOnlyServer() *httptest.Server {
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -130,6 +180,150 @@ func TestCompleteAnswer(t *testing.T) {
 
 }
 
+// The format is negotiated from the Accept header, unless the format parameter is given
+func TestFormats(t *testing.T) {
+	var tests = []struct {
+		accept      string
+		qs          string
+		statusCode  int
+		contentType string
+	}{
+		{"text/csv", "gameId=1", http.StatusOK, "text/csv; charset=utf-8"},
+		{"application/x-ndjson", "gameId=1", http.StatusOK, "application/x-ndjson"},
+		{"text/html", "gameId=1&format=yaml", http.StatusOK, "application/yaml"},
+		{"text/html", "gameId=1", http.StatusNotAcceptable, "application/problem+json"},
+		{"", "gameId=1&format=xml", http.StatusBadRequest, "application/problem+json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept+"|"+tt.qs, func(t *testing.T) {
+			mockServer := SetupTestServer("assets/sample_campaign_page.html")
+			req := handler2.Request{
+				Body:        nil,
+				Header:      http.Header{"Accept": {tt.accept}},
+				QueryString: tt.qs,
+				Method:      "GET",
+				Host:        "",
+			}
+			res, _ := Handle(req)
+			assert.Equal(t, tt.statusCode, res.StatusCode)
+			assert.Equal(t, []string{tt.contentType}, res.Header["Content-type"])
+			mockServer.Close()
+		})
+	}
+}
+
+// A CSV line per player, with fixed columns
+func TestCSVAnswer(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_page.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1&format=csv",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(res.Body)), "\n")
+	assert.Len(t, lines, 8)
+	assert.Equal(t, "avatarUrl,isGm,roll20Id,username,profile.about,profile.subscription,profile.timeZone,profile.memberSince", lines[0])
+	mockServer.Close()
+}
+
+// Profiles are embedded on demand
+func TestEnrichedAnswer(t *testing.T) {
+	mockServer := SetupProfilesTestServer("assets/sample_campaign_page.html", false)
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1&enrich=profile",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var players []scrapper.Player
+	err = json.Unmarshal(res.Body, &players)
+	assert.Nil(t, err)
+	assert.Len(t, players, 7)
+	for _, p := range players {
+		assert.Equal(t, "Pro", *p.Profile.Subscription)
+	}
+	mockServer.Close()
+}
+
+// Players are still returned when their profile can't be retrieved
+func TestEnrichedIncompleteAnswer(t *testing.T) {
+	mockServer := SetupProfilesTestServer("assets/sample_campaign_page.html", true)
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1&enrich=profile",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusMultiStatus, res.StatusCode)
+	var players []scrapper.Player
+	err = json.Unmarshal(res.Body, &players)
+	assert.Nil(t, err)
+	assert.Len(t, players, 7)
+	for _, p := range players {
+		assert.Nil(t, p.Profile)
+	}
+	mockServer.Close()
+}
+
+// Only profiles can be embedded
+func TestWrongEnrich(t *testing.T) {
+	mockServer := SetupProfilesTestServer("assets/sample_campaign_page.html", false)
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1&enrich=characters",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	mockServer.Close()
+}
+
+// The game can be designated by a link instead of its ID
+func TestGameLink(t *testing.T) {
+	var tests = []struct {
+		qs         string
+		statusCode int
+	}{
+		{"link=1", http.StatusOK},
+		{"link=https://app.roll20.net/join/1/59lzQg", http.StatusOK},
+		{"link=https://app.roll20.net/campaigns/details/1/my-campaign", http.StatusOK},
+		{"gameId=1&link=https://app.roll20.net/editor/setcampaign/1", http.StatusOK},
+		{"link=https://example.com/join/1/59lzQg", http.StatusBadRequest},
+		{"link=https://app.roll20.net/users/2", http.StatusBadRequest},
+		{"gameId=2&link=https://app.roll20.net/join/1/59lzQg", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.qs, func(t *testing.T) {
+			mockServer := SetupTestServer("assets/sample_campaign_page.html")
+			req := handler2.Request{
+				Body:        nil,
+				Header:      nil,
+				QueryString: tt.qs,
+				Method:      "GET",
+				Host:        "",
+			}
+			res, _ := Handle(req)
+			assert.Equal(t, tt.statusCode, res.StatusCode)
+			mockServer.Close()
+		})
+	}
+}
+
 // No env variables defined
 func TestNoEnv(t *testing.T) {
 	os.Unsetenv("ROLL20_BASE_URL")
@@ -215,3 +409,166 @@ func TestScrappingError(t *testing.T) {
 	mockServer.Close()
 
 }
+
+// Several games at once. One failing game doesn't fail the others
+func TestBatch(t *testing.T) {
+	mockServer := SetupBatchServer("assets/sample_campaign_page.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1,2&link=https://app.roll20.net/campaigns/details/3/name",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusMultiStatus, res.StatusCode)
+	var batch map[string]struct {
+		Status int                         `json:"status"`
+		Data   *[]scrapper.Player          `json:"data"`
+		Error  *http_helpers.ErrorTemplate `json:"error"`
+	}
+	err = json.Unmarshal(res.Body, &batch)
+	assert.Nil(t, err)
+	assert.Len(t, batch, 3)
+	for _, id := range []string{"1", "3"} {
+		assert.Equal(t, http.StatusOK, batch[id].Status)
+		assert.NotNil(t, batch[id].Data)
+		assert.Nil(t, batch[id].Error)
+	}
+	assert.Equal(t, http.StatusInternalServerError, batch["2"].Status)
+	assert.Nil(t, batch["2"].Data)
+	assert.Equal(t, http_helpers.ScrappingFailed, batch["2"].Error.Code)
+	mockServer.Close()
+}
+
+// Players are served from the cache while fresh, unless the caller bypasses it
+func TestCache(t *testing.T) {
+	os.Setenv("CACHE_BACKEND", "memory")
+	defer os.Unsetenv("CACHE_BACKEND")
+	mockServer := SetupProfilesTestServer("assets/sample_campaign_page.html", false)
+	var tests = []struct {
+		name         string
+		qs           string
+		cacheControl string
+		xCache       string
+	}{
+		{"first call", "gameId=1", "", "MISS"},
+		{"cached", "gameId=1", "", "HIT"},
+		{"bypassed", "gameId=1", "no-cache", "BYPASS"},
+		{"enriched players are cached apart", "gameId=1&enrich=profile", "", "MISS"},
+		{"batch", "gameId=1,2", "", "HIT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := handler2.Request{
+				Body:        nil,
+				Header:      http.Header{"Cache-Control": {tt.cacheControl}},
+				QueryString: tt.qs,
+				Method:      "GET",
+				Host:        "",
+			}
+			res, err := Handle(req)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			assert.Equal(t, []string{tt.xCache}, res.Header["X-Cache"])
+		})
+	}
+	mockServer.Close()
+
+	// Incomplete players aren't cached
+	mockServer = SetupTestServer("assets/sample_campaign_missing_id.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=3",
+		Method:      "GET",
+		Host:        "",
+	}
+	for i := 0; i < 2; i++ {
+		res, err := Handle(req)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusMultiStatus, res.StatusCode)
+		assert.Equal(t, []string{"MISS"}, res.Header["X-Cache"])
+	}
+	mockServer.Close()
+}
+
+// Cached players don't cost a login, and are still served when Roll20 can't be logged in to
+func TestCacheLoginFailed(t *testing.T) {
+	os.Setenv("CACHE_BACKEND", "file")
+	os.Setenv("CACHE_DIR", t.TempDir())
+	defer os.Unsetenv("CACHE_BACKEND")
+	defer os.Unsetenv("CACHE_DIR")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "GET",
+		Host:        "",
+	}
+	mockServer := SetupTestServer("assets/sample_campaign_page.html")
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"MISS"}, res.Header["X-Cache"])
+	scrapped := res.Body
+	mockServer.Close()
+
+	// Roll20 is now down, the login fails
+	var requests int32
+	mockServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer mockServer.Close()
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	res, err = Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"HIT"}, res.Header["X-Cache"])
+	assert.Equal(t, int32(0), atomic.LoadInt32(&requests))
+
+	req.Header = http.Header{"Cache-Control": {"no-cache"}}
+	res, err = Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, []string{"STALE"}, res.Header["X-Cache"])
+	assert.Equal(t, scrapped, res.Body)
+
+	// Without a cached copy, the failed login is reported
+	req.QueryString = "gameId=2"
+	res, err = Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	assert.Contains(t, string(res.Body), string(http_helpers.Roll20LoginFailed))
+}
+
+// Unchanged players aren't sent again
+func TestConditionalGet(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_page.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Empty(t, res.Header["Last-Modified"])
+	etag := res.Header["ETag"][0]
+
+	req.Header = http.Header{"If-None-Match": {`"outdated", ` + etag}}
+	res, err = Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotModified, res.StatusCode)
+	assert.Empty(t, res.Body)
+
+	req.Header = http.Header{"If-None-Match": {`"outdated"`}}
+	res, err = Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, []string{etag}, res.Header["ETag"])
+	mockServer.Close()
+}
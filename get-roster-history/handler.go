@@ -0,0 +1,164 @@
+package function
+
+import (
+	"fmt"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"handler/function/pkg/auth"
+	config_parser "handler/function/pkg/config-parser"
+	http_helpers "handler/function/pkg/http-helpers"
+	link_parser "handler/function/pkg/link-parser"
+	"handler/function/pkg/roster"
+	"handler/function/pkg/scrapper"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Query parameters of get-roster-history
+type rosterQuery struct {
+	link_parser.GameQuery
+	http_helpers.FormatQuery
+	Since time.Time `qs:"since"`
+}
+
+// Where snapshots are stored when ROSTER_STORE_DIR isn't defined
+const DEFAULT_STORE_DIR = "/tmp/roster"
+
+// Path of the function, used as the instance of its errors
+const FUNCTION_PATH = "/get-roster-history"
+
+// swagger:route GET /get-roster-history Players get-roster-history
+//
+// Retrieve the changes in the players of a roll20 game
+//
+// Each call records the current players of the game if they changed since the last call.
+// The history is the list of players who joined, left, were granted or revoked the GM role, were renamed or changed their avatar between successive records
+//     Produces:
+//     - application/json
+//     - application/x-ndjson
+//     - text/csv
+//     - application/yaml
+//     - application/problem+json
+//     Parameters:
+//       + name: gameId
+//         in: query
+//         description: Roll20 ID of the game to parse. For link https://app.roll20.net/join/1/59lzQg --> Game ID is "1"
+//         required: false
+//         type: integer
+//         format: int32
+//       + name: link
+//         in: query
+//         description: Roll20 link of the game, accepted instead of gameId. Either a join link (https://app.roll20.net/join/1/59lzQg), a campaign details or editor URL, or a bare game ID
+//         required: false
+//         type: string
+//       + name: since
+//         in: query
+//         description: Only return the changes seen after this date (RFC 3339). Ex 2022-05-01T20:00:00Z
+//         required: false
+//         type: string
+//         format: date-time
+//       + name: format
+//         in: query
+//         description: Format of the response, overriding the Accept header. One of "json", "ndjson", "csv" or "yaml". Default is "json"
+//         required: false
+//         type: string
+//       + name: If-None-Match
+//         in: header
+//         description: ETag of a previous response. If the response would be the same, a 304 without body is answered instead
+//         required: false
+//         type: string
+// responses:
+//  200: RosterHistory History of the requested game, including the current players
+//  207: RosterHistory Incomplete list of current players for the requested game. It hasn't been recorded
+//  304: description: The caller already holds the current representation
+//	400: ErrorTemplate Missing or invalid game ID, link or since provided
+//  401: ErrorTemplate Authentication is enabled, and the caller didn't provide valid credentials
+//  403: ErrorTemplate The caller isn't allowed to read the players of this game
+//  406: ErrorTemplate None of the accepted media types is supported
+//  500: ErrorTemplate Configuration error, either env variables missing or provided roll20 credentials invalid, or the history couldn't be stored
+func Handle(req handler2.Request) (handler2.Response, error) {
+	log.Println("Get roster history handler has been woken up")
+	var err error
+	// Callers are authenticated before anything else
+	identity, err := auth.Authenticate(req, FUNCTION_PATH)
+	if err != nil {
+		log.Printf("Unauthenticated call : %s\n", err)
+		return auth.NewAuthProblem(req, FUNCTION_PATH, err), err
+	}
+	// Retrieve runtime values from env & QS
+	var keys = []string{"ROLL20_USERNAME", "ROLL20_PASSWORD", "ROLL20_BASE_URL"}
+	values, err := config_parser.ParseEnv(keys)
+	if err != nil {
+		log.Printf("Invalid env : %s\n", err)
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.MissingConfiguration, "Some environment variables are missing"), err
+	}
+	storeDir, isSet := os.LookupEnv("ROSTER_STORE_DIR")
+	if !isSet {
+		storeDir = DEFAULT_STORE_DIR
+	}
+	var query rosterQuery
+	if err = http_helpers.BindQuery(req.QueryString, &query); err != nil {
+		log.Printf("Invalid QS : %s\n", err)
+		return http_helpers.NewBindingProblem(req, FUNCTION_PATH, err), err
+	}
+	format, ok := http_helpers.NegotiateFormat(req, query.Format)
+	if !ok {
+		return http_helpers.NewNotAcceptableProblem(req, FUNCTION_PATH), nil
+	}
+	gameId := query.Game().GameId
+	if err = auth.Authorize(identity, auth.Players, gameId); err != nil {
+		log.Printf("Forbidden call : %s\n", err)
+		return auth.NewAuthProblem(req, FUNCTION_PATH, err), err
+	}
+	since := query.Since
+	store, err := roster.NewFileStore(storeDir)
+	if err != nil {
+		log.Printf("The roster store couldn't be initialized. Error %s\n", err)
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.StorageFailed, "The roster store couldn't be opened"), err
+	}
+	log.Println("Now fetching players for campaign " + gameId)
+
+	// Scrap the current players from the game
+	s, err := scrapper.NewScrapper(values["ROLL20_BASE_URL"], &scrapper.Roll20Account{Login: values["ROLL20_USERNAME"], Password: values["ROLL20_PASSWORD"]}, nil)
+	if err != nil {
+		log.Printf("The scrapper instance couldn't be initialized. Error %s\n", err)
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.Roll20LoginFailed, "The bot account couldn't log in to Roll20"), err
+	}
+	statusCode := http.StatusOK
+	players, err := s.GetPlayers(gameId)
+	if err != nil {
+		re, ok := err.(*scrapper.IncompleteError)
+		if !ok {
+			log.Printf("Unexpected error : %s\n", err.Error())
+			return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.ScrappingFailed, fmt.Sprintf("Roll20 couldn't be scrapped for game %s", gameId)), err
+		}
+		// Recording an incomplete list would report the ignored players as having left
+		log.Println(re.Error())
+		statusCode = http.StatusMultiStatus
+	}
+	if statusCode == http.StatusOK {
+		if _, err = roster.Record(store, gameId, *players, time.Now().UTC()); err != nil {
+			log.Printf("Unexpected error : %s\n", err.Error())
+			return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.StorageFailed, fmt.Sprintf("The players of game %s couldn't be recorded", gameId)), err
+		}
+	}
+
+	snapshots, err := store.Load(gameId)
+	if err != nil {
+		log.Printf("Unexpected error : %s\n", err.Error())
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.StorageFailed, fmt.Sprintf("The history of game %s couldn't be read", gameId)), err
+	}
+	history := roster.RosterHistory{GameId: gameId, Players: *players, Events: []roster.Event{}}
+	if len(snapshots) > 0 {
+		history.TrackedSince = &snapshots[0].TakenAt
+	}
+	for _, event := range roster.History(snapshots) {
+		if event.SeenAt.After(since) {
+			history.Events = append(history.Events, event)
+		}
+	}
+	log.Printf("%d roster changes found for campaign %s\n", len(history.Events), gameId)
+
+	return http_helpers.NewConditionalResponse(req, statusCode, format, history, time.Time{})
+}
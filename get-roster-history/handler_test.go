@@ -0,0 +1,245 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"github.com/stretchr/testify/assert"
+	"handler/function/pkg/roster"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Server answering with a campaign page that can be altered between two calls
+func SetupTestServer(campaignDataPath string) (*httptest.Server, func(alter func(string) string)) {
+	_, filename, _, _ := runtime.Caller(0)
+	dir := path.Dir(filename)
+	// Open provided path
+	sampleData, err := ioutil.ReadFile(path.Join(dir, campaignDataPath))
+	// On CI, the path may be wrong because the import path is different
+	if err != nil {
+		sampleData, _ = ioutil.ReadFile(path.Join(dir, "../", campaignDataPath))
+	}
+	var mutex sync.Mutex
+	served := sampleData
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if strings.Contains(r.URL.Path, "/campaigns/details/") {
+			w.Write(served)
+		} else {
+			w.WriteHeader(200)
+		}
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	alter := func(alter func(string) string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		served = []byte(alter(string(sampleData)))
+	}
+	return mockServer, alter
+}
+
+// Server only allowing the scrapper to log in
+func SetupLoginOnlyServer() *httptest.Server {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/campaigns/details/") {
+			w.WriteHeader(500)
+		} else {
+			w.WriteHeader(200)
+		}
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	return mockServer
+}
+
+func getHistory(t *testing.T, qs string) (handler2.Response, roster.RosterHistory) {
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: qs,
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	var history roster.RosterHistory
+	err = json.Unmarshal(res.Body, &history)
+	assert.Nil(t, err)
+	return res, history
+}
+
+// The first call only records the players
+func TestFirstRecord(t *testing.T) {
+	os.Setenv("ROSTER_STORE_DIR", t.TempDir())
+	mockServer, _ := SetupTestServer("assets/sample_campaign_page.html")
+	res, history := getHistory(t, "gameId=1")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "1", history.GameId)
+	assert.NotNil(t, history.TrackedSince)
+	assert.NotEmpty(t, history.Players)
+	assert.Empty(t, history.Events)
+	mockServer.Close()
+}
+
+func TestRosterChanges(t *testing.T) {
+	os.Setenv("ROSTER_STORE_DIR", t.TempDir())
+	mockServer, alter := SetupTestServer("assets/sample_campaign_page.html")
+	res, _ := getHistory(t, "gameId=1")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// Player 2 (3) is renamed, Player 3 (4) is replaced by a newcomer (40)
+	alter(func(page string) string {
+		page = strings.Replace(page, "Player 2\n", "Player Two\n", 1)
+		return strings.Replace(page, `href="/users/4"`, `href="/users/40"`, 1)
+	})
+	res, history := getHistory(t, "gameId=1")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Len(t, history.Events, 3)
+	assert.Equal(t, roster.Renamed, history.Events[0].Type)
+	assert.Equal(t, 3, history.Events[0].Roll20Id)
+	assert.Equal(t, "Player Two", history.Events[0].Current)
+	assert.Equal(t, roster.PlayerLeft, history.Events[1].Type)
+	assert.Equal(t, 4, history.Events[1].Roll20Id)
+	assert.Equal(t, roster.PlayerJoined, history.Events[2].Type)
+	assert.Equal(t, 40, history.Events[2].Roll20Id)
+
+	// The history is kept across calls
+	res, history = getHistory(t, "link=https://app.roll20.net/campaigns/details/1/my-campaign")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Len(t, history.Events, 3)
+
+	// But can be restricted to recent changes
+	res, history = getHistory(t, "gameId=1&since=2100-01-01T00:00:00Z")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Empty(t, history.Events)
+	mockServer.Close()
+}
+
+// An incomplete list of players isn't recorded
+func TestIncompleteAnswer(t *testing.T) {
+	storeDir := t.TempDir()
+	os.Setenv("ROSTER_STORE_DIR", storeDir)
+	mockServer, _ := SetupTestServer("assets/sample_campaign_missing_id.html")
+	res, history := getHistory(t, "gameId=1")
+	assert.Equal(t, http.StatusMultiStatus, res.StatusCode)
+	assert.Nil(t, history.TrackedSince)
+	assert.Empty(t, history.Events)
+	_, err := os.Stat(filepath.Join(storeDir, "1.json"))
+	assert.True(t, os.IsNotExist(err))
+	mockServer.Close()
+}
+
+func TestWrongQsParams(t *testing.T) {
+	os.Setenv("ROSTER_STORE_DIR", t.TempDir())
+	mockServer, _ := SetupTestServer("assets/sample_campaign_page.html")
+	var tests = []string{
+		"",
+		"gameId=sss",
+		"link=https://example.com/join/1/59lzQg",
+		"gameId=1&since=yesterday",
+		"wrong=;;;",
+	}
+	for _, qs := range tests {
+		t.Run(qs, func(t *testing.T) {
+			req := handler2.Request{
+				Body:        nil,
+				Header:      nil,
+				QueryString: qs,
+				Method:      "GET",
+				Host:        "",
+			}
+			res, _ := Handle(req)
+			assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		})
+	}
+	mockServer.Close()
+}
+
+// The store directory can't be created
+func TestStoreError(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	ioutil.WriteFile(file, []byte{}, 0o644)
+	os.Setenv("ROSTER_STORE_DIR", filepath.Join(file, "roster"))
+	mockServer, _ := SetupTestServer("assets/sample_campaign_page.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	fmt.Println(string(res.Body))
+	mockServer.Close()
+}
+
+// No env variables defined
+func TestNoEnv(t *testing.T) {
+	os.Unsetenv("ROLL20_BASE_URL")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, _ := Handle(req)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	fmt.Println(string(res.Body))
+
+}
+
+// End gracefully when the scrapper itself fails
+func TestScrapperLoginError(t *testing.T) {
+	os.Setenv("ROSTER_STORE_DIR", t.TempDir())
+	// env defined but wrong, the scrapper won't be able to login
+	os.Setenv("ROLL20_BASE_URL", "wrong")
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	fmt.Println(string(res.Body))
+
+}
+
+// End gracefully when the scrapper itself fails
+func TestScrappingError(t *testing.T) {
+	os.Setenv("ROSTER_STORE_DIR", t.TempDir())
+	mockServer := SetupLoginOnlyServer()
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	fmt.Println(string(res.Body))
+	mockServer.Close()
+
+}
@@ -1,79 +1,169 @@
 package function
 
 import (
-	"encoding/json"
 	"fmt"
 	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"handler/function/pkg/auth"
+	"handler/function/pkg/cache"
 	config_parser "handler/function/pkg/config-parser"
 	http_helpers "handler/function/pkg/http-helpers"
+	link_parser "handler/function/pkg/link-parser"
 	"handler/function/pkg/scrapper"
 	"log"
 	"net/http"
-	"net/url"
-	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-const QS_GAME_URL_NAME = "gameId"
+// Query parameters of get-summary
+type summaryQuery struct {
+	link_parser.GamesQuery
+	http_helpers.FormatQuery
+}
+
+// Path of the function, used as the instance of its errors
+const FUNCTION_PATH = "/get-summary"
+
+// How long scrapped summaries are served from the cache, when CACHE_TTL isn't defined
+const DEFAULT_CACHE_TTL = 15 * time.Minute
 
 // swagger:route GET /get-summary Summary get-summary
 //
 // Retrieve basic info about a roll20 campaign
 //
+// Name, image, description, game system, player count, creation date, last played date and next session.
+// Fields not displayed on the campaign page are null
 //     Produces:
 //     - application/json
+//     - application/x-ndjson
+//     - text/csv
+//     - application/yaml
+//     - application/problem+json
 //     Parameters:
 //       + name: gameId
 //         in: query
-//         description: Roll20 ID of the game to parse. For link https://app.roll20.net/join/1/59lzQg --> Game ID is "1"
-//         required: true
+//         description: Roll20 ID of the game to parse. For link https://app.roll20.net/join/1/59lzQg --> Game ID is "1". Several games can be requested at once by repeating gameId or link, or as comma separated lists, the response then being a map of CampaignResult keyed by game ID
+//         required: false
 //         type: integer
 //         format: int32
+//       + name: link
+//         in: query
+//         description: Roll20 link of the game, accepted instead of gameId. Either a join link (https://app.roll20.net/join/1/59lzQg), a campaign details or editor URL, or a bare game ID
+//         required: false
+//         type: string
+//       + name: format
+//         in: query
+//         description: Format of the response, overriding the Accept header. One of "json", "ndjson", "csv" or "yaml". Default is "json"
+//         required: false
+//         type: string
+//       + name: Cache-Control
+//         in: header
+//         description: Set to "no-cache" to scrap Roll20 even if a fresh result is cached. When cached data is served, the X-Cache response header is HIT or STALE, and Age tells how old it is
+//         required: false
+//         type: string
+//       + name: If-None-Match
+//         in: header
+//         description: ETag of a previous response. If the response would be the same, a 304 without body is answered instead
+//         required: false
+//         type: string
 // responses:
-//  200: []Player Complete list of players for the requested game
-//	400: ErrorTemplate Missing or invalid game ID provided
+//  200: Summary Overview of the requested game
+//  207: map[string]CampaignResult Several games were requested, and some of them couldn't be scrapped
+//  304: description: The caller already holds the current representation
+//	400: ErrorTemplate Missing or invalid game ID or link provided
+//  401: ErrorTemplate Authentication is enabled, and the caller didn't provide valid credentials
+//  403: ErrorTemplate The caller isn't allowed to access these games
+//  406: ErrorTemplate None of the accepted media types is supported
 //  500: ErrorTemplate Configuration error, either env variables missing or provided roll20 credentials invalid
 func Handle(req handler2.Request) (handler2.Response, error) {
 	log.Println("Get summary handler has been woken up")
 	var err error
+	// Callers are authenticated before anything else
+	identity, err := auth.Authenticate(req, FUNCTION_PATH)
+	if err != nil {
+		log.Printf("Unauthenticated call : %s\n", err)
+		return auth.NewAuthProblem(req, FUNCTION_PATH, err), err
+	}
 	// Retrieve runtime values from env & QS
 	var keys = []string{"ROLL20_USERNAME", "ROLL20_PASSWORD", "ROLL20_BASE_URL"}
 	values, err := config_parser.ParseEnv(keys)
 	if err != nil {
 		log.Printf("Invalid env : %s\n", err)
-		return handler2.Response{StatusCode: http.StatusInternalServerError, Body: http_helpers.FormatError("Unexpected error while parsing env")}, err
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.MissingConfiguration, "Some environment variables are missing"), err
+	}
+	var query summaryQuery
+	if err = http_helpers.BindQuery(req.QueryString, &query); err != nil {
+		log.Printf("Invalid QS : %s\n", err)
+		return http_helpers.NewBindingProblem(req, FUNCTION_PATH, err), err
 	}
-	qs, err := url.ParseQuery(req.QueryString)
+	format, ok := http_helpers.NegotiateFormat(req, query.Format)
+	if !ok {
+		return http_helpers.NewNotAcceptableProblem(req, FUNCTION_PATH), nil
+	}
+	c, err := cache.FromEnv(DEFAULT_CACHE_TTL)
 	if err != nil {
-		log.Printf("Invalid QS : %s. Error : %s \n", qs, err)
-		return handler2.Response{StatusCode: http.StatusBadRequest, Body: http_helpers.FormatError("Unexpected error while parsing qs")}, err
+		log.Printf("Invalid cache configuration : %s\n", err)
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.MissingConfiguration, "The cache is misconfigured"), err
 	}
-	gameId := qs.Get(QS_GAME_URL_NAME)
-	if _, err = strconv.Atoi(gameId); len(gameId) == 0 || err != nil {
-		log.Printf("Wrong gameid provided: %s. Error : %s \n", gameId, err)
-		errMessage := fmt.Sprintf("The provided gameId is invalid %s\n", gameId)
-		return handler2.Response{StatusCode: http.StatusBadRequest, Body: http_helpers.FormatError(errMessage)}, err
+	gameIds := query.Ids()
+	if err = auth.Authorize(identity, auth.Players, gameIds...); err != nil {
+		log.Printf("Forbidden call : %s\n", err)
+		return auth.NewAuthProblem(req, FUNCTION_PATH, err), err
 	}
-	log.Println("Now fetching summary for campaign " + gameId)
+	log.Printf("Now fetching summary for campaigns %s\n", strings.Join(gameIds, ","))
 
-	// Scrap the players from the game
-	s, err := scrapper.NewScrapper(values["ROLL20_BASE_URL"], &scrapper.Roll20Account{Login: values["ROLL20_USERNAME"], Password: values["ROLL20_PASSWORD"]}, nil)
-	if err != nil {
-		log.Printf("The scrapper instance couldn't be initialized. Error %s\n", err)
-		return handler2.Response{StatusCode: http.StatusInternalServerError, Body: http_helpers.FormatError("Unexpected error")}, err
+	// Scrap the summaries from the games, over a single Roll20 session
+	// Only logged in when something isn't cached
+	session := scrapper.NewSession(values["ROLL20_BASE_URL"], &scrapper.Roll20Account{Login: values["ROLL20_USERNAME"], Password: values["ROLL20_PASSWORD"]}, nil)
+	noCache := cache.NoCache(req)
+	if query.IsBatch() {
+		batch := http_helpers.NewBatch(gameIds)
+		var outcomes []*cache.Outcome
+		var mutex sync.Mutex
+		session.ForEachCampaign(gameIds, func(gameId string) {
+			result, outcome, _ := getCachedSummary(c, noCache, session, gameId)
+			batch.Set(gameId, result)
+			mutex.Lock()
+			outcomes = append(outcomes, outcome)
+			mutex.Unlock()
+		})
+		res, err := http_helpers.NewConditionalResponse(req, batch.Status(), format, batch, time.Time{})
+		cache.SetHeaders(&res, outcomes...)
+		return res, err
 	}
+	result, outcome, err := getCachedSummary(c, noCache, session, gameIds[0])
+	if result.Error != nil {
+		return http_helpers.NewProblemResponse(req, result.Error), err
+	}
+	res, err := http_helpers.NewConditionalResponse(req, result.Status, format, result.Data, time.Time{})
+	cache.SetHeaders(&res, outcome)
+	return res, err
+}
+
+// Summary of a single game, from the cache while it is fresh.
+// Roll20 is only logged in to on a miss, a failed login being a failed scrapping
+func getCachedSummary(c *cache.Cache, noCache bool, session *scrapper.Session, gameId string) (*http_helpers.CampaignResult, *cache.Outcome, error) {
+	return c.Fetch("get-summary/"+gameId, noCache, &scrapper.Summary{}, func() (*http_helpers.CampaignResult, error) {
+		s, err := session.Scrapper()
+		if err != nil {
+			// Roll20 being down, a stale result may still be served
+			log.Printf("The scrapper instance couldn't be initialized. Error %s\n", err)
+			problem := http_helpers.Problem(FUNCTION_PATH, http.StatusInternalServerError, http_helpers.Roll20LoginFailed, "The bot account couldn't log in to Roll20")
+			return &http_helpers.CampaignResult{Status: problem.Status, Error: problem}, err
+		}
+		return getSummary(s, gameId)
+	})
+}
+
+// Scrap the summary of a single game
+func getSummary(s *scrapper.Scrapper, gameId string) (*http_helpers.CampaignResult, error) {
 	summary, err := s.GetSummary(gameId)
 	if err != nil {
 		log.Printf("Unexpected error : %s\n", err.Error())
-		return handler2.Response{StatusCode: http.StatusInternalServerError, Body: http_helpers.FormatError(err.Error())}, err
+		problem := http_helpers.Problem(FUNCTION_PATH, http.StatusInternalServerError, http_helpers.ScrappingFailed, fmt.Sprintf("Roll20 couldn't be scrapped for game %s", gameId))
+		return &http_helpers.CampaignResult{Status: problem.Status, Error: problem}, err
 	}
 	log.Println("Summary have been successfully scrapped from campaign " + gameId)
-	// If all players have been picked up, send them back with a 200
-	summaryJson, err := json.Marshal(summary)
-	return handler2.Response{
-		StatusCode: http.StatusOK,
-		Body:       summaryJson,
-		Header: map[string][]string{
-			"Content-type": {"application/json"},
-		},
-	}, err
+	return &http_helpers.CampaignResult{Status: http.StatusOK, Data: summary}, nil
 }
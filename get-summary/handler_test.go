@@ -5,6 +5,7 @@ import (
 	"fmt"
 	handler2 "github.com/openfaas/templates-sdk/go-http"
 	"github.com/stretchr/testify/assert"
+	http_helpers "handler/function/pkg/http-helpers"
 	"handler/function/pkg/scrapper"
 	"io/ioutil"
 	"net/http"
@@ -13,6 +14,7 @@ import (
 	"path"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"testing"
 )
 
@@ -41,6 +43,44 @@ func SetupTestServer(campaignDataPath string) *httptest.Server {
 
 }
 
+// Server answering with the sample details page for every game, but game 2
+func SetupBatchServer(campaignDataPath string) *httptest.Server {
+	_, filename, _, _ := runtime.Caller(0)
+	dir := path.Dir(filename)
+	sampleData, err := ioutil.ReadFile(path.Join(dir, campaignDataPath))
+	// On CI, the path may be wrong because the import path is different
+	if err != nil {
+		sampleData, _ = ioutil.ReadFile(path.Join(dir, "../", campaignDataPath))
+	}
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/campaigns/details/2"):
+			w.WriteHeader(500)
+		case strings.Contains(r.URL.Path, "/campaigns/details/"):
+			w.Write(sampleData)
+		default:
+			w.WriteHeader(200)
+		}
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	return mockServer
+}
+
+// Roll20 being down, every request fails. Requests are counted
+func SetupDownServer() (*httptest.Server, *int32) {
+	var requests int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	return mockServer, &requests
+}
+
 // Server only allowing the scrapper to log in
 func SetupLoginOnlyServer() *httptest.Server {
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -107,6 +147,66 @@ func TestCompleteAnswer(t *testing.T) {
 
 }
 
+// Optional fields are serialized as null when missing
+func TestRichAnswer(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_page_full_summary.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var summary scrapper.Summary
+	err = json.Unmarshal(res.Body, &summary)
+	assert.Nil(t, err)
+	assert.Equal(t, "The Witcher TRPG", *summary.System)
+	assert.Equal(t, 7, *summary.PlayerCount)
+	assert.NotNil(t, summary.NextSession)
+	mockServer.Close()
+
+	mockServer = SetupTestServer("assets/sample_campaign_page_partial_summary.html")
+	res, err = Handle(req)
+	assert.Nil(t, err)
+	assert.Contains(t, string(res.Body), `"nextSession":null`)
+	mockServer.Close()
+}
+
+// The game can be designated by a link instead of its ID
+func TestGameLink(t *testing.T) {
+	var tests = []struct {
+		qs         string
+		statusCode int
+	}{
+		{"link=1", http.StatusOK},
+		{"link=https://app.roll20.net/join/1/59lzQg", http.StatusOK},
+		{"link=https://app.roll20.net/campaigns/details/1/my-campaign", http.StatusOK},
+		{"gameId=1&link=https://app.roll20.net/editor/setcampaign/1", http.StatusOK},
+		{"link=https://example.com/join/1/59lzQg", http.StatusBadRequest},
+		{"link=https://app.roll20.net/users/2", http.StatusBadRequest},
+		{"gameId=2&link=https://app.roll20.net/join/1/59lzQg", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.qs, func(t *testing.T) {
+			mockServer := SetupTestServer("assets/sample_campaign_page.html")
+			req := handler2.Request{
+				Body:        nil,
+				Header:      nil,
+				QueryString: tt.qs,
+				Method:      "GET",
+				Host:        "",
+			}
+			res, _ := Handle(req)
+			assert.Equal(t, tt.statusCode, res.StatusCode)
+			mockServer.Close()
+		})
+	}
+}
+
 // No env variables defined
 func TestNoEnv(t *testing.T) {
 	os.Unsetenv("ROLL20_BASE_URL")
@@ -192,3 +292,138 @@ func TestScrappingError(t *testing.T) {
 	mockServer.Close()
 
 }
+
+// Several games at once. One failing game doesn't fail the others
+func TestBatch(t *testing.T) {
+	mockServer := SetupBatchServer("assets/sample_campaign_page.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1,2&link=https://app.roll20.net/campaigns/details/3/name",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusMultiStatus, res.StatusCode)
+	var batch map[string]struct {
+		Status int                         `json:"status"`
+		Data   *scrapper.Summary           `json:"data"`
+		Error  *http_helpers.ErrorTemplate `json:"error"`
+	}
+	err = json.Unmarshal(res.Body, &batch)
+	assert.Nil(t, err)
+	assert.Len(t, batch, 3)
+	for _, id := range []string{"1", "3"} {
+		assert.Equal(t, http.StatusOK, batch[id].Status)
+		assert.NotNil(t, batch[id].Data)
+		assert.Nil(t, batch[id].Error)
+	}
+	assert.Equal(t, http.StatusInternalServerError, batch["2"].Status)
+	assert.Nil(t, batch["2"].Data)
+	assert.Equal(t, http_helpers.ScrappingFailed, batch["2"].Error.Code)
+	mockServer.Close()
+}
+
+// Summaries are served from the cache while fresh, and when scrapping fails
+func TestCache(t *testing.T) {
+	os.Setenv("CACHE_BACKEND", "file")
+	os.Setenv("CACHE_DIR", t.TempDir())
+	defer os.Unsetenv("CACHE_BACKEND")
+	defer os.Unsetenv("CACHE_DIR")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "GET",
+		Host:        "",
+	}
+	mockServer := SetupTestServer("assets/sample_campaign_page.html")
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"MISS"}, res.Header["X-Cache"])
+	scrapped := res.Body
+
+	res, err = Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, []string{"HIT"}, res.Header["X-Cache"])
+	assert.Equal(t, []string{"0"}, res.Header["Age"])
+	assert.Equal(t, scrapped, res.Body)
+	mockServer.Close()
+
+	// Roll20 is now failing, the cached summary is still served even though the caller bypasses the cache
+	mockServer = SetupLoginOnlyServer()
+	req.Header = http.Header{"Cache-Control": {"no-cache"}}
+	res, err = Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, []string{"STALE"}, res.Header["X-Cache"])
+	assert.Equal(t, scrapped, res.Body)
+
+	// Unknown games still fail
+	req.QueryString = "gameId=1,2"
+	res, err = Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusMultiStatus, res.StatusCode)
+	assert.Equal(t, []string{"STALE"}, res.Header["X-Cache"])
+	mockServer.Close()
+
+	os.Setenv("CACHE_BACKEND", "redis")
+	res, err = Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+}
+
+// Cached summaries don't cost a login, and are still served when Roll20 can't be logged in to
+func TestCacheLoginFailed(t *testing.T) {
+	os.Setenv("CACHE_BACKEND", "file")
+	os.Setenv("CACHE_DIR", t.TempDir())
+	defer os.Unsetenv("CACHE_BACKEND")
+	defer os.Unsetenv("CACHE_DIR")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "GET",
+		Host:        "",
+	}
+	mockServer := SetupTestServer("assets/sample_campaign_page.html")
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"MISS"}, res.Header["X-Cache"])
+	scrapped := res.Body
+	mockServer.Close()
+
+	mockServer, requests := SetupDownServer()
+	defer mockServer.Close()
+	res, err = Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, []string{"HIT"}, res.Header["X-Cache"])
+	assert.Equal(t, int32(0), atomic.LoadInt32(requests))
+
+	req.Header = http.Header{"Cache-Control": {"no-cache"}}
+	res, err = Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, []string{"STALE"}, res.Header["X-Cache"])
+	assert.Equal(t, scrapped, res.Body)
+	assert.Equal(t, int32(1), atomic.LoadInt32(requests))
+
+	// A failed login isn't retried for each game of a batch
+	req.QueryString = "gameId=1,2,3"
+	res, err = Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusMultiStatus, res.StatusCode)
+	var batch map[string]struct {
+		Status int                         `json:"status"`
+		Error  *http_helpers.ErrorTemplate `json:"error"`
+	}
+	err = json.Unmarshal(res.Body, &batch)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, batch["1"].Status)
+	assert.Equal(t, http_helpers.Roll20LoginFailed, batch["2"].Error.Code)
+	assert.Equal(t, http_helpers.Roll20LoginFailed, batch["3"].Error.Code)
+	assert.Equal(t, int32(2), atomic.LoadInt32(requests))
+}
@@ -0,0 +1,27 @@
+package function
+
+import (
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"handler/function/pkg/health"
+	http_helpers "handler/function/pkg/http-helpers"
+	"net/http"
+)
+
+// Path of the function, used as the instance of its errors
+const FUNCTION_PATH = "/healthz"
+
+// swagger:route GET /healthz Health healthz
+//
+// Tell whether the function process is alive.
+//
+// Meant for liveness probes. Nothing is checked besides the process answering, so it isn't authenticated
+// and never reaches Roll20. See readyz for the deep checks
+//     Produces:
+//     - application/json
+// responses:
+//  200: Readiness The process is alive. No check is listed
+func Handle(req handler2.Request) (handler2.Response, error) {
+	res, err := http_helpers.NewDataResponse(http.StatusOK, http_helpers.JSON, &health.Report{Status: health.Pass, Checks: []health.Check{}})
+	res.Header["Cache-Control"] = []string{"no-store"}
+	return res, err
+}
@@ -0,0 +1,24 @@
+package function
+
+import (
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+// Alive without any configuration
+func TestHealthz(t *testing.T) {
+	t.Setenv("AUTH_SECRETS_DIR", t.TempDir())
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.JSONEq(t, `{"status":"pass","checks":[]}`, string(res.Body))
+}
@@ -0,0 +1,87 @@
+package function
+
+import (
+	"encoding/json"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"github.com/stretchr/testify/assert"
+	http_helpers "handler/function/pkg/http-helpers"
+	"handler/function/pkg/jobs"
+	"handler/function/pkg/scrapper"
+	"net/http"
+	"testing"
+)
+
+// Store jobs in a temporary directory
+func SetupJobStore(t *testing.T) jobs.Store {
+	t.Setenv("JOB_STORE", "file")
+	t.Setenv("JOB_STORE_DIR", t.TempDir())
+	store, err := jobs.FromEnv()
+	assert.NoError(t, err)
+	return store
+}
+
+func resultRequest(jobId string) handler2.Request {
+	return handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "jobId=" + jobId,
+		Method:      "GET",
+		Host:        "",
+	}
+}
+
+func TestJobResult(t *testing.T) {
+	store := SetupJobStore(t)
+	job, _ := jobs.NewJob(jobs.MessagesJob, "1", "")
+	job.Status = jobs.Succeeded
+	assert.NoError(t, store.Save(job))
+	messages := []scrapper.Message{{PlayerId: "-N1", Content: "Hello", Priority: 1651521600000}}
+	data, _ := json.Marshal(messages)
+	assert.NoError(t, store.SaveResult(job.Id, data))
+
+	res, err := Handle(resultRequest(job.Id))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var found []scrapper.Message
+	assert.NoError(t, json.Unmarshal(res.Body, &found))
+	assert.Equal(t, messages, found)
+	assert.NotEmpty(t, res.Header["ETag"])
+	assert.Equal(t, []string{"Mon, 02 May 2022 20:00:00 GMT"}, res.Header["Last-Modified"])
+}
+
+func TestJobResultNotFinished(t *testing.T) {
+	store := SetupJobStore(t)
+	job, _ := jobs.NewJob(jobs.MessagesJob, "1", "")
+	job.Status = jobs.Running
+	assert.NoError(t, store.Save(job))
+
+	res, err := Handle(resultRequest(job.Id))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+	var problem http_helpers.ErrorTemplate
+	assert.NoError(t, json.Unmarshal(res.Body, &problem))
+	assert.Equal(t, http_helpers.JobNotFinished, problem.Code)
+}
+
+func TestJobResultFailed(t *testing.T) {
+	store := SetupJobStore(t)
+	job, _ := jobs.NewJob(jobs.MessagesJob, "1", "")
+	job.Status = jobs.Failed
+	job.Error = http_helpers.Problem("/start-scrape", http.StatusInternalServerError, http_helpers.ScrappingFailed, "Roll20 couldn't be scrapped for game 1")
+	assert.NoError(t, store.Save(job))
+
+	res, err := Handle(resultRequest(job.Id))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	var problem http_helpers.ErrorTemplate
+	assert.NoError(t, json.Unmarshal(res.Body, &problem))
+	assert.Equal(t, http_helpers.ScrappingFailed, problem.Code)
+}
+
+func TestJobResultNotFound(t *testing.T) {
+	SetupJobStore(t)
+	job, _ := jobs.NewJob(jobs.MessagesJob, "1", "")
+	res, err := Handle(resultRequest(job.Id))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
@@ -0,0 +1,101 @@
+package function
+
+import (
+	"fmt"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"handler/function/pkg/auth"
+	http_helpers "handler/function/pkg/http-helpers"
+	"handler/function/pkg/jobs"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Query parameters of job-status
+type statusQuery struct {
+	http_helpers.FormatQuery
+	JobId string `qs:"jobId" required:"true"`
+}
+
+// Validate Job IDs are generated, anything else can't be a job
+func (q *statusQuery) Validate(errs *http_helpers.ValidationError) {
+	if len(q.JobId) != 0 && !jobs.IsValidId(q.JobId) {
+		errs.Add("jobId", fmt.Sprintf("Invalid value %q. Should be an ID returned by start-scrape", q.JobId))
+	}
+}
+
+// Path of the function, used as the instance of its errors
+const FUNCTION_PATH = "/job-status"
+
+// swagger:route GET /job-status Jobs job-status
+//
+// Retrieve the status and progress of a job started by start-scrape
+//
+// Jobs are only visible to the caller having started them
+//     Produces:
+//     - application/json
+//     - application/x-ndjson
+//     - text/csv
+//     - application/yaml
+//     - application/problem+json
+//     Parameters:
+//       + name: jobId
+//         in: query
+//         description: ID of the job, as returned by start-scrape
+//         required: true
+//         type: string
+//       + name: format
+//         in: query
+//         description: Format of the response, overriding the Accept header. One of "json", "ndjson", "csv" or "yaml". Default is "json"
+//         required: false
+//         type: string
+//       + name: If-None-Match
+//         in: header
+//         description: ETag of a previous response. If the response would be the same, a 304 without body is answered instead
+//         required: false
+//         type: string
+// responses:
+//  200: Job Status of the job. Once succeeded, its result can be retrieved with job-result
+//  304: description: The caller already holds the current representation
+//	400: ErrorTemplate Missing or invalid job ID provided
+//  401: ErrorTemplate Authentication is enabled, and the caller didn't provide valid credentials
+//  404: ErrorTemplate There is no such job, or it finished more than JOB_TTL ago
+//  406: ErrorTemplate None of the accepted media types is supported
+//  500: ErrorTemplate The job store is misconfigured or unavailable
+func Handle(req handler2.Request) (handler2.Response, error) {
+	log.Println("Job status handler has been woken up")
+	var err error
+	// Callers are authenticated before anything else
+	identity, err := auth.Authenticate(req, FUNCTION_PATH)
+	if err != nil {
+		log.Printf("Unauthenticated call : %s\n", err)
+		return auth.NewAuthProblem(req, FUNCTION_PATH, err), err
+	}
+	var query statusQuery
+	if err = http_helpers.BindQuery(req.QueryString, &query); err != nil {
+		log.Printf("Invalid QS : %s\n", err)
+		return http_helpers.NewBindingProblem(req, FUNCTION_PATH, err), err
+	}
+	format, ok := http_helpers.NegotiateFormat(req, query.Format)
+	if !ok {
+		return http_helpers.NewNotAcceptableProblem(req, FUNCTION_PATH), nil
+	}
+	store, err := jobs.FromEnv()
+	if err != nil {
+		log.Printf("Invalid job store : %s\n", err)
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.MissingConfiguration, "The job store is misconfigured"), err
+	}
+	owner := ""
+	if identity != nil {
+		owner = identity.Name
+	}
+	job, err := jobs.Find(store, query.JobId, owner)
+	if err != nil {
+		log.Printf("Couldn't read job %s : %s\n", query.JobId, err)
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.StorageFailed, fmt.Sprintf("Job %s couldn't be read", query.JobId)), err
+	}
+	if job == nil {
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusNotFound, http_helpers.JobNotFound, fmt.Sprintf("There is no job %s", query.JobId)), nil
+	}
+	return http_helpers.NewConditionalResponse(req, http.StatusOK, format, job, time.Time{})
+}
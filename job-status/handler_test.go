@@ -0,0 +1,73 @@
+package function
+
+import (
+	"encoding/json"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"github.com/stretchr/testify/assert"
+	"handler/function/pkg/jobs"
+	"net/http"
+	"testing"
+)
+
+// Store jobs in a temporary directory
+func SetupJobStore(t *testing.T) jobs.Store {
+	t.Setenv("JOB_STORE", "file")
+	t.Setenv("JOB_STORE_DIR", t.TempDir())
+	store, err := jobs.FromEnv()
+	assert.NoError(t, err)
+	return store
+}
+
+func TestJobStatus(t *testing.T) {
+	store := SetupJobStore(t)
+	job, _ := jobs.NewJob(jobs.MessagesJob, "1", "")
+	job.Status = jobs.Running
+	job.Progress = jobs.JobProgress{PagesDone: 2, PagesTotal: 5}
+	assert.NoError(t, store.Save(job))
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "jobId=" + job.Id,
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var found jobs.Job
+	assert.NoError(t, json.Unmarshal(res.Body, &found))
+	assert.Equal(t, job.Id, found.Id)
+	assert.Equal(t, jobs.Running, found.Status)
+	assert.Equal(t, job.Progress, found.Progress)
+}
+
+func TestJobStatusNotFound(t *testing.T) {
+	SetupJobStore(t)
+	job, _ := jobs.NewJob(jobs.MessagesJob, "1", "")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "jobId=" + job.Id,
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestJobStatusInvalidId(t *testing.T) {
+	SetupJobStore(t)
+	for _, qs := range []string{"", "jobId=../../etc/passwd", "jobId=42"} {
+		req := handler2.Request{
+			Body:        nil,
+			Header:      nil,
+			QueryString: qs,
+			Method:      "GET",
+			Host:        "",
+		}
+		res, err := Handle(req)
+		assert.Error(t, err, qs)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode, qs)
+	}
+}
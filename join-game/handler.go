@@ -3,28 +3,62 @@ package function
 import (
 	"fmt"
 	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"handler/function/pkg/auth"
 	config_parser "handler/function/pkg/config-parser"
 	http_helpers "handler/function/pkg/http-helpers"
+	link_parser "handler/function/pkg/link-parser"
 	"handler/function/pkg/scrapper"
+	"log"
 	"net/http"
-	"net/url"
 )
 
-const QS_ID_URL_NAME = "gameId"
-const QS_CODE_URL_NAME = "gameCode"
+// Query parameters of join-game
+type joinQuery struct {
+	http_helpers.FormatQuery
+	GameId   string `qs:"gameId"`
+	GameCode string `qs:"gameCode"`
+	Link     string `qs:"link"`
+	game     *link_parser.GameLink
+}
+
+// Validate Resolve the game to join, either from a join link or from its ID and join code
+func (q *joinQuery) Validate(errs *http_helpers.ValidationError) {
+	game, err := link_parser.Resolve(q.GameId, q.GameCode, q.Link)
+	if err != nil {
+		field := "gameId"
+		if len(q.Link) != 0 {
+			field = "link"
+		}
+		errs.Add(field, err.Error())
+		return
+	}
+	if len(game.GameCode) == 0 {
+		errs.Add("gameCode", "The gameCode is missing. Either provide a gameCode or a join link")
+		return
+	}
+	q.game = game
+}
+
+// Path of the function, used as the instance of its errors
+const FUNCTION_PATH = "/join-game"
 
 // swagger:route GET /join-game Players join-game
 //
 // Makes the bot account join the game as a player
 //
-// This is a mandatory step for every other request, as the bot account won't have access to a game before joining it
+// This is a mandatory step for every other request, as the bot account won't have access to a game before joining it.
+// The join is only reported as successful once the bot account is listed in the game players
 //     Produces:
 //     - application/json
+//     - application/x-ndjson
+//     - text/csv
+//     - application/yaml
+//     - application/problem+json
 //     Parameters:
 //       + name: gameId
 //         in: query
 //         description: Roll20 ID of the game to parse. For link https://app.roll20.net/join/1/59lzQg --> Game ID is "1"
-//         required: true
+//         required: false
 //		   example: 1
 //         type: integer
 //         format: int32
@@ -32,47 +66,72 @@ const QS_CODE_URL_NAME = "gameCode"
 //         in: query
 //         description: Roll20 Code to join the game. This is usually the last part of the join link. For link https://app.roll20.net/join/1/59lzQg --> Join code is "59lzQg"
 //		   example: "59lzQg"
-//         required: true
+//         required: false
+//         type: string
+//       + name: link
+//         in: query
+//         description: Roll20 join link of the game, accepted instead of gameId and gameCode. Ex https://app.roll20.net/join/1/59lzQg
+//         required: false
+//         type: string
+//       + name: format
+//         in: query
+//         description: Format of the response, overriding the Accept header. One of "json", "ndjson", "csv" or "yaml". Default is "json"
+//         required: false
 //         type: string
 // responses:
-//  204: description: Game successfully joined
-//	400: ErrorTemplate Missing or invalid game ID or gameCode provided
+//  200: JoinResult The game had already been joined by the bot account
+//  201: JoinResult Game successfully joined
+//	400: ErrorTemplate Missing or invalid game ID, gameCode or link provided, or Roll20 refused the join (expired code...)
+//  401: ErrorTemplate Authentication is enabled, and the caller didn't provide valid credentials
+//  403: ErrorTemplate The caller isn't allowed to make the bot join this game
+//  406: ErrorTemplate None of the accepted media types is supported
 //  500: ErrorTemplate Configuration error, either env variables missing or provided roll20 credentials invalid
 func Handle(req handler2.Request) (handler2.Response, error) {
 	var err error
+	// Callers are authenticated before anything else
+	identity, err := auth.Authenticate(req, FUNCTION_PATH)
+	if err != nil {
+		log.Printf("Unauthenticated call : %s\n", err)
+		return auth.NewAuthProblem(req, FUNCTION_PATH, err), err
+	}
 	// Retrieve runtime values from env & QS
 	var keys = []string{"ROLL20_USERNAME", "ROLL20_PASSWORD", "ROLL20_BASE_URL"}
 	values, err := config_parser.ParseEnv(keys)
 	if err != nil {
-		return handler2.Response{StatusCode: http.StatusInternalServerError, Body: http_helpers.FormatError("Unexpected error while parsing env")}, err
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.MissingConfiguration, "Some environment variables are missing"), err
 	}
-	qs, err := url.ParseQuery(req.QueryString)
-	if err != nil {
-		return handler2.Response{StatusCode: http.StatusBadRequest, Body: http_helpers.FormatError("Unexpected error while parsing qs")}, err
+	var query joinQuery
+	if err = http_helpers.BindQuery(req.QueryString, &query); err != nil {
+		return http_helpers.NewBindingProblem(req, FUNCTION_PATH, err), nil
 	}
-	gameId := qs.Get(QS_ID_URL_NAME)
-	if len(gameId) == 0 {
-		err = fmt.Errorf("The provided gameId is invalid %s\n", gameId)
-		return handler2.Response{StatusCode: http.StatusBadRequest, Body: http_helpers.FormatError(err.Error())}, nil
+	format, ok := http_helpers.NegotiateFormat(req, query.Format)
+	if !ok {
+		return http_helpers.NewNotAcceptableProblem(req, FUNCTION_PATH), nil
 	}
-	gameCode := qs.Get(QS_CODE_URL_NAME)
-	if len(gameCode) == 0 {
-		err = fmt.Errorf("The provided gameCode is invalid %s\n", gameCode)
-		return handler2.Response{StatusCode: http.StatusBadRequest, Body: http_helpers.FormatError(err.Error())}, nil
+	gameId, gameCode := query.game.GameId, query.game.GameCode
+	if err = auth.Authorize(identity, auth.Join, gameId); err != nil {
+		log.Printf("Forbidden call : %s\n", err)
+		return auth.NewAuthProblem(req, FUNCTION_PATH, err), err
 	}
 
 	// Join the roll20 game
 	s, err := scrapper.NewScrapper(values["ROLL20_BASE_URL"], &scrapper.Roll20Account{Login: values["ROLL20_USERNAME"], Password: values["ROLL20_PASSWORD"]}, nil)
 	if err != nil {
-		return handler2.Response{StatusCode: http.StatusInternalServerError, Body: http_helpers.FormatError("Unexpected error")}, err
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.Roll20LoginFailed, "The bot account couldn't log in to Roll20"), err
 	}
-	err = s.JoinGame(gameId, gameCode)
+	result, err := s.JoinGame(gameId, gameCode)
 	if err != nil {
-		errMessage := fmt.Sprintf("Couldn't join roll20 game with gameid %s and gamecode %s. Reason : %s\n", gameId, gameCode, err)
-		return handler2.Response{StatusCode: http.StatusBadRequest, Body: http_helpers.FormatError(errMessage)}, err
+		log.Printf("Couldn't join roll20 game with gameid %s and gamecode %s. Reason : %s\n", gameId, gameCode, err)
+		if _, ok := err.(*scrapper.JoinRefusedError); ok {
+			return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusBadRequest, http_helpers.JoinRefused, fmt.Sprintf("Roll20 didn't let the bot account in game %s. Is the join code %s still valid ?", gameId, gameCode)), err
+		}
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.ScrappingFailed, fmt.Sprintf("The bot account couldn't join game %s", gameId)), err
 	}
 
-	return handler2.Response{
-		StatusCode: http.StatusNoContent,
-	}, err
+	// Joining an already joined game is fine, but nothing has been created
+	statusCode := http.StatusCreated
+	if result.AlreadyJoined {
+		statusCode = http.StatusOK
+	}
+	return http_helpers.NewDataResponse(statusCode, format, result)
 }
@@ -1,9 +1,11 @@
 package function
 
 import (
+	"encoding/json"
 	"fmt"
 	handler2 "github.com/openfaas/templates-sdk/go-http"
 	"github.com/stretchr/testify/assert"
+	"handler/function/pkg/scrapper"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -34,11 +36,41 @@ func SetupTestServer(campaignDataPath string) *httptest.Server {
 
 }
 
+// Server listing the bot account in the campaign players only once the join link has been followed
+func SetupJoinServer(honourJoin bool) *httptest.Server {
+	_, filename, _, _ := runtime.Caller(0)
+	dir := path.Dir(filename)
+	after, _ := ioutil.ReadFile(path.Join(dir, "../assets/sample_campaign_page.html"))
+	// The bot account (ID 2) isn't part of the players yet
+	before := []byte(strings.Replace(string(after), `href="/users/2"`, `href="/users/20"`, 1))
+	joined := false
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "/join/"):
+			joined = honourJoin
+			w.WriteHeader(200)
+		case strings.Contains(r.URL.Path, "/campaigns/details/") && joined:
+			w.Write(after)
+		case strings.Contains(r.URL.Path, "/campaigns/details/"):
+			w.Write(before)
+		default:
+			w.WriteHeader(200)
+		}
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	return mockServer
+}
+
 // Server only allowing the scrapper to log in
 func SetupLoginOnlyServer() *httptest.Server {
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if strings.Contains(r.URL.Path, "/join") {
 			w.WriteHeader(500)
+		} else if strings.Contains(r.URL.Path, "/campaigns/details/") {
+			// Game not joined yet, its details are out of reach
+			w.WriteHeader(http.StatusForbidden)
 		} else {
 			w.WriteHeader(200)
 		}
@@ -59,7 +91,7 @@ func TestQsParams(t *testing.T) {
 		{"", "", http.StatusBadRequest},
 		{"gg", "", http.StatusBadRequest},
 		{"", "gg", http.StatusBadRequest},
-		{"30000", "dsdsds", http.StatusNoContent},
+		{"30000", "dsdsds", http.StatusOK},
 	}
 
 	for _, tt := range tests {
@@ -162,3 +194,108 @@ func TestScrappingError(t *testing.T) {
 	mockServer.Close()
 
 }
+
+// The bot account wasn't part of the game yet
+func TestNewlyJoined(t *testing.T) {
+	mockServer := SetupJoinServer(true)
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=5632681&gameCode=59lzQg",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	var result scrapper.JoinResult
+	err = json.Unmarshal(res.Body, &result)
+	assert.Nil(t, err)
+	assert.Equal(t, "5632681", result.GameId)
+	assert.False(t, result.AlreadyJoined)
+	mockServer.Close()
+}
+
+// Roll20 answered 200 to the join link, but the bot account still isn't listed
+func TestExpiredCode(t *testing.T) {
+	mockServer := SetupJoinServer(false)
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=5632681&gameCode=expired",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	fmt.Println(string(res.Body))
+	mockServer.Close()
+}
+
+// The game ID and join code can be given as a single join link
+func TestJoinLink(t *testing.T) {
+	var tests = []struct {
+		qs         string
+		statusCode int
+	}{
+		{"link=https://app.roll20.net/join/5632681/59lzQg", http.StatusCreated},
+		{"link=https://app.roll20.net/campaigns/details/5632681/my-campaign&gameCode=59lzQg", http.StatusCreated},
+		{"link=https://app.roll20.net/campaigns/details/5632681/my-campaign", http.StatusBadRequest},
+		{"link=https://app.roll20.net/join/5632681/59lzQg&gameCode=other", http.StatusBadRequest},
+		{"link=https://app.roll20.net/join/5632681/59lzQg&gameId=1", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.qs, func(t *testing.T) {
+			mockServer := SetupJoinServer(true)
+			req := handler2.Request{
+				Body:        nil,
+				Header:      nil,
+				QueryString: tt.qs,
+				Method:      "GET",
+				Host:        "",
+			}
+			res, _ := Handle(req)
+			assert.Equal(t, tt.statusCode, res.StatusCode)
+			mockServer.Close()
+		})
+	}
+}
+
+// Once keys are configured, only their holders can make the bot join a game
+func TestAuthentication(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(path.Join(dir, "api-keys"), []byte("team-a:key-a\n"), 0o600)
+	assert.Nil(t, err)
+	os.Setenv("AUTH_SECRETS_DIR", dir)
+	defer os.Unsetenv("AUTH_SECRETS_DIR")
+	mockServer := SetupJoinServer(true)
+	var tests = []struct {
+		header     http.Header
+		statusCode int
+	}{
+		{nil, http.StatusUnauthorized},
+		{http.Header{"X-Api-Key": {"key-b"}}, http.StatusUnauthorized},
+		{http.Header{"X-Api-Key": {"key-a"}}, http.StatusCreated},
+		// Already joined by the previous call
+		{http.Header{"Authorization": {"Bearer key-a"}}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := handler2.Request{
+			Body:        nil,
+			Header:      tt.header,
+			QueryString: "gameId=5632681&gameCode=59lzQg",
+			Method:      "GET",
+			Host:        "",
+		}
+		res, _ := Handle(req)
+		assert.Equal(t, tt.statusCode, res.StatusCode)
+		if tt.statusCode == http.StatusUnauthorized {
+			assert.Equal(t, []string{"application/problem+json"}, res.Header["Content-type"])
+			assert.NotEmpty(t, res.Header["WWW-Authenticate"])
+		}
+	}
+	mockServer.Close()
+}
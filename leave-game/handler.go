@@ -0,0 +1,87 @@
+package function
+
+import (
+	"fmt"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"handler/function/pkg/auth"
+	config_parser "handler/function/pkg/config-parser"
+	http_helpers "handler/function/pkg/http-helpers"
+	link_parser "handler/function/pkg/link-parser"
+	"handler/function/pkg/scrapper"
+	"log"
+	"net/http"
+)
+
+// Path of the function, used as the instance of its errors
+const FUNCTION_PATH = "/leave-game"
+
+// swagger:route GET /leave-game Players leave-game
+//
+// Makes the bot account leave a game it has joined
+//
+// This is the counterpart of join-game. The bot account must not be the creator of the game
+//     Produces:
+//     - application/json
+//     - application/problem+json
+//     Parameters:
+//       + name: gameId
+//         in: query
+//         description: Roll20 ID of the game to leave. For link https://app.roll20.net/join/1/59lzQg --> Game ID is "1"
+//         required: false
+//		   example: 1
+//         type: integer
+//         format: int32
+//       + name: link
+//         in: query
+//         description: Roll20 link of the game, accepted instead of gameId. Either a join link (https://app.roll20.net/join/1/59lzQg), a campaign details or editor URL, or a bare game ID
+//         required: false
+//         type: string
+// responses:
+//  204: description: Game successfully left
+//	400: ErrorTemplate Missing or invalid game ID or link provided
+//  401: ErrorTemplate Authentication is enabled, and the caller didn't provide valid credentials
+//  403: ErrorTemplate The caller isn't allowed to make the bot leave this game
+//	404: ErrorTemplate The game hasn't been joined by the bot account
+//  500: ErrorTemplate Configuration error, either env variables missing or provided roll20 credentials invalid, or the game couldn't be left
+func Handle(req handler2.Request) (handler2.Response, error) {
+	var err error
+	// Callers are authenticated before anything else
+	identity, err := auth.Authenticate(req, FUNCTION_PATH)
+	if err != nil {
+		log.Printf("Unauthenticated call : %s\n", err)
+		return auth.NewAuthProblem(req, FUNCTION_PATH, err), err
+	}
+	// Retrieve runtime values from env & QS
+	var keys = []string{"ROLL20_USERNAME", "ROLL20_PASSWORD", "ROLL20_BASE_URL"}
+	values, err := config_parser.ParseEnv(keys)
+	if err != nil {
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.MissingConfiguration, "Some environment variables are missing"), err
+	}
+	var query link_parser.GameQuery
+	if err = http_helpers.BindQuery(req.QueryString, &query); err != nil {
+		return http_helpers.NewBindingProblem(req, FUNCTION_PATH, err), nil
+	}
+	gameId := query.Game().GameId
+	if err = auth.Authorize(identity, auth.Join, gameId); err != nil {
+		log.Printf("Forbidden call : %s\n", err)
+		return auth.NewAuthProblem(req, FUNCTION_PATH, err), err
+	}
+
+	// Leave the roll20 game
+	s, err := scrapper.NewScrapper(values["ROLL20_BASE_URL"], &scrapper.Roll20Account{Login: values["ROLL20_USERNAME"], Password: values["ROLL20_PASSWORD"]}, nil)
+	if err != nil {
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.Roll20LoginFailed, "The bot account couldn't log in to Roll20"), err
+	}
+	err = s.LeaveGame(gameId)
+	if err != nil {
+		log.Printf("Couldn't leave roll20 game with gameid %s. Reason : %s\n", gameId, err)
+		if _, ok := err.(*scrapper.NotJoinedError); ok {
+			return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusNotFound, http_helpers.GameNotJoined, fmt.Sprintf("The bot account hasn't joined game %s", gameId)), err
+		}
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.LeaveFailed, fmt.Sprintf("The bot account couldn't leave game %s. It can't leave a game it created", gameId)), err
+	}
+
+	return handler2.Response{
+		StatusCode: http.StatusNoContent,
+	}, err
+}
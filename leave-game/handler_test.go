@@ -0,0 +1,221 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"github.com/stretchr/testify/assert"
+	http_helpers "handler/function/pkg/http-helpers"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Read a sample file from the assets
+func readSample(samplePath string) []byte {
+	_, filename, _, _ := runtime.Caller(0)
+	dir := path.Dir(filename)
+	// Open provided path
+	sampleData, err := ioutil.ReadFile(path.Join(dir, samplePath))
+	// On CI, the path may be wrong because the import path is different
+	if err != nil {
+		sampleData, _ = ioutil.ReadFile(path.Join(dir, "../", samplePath))
+	}
+	return sampleData
+}
+
+// Setup a server on which the bot account has joined campaign 5632681, and can leave it
+func SetupTestServer(campaignDataPath string) *httptest.Server {
+	details := readSample(campaignDataPath)
+	before1 := readSample("assets/sample_campaign_listing_page_1.html")
+	before2 := readSample("assets/sample_campaign_listing_page_2.html")
+	after := readSample("assets/sample_campaign_listing_after_leave.html")
+	var mutex sync.Mutex
+	left := false
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch {
+		case strings.Contains(r.URL.Path, "/campaigns/details/"):
+			w.Write(details)
+		case strings.Contains(r.URL.Path, "/campaigns/leave/5632681"):
+			left = true
+			w.WriteHeader(200)
+		case strings.Contains(r.URL.Path, "/campaigns/search/") && left:
+			w.Write(after)
+		case strings.Contains(r.URL.Path, "/campaigns/search/") && r.URL.Query().Get("p") == "2":
+			w.Write(before2)
+		case strings.Contains(r.URL.Path, "/campaigns/search/"):
+			w.Write(before1)
+		default:
+			w.WriteHeader(200)
+		}
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	return mockServer
+}
+
+// Server only allowing the scrapper to log in
+func SetupLoginOnlyServer() *httptest.Server {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/campaigns/") {
+			w.WriteHeader(500)
+		} else {
+			w.WriteHeader(200)
+		}
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	return mockServer
+}
+
+func TestQsParams(t *testing.T) {
+	var tests = []struct {
+		qs         string
+		statusCode int
+	}{
+		{"", http.StatusBadRequest},
+		{"gameId=", http.StatusBadRequest},
+		{"gameId=gg", http.StatusBadRequest},
+		{"gameId=5632681", http.StatusNoContent},
+		{"link=https://app.roll20.net/campaigns/details/5632681/my-campaign", http.StatusNoContent},
+		{"gameId=5632681&link=https://app.roll20.net/join/5632681/59lzQg", http.StatusNoContent},
+		{"gameId=1&link=https://app.roll20.net/join/5632681/59lzQg", http.StatusBadRequest},
+		{"link=https://app.roll20.net/join/5632681", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.qs, func(t *testing.T) {
+			mockServer := SetupTestServer("assets/sample_campaign_page.html")
+			req := handler2.Request{
+				Body:        nil,
+				Header:      nil,
+				QueryString: tt.qs,
+				Method:      "GET",
+				Host:        "",
+			}
+			res, _ := Handle(req)
+			if res.StatusCode != tt.statusCode {
+				t.Errorf("got %d, want %d", res.StatusCode, tt.statusCode)
+			}
+			mockServer.Close()
+		})
+	}
+}
+
+// The game was left, but it wasn't the requested one
+func TestStillListed(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_page.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=6012345",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	mockServer.Close()
+}
+
+// The game hasn't been joined, there are no players to see
+func TestNotJoined(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_listing_page_1.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=5632681",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	assert.Equal(t, []string{http_helpers.ProblemContentType}, res.Header["Content-type"])
+	et := http_helpers.ErrorTemplate{}
+	assert.Nil(t, json.Unmarshal(res.Body, &et))
+	assert.Equal(t, http_helpers.GameNotJoined, et.Code)
+	assert.Equal(t, "/leave-game", et.Instance)
+	assert.NotEmpty(t, et.RequestId)
+	mockServer.Close()
+}
+
+// No env variables defined
+func TestNoEnv(t *testing.T) {
+	os.Unsetenv("ROLL20_BASE_URL")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, _ := Handle(req)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	fmt.Println(string(res.Body))
+
+}
+
+// QS is somehow wrong. Fuzz attack ?
+func TestInvalidQS(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_page.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "wrong=;;;",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, _ := Handle(req)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	fmt.Println(string(res.Body))
+	mockServer.Close()
+}
+
+// End gracefully when the scrapper itself fails
+func TestScrapperLoginError(t *testing.T) {
+	// env defined but wrong, the scrapper won't be able to login
+	os.Setenv("ROLL20_BASE_URL", "wrong")
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	fmt.Println(string(res.Body))
+
+}
+
+// End gracefully when the scrapper itself fails
+func TestScrappingError(t *testing.T) {
+	mockServer := SetupLoginOnlyServer()
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	fmt.Println(string(res.Body))
+	mockServer.Close()
+
+}
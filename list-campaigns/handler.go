@@ -0,0 +1,107 @@
+package function
+
+import (
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"handler/function/pkg/auth"
+	config_parser "handler/function/pkg/config-parser"
+	http_helpers "handler/function/pkg/http-helpers"
+	"handler/function/pkg/scrapper"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Path of the function, used as the instance of its errors
+const FUNCTION_PATH = "/list-campaigns"
+
+// swagger:route GET /list-campaigns Summary list-campaigns
+//
+// List all the campaigns the bot account has joined
+//
+// Along with the role of the bot account in each of them. This allows to audit and clean up the joined games
+//     Produces:
+//     - application/json
+//     - application/x-ndjson
+//     - text/csv
+//     - application/yaml
+//     - application/problem+json
+//     Parameters:
+//       + name: format
+//         in: query
+//         description: Format of the response, overriding the Accept header. One of "json", "ndjson", "csv" or "yaml". Default is "json"
+//         required: false
+//         type: string
+//       + name: If-None-Match
+//         in: header
+//         description: ETag of a previous response. If the response would be the same, a 304 without body is answered instead
+//         required: false
+//         type: string
+// responses:
+//  200: []JoinedCampaign Complete list of joined campaigns
+//  207: []JoinedCampaign Incomplete list of joined campaigns
+//  304: description: The caller already holds the current representation
+//  400: ErrorTemplate Invalid format provided
+//  401: ErrorTemplate Authentication is enabled, and the caller didn't provide valid credentials
+//  406: ErrorTemplate None of the accepted media types is supported
+//  500: ErrorTemplate Configuration error, either env variables missing or provided roll20 credentials invalid
+func Handle(req handler2.Request) (handler2.Response, error) {
+	log.Println("List campaigns handler has been woken up")
+	var err error
+	// Callers are authenticated before anything else
+	identity, err := auth.Authenticate(req, FUNCTION_PATH)
+	if err != nil {
+		log.Printf("Unauthenticated call : %s\n", err)
+		return auth.NewAuthProblem(req, FUNCTION_PATH, err), err
+	}
+	// Retrieve runtime values from env
+	var keys = []string{"ROLL20_USERNAME", "ROLL20_PASSWORD", "ROLL20_BASE_URL"}
+	values, err := config_parser.ParseEnv(keys)
+	if err != nil {
+		log.Printf("Invalid env : %s\n", err)
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.MissingConfiguration, "Some environment variables are missing"), err
+	}
+	var query http_helpers.FormatQuery
+	if err = http_helpers.BindQuery(req.QueryString, &query); err != nil {
+		log.Printf("Invalid QS : %s\n", err)
+		return http_helpers.NewBindingProblem(req, FUNCTION_PATH, err), err
+	}
+	format, ok := http_helpers.NegotiateFormat(req, query.Format)
+	if !ok {
+		return http_helpers.NewNotAcceptableProblem(req, FUNCTION_PATH), nil
+	}
+	log.Println("Now listing joined campaigns")
+
+	s, err := scrapper.NewScrapper(values["ROLL20_BASE_URL"], &scrapper.Roll20Account{Login: values["ROLL20_USERNAME"], Password: values["ROLL20_PASSWORD"]}, nil)
+	if err != nil {
+		log.Printf("The scrapper instance couldn't be initialized. Error %s\n", err)
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.Roll20LoginFailed, "The bot account couldn't log in to Roll20"), err
+	}
+	statusCode := http.StatusOK
+	campaigns, err := s.ListCampaigns()
+	if err != nil {
+		// If the scrapper did not succeed with all the campaigns, indicate it
+		re, ok := err.(*scrapper.IncompleteError)
+		if !ok {
+			log.Printf("Unexpected error : %s\n", err.Error())
+			return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.ScrappingFailed, "The games of the bot account couldn't be listed"), err
+		}
+		log.Println(re.Error())
+		statusCode = http.StatusMultiStatus
+	}
+	// Callers only see the campaigns they can access
+	visible, err := auth.CampaignFilter(identity)
+	if err != nil {
+		log.Printf("Invalid ACL : %s\n", err)
+		return auth.NewAuthProblem(req, FUNCTION_PATH, err), err
+	}
+	allowed := []scrapper.JoinedCampaign{}
+	for _, campaign := range *campaigns {
+		if visible(strconv.Itoa(campaign.Summary.Id)) {
+			allowed = append(allowed, campaign)
+		}
+	}
+	campaigns = &allowed
+	log.Printf("%d joined campaigns have been listed\n", len(*campaigns))
+	return http_helpers.NewConditionalResponse(req, statusCode, format, campaigns, time.Time{})
+}
@@ -0,0 +1,65 @@
+//go:build integration
+// +build integration
+
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/joho/godotenv"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"github.com/stretchr/testify/assert"
+	"handler/function/pkg/scrapper"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+const projectDirName = "roll20-scrapper"
+
+func LoadEnv(t *testing.T) {
+	re := regexp.MustCompile(`^(.*` + projectDirName + `)`)
+	cwd, _ := os.Getwd()
+	rootPath := re.Find([]byte(cwd))
+	err := godotenv.Load(string(rootPath) + `/.env.yaml`)
+	if err != nil {
+		log.Printf(err.Error())
+		t.SkipNow()
+	}
+}
+
+// The testing campaign must have been joined by the bot account
+func TestListCampaigns(t *testing.T) {
+	LoadEnv(t)
+	game_id, err := strconv.Atoi(os.Getenv("TESTING_CAMPAIGN_ID"))
+	if err != nil {
+		t.Fatalf("testing campaign id invalid -> %s", os.Getenv("TESTING_CAMPAIGN_ID"))
+	}
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	if err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusMultiStatus {
+		fmt.Println("Could not list joined roll20 games")
+		t.FailNow()
+	}
+	var campaigns []scrapper.JoinedCampaign
+	json.Unmarshal(res.Body, &campaigns)
+	found := false
+	for _, c := range campaigns {
+		found = found || c.Summary.Id == game_id
+	}
+	assert.True(t, found)
+	fmt.Printf("%s", res.Body)
+}
@@ -0,0 +1,208 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"github.com/stretchr/testify/assert"
+	"handler/function/pkg/scrapper"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Read a sample file from the assets
+func readSample(samplePath string) []byte {
+	_, filename, _, _ := runtime.Caller(0)
+	dir := path.Dir(filename)
+	// Open provided path
+	sampleData, err := ioutil.ReadFile(path.Join(dir, samplePath))
+	// On CI, the path may be wrong because the import path is different
+	if err != nil {
+		sampleData, _ = ioutil.ReadFile(path.Join(dir, "../", samplePath))
+	}
+	return sampleData
+}
+
+// Setup a server answering with the sample games listing, one sample per page
+func SetupTestServer(pagesDataPath ...string) *httptest.Server {
+	var pages [][]byte
+	for _, pageDataPath := range pagesDataPath {
+		pages = append(pages, readSample(pageDataPath))
+	}
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "/campaigns/search/") {
+			w.WriteHeader(200)
+			return
+		}
+		page, err := strconv.Atoi(r.URL.Query().Get("p"))
+		if err != nil || page < 1 || page > len(pages) {
+			w.WriteHeader(404)
+			return
+		}
+		w.Write(pages[page-1])
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	return mockServer
+}
+
+// All campaigns were parsed
+func TestCompleteAnswer(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_listing_page_1.html", "assets/sample_campaign_listing_page_2.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var campaigns []scrapper.JoinedCampaign
+	err = json.Unmarshal(res.Body, &campaigns)
+	assert.Nil(t, err)
+	assert.Len(t, campaigns, 5)
+	mockServer.Close()
+}
+
+// A joined campaign per line
+func TestNDJSONAnswer(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_listing_page_1.html", "assets/sample_campaign_listing_page_2.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      http.Header{"Accept": {"application/x-ndjson"}},
+		QueryString: "",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, []string{"application/x-ndjson"}, res.Header["Content-type"])
+	lines := strings.Split(strings.TrimSpace(string(res.Body)), "\n")
+	assert.Len(t, lines, 5)
+	var campaign scrapper.JoinedCampaign
+	err = json.Unmarshal([]byte(lines[0]), &campaign)
+	assert.Nil(t, err)
+	mockServer.Close()
+}
+
+// Not all campaigns were parsed
+func TestIncompleteAnswer(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_listing_missing_id.html")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusMultiStatus, res.StatusCode)
+	var campaigns []scrapper.JoinedCampaign
+	err = json.Unmarshal(res.Body, &campaigns)
+	assert.Nil(t, err)
+	assert.Len(t, campaigns, 1)
+	mockServer.Close()
+}
+
+// No campaign at all
+func TestEmptyAnswer(t *testing.T) {
+	mockServer := SetupTestServer()
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	mockServer.Close()
+}
+
+// No env variables defined
+func TestNoEnv(t *testing.T) {
+	os.Unsetenv("ROLL20_BASE_URL")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, _ := Handle(req)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	fmt.Println(string(res.Body))
+
+}
+
+// End gracefully when the scrapper itself fails
+func TestScrapperLoginError(t *testing.T) {
+	// env defined but wrong, the scrapper won't be able to login
+	os.Setenv("ROLL20_BASE_URL", "wrong")
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	fmt.Println(string(res.Body))
+
+}
+
+// Callers restricted by the ACL only see their own campaigns
+func TestRestrictedListing(t *testing.T) {
+	dir := t.TempDir()
+	ioutil.WriteFile(path.Join(dir, "api-keys"), []byte("team-a:key-a\ntrusted:key-t\n"), 0o600)
+	ioutil.WriteFile(path.Join(dir, "acl"), []byte("team-a:\n  campaigns: [\"5939283\", \"6234567\"]\n  capabilities: [players]\ntrusted:\n  campaigns: [\"*\"]\n  capabilities: [players]\n"), 0o600)
+	os.Setenv("AUTH_SECRETS_DIR", dir)
+	defer os.Unsetenv("AUTH_SECRETS_DIR")
+	mockServer := SetupTestServer("assets/sample_campaign_listing_page_1.html", "assets/sample_campaign_listing_page_2.html")
+	var tests = []struct {
+		key string
+		ids []int
+	}{
+		{"key-a", []int{5939283, 6234567}},
+		{"key-t", []int{5632681, 5939283, 6012345, 6123456, 6234567}},
+	}
+
+	for _, tt := range tests {
+		req := handler2.Request{
+			Body:        nil,
+			Header:      http.Header{"X-Api-Key": {tt.key}},
+			QueryString: "",
+			Method:      "GET",
+			Host:        "",
+		}
+		res, err := Handle(req)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		var campaigns []scrapper.JoinedCampaign
+		err = json.Unmarshal(res.Body, &campaigns)
+		assert.Nil(t, err)
+		var ids []int
+		for _, campaign := range campaigns {
+			ids = append(ids, campaign.Summary.Id)
+		}
+		assert.Equal(t, tt.ids, ids)
+	}
+	mockServer.Close()
+}
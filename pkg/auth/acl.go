@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Secret mapping each caller to what it is allowed to do, as YAML or JSON
+const ACL_SECRET = "acl"
+
+// Capability Something a caller can be allowed to do
+type Capability string
+
+const (
+	// Make the bot account join or leave games
+	Join Capability = "join"
+	// Read the players of games, along with their summary and roster history
+	Players Capability = "players"
+	// Read the chat of games, and the characters deduced from it
+	Messages Capability = "messages"
+	// Read whispers, on top of Messages
+	Whispers Capability = "whispers"
+)
+
+var capabilities = map[Capability]bool{Join: true, Players: true, Messages: true, Whispers: true}
+
+// Grants every campaign, including the ones joined later
+const anyCampaign = "*"
+
+// Grant What a single caller is allowed to do
+type Grant struct {
+	// IDs of the campaigns the caller can access, or "*" for all of them
+	Campaigns []string `yaml:"campaigns"`
+	// What the caller can do on these campaigns
+	Capabilities []Capability `yaml:"capabilities"`
+}
+
+// ACL Grants of the callers, keyed by identity name. Callers without a grant can't do anything
+type ACL map[string]*Grant
+
+// ForbiddenError The caller is authenticated, but not allowed to do what it asked for
+type ForbiddenError struct {
+	Reason string
+}
+
+func (e *ForbiddenError) Error() string {
+	return e.Reason
+}
+
+// LoadACL Read the acl secret, or nil if it doesn't exist. Without it, every authenticated caller can do anything.
+// Ex :
+//
+//	team-a:
+//	  campaigns: ["1", "2"]
+//	  capabilities: [players, messages]
+//	trusted:
+//	  campaigns: ["*"]
+//	  capabilities: [join, players, messages, whispers]
+func LoadACL() (ACL, error) {
+	content, err := ioutil.ReadFile(filepath.Join(SecretsDir(), ACL_SECRET))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the %s secret : %s", ACL_SECRET, err)
+	}
+	acl := ACL{}
+	if err = yaml.Unmarshal(content, &acl); err != nil {
+		return nil, fmt.Errorf("The %s secret is malformed : %s", ACL_SECRET, err)
+	}
+	for name, grant := range acl {
+		if grant == nil {
+			return nil, fmt.Errorf("The grant of %s is empty in the %s secret", name, ACL_SECRET)
+		}
+		for _, capability := range grant.Capabilities {
+			if !capabilities[capability] {
+				return nil, fmt.Errorf("Unknown capability %q granted to %s. Should be one of join, players, messages or whispers", capability, name)
+			}
+		}
+	}
+	return acl, nil
+}
+
+// Authorize Check that identity can use capability on every campaign of campaignIds, as configured by the acl secret.
+// Everything is allowed when either authentication or the ACL is disabled.
+// A ForbiddenError is returned when the caller isn't allowed
+func Authorize(identity *Identity, capability Capability, campaignIds ...string) error {
+	acl, err := LoadACL()
+	if err != nil {
+		return err
+	}
+	return acl.Authorize(identity, capability, campaignIds...)
+}
+
+// Authorize Check that identity can use capability on every campaign of campaignIds
+func (acl ACL) Authorize(identity *Identity, capability Capability, campaignIds ...string) error {
+	if acl == nil || identity == nil {
+		return nil
+	}
+	grant := acl[identity.Name]
+	if !grant.allows(capability) {
+		return &ForbiddenError{Reason: fmt.Sprintf("%s isn't allowed to %s", identity.Name, capability.describe())}
+	}
+	var forbidden []string
+	for _, id := range campaignIds {
+		if !grant.covers(id) {
+			forbidden = append(forbidden, id)
+		}
+	}
+	if len(forbidden) != 0 {
+		sort.Strings(forbidden)
+		return &ForbiddenError{Reason: fmt.Sprintf("%s can't access games %s", identity.Name, strings.Join(forbidden, ", "))}
+	}
+	return nil
+}
+
+// CampaignFilter Whether identity can access a campaign, to hide the others from listings
+func CampaignFilter(identity *Identity) (func(campaignId string) bool, error) {
+	acl, err := LoadACL()
+	if err != nil {
+		return nil, err
+	}
+	if acl == nil || identity == nil {
+		return func(string) bool { return true }, nil
+	}
+	grant := acl[identity.Name]
+	return grant.covers, nil
+}
+
+func (g *Grant) allows(capability Capability) bool {
+	if g == nil {
+		return false
+	}
+	for _, c := range g.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Grant) covers(campaignId string) bool {
+	if g == nil {
+		return false
+	}
+	for _, id := range g.Campaigns {
+		if id == anyCampaign || id == campaignId {
+			return true
+		}
+	}
+	return false
+}
+
+// What the capability allows, to explain a refusal
+func (c Capability) describe() string {
+	switch c {
+	case Join:
+		return "make the bot join or leave games"
+	case Players:
+		return "read players"
+	case Messages:
+		return "read messages"
+	case Whispers:
+		return "read whispers"
+	}
+	return string(c)
+}
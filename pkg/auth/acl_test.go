@@ -0,0 +1,107 @@
+package auth
+
+import (
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+const sampleACL = `
+team-a:
+  campaigns: ["1", "2"]
+  capabilities: [players, messages]
+trusted:
+  campaigns: ["*"]
+  capabilities: [join, players, messages, whispers]
+`
+
+var teamA = &Identity{Name: "team-a", Scheme: ApiKey}
+var trusted = &Identity{Name: "trusted", Scheme: Hmac}
+var stranger = &Identity{Name: "stranger", Scheme: ApiKey}
+
+func TestLoadACL(t *testing.T) {
+	setupSecrets(t, map[string]string{ACL_SECRET: sampleACL})
+	acl, err := LoadACL()
+	assert.Nil(t, err)
+	assert.Equal(t, ACL{
+		"team-a":  {Campaigns: []string{"1", "2"}, Capabilities: []Capability{Players, Messages}},
+		"trusted": {Campaigns: []string{"*"}, Capabilities: []Capability{Join, Players, Messages, Whispers}},
+	}, acl)
+
+	// JSON works as well
+	setupSecrets(t, map[string]string{ACL_SECRET: `{"team-a": {"campaigns": ["1"], "capabilities": ["join"]}}`})
+	acl, err = LoadACL()
+	assert.Nil(t, err)
+	assert.Equal(t, []Capability{Join}, acl["team-a"].Capabilities)
+
+	setupSecrets(t, nil)
+	acl, err = LoadACL()
+	assert.Nil(t, err)
+	assert.Nil(t, acl)
+}
+
+func TestInvalidACL(t *testing.T) {
+	for _, content := range []string{"team-a: [", "team-a:\n  capabilities: [delete]", "team-a:"} {
+		setupSecrets(t, map[string]string{ACL_SECRET: content})
+		_, err := LoadACL()
+		assert.Error(t, err, content)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	setupSecrets(t, map[string]string{ACL_SECRET: sampleACL})
+	var tests = []struct {
+		name       string
+		identity   *Identity
+		capability Capability
+		campaigns  []string
+		reason     string
+	}{
+		{"granted", teamA, Messages, []string{"1", "2"}, ""},
+		{"any campaign", trusted, Whispers, []string{"3"}, ""},
+		{"not a listing", teamA, Players, nil, ""},
+		{"missing capability", teamA, Whispers, []string{"1"}, "team-a isn't allowed to read whispers"},
+		{"other campaigns", teamA, Players, []string{"4", "1", "3"}, "team-a can't access games 3, 4"},
+		{"unknown caller", stranger, Players, []string{"1"}, "stranger isn't allowed to read players"},
+		{"authentication disabled", nil, Join, []string{"1"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Authorize(tt.identity, tt.capability, tt.campaigns...)
+			if len(tt.reason) == 0 {
+				assert.Nil(t, err)
+			} else {
+				assert.IsType(t, &ForbiddenError{}, err)
+				assert.EqualError(t, err, tt.reason)
+			}
+		})
+	}
+
+	// Without an ACL, authenticated callers can do anything
+	setupSecrets(t, nil)
+	assert.Nil(t, Authorize(stranger, Whispers, "1"))
+}
+
+func TestCampaignFilter(t *testing.T) {
+	setupSecrets(t, map[string]string{ACL_SECRET: sampleACL})
+	filter, err := CampaignFilter(teamA)
+	assert.Nil(t, err)
+	assert.True(t, filter("1"))
+	assert.False(t, filter("3"))
+
+	filter, err = CampaignFilter(stranger)
+	assert.Nil(t, err)
+	assert.False(t, filter("1"))
+
+	filter, err = CampaignFilter(nil)
+	assert.Nil(t, err)
+	assert.True(t, filter("3"))
+}
+
+func TestForbiddenProblem(t *testing.T) {
+	res := NewAuthProblem(handler2.Request{}, "/get-messages", &ForbiddenError{Reason: "team-a isn't allowed to read whispers"})
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	assert.Contains(t, string(res.Body), `"code":"forbidden"`)
+	assert.Empty(t, res.Header["WWW-Authenticate"])
+}
@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	http_helpers "handler/function/pkg/http-helpers"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Where OpenFaaS mounts the secrets of a function, when AUTH_SECRETS_DIR isn't defined
+const DEFAULT_SECRETS_DIR = "/var/openfaas/secrets"
+
+// Secrets holding the credentials of the callers, one "<name>:<value>" pair per line
+const (
+	// Static API keys, named after the caller they identify
+	API_KEYS_SECRET = "api-keys"
+	// HMAC signing keys, by key ID
+	HMAC_KEYS_SECRET = "hmac-keys"
+)
+
+// How far the timestamp of a signed request can be from now, when AUTH_MAX_SKEW isn't defined
+const DEFAULT_MAX_SKEW = 5 * time.Minute
+
+// Headers of authenticated requests
+const (
+	ApiKeyHeader    = "X-Api-Key"
+	KeyIdHeader     = "X-Key-Id"
+	TimestampHeader = "X-Timestamp"
+	SignatureHeader = "X-Signature"
+)
+
+// Scheme How a caller proved its identity
+type Scheme string
+
+const (
+	ApiKey Scheme = "api-key"
+	Hmac   Scheme = "hmac"
+)
+
+// Identity An authenticated caller
+type Identity struct {
+	// Name of the API key, or ID of the HMAC key
+	Name   string
+	Scheme Scheme
+}
+
+// UnauthenticatedError The caller couldn't be authenticated
+type UnauthenticatedError struct {
+	Reason string
+}
+
+func (e *UnauthenticatedError) Error() string {
+	return e.Reason
+}
+
+// Authenticator Checks the credentials of incoming requests
+type Authenticator struct {
+	// API key -> name of the caller
+	apiKeys map[string]string
+	// Key ID -> secret
+	hmacKeys map[string][]byte
+	maxSkew  time.Duration
+	now      func() time.Time
+	replays  *replayCache
+}
+
+// Signatures already seen, shared by all invocations of the function as the process outlives them
+var seenSignatures = newReplayCache()
+
+// FromSecrets Build the authenticator of the functions from the api-keys and hmac-keys secrets.
+// Authentication is disabled, and nil returned, if neither of them exists.
+// The following optional variables are also read :
+//   - AUTH_SECRETS_DIR : where the secrets are mounted
+//   - AUTH_MAX_SKEW : how far the timestamp of a signed request can be from now
+func FromSecrets() (*Authenticator, error) {
+	dir := SecretsDir()
+	maxSkew := DEFAULT_MAX_SKEW
+	if value, isSet := os.LookupEnv("AUTH_MAX_SKEW"); isSet {
+		var err error
+		if maxSkew, err = time.ParseDuration(value); err != nil || maxSkew <= 0 {
+			return nil, fmt.Errorf("Invalid AUTH_MAX_SKEW %q. Should be a duration such as 30s or 5m", value)
+		}
+	}
+	apiKeys, err := readSecret(dir, API_KEYS_SECRET)
+	if err != nil {
+		return nil, err
+	}
+	hmacKeys, err := readSecret(dir, HMAC_KEYS_SECRET)
+	if err != nil {
+		return nil, err
+	}
+	if apiKeys == nil && hmacKeys == nil {
+		return nil, nil
+	}
+	a := &Authenticator{apiKeys: make(map[string]string), hmacKeys: make(map[string][]byte), maxSkew: maxSkew, now: time.Now, replays: seenSignatures}
+	for name, key := range apiKeys {
+		a.apiKeys[key] = name
+	}
+	for id, secret := range hmacKeys {
+		a.hmacKeys[id] = []byte(secret)
+	}
+	return a, nil
+}
+
+// SecretsDir Where the secrets are mounted
+func SecretsDir() string {
+	if dir, isSet := os.LookupEnv("AUTH_SECRETS_DIR"); isSet {
+		return dir
+	}
+	return DEFAULT_SECRETS_DIR
+}
+
+// Pairs of a secret, or nil if it doesn't exist. Empty lines and lines starting with # are ignored
+func readSecret(dir string, name string) (map[string]string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the %s secret : %s", name, err)
+	}
+	pairs := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(text, ":")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !found || len(key) == 0 || len(value) == 0 {
+			return nil, fmt.Errorf("Line %d of the %s secret should be formatted as <name>:<value>", line, name)
+		}
+		if _, exists := pairs[key]; exists {
+			return nil, fmt.Errorf("%s is defined twice in the %s secret", key, name)
+		}
+		pairs[key] = value
+	}
+	return pairs, nil
+}
+
+// Authenticate Check the credentials of req, a call to the function at path.
+// With authentication disabled, every call is accepted and a nil identity returned.
+// An UnauthenticatedError is returned when the credentials are missing or wrong
+func Authenticate(req handler2.Request, path string) (*Identity, error) {
+	a, err := FromSecrets()
+	if err != nil || a == nil {
+		return nil, err
+	}
+	return a.Authenticate(req, path)
+}
+
+// Authenticate Check the credentials of req, a call to the function at path, either an API key or a signature
+func (a *Authenticator) Authenticate(req handler2.Request, path string) (*Identity, error) {
+	if key := req.Header.Get(ApiKeyHeader); len(key) != 0 {
+		return a.checkApiKey(key)
+	}
+	if authorization := req.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		return a.checkApiKey(strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer ")))
+	}
+	if len(req.Header.Get(SignatureHeader)) != 0 {
+		return a.checkSignature(req, path)
+	}
+	return nil, &UnauthenticatedError{Reason: "No credentials provided. Either an API key or a signature is required"}
+}
+
+func (a *Authenticator) checkApiKey(key string) (*Identity, error) {
+	// Every key is compared, not to tell how close a guess was by how long it took
+	var name string
+	for candidate, candidateName := range a.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(key)) == 1 {
+			name = candidateName
+		}
+	}
+	if len(name) == 0 {
+		return nil, &UnauthenticatedError{Reason: "Unknown API key"}
+	}
+	return &Identity{Name: name, Scheme: ApiKey}, nil
+}
+
+func (a *Authenticator) checkSignature(req handler2.Request, path string) (*Identity, error) {
+	keyId := req.Header.Get(KeyIdHeader)
+	secret, ok := a.hmacKeys[keyId]
+	if !ok {
+		return nil, &UnauthenticatedError{Reason: fmt.Sprintf("Unknown key ID %q", keyId)}
+	}
+	timestamp := req.Header.Get(TimestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, &UnauthenticatedError{Reason: fmt.Sprintf("Invalid %s %q. Should be a Unix timestamp in seconds", TimestampHeader, timestamp)}
+	}
+	signedAt := time.Unix(seconds, 0)
+	now := a.now()
+	if signedAt.Before(now.Add(-a.maxSkew)) || signedAt.After(now.Add(a.maxSkew)) {
+		return nil, &UnauthenticatedError{Reason: "The request timestamp is too far from the current time"}
+	}
+	signature, err := hex.DecodeString(strings.TrimPrefix(req.Header.Get(SignatureHeader), "sha256="))
+	if err != nil || !hmac.Equal(signature, Sign(secret, req.Method, path, req.QueryString, timestamp, req.Body)) {
+		return nil, &UnauthenticatedError{Reason: "Invalid signature"}
+	}
+	// A signed request can only be sent once. Past the skew, the timestamp check takes over
+	if !a.replays.add(keyId+":"+hex.EncodeToString(signature), now, signedAt.Add(a.maxSkew)) {
+		return nil, &UnauthenticatedError{Reason: "This signed request has already been received"}
+	}
+	return &Identity{Name: keyId, Scheme: Hmac}, nil
+}
+
+// Sign The HMAC-SHA256 signature of a request. The signed string is made of the method, the path, the raw query,
+// the timestamp and the hex-encoded SHA-256 of the body, separated by new lines
+func Sign(secret []byte, method string, path string, query string, timestamp string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join([]string{strings.ToUpper(method), path, query, timestamp, hex.EncodeToString(bodyHash[:])}, "\n")))
+	return mac.Sum(nil)
+}
+
+// NewAuthProblem Build the error response to req when Authenticate or Authorize failed.
+// Wrong credentials and missing permissions are the client fault, anything else is ours
+func NewAuthProblem(req handler2.Request, instance string, err error) handler2.Response {
+	switch e := err.(type) {
+	case *UnauthenticatedError:
+		res := http_helpers.NewProblem(req, instance, http.StatusUnauthorized, http_helpers.Unauthenticated, e.Reason)
+		res.Header["WWW-Authenticate"] = []string{`ApiKey realm="roll20-scrapper"`, `HMAC-SHA256 realm="roll20-scrapper"`}
+		return res
+	case *ForbiddenError:
+		return http_helpers.NewProblem(req, instance, http.StatusForbidden, http_helpers.Forbidden, e.Reason)
+	}
+	return http_helpers.NewProblem(req, instance, http.StatusInternalServerError, http_helpers.MissingConfiguration, "The authentication secrets are invalid")
+}
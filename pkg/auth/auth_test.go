@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"encoding/hex"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"github.com/stretchr/testify/assert"
+	http_helpers "handler/function/pkg/http-helpers"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2022, 5, 1, 20, 0, 0, 0, time.UTC)
+
+// Mount the given secrets in a temporary directory, as OpenFaaS would
+func setupSecrets(t *testing.T, secrets map[string]string) {
+	dir := t.TempDir()
+	for name, content := range secrets {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+	os.Setenv("AUTH_SECRETS_DIR", dir)
+	t.Cleanup(func() { os.Unsetenv("AUTH_SECRETS_DIR") })
+}
+
+func testAuthenticator(t *testing.T) *Authenticator {
+	setupSecrets(t, map[string]string{
+		API_KEYS_SECRET:  "# Team keys\nteam-a: key-a\n\nteam-b:key-b\n",
+		HMAC_KEYS_SECRET: "poller:s3cr3t",
+	})
+	a, err := FromSecrets()
+	assert.Nil(t, err)
+	a.now = func() time.Time { return t0 }
+	a.replays = newReplayCache()
+	return a
+}
+
+// A request to /get-players signed at signedAt with the given secret
+func signedRequest(secret string, signedAt time.Time) handler2.Request {
+	timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+	signature := Sign([]byte(secret), "GET", "/get-players", "gameId=1", timestamp, nil)
+	return handler2.Request{
+		Method:      "GET",
+		QueryString: "gameId=1",
+		Header: http.Header{
+			KeyIdHeader:     {"poller"},
+			TimestampHeader: {timestamp},
+			SignatureHeader: {"sha256=" + hex.EncodeToString(signature)},
+		},
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	setupSecrets(t, nil)
+	a, err := FromSecrets()
+	assert.Nil(t, err)
+	assert.Nil(t, a)
+	identity, err := Authenticate(handler2.Request{}, "/get-players")
+	assert.Nil(t, err)
+	assert.Nil(t, identity)
+}
+
+func TestInvalidSecrets(t *testing.T) {
+	for _, content := range []string{"no separator", "team-a:key-a\nteam-a:key-b", ":key"} {
+		setupSecrets(t, map[string]string{API_KEYS_SECRET: content})
+		_, err := FromSecrets()
+		assert.Error(t, err, content)
+	}
+
+	setupSecrets(t, map[string]string{HMAC_KEYS_SECRET: "poller:s3cr3t"})
+	os.Setenv("AUTH_MAX_SKEW", "never")
+	defer os.Unsetenv("AUTH_MAX_SKEW")
+	_, err := FromSecrets()
+	assert.Error(t, err)
+}
+
+func TestApiKey(t *testing.T) {
+	a := testAuthenticator(t)
+	identity, err := a.Authenticate(handler2.Request{Header: http.Header{ApiKeyHeader: {"key-a"}}}, "/get-players")
+	assert.Nil(t, err)
+	assert.Equal(t, &Identity{Name: "team-a", Scheme: ApiKey}, identity)
+
+	identity, err = a.Authenticate(handler2.Request{Header: http.Header{"Authorization": {"Bearer key-b"}}}, "/get-players")
+	assert.Nil(t, err)
+	assert.Equal(t, &Identity{Name: "team-b", Scheme: ApiKey}, identity)
+
+	for _, header := range []http.Header{nil, {ApiKeyHeader: {"key-c"}}, {"Authorization": {"Basic a2V5LWE="}}} {
+		_, err = a.Authenticate(handler2.Request{Header: header}, "/get-players")
+		assert.IsType(t, &UnauthenticatedError{}, err)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	a := testAuthenticator(t)
+	identity, err := a.Authenticate(signedRequest("s3cr3t", t0.Add(-time.Minute)), "/get-players")
+	assert.Nil(t, err)
+	assert.Equal(t, &Identity{Name: "poller", Scheme: Hmac}, identity)
+
+	// The same request can't be received twice
+	_, err = a.Authenticate(signedRequest("s3cr3t", t0.Add(-time.Minute)), "/get-players")
+	assert.EqualError(t, err, "This signed request has already been received")
+}
+
+func TestInvalidSignature(t *testing.T) {
+	a := testAuthenticator(t)
+	var tests = []struct {
+		name   string
+		req    handler2.Request
+		path   string
+		reason string
+	}{
+		{"wrong secret", signedRequest("guess", t0), "/get-players", "Invalid signature"},
+		{"other function", signedRequest("s3cr3t", t0), "/join-game", "Invalid signature"},
+		{"too old", signedRequest("s3cr3t", t0.Add(-6*time.Minute)), "/get-players", "The request timestamp is too far from the current time"},
+		{"in the future", signedRequest("s3cr3t", t0.Add(6*time.Minute)), "/get-players", "The request timestamp is too far from the current time"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.Authenticate(tt.req, tt.path)
+			assert.EqualError(t, err, tt.reason)
+		})
+	}
+
+	req := signedRequest("s3cr3t", t0)
+	req.QueryString = "gameId=2"
+	_, err := a.Authenticate(req, "/get-players")
+	assert.EqualError(t, err, "Invalid signature")
+
+	req = signedRequest("s3cr3t", t0)
+	req.Header.Set(KeyIdHeader, "other")
+	_, err = a.Authenticate(req, "/get-players")
+	assert.EqualError(t, err, "Unknown key ID \"other\"")
+
+	req = signedRequest("s3cr3t", t0)
+	req.Header.Set(TimestampHeader, "now")
+	_, err = a.Authenticate(req, "/get-players")
+	assert.IsType(t, &UnauthenticatedError{}, err)
+}
+
+func TestReplayCache(t *testing.T) {
+	rc := newReplayCache()
+	assert.True(t, rc.add("a", t0, t0.Add(time.Minute)))
+	assert.False(t, rc.add("a", t0.Add(30*time.Second), t0.Add(time.Minute)))
+	// Expired signatures are forgotten
+	assert.True(t, rc.add("b", t0.Add(time.Minute), t0.Add(2*time.Minute)))
+	assert.Len(t, rc.expirations, 1)
+}
+
+func TestNewAuthProblem(t *testing.T) {
+	res := NewAuthProblem(handler2.Request{}, "/get-players", &UnauthenticatedError{Reason: "Unknown API key"})
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.Len(t, res.Header["WWW-Authenticate"], 2)
+	assert.Contains(t, string(res.Body), `"code":"`+string(http_helpers.Unauthenticated)+`"`)
+	assert.Contains(t, string(res.Body), "Unknown API key")
+
+	res = NewAuthProblem(handler2.Request{}, "/get-players", os.ErrPermission)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+}
@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// Remembers signatures until they expire
+type replayCache struct {
+	expirations map[string]time.Time
+	mutex       sync.Mutex
+}
+
+func newReplayCache() *replayCache {
+	return &replayCache{expirations: make(map[string]time.Time)}
+}
+
+// Record signature until expiresAt. False if it was already recorded and hasn't expired yet
+func (rc *replayCache) add(signature string, now time.Time, expiresAt time.Time) bool {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	for seen, expiration := range rc.expirations {
+		if !expiration.After(now) {
+			delete(rc.expirations, seen)
+		}
+	}
+	if _, exists := rc.expirations[signature]; exists {
+		return false
+	}
+	rc.expirations[signature] = expiresAt
+	return true
+}
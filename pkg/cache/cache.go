@@ -0,0 +1,204 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	http_helpers "handler/function/pkg/http-helpers"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Status How a result was obtained, as told by the X-Cache header
+type Status string
+
+const (
+	// Fresh enough to be served from the cache
+	Hit Status = "HIT"
+	// Scrapped, as nothing fresh was cached
+	Miss Status = "MISS"
+	// Scrapping failed, an expired entry was served instead
+	Stale Status = "STALE"
+	// Scrapped, as the caller asked not to use the cache
+	Bypass Status = "BYPASS"
+)
+
+// Outcome How a result was obtained, and how old it is
+type Outcome struct {
+	Status Status
+	Age    time.Duration
+}
+
+// Where entries are stored when CACHE_DIR isn't defined
+const DEFAULT_CACHE_DIR = "/tmp/cache"
+
+// How long an expired entry can be served when scrapping fails, when CACHE_STALE_IF_ERROR isn't defined
+const DEFAULT_STALE_IF_ERROR = 24 * time.Hour
+
+// Shared by all invocations of the function, as the process outlives them
+var memoryStore = NewMemoryStore(defaultMemoryCapacity)
+
+// Cache Serves results from a store while they are fresh, and expired ones when scrapping fails
+type Cache struct {
+	store        Store
+	ttl          time.Duration
+	staleIfError time.Duration
+	now          func() time.Time
+}
+
+// NewCache Build a cache over store. Entries are fresh for ttl, and then served for staleIfError more if scrapping fails
+func NewCache(store Store, ttl time.Duration, staleIfError time.Duration) *Cache {
+	return &Cache{store: store, ttl: ttl, staleIfError: staleIfError, now: time.Now}
+}
+
+// FromEnv Build the cache of a function, defaultTTL being the freshness of its results.
+// The cache is disabled, and nil returned, unless CACHE_BACKEND is set to either "memory" or "file".
+// The following optional variables are also read :
+//   - CACHE_DIR : directory of the "file" backend
+//   - CACHE_TTL : overrides defaultTTL
+//   - CACHE_STALE_IF_ERROR : how long an expired entry can be served when scrapping fails
+func FromEnv(defaultTTL time.Duration) (*Cache, error) {
+	backend, isSet := os.LookupEnv("CACHE_BACKEND")
+	if !isSet || len(backend) == 0 {
+		return nil, nil
+	}
+	ttl, err := durationFromEnv("CACHE_TTL", defaultTTL)
+	if err != nil {
+		return nil, err
+	}
+	staleIfError, err := durationFromEnv("CACHE_STALE_IF_ERROR", DEFAULT_STALE_IF_ERROR)
+	if err != nil {
+		return nil, err
+	}
+	switch backend {
+	case "memory":
+		return NewCache(memoryStore, ttl, staleIfError), nil
+	case "file":
+		dir, isSet := os.LookupEnv("CACHE_DIR")
+		if !isSet {
+			dir = DEFAULT_CACHE_DIR
+		}
+		store, err := NewFileStore(dir)
+		if err != nil {
+			return nil, err
+		}
+		return NewCache(store, ttl, staleIfError), nil
+	}
+	return nil, fmt.Errorf("Invalid CACHE_BACKEND %q. Should be either memory or file", backend)
+}
+
+func durationFromEnv(key string, byDefault time.Duration) (time.Duration, error) {
+	value, isSet := os.LookupEnv(key)
+	if !isSet {
+		return byDefault, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("Invalid %s %q. Should be a duration such as 30s or 5m", key, value)
+	}
+	return duration, nil
+}
+
+// NoCache Whether the caller asked for a result straight from Roll20, with Cache-Control: no-cache
+func NoCache(req handler2.Request) bool {
+	for _, directive := range strings.Split(strings.Join(req.Header.Values("Cache-Control"), ","), ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetch Retrieve the result of key. While fresh, the cached data is decoded into target, and returned.
+// Otherwise fetch is called, its result being cached if complete. If fetch fails with a server error,
+// an expired entry is served instead, as long as it isn't older than the stale-if-error window.
+// noCache skips fresh entries, but still allows stale ones on failure. A nil cache always calls fetch
+func (c *Cache) Fetch(key string, noCache bool, target interface{}, fetch func() (*http_helpers.CampaignResult, error)) (*http_helpers.CampaignResult, *Outcome, error) {
+	if c == nil {
+		result, err := fetch()
+		return result, nil, err
+	}
+	entry, err := c.store.Get(key)
+	if err != nil {
+		// A broken cache shouldn't fail the request, Roll20 is still there
+		log.Printf("Ignoring the cache entry of %s : %s\n", key, err)
+		entry = nil
+	}
+	var age time.Duration
+	if entry != nil {
+		age = c.now().Sub(entry.StoredAt)
+	}
+	if entry != nil && !noCache && age < c.ttl {
+		if result, ok := decode(entry, target); ok {
+			return result, &Outcome{Status: Hit, Age: age}, nil
+		}
+	}
+
+	result, err := fetch()
+	if result.Status == http.StatusOK {
+		if data, marshalErr := json.Marshal(result.Data); marshalErr == nil {
+			if setErr := c.store.Set(key, &Entry{StoredAt: c.now(), Data: data}); setErr != nil {
+				log.Printf("Couldn't cache %s : %s\n", key, setErr)
+			}
+		}
+	}
+	if result.Status >= http.StatusInternalServerError && entry != nil && age < c.ttl+c.staleIfError {
+		if stale, ok := decode(entry, target); ok {
+			log.Printf("Serving a stale result for %s, scrapping failed : %s\n", key, err)
+			return stale, &Outcome{Status: Stale, Age: age}, nil
+		}
+	}
+	if noCache {
+		return result, &Outcome{Status: Bypass}, err
+	}
+	return result, &Outcome{Status: Miss}, err
+}
+
+func decode(entry *Entry, target interface{}) (*http_helpers.CampaignResult, bool) {
+	if err := json.Unmarshal(entry.Data, target); err != nil {
+		log.Printf("Ignoring a corrupted cache entry : %s\n", err)
+		return nil, false
+	}
+	return &http_helpers.CampaignResult{Status: http.StatusOK, Data: target}, true
+}
+
+// SetHeaders Tell the caller where the response comes from, with the X-Cache and Age headers.
+// For responses spanning several campaigns, the least fresh outcome is told
+func SetHeaders(res *handler2.Response, outcomes ...*Outcome) {
+	var told *Outcome
+	for _, outcome := range outcomes {
+		if outcome == nil {
+			continue
+		}
+		if told == nil || rank(outcome.Status) > rank(told.Status) || (outcome.Status == told.Status && outcome.Age > told.Age) {
+			told = outcome
+		}
+	}
+	if told == nil {
+		return
+	}
+	if res.Header == nil {
+		res.Header = map[string][]string{}
+	}
+	res.Header["X-Cache"] = []string{string(told.Status)}
+	if told.Status == Hit || told.Status == Stale {
+		res.Header["Age"] = []string{strconv.Itoa(int(told.Age.Seconds()))}
+	}
+}
+
+// Cached data outranks scrapped data, stale data outranks everything
+func rank(status Status) int {
+	switch status {
+	case Stale:
+		return 3
+	case Hit:
+		return 2
+	case Miss:
+		return 1
+	}
+	return 0
+}
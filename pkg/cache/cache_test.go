@@ -0,0 +1,189 @@
+package cache
+
+import (
+	"errors"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"github.com/stretchr/testify/assert"
+	http_helpers "handler/function/pkg/http-helpers"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+type sample struct {
+	Name string `json:"name"`
+}
+
+// A cache whose clock is set by the test
+func newTestCache(now *time.Time) *Cache {
+	c := NewCache(NewMemoryStore(0), time.Minute, time.Hour)
+	c.now = func() time.Time { return *now }
+	return c
+}
+
+// Fetch function answering with name, and counting its calls
+func fetchSample(name string, calls *int) func() (*http_helpers.CampaignResult, error) {
+	return func() (*http_helpers.CampaignResult, error) {
+		*calls++
+		return &http_helpers.CampaignResult{Status: http.StatusOK, Data: &sample{Name: name}}, nil
+	}
+}
+
+func fetchError() (*http_helpers.CampaignResult, error) {
+	problem := http_helpers.Problem("/test", http.StatusInternalServerError, http_helpers.ScrappingFailed, "Roll20 couldn't be scrapped")
+	return &http_helpers.CampaignResult{Status: problem.Status, Error: problem}, errors.New("scrapping failed")
+}
+
+func TestFetchHitAndMiss(t *testing.T) {
+	now := t0
+	c := newTestCache(&now)
+	calls := 0
+
+	result, outcome, err := c.Fetch("k", false, &sample{}, fetchSample("first", &calls))
+	assert.Nil(t, err)
+	assert.Equal(t, &Outcome{Status: Miss}, outcome)
+	assert.Equal(t, &sample{Name: "first"}, result.Data)
+
+	now = t0.Add(30 * time.Second)
+	result, outcome, err = c.Fetch("k", false, &sample{}, fetchSample("second", &calls))
+	assert.Nil(t, err)
+	assert.Equal(t, &Outcome{Status: Hit, Age: 30 * time.Second}, outcome)
+	assert.Equal(t, http.StatusOK, result.Status)
+	assert.Equal(t, &sample{Name: "first"}, result.Data)
+	assert.Equal(t, 1, calls)
+
+	// Expired, scrapped again
+	now = t0.Add(time.Minute)
+	result, outcome, _ = c.Fetch("k", false, &sample{}, fetchSample("third", &calls))
+	assert.Equal(t, Miss, outcome.Status)
+	assert.Equal(t, &sample{Name: "third"}, result.Data)
+	assert.Equal(t, 2, calls)
+}
+
+func TestFetchBypass(t *testing.T) {
+	now := t0
+	c := newTestCache(&now)
+	calls := 0
+	c.Fetch("k", false, &sample{}, fetchSample("first", &calls))
+	result, outcome, _ := c.Fetch("k", true, &sample{}, fetchSample("second", &calls))
+	assert.Equal(t, &Outcome{Status: Bypass}, outcome)
+	assert.Equal(t, &sample{Name: "second"}, result.Data)
+	// The bypassing result still refreshes the cache
+	result, _, _ = c.Fetch("k", false, &sample{}, fetchSample("third", &calls))
+	assert.Equal(t, &sample{Name: "second"}, result.Data)
+}
+
+func TestFetchStaleIfError(t *testing.T) {
+	now := t0
+	c := newTestCache(&now)
+	calls := 0
+	c.Fetch("k", false, &sample{}, fetchSample("first", &calls))
+
+	// Expired, but within the stale-if-error window. Bypassing the cache doesn't prevent it
+	now = t0.Add(30 * time.Minute)
+	for _, noCache := range []bool{false, true} {
+		result, outcome, err := c.Fetch("k", noCache, &sample{}, fetchError)
+		assert.Nil(t, err)
+		assert.Equal(t, &Outcome{Status: Stale, Age: 30 * time.Minute}, outcome)
+		assert.Equal(t, &sample{Name: "first"}, result.Data)
+	}
+
+	// Too old to be served
+	now = t0.Add(2 * time.Hour)
+	result, outcome, err := c.Fetch("k", false, &sample{}, fetchError)
+	assert.Error(t, err)
+	assert.Equal(t, Miss, outcome.Status)
+	assert.Equal(t, http.StatusInternalServerError, result.Status)
+}
+
+// Incomplete results aren't cached, and client errors aren't hidden by stale entries
+func TestFetchUncached(t *testing.T) {
+	now := t0
+	c := newTestCache(&now)
+	calls := 0
+	c.Fetch("k", false, &sample{}, func() (*http_helpers.CampaignResult, error) {
+		calls++
+		return &http_helpers.CampaignResult{Status: http.StatusMultiStatus, Data: &sample{Name: "partial"}}, nil
+	})
+	_, outcome, _ := c.Fetch("k", false, &sample{}, fetchSample("complete", &calls))
+	assert.Equal(t, Miss, outcome.Status)
+	assert.Equal(t, 2, calls)
+
+	now = t0.Add(2 * time.Minute)
+	result, _, _ := c.Fetch("k", false, &sample{}, func() (*http_helpers.CampaignResult, error) {
+		problem := http_helpers.Problem("/test", http.StatusNotFound, http_helpers.GameNotJoined, "Not joined")
+		return &http_helpers.CampaignResult{Status: problem.Status, Error: problem}, nil
+	})
+	assert.Equal(t, http.StatusNotFound, result.Status)
+}
+
+func TestFetchDisabled(t *testing.T) {
+	var c *Cache
+	calls := 0
+	result, outcome, err := c.Fetch("k", false, &sample{}, fetchSample("first", &calls))
+	assert.Nil(t, err)
+	assert.Nil(t, outcome)
+	assert.Equal(t, &sample{Name: "first"}, result.Data)
+}
+
+func TestFromEnv(t *testing.T) {
+	for _, key := range []string{"CACHE_BACKEND", "CACHE_DIR", "CACHE_TTL", "CACHE_STALE_IF_ERROR"} {
+		os.Unsetenv(key)
+		defer os.Unsetenv(key)
+	}
+	c, err := FromEnv(time.Minute)
+	assert.Nil(t, err)
+	assert.Nil(t, c)
+
+	os.Setenv("CACHE_BACKEND", "memory")
+	c, err = FromEnv(time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Minute, c.ttl)
+	assert.Equal(t, DEFAULT_STALE_IF_ERROR, c.staleIfError)
+
+	os.Setenv("CACHE_BACKEND", "file")
+	os.Setenv("CACHE_DIR", t.TempDir())
+	os.Setenv("CACHE_TTL", "10s")
+	os.Setenv("CACHE_STALE_IF_ERROR", "1h")
+	c, err = FromEnv(time.Minute)
+	assert.Nil(t, err)
+	assert.IsType(t, &FileStore{}, c.store)
+	assert.Equal(t, 10*time.Second, c.ttl)
+	assert.Equal(t, time.Hour, c.staleIfError)
+
+	os.Setenv("CACHE_TTL", "soon")
+	_, err = FromEnv(time.Minute)
+	assert.Error(t, err)
+
+	os.Setenv("CACHE_TTL", "10s")
+	os.Setenv("CACHE_BACKEND", "redis")
+	_, err = FromEnv(time.Minute)
+	assert.Error(t, err)
+}
+
+func TestNoCache(t *testing.T) {
+	assert.False(t, NoCache(handler2.Request{}))
+	assert.True(t, NoCache(handler2.Request{Header: http.Header{"Cache-Control": {"max-age=0, No-Cache"}}}))
+	assert.False(t, NoCache(handler2.Request{Header: http.Header{"Cache-Control": {"no-store"}}}))
+}
+
+func TestSetHeaders(t *testing.T) {
+	var res handler2.Response
+	SetHeaders(&res, nil)
+	assert.Nil(t, res.Header)
+
+	SetHeaders(&res, &Outcome{Status: Miss})
+	assert.Equal(t, []string{"MISS"}, res.Header["X-Cache"])
+	assert.Empty(t, res.Header["Age"])
+
+	// The least fresh outcome is told
+	res = handler2.Response{Header: map[string][]string{}}
+	SetHeaders(&res, &Outcome{Status: Hit, Age: 10 * time.Second}, &Outcome{Status: Miss}, &Outcome{Status: Hit, Age: 90 * time.Second})
+	assert.Equal(t, []string{"HIT"}, res.Header["X-Cache"])
+	assert.Equal(t, []string{"90"}, res.Header["Age"])
+
+	SetHeaders(&res, &Outcome{Status: Hit, Age: 10 * time.Second}, &Outcome{Status: Stale, Age: 5 * time.Second})
+	assert.Equal(t, []string{"STALE"}, res.Header["X-Cache"])
+	assert.Equal(t, []string{"5"}, res.Header["Age"])
+}
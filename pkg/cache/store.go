@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Entry A cached value, along with when it was stored
+type Entry struct {
+	StoredAt time.Time       `json:"storedAt"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// Store Keeps the cached entries
+type Store interface {
+	// Get Retrieve the entry of key, or nil if there is none
+	Get(key string) (*Entry, error)
+	// Set Store the entry of key, replacing the previous one
+	Set(key string, entry *Entry) error
+}
+
+// Max number of entries of a MemoryStore
+const defaultMemoryCapacity = 1000
+
+// MemoryStore Store keeping entries in the memory of the process.
+// Once full, the oldest entry is evicted to make room for a new one
+type MemoryStore struct {
+	capacity int
+	entries  map[string]*Entry
+	mutex    sync.Mutex
+}
+
+// NewMemoryStore Build an empty memory store, holding at most capacity entries
+func NewMemoryStore(capacity int) *MemoryStore {
+	if capacity <= 0 {
+		capacity = defaultMemoryCapacity
+	}
+	return &MemoryStore{capacity: capacity, entries: make(map[string]*Entry)}
+}
+
+func (ms *MemoryStore) Get(key string) (*Entry, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	return ms.entries[key], nil
+}
+
+func (ms *MemoryStore) Set(key string, entry *Entry) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	if _, exists := ms.entries[key]; !exists && len(ms.entries) >= ms.capacity {
+		oldestKey := ""
+		for k, e := range ms.entries {
+			if oldestKey == "" || e.StoredAt.Before(ms.entries[oldestKey].StoredAt) {
+				oldestKey = k
+			}
+		}
+		delete(ms.entries, oldestKey)
+	}
+	ms.entries[key] = entry
+	return nil
+}
+
+// FileStore Store keeping each entry as a JSON file in a local directory
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore Build a store in the given directory, creating it if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("Unable to create the cache directory %s : %s", dir, err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (fs *FileStore) Get(key string) (*Entry, error) {
+	data, err := ioutil.ReadFile(fs.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the cache entry of %s : %s", key, err)
+	}
+	var entry Entry
+	if err = json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("The cache entry of %s is corrupted : %s", key, err)
+	}
+	return &entry, nil
+}
+
+func (fs *FileStore) Set(key string, entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	// Write then rename, concurrent readers must never see a partial entry
+	tmp, err := ioutil.TempFile(fs.dir, "*.tmp")
+	if err != nil {
+		return fmt.Errorf("Unable to cache %s : %s", key, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Unable to cache %s : %s", key, err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("Unable to cache %s : %s", key, err)
+	}
+	if err = os.Rename(tmp.Name(), fs.path(key)); err != nil {
+		return fmt.Errorf("Unable to cache %s : %s", key, err)
+	}
+	return nil
+}
+
+// Keys are hashed, they can hold any character
+func (fs *FileStore) path(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(fs.dir, hex.EncodeToString(hash[:])+".json")
+}
@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2022, 5, 1, 20, 0, 0, 0, time.UTC)
+
+func TestMemoryStoreRoundTrip(t *testing.T) {
+	store := NewMemoryStore(0)
+	entry, err := store.Get("get-players/1")
+	assert.Nil(t, err)
+	assert.Nil(t, entry)
+
+	assert.Nil(t, store.Set("get-players/1", &Entry{StoredAt: t0, Data: json.RawMessage(`[]`)}))
+	entry, err = store.Get("get-players/1")
+	assert.Nil(t, err)
+	assert.Equal(t, json.RawMessage(`[]`), entry.Data)
+}
+
+// Once full, the oldest entry makes room for the new one
+func TestMemoryStoreEviction(t *testing.T) {
+	store := NewMemoryStore(2)
+	assert.Nil(t, store.Set("b", &Entry{StoredAt: t0.Add(time.Minute)}))
+	assert.Nil(t, store.Set("a", &Entry{StoredAt: t0}))
+	// Replacing an entry doesn't evict anything
+	assert.Nil(t, store.Set("a", &Entry{StoredAt: t0.Add(2 * time.Minute)}))
+	assert.Nil(t, store.Set("c", &Entry{StoredAt: t0.Add(3 * time.Minute)}))
+
+	entry, _ := store.Get("b")
+	assert.Nil(t, entry)
+	entry, _ = store.Get("a")
+	assert.NotNil(t, entry)
+	entry, _ = store.Get("c")
+	assert.NotNil(t, entry)
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	assert.Nil(t, err)
+	entry, err := store.Get("get-summary/1")
+	assert.Nil(t, err)
+	assert.Nil(t, entry)
+
+	assert.Nil(t, store.Set("get-summary/1", &Entry{StoredAt: t0, Data: json.RawMessage(`{"name":"Test"}`)}))
+	// Another store on the same directory sees the same entries
+	other, err := NewFileStore(dir)
+	assert.Nil(t, err)
+	entry, err = other.Get("get-summary/1")
+	assert.Nil(t, err)
+	assert.True(t, t0.Equal(entry.StoredAt))
+	assert.JSONEq(t, `{"name":"Test"}`, string(entry.Data))
+
+	// No temporary file is left behind
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 1)
+}
+
+func TestFileStoreCorrupted(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(store.path("get-summary/1"), []byte("{"), 0o644))
+	_, err = store.Get("get-summary/1")
+	assert.Error(t, err)
+}
+
+func TestFileStoreInvalidDir(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	assert.Nil(t, ioutil.WriteFile(file, nil, 0o644))
+	_, err := NewFileStore(filepath.Join(file, "cache"))
+	assert.Error(t, err)
+	_, err = os.Stat(file)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,245 @@
+package callback
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"handler/function/pkg/auth"
+	http_helpers "handler/function/pkg/http-helpers"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Header of OpenFaaS async invocations, telling where the result is expected
+const CallbackUrlHeader = "X-Callback-Url"
+
+// Headers of the callbacks, besides the auth ones carrying the signature
+const (
+	// Same ID for every attempt, the one of the invocation
+	CorrelationIdHeader = "X-Correlation-Id"
+	// Status the function answered with, as the OpenFaaS queue worker names it
+	FunctionStatusHeader = "X-Function-Status"
+	// Path of the function having answered
+	FunctionNameHeader = "X-Function-Name"
+	// Number of the attempt, starting at 1
+	AttemptHeader = "X-Callback-Attempt"
+)
+
+// Secret holding the key callbacks are signed with. Callbacks are sent unsigned when it doesn't exist
+const CALLBACK_KEY_SECRET = "callback-key"
+
+// Defaults of the optional variables read by SenderFromEnv
+const (
+	DEFAULT_RETRIES = 3
+	DEFAULT_BACKOFF = time.Second
+	DEFAULT_TIMEOUT = 10 * time.Second
+)
+
+// Callback Where and how the result of an invocation must be delivered
+type Callback struct {
+	Url           *url.URL
+	CorrelationId string
+	// Path of the invoked function
+	Function string
+}
+
+// FromRequest The callback requested by req, a call to the function at path. Nil if req isn't an async invocation
+func FromRequest(req handler2.Request, function string) (*Callback, error) {
+	raw := req.Header.Get(CallbackUrlHeader)
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	target, err := url.Parse(raw)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || len(target.Host) == 0 {
+		return nil, fmt.Errorf("Invalid %s %q. Should be an absolute http or https URL", CallbackUrlHeader, raw)
+	}
+	return &Callback{Url: target, CorrelationId: http_helpers.RequestId(req), Function: function}, nil
+}
+
+// Sender Delivers results to callbacks, retrying on failures
+type Sender struct {
+	client *http.Client
+	// Nil when callbacks aren't signed
+	secret []byte
+	// Attempts after the first one
+	retries int
+	// Wait before the first retry, doubled after each of them
+	backoff time.Duration
+	sleep   func(time.Duration)
+	now     func() time.Time
+}
+
+// FromEnv Build the sender of the functions. The signing key is read from the callback-key secret,
+// the other settings as described by SenderFromEnv
+func FromEnv() (*Sender, error) {
+	secret, err := ioutil.ReadFile(filepath.Join(auth.SecretsDir(), CALLBACK_KEY_SECRET))
+	if os.IsNotExist(err) {
+		secret, err = nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the %s secret : %s", CALLBACK_KEY_SECRET, err)
+	}
+	return SenderFromEnv(secret)
+}
+
+// SenderFromEnv Build a sender signing with secret, left unsigned if it is empty.
+// The following optional variables are read :
+//   - CALLBACK_RETRIES : number of retries after a failed delivery
+//   - CALLBACK_BACKOFF : wait before the first retry, doubled after each of them
+//   - CALLBACK_TIMEOUT : max duration of a single delivery attempt
+func SenderFromEnv(secret []byte) (*Sender, error) {
+	retries := DEFAULT_RETRIES
+	if value, isSet := os.LookupEnv("CALLBACK_RETRIES"); isSet {
+		var err error
+		if retries, err = strconv.Atoi(value); err != nil || retries < 0 {
+			return nil, fmt.Errorf("Invalid CALLBACK_RETRIES %q. Should be a positive integer", value)
+		}
+	}
+	durations := map[string]time.Duration{"CALLBACK_BACKOFF": DEFAULT_BACKOFF, "CALLBACK_TIMEOUT": DEFAULT_TIMEOUT}
+	for name := range durations {
+		value, isSet := os.LookupEnv(name)
+		if !isSet {
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("Invalid %s %q. Should be a duration such as 500ms or 10s", name, value)
+		}
+		durations[name] = duration
+	}
+	if secret = bytes.TrimSpace(secret); len(secret) == 0 {
+		secret = nil
+	}
+	return NewSender(&http.Client{Timeout: durations["CALLBACK_TIMEOUT"]}, secret, retries, durations["CALLBACK_BACKOFF"]), nil
+}
+
+// NewSender Sender signing callbacks with secret, nil to leave them unsigned
+func NewSender(client *http.Client, secret []byte, retries int, backoff time.Duration) *Sender {
+	return &Sender{client: client, secret: secret, retries: retries, backoff: backoff, sleep: time.Sleep, now: time.Now}
+}
+
+// DeliveryError The result couldn't be delivered to the callback
+type DeliveryError struct {
+	Attempts int
+	Reason   string
+}
+
+func (e *DeliveryError) Error() string {
+	return fmt.Sprintf("Callback not delivered after %d attempts : %s", e.Attempts, e.Reason)
+}
+
+// Deliver POST res to the callback. Network errors, timeouts, 429 and 5xx are retried,
+// any other status is the receiver refusing the callback
+func (s *Sender) Deliver(cb *Callback, res handler2.Response) error {
+	wait := s.backoff
+	for attempt := 1; ; attempt++ {
+		status, err := s.post(cb, res, attempt)
+		if err == nil && status < 300 {
+			return nil
+		}
+		reason := fmt.Sprintf("the receiver answered %d", status)
+		if err != nil {
+			reason = err.Error()
+		}
+		if attempt > s.retries || (err == nil && !isRetryable(status)) {
+			return &DeliveryError{Attempts: attempt, Reason: reason}
+		}
+		log.Printf("Callback %s attempt %d failed, retrying in %s : %s\n", cb.CorrelationId, attempt, wait, reason)
+		s.sleep(wait)
+		wait *= 2
+	}
+}
+
+// Send a single attempt, signed with its own timestamp so that receivers rejecting replays accept retries
+func (s *Sender) post(cb *Callback, res handler2.Response, attempt int) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, cb.Url.String(), bytes.NewReader(res.Body))
+	if err != nil {
+		return 0, err
+	}
+	contentType := "application/json"
+	for key, values := range res.Header {
+		if strings.EqualFold(key, "Content-type") && len(values) != 0 {
+			contentType = values[0]
+		}
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(CorrelationIdHeader, cb.CorrelationId)
+	req.Header.Set(FunctionStatusHeader, strconv.Itoa(res.StatusCode))
+	req.Header.Set(FunctionNameHeader, cb.Function)
+	req.Header.Set(AttemptHeader, strconv.Itoa(attempt))
+	if s.secret != nil {
+		timestamp := strconv.FormatInt(s.now().Unix(), 10)
+		signature := auth.Sign(s.secret, http.MethodPost, cb.Url.EscapedPath(), cb.Url.RawQuery, timestamp, res.Body)
+		req.Header.Set(auth.TimestampHeader, timestamp)
+		req.Header.Set(auth.SignatureHeader, "sha256="+hex.EncodeToString(signature))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// Whether the receiver may accept the callback later
+func isRetryable(status int) bool {
+	return status >= 500 || status == http.StatusTooManyRequests || status == http.StatusRequestTimeout
+}
+
+// Respond Answer req with handle, the function at path, once its caller is authenticated. For async invocations,
+// the response is also POSTed to the callback URL, sharing the request ID of the invocation as correlation ID.
+// As the functions would otherwise POST to any URL they are given, callbacks are only accepted from authenticated
+// callers, always signed with the callback-key secret, and never made of the response to a rejected call
+func Respond(req handler2.Request, function string, handle func(req handler2.Request, identity *auth.Identity) (handler2.Response, error)) (handler2.Response, error) {
+	// The identity is handed to handle, signatures being single-use
+	identity, err := auth.Authenticate(req, function)
+	if err != nil {
+		log.Printf("Unauthenticated call : %s\n", err)
+		return auth.NewAuthProblem(req, function, err), err
+	}
+	cb, err := FromRequest(req, function)
+	if err != nil {
+		log.Printf("Invalid callback : %s\n", err)
+		return http_helpers.NewProblem(req, function, http.StatusBadRequest, http_helpers.InvalidQuery, err.Error()), err
+	}
+	if cb == nil {
+		return handle(req, identity)
+	}
+	if identity == nil {
+		err = fmt.Errorf("Callbacks are only accepted from authenticated callers, and authentication is disabled")
+		log.Printf("Refused callback : %s\n", err)
+		return http_helpers.NewProblem(req, function, http.StatusBadRequest, http_helpers.CallbackRefused, err.Error()), err
+	}
+	sender, err := FromEnv()
+	if err != nil {
+		log.Printf("Invalid env : %s\n", err)
+		return http_helpers.NewProblem(req, function, http.StatusInternalServerError, http_helpers.MissingConfiguration, "The callback settings are invalid"), err
+	}
+	if sender.secret == nil {
+		err = fmt.Errorf("Callbacks are disabled, as there is no %s secret to sign them with", CALLBACK_KEY_SECRET)
+		log.Printf("Refused callback : %s\n", err)
+		return http_helpers.NewProblem(req, function, http.StatusBadRequest, http_helpers.CallbackRefused, err.Error()), err
+	}
+	// Errors are delivered too, and must carry the ID the receiver correlates on
+	req.Header = req.Header.Clone()
+	req.Header.Set(http_helpers.RequestIdHeader, cb.CorrelationId)
+	res, err := handle(req, identity)
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		log.Printf("Callback %s not delivered, the call was rejected\n", cb.CorrelationId)
+		return res, err
+	}
+	if deliveryErr := sender.Deliver(cb, res); deliveryErr != nil {
+		log.Printf("Callback %s to %s failed : %s\n", cb.CorrelationId, cb.Url.Redacted(), deliveryErr)
+	} else {
+		log.Printf("Callback %s delivered to %s\n", cb.CorrelationId, cb.Url.Redacted())
+	}
+	return res, err
+}
@@ -0,0 +1,307 @@
+package callback
+
+import (
+	"encoding/hex"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"github.com/stretchr/testify/assert"
+	"handler/function/pkg/auth"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2022, 5, 1, 20, 0, 0, 0, time.UTC)
+
+// A callback as the receiver got it
+type received struct {
+	header http.Header
+	path   string
+	query  string
+	body   []byte
+}
+
+// Receiver answering the given statuses in turn, then 200
+func setupReceiver(statuses ...int) (*httptest.Server, func() []received) {
+	var mutex sync.Mutex
+	var calls []received
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mutex.Lock()
+		calls = append(calls, received{header: r.Header, path: r.URL.Path, query: r.URL.RawQuery, body: body})
+		attempt := len(calls)
+		mutex.Unlock()
+		if attempt <= len(statuses) {
+			w.WriteHeader(statuses[attempt-1])
+		}
+	}))
+	return server, func() []received {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]received{}, calls...)
+	}
+}
+
+// Sender not waiting between retries, recording the waits instead
+func testSender(secret []byte, retries int) (*Sender, *[]time.Duration) {
+	var waits []time.Duration
+	s := NewSender(&http.Client{Timeout: time.Second}, secret, retries, time.Second)
+	s.sleep = func(d time.Duration) { waits = append(waits, d) }
+	s.now = func() time.Time { return t0 }
+	return s, &waits
+}
+
+func testCallback(t *testing.T, server *httptest.Server) *Callback {
+	cb, err := FromRequest(handler2.Request{Header: http.Header{CallbackUrlHeader: {server.URL + "/hooks/roll20?source=faas"}, "X-Call-Id": {"call-1"}}}, "/get-messages")
+	assert.Nil(t, err)
+	return cb
+}
+
+func TestFromRequest(t *testing.T) {
+	cb, err := FromRequest(handler2.Request{}, "/get-messages")
+	assert.Nil(t, err)
+	assert.Nil(t, cb)
+
+	cb, err = FromRequest(handler2.Request{Header: http.Header{CallbackUrlHeader: {"https://example.com/hook"}, "X-Call-Id": {"call-1"}}}, "/get-messages")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://example.com/hook", cb.Url.String())
+	assert.Equal(t, "call-1", cb.CorrelationId)
+	assert.Equal(t, "/get-messages", cb.Function)
+
+	// Without an ID given by the caller, one is generated
+	cb, err = FromRequest(handler2.Request{Header: http.Header{CallbackUrlHeader: {"http://example.com/hook"}}}, "/get-messages")
+	assert.Nil(t, err)
+	assert.Len(t, cb.CorrelationId, 32)
+
+	for _, invalid := range []string{"example.com/hook", "ftp://example.com/hook", "http://", "://"} {
+		_, err = FromRequest(handler2.Request{Header: http.Header{CallbackUrlHeader: {invalid}}}, "/get-messages")
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	server, calls := setupReceiver()
+	defer server.Close()
+	s, waits := testSender([]byte("s3cr3t"), 3)
+	res := handler2.Response{StatusCode: http.StatusMultiStatus, Body: []byte(`[{"content":"Hello"}]`), Header: http.Header{"Content-type": {"application/x-ndjson"}}}
+
+	err := s.Deliver(testCallback(t, server), res)
+	assert.Nil(t, err)
+	assert.Empty(t, *waits)
+	assert.Len(t, calls(), 1)
+	call := calls()[0]
+	assert.Equal(t, "/hooks/roll20", call.path)
+	assert.Equal(t, "source=faas", call.query)
+	assert.Equal(t, res.Body, call.body)
+	assert.Equal(t, "application/x-ndjson", call.header.Get("Content-Type"))
+	assert.Equal(t, "call-1", call.header.Get(CorrelationIdHeader))
+	assert.Equal(t, "207", call.header.Get(FunctionStatusHeader))
+	assert.Equal(t, "/get-messages", call.header.Get(FunctionNameHeader))
+	assert.Equal(t, "1", call.header.Get(AttemptHeader))
+
+	// The signature is the one callers sign their requests with
+	timestamp := call.header.Get(auth.TimestampHeader)
+	assert.Equal(t, "1651435200", timestamp)
+	expected := auth.Sign([]byte("s3cr3t"), "POST", "/hooks/roll20", "source=faas", timestamp, res.Body)
+	assert.Equal(t, "sha256="+hex.EncodeToString(expected), call.header.Get(auth.SignatureHeader))
+}
+
+func TestDeliverUnsigned(t *testing.T) {
+	server, calls := setupReceiver()
+	defer server.Close()
+	s, _ := testSender(nil, 3)
+
+	err := s.Deliver(testCallback(t, server), handler2.Response{StatusCode: http.StatusOK, Body: []byte(`[]`)})
+	assert.Nil(t, err)
+	call := calls()[0]
+	assert.Empty(t, call.header.Get(auth.SignatureHeader))
+	assert.Equal(t, "application/json", call.header.Get("Content-Type"))
+}
+
+func TestDeliverRetries(t *testing.T) {
+	server, calls := setupReceiver(http.StatusBadGateway, http.StatusTooManyRequests)
+	defer server.Close()
+	s, waits := testSender([]byte("s3cr3t"), 3)
+
+	err := s.Deliver(testCallback(t, server), handler2.Response{StatusCode: http.StatusOK, Body: []byte(`[]`)})
+	assert.Nil(t, err)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, *waits)
+	assert.Len(t, calls(), 3)
+	for i, call := range calls() {
+		assert.Equal(t, "call-1", call.header.Get(CorrelationIdHeader))
+		assert.Equal(t, strconv.Itoa(i+1), call.header.Get(AttemptHeader))
+	}
+}
+
+func TestDeliverGivesUp(t *testing.T) {
+	server, calls := setupReceiver(500, 500, 500, 500, 500)
+	defer server.Close()
+	s, waits := testSender(nil, 2)
+
+	err := s.Deliver(testCallback(t, server), handler2.Response{StatusCode: http.StatusOK, Body: []byte(`[]`)})
+	assert.IsType(t, &DeliveryError{}, err)
+	assert.Equal(t, 3, err.(*DeliveryError).Attempts)
+	assert.Len(t, calls(), 3)
+	assert.Len(t, *waits, 2)
+
+	// A receiver refusing the callback won't change its mind
+	refusing, refusedCalls := setupReceiver(http.StatusBadRequest)
+	defer refusing.Close()
+	err = s.Deliver(testCallback(t, refusing), handler2.Response{StatusCode: http.StatusOK, Body: []byte(`[]`)})
+	assert.IsType(t, &DeliveryError{}, err)
+	assert.Len(t, refusedCalls(), 1)
+
+	// Neither will an unreachable one, once the retries are exhausted
+	server.Close()
+	err = s.Deliver(testCallback(t, server), handler2.Response{StatusCode: http.StatusOK, Body: []byte(`[]`)})
+	assert.IsType(t, &DeliveryError{}, err)
+}
+
+func TestFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AUTH_SECRETS_DIR", dir)
+	s, err := FromEnv()
+	assert.Nil(t, err)
+	assert.Nil(t, s.secret)
+	assert.Equal(t, DEFAULT_RETRIES, s.retries)
+	assert.Equal(t, DEFAULT_BACKOFF, s.backoff)
+	assert.Equal(t, DEFAULT_TIMEOUT, s.client.Timeout)
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, CALLBACK_KEY_SECRET), []byte("s3cr3t\n"), 0o600))
+	t.Setenv("CALLBACK_RETRIES", "0")
+	t.Setenv("CALLBACK_BACKOFF", "200ms")
+	t.Setenv("CALLBACK_TIMEOUT", "3s")
+	s, err = FromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("s3cr3t"), s.secret)
+	assert.Equal(t, 0, s.retries)
+	assert.Equal(t, 200*time.Millisecond, s.backoff)
+	assert.Equal(t, 3*time.Second, s.client.Timeout)
+
+	for name, value := range map[string]string{"CALLBACK_RETRIES": "-1", "CALLBACK_BACKOFF": "soon", "CALLBACK_TIMEOUT": "0s"} {
+		t.Setenv(name, value)
+		_, err = FromEnv()
+		assert.Error(t, err, name)
+		os.Unsetenv(name)
+	}
+}
+
+// Secrets enabling authentication, with callbacks signed unless the key is empty
+func setupSecrets(t *testing.T, callbackKey string) {
+	dir := t.TempDir()
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, auth.API_KEYS_SECRET), []byte("team-a:key-a\n"), 0o600))
+	if len(callbackKey) != 0 {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, CALLBACK_KEY_SECRET), []byte(callbackKey), 0o600))
+	}
+	t.Setenv("AUTH_SECRETS_DIR", dir)
+}
+
+func TestRespond(t *testing.T) {
+	server, calls := setupReceiver()
+	defer server.Close()
+	setupSecrets(t, "s3cr3t")
+	var identities []*auth.Identity
+	handle := func(req handler2.Request, identity *auth.Identity) (handler2.Response, error) {
+		identities = append(identities, identity)
+		return handler2.Response{StatusCode: http.StatusOK, Body: []byte(req.Header.Get("X-Request-Id"))}, nil
+	}
+	authenticated := func(header http.Header) handler2.Request {
+		header.Set(auth.ApiKeyHeader, "key-a")
+		return handler2.Request{Header: header}
+	}
+
+	// Synchronous invocations are answered as usual
+	res, err := Respond(authenticated(http.Header{}), "/get-messages", handle)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "team-a", identities[0].Name)
+	assert.Empty(t, calls())
+
+	// The correlation ID is the request ID the function sees
+	res, err = Respond(authenticated(http.Header{CallbackUrlHeader: {server.URL}}), "/get-messages", handle)
+	assert.Nil(t, err)
+	assert.Len(t, calls(), 1)
+	assert.Equal(t, string(res.Body), calls()[0].header.Get(CorrelationIdHeader))
+	assert.Equal(t, res.Body, calls()[0].body)
+	assert.NotEmpty(t, calls()[0].header.Get(auth.SignatureHeader))
+
+	res, err = Respond(authenticated(http.Header{CallbackUrlHeader: {"not a url"}}), "/get-messages", handle)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Len(t, calls(), 1)
+	assert.Len(t, identities, 2)
+}
+
+// Unauthenticated callers can't make the function POST anywhere, not even their 401
+func TestRespondUnauthenticated(t *testing.T) {
+	server, calls := setupReceiver()
+	defer server.Close()
+	setupSecrets(t, "s3cr3t")
+	called := false
+	handle := func(req handler2.Request, identity *auth.Identity) (handler2.Response, error) {
+		called = true
+		return handler2.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	for _, key := range []string{"", "wrong"} {
+		res, err := Respond(handler2.Request{Header: http.Header{CallbackUrlHeader: {server.URL}, auth.ApiKeyHeader: {key}}}, "/get-messages", handle)
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	}
+	assert.False(t, called)
+	assert.Empty(t, calls())
+}
+
+// Rejected calls are answered, never delivered
+func TestRespondForbidden(t *testing.T) {
+	server, calls := setupReceiver()
+	defer server.Close()
+	setupSecrets(t, "s3cr3t")
+	handle := func(req handler2.Request, identity *auth.Identity) (handler2.Response, error) {
+		err := &auth.ForbiddenError{Reason: "Not your campaign"}
+		return auth.NewAuthProblem(req, "/get-messages", err), err
+	}
+
+	res, err := Respond(handler2.Request{Header: http.Header{CallbackUrlHeader: {server.URL}, auth.ApiKeyHeader: {"key-a"}}}, "/get-messages", handle)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	assert.Empty(t, calls())
+}
+
+// Callbacks are refused when they couldn't be signed, or their caller authenticated
+func TestRespondRefused(t *testing.T) {
+	server, calls := setupReceiver()
+	defer server.Close()
+	called := false
+	handle := func(req handler2.Request, identity *auth.Identity) (handler2.Response, error) {
+		called = true
+		return handler2.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	setupSecrets(t, "")
+	res, err := Respond(handler2.Request{Header: http.Header{CallbackUrlHeader: {server.URL}, auth.ApiKeyHeader: {"key-a"}}}, "/get-messages", handle)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Contains(t, string(res.Body), "callback-refused")
+
+	// Authentication disabled, the callback key alone isn't enough
+	dir := t.TempDir()
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, CALLBACK_KEY_SECRET), []byte("s3cr3t"), 0o600))
+	t.Setenv("AUTH_SECRETS_DIR", dir)
+	res, err = Respond(handler2.Request{Header: http.Header{CallbackUrlHeader: {server.URL}}}, "/get-messages", handle)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Contains(t, string(res.Body), "callback-refused")
+
+	// Synchronous invocations still work without authentication
+	res, err = Respond(handler2.Request{}, "/get-messages", handle)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.True(t, called)
+	assert.Empty(t, calls())
+}
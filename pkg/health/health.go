@@ -0,0 +1,190 @@
+package health
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	config_parser "handler/function/pkg/config-parser"
+	"handler/function/pkg/scrapper"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status Outcome of a check, or of all of them
+type Status string
+
+const (
+	Pass Status = "pass"
+	Fail Status = "fail"
+	// The check couldn't be run, either not being configured or depending on a failed one
+	Skip Status = "skip"
+)
+
+// Names of the readiness checks, in the order they are run
+const (
+	ConfigCheck         = "config"
+	LoginCheck          = "roll20-login"
+	CanaryCampaignCheck = "canary-campaign"
+)
+
+// How long the outcome of the Roll20 checks is reused when HEALTH_CACHE_TTL isn't defined
+const DEFAULT_CACHE_TTL = 5 * time.Minute
+
+var validCampaignId = regexp.MustCompile(`^[0-9]+$`)
+
+// swagger:model HealthCheck
+//HealthCheck Outcome of a single readiness check
+type Check struct {
+	// Name of the check. One of "config", "roll20-login" or "canary-campaign"
+	// required: true
+	Name string `json:"name"`
+	// One of "pass", "fail" or "skip"
+	// required: true
+	Status Status `json:"status"`
+	// Why the check failed or was skipped
+	Detail string `json:"detail,omitempty"`
+	// When the check was run. The Roll20 checks are cached, and may have been run by a previous probe
+	// required: true
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// swagger:model Readiness
+//Readiness Outcome of all readiness checks
+type Report struct {
+	// "fail" if any check failed, "pass" otherwise
+	// required: true
+	Status Status `json:"status"`
+	// required: true
+	Checks []Check `json:"checks"`
+}
+
+// Items Row-oriented formats only list the checks
+func (r *Report) Items() interface{} {
+	return r.Checks
+}
+
+// Checker Runs the readiness checks. The Roll20 ones are cached, so that frequent probes neither slow Roll20
+// down nor lock the bot account out after a failed login
+type Checker struct {
+	now   func() time.Time
+	mutex sync.Mutex
+	// What the cached checks were run against. A configuration change runs them again
+	key       string
+	roll20    []Check
+	expiresAt time.Time
+}
+
+// Shared by all invocations of the function, as the process outlives them
+var checker = NewChecker()
+
+// NewChecker Checker with an empty cache
+func NewChecker() *Checker {
+	return &Checker{now: time.Now}
+}
+
+// Ready Run the readiness checks of the function, see Checker.Ready
+func Ready() *Report {
+	return checker.Ready()
+}
+
+// Ready Check that the configuration is valid, that the bot account can log in to Roll20, and that the details page
+// of the canary campaign still has what the scrapper depends on. The following optional variables are read :
+//   - HEALTH_CACHE_TTL : how long the outcome of the Roll20 checks is reused
+//   - HEALTH_CANARY_CAMPAIGN : ID of a campaign the bot account has joined. The canary check is skipped without it
+func (c *Checker) Ready() *Report {
+	now := c.now().UTC()
+	config := Check{Name: ConfigCheck, Status: Pass, CheckedAt: now}
+	values, ttl, canary, err := readConfig()
+	var roll20 []Check
+	if err != nil {
+		config.Status, config.Detail = Fail, err.Error()
+		roll20 = []Check{
+			{Name: LoginCheck, Status: Skip, Detail: "The configuration is invalid", CheckedAt: now},
+			{Name: CanaryCampaignCheck, Status: Skip, Detail: "The configuration is invalid", CheckedAt: now},
+		}
+	} else {
+		roll20 = c.checkRoll20(values, canary, ttl)
+	}
+	report := &Report{Status: Pass, Checks: append([]Check{config}, roll20...)}
+	for _, check := range report.Checks {
+		if check.Status == Fail {
+			report.Status = Fail
+		}
+	}
+	return report
+}
+
+// The Roll20 checks, from the cache while they are fresh
+func (c *Checker) checkRoll20(values map[string]string, canary string, ttl time.Duration) []Check {
+	// Credentials are only kept hashed
+	hash := sha256.Sum256([]byte(strings.Join([]string{values["ROLL20_BASE_URL"], values["ROLL20_USERNAME"], values["ROLL20_PASSWORD"], canary}, "\n")))
+	key := hex.EncodeToString(hash[:])
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.key == key && c.now().Before(c.expiresAt) {
+		return append([]Check{}, c.roll20...)
+	}
+	c.roll20 = runRoll20Checks(values, canary, c.now().UTC())
+	c.key, c.expiresAt = key, c.now().Add(ttl)
+	return append([]Check{}, c.roll20...)
+}
+
+func runRoll20Checks(values map[string]string, canary string, now time.Time) []Check {
+	login := Check{Name: LoginCheck, Status: Pass, CheckedAt: now}
+	details := Check{Name: CanaryCampaignCheck, Status: Pass, CheckedAt: now}
+	s, err := scrapper.NewScrapper(values["ROLL20_BASE_URL"], &scrapper.Roll20Account{Login: values["ROLL20_USERNAME"], Password: values["ROLL20_PASSWORD"]}, nil)
+	if err != nil {
+		// The error holds the credentials, only the logs get it
+		log.Printf("Readiness : the bot account couldn't log in. Error %s\n", err)
+		login.Status, login.Detail = Fail, "The bot account couldn't log in to Roll20"
+		details.Status, details.Detail = Skip, "The bot account couldn't log in to Roll20"
+		return []Check{login, details}
+	}
+	if len(canary) == 0 {
+		details.Status, details.Detail = Skip, "HEALTH_CANARY_CAMPAIGN isn't defined"
+		return []Check{login, details}
+	}
+	missing, err := s.CheckCampaignDetails(canary)
+	if err != nil {
+		log.Printf("Readiness : the canary campaign couldn't be retrieved. Error %s\n", err)
+		details.Status, details.Detail = Fail, fmt.Sprintf("The details page of campaign %s couldn't be retrieved", canary)
+	} else if len(missing) != 0 {
+		log.Printf("Readiness : selectors missing from the details page of campaign %s : %s\n", canary, strings.Join(missing, ", "))
+		details.Status, details.Detail = Fail, fmt.Sprintf("The details page of campaign %s is missing %s", canary, strings.Join(missing, ", "))
+	}
+	return []Check{login, details}
+}
+
+// Read and validate the variables the readiness checks depend on
+func readConfig() (map[string]string, time.Duration, string, error) {
+	var keys = []string{"ROLL20_USERNAME", "ROLL20_PASSWORD", "ROLL20_BASE_URL"}
+	values, err := config_parser.ParseEnv(keys)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	for _, key := range keys {
+		if len(values[key]) == 0 {
+			return nil, 0, "", fmt.Errorf("%s is empty", key)
+		}
+	}
+	base, err := url.Parse(values["ROLL20_BASE_URL"])
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || len(base.Host) == 0 {
+		return nil, 0, "", fmt.Errorf("Invalid ROLL20_BASE_URL %q. Should be an absolute http or https URL", values["ROLL20_BASE_URL"])
+	}
+	ttl := DEFAULT_CACHE_TTL
+	if value, isSet := os.LookupEnv("HEALTH_CACHE_TTL"); isSet {
+		if ttl, err = time.ParseDuration(value); err != nil || ttl < 0 {
+			return nil, 0, "", fmt.Errorf("Invalid HEALTH_CACHE_TTL %q. Should be a duration such as 30s or 5m", value)
+		}
+	}
+	canary := os.Getenv("HEALTH_CANARY_CAMPAIGN")
+	if len(canary) != 0 && !validCampaignId.MatchString(canary) {
+		return nil, 0, "", fmt.Errorf("Invalid HEALTH_CANARY_CAMPAIGN %q. Should be a numeric campaign ID", canary)
+	}
+	return values, ttl, canary, nil
+}
@@ -0,0 +1,136 @@
+package health
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2022, 5, 1, 20, 0, 0, 0, time.UTC)
+
+// Mock Roll20, answering logins with loginStatus and campaign details with the given sample. Logins are counted
+func setupRoll20(t *testing.T, loginStatus int, detailsPath string) *int32 {
+	_, filename, _, _ := runtime.Caller(0)
+	details, _ := ioutil.ReadFile(path.Join(path.Dir(filename), "../..", detailsPath))
+	var logins int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/campaigns/details/") {
+			w.Write(details)
+			return
+		}
+		if r.Method == http.MethodPost {
+			atomic.AddInt32(&logins, 1)
+			w.WriteHeader(loginStatus)
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("ROLL20_BASE_URL", server.URL)
+	t.Setenv("ROLL20_USERNAME", "mock")
+	t.Setenv("ROLL20_PASSWORD", "mock")
+	return &logins
+}
+
+// Checker with a clock moved by hand
+func testChecker() (*Checker, *time.Time) {
+	now := t0
+	c := NewChecker()
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func statuses(report *Report) map[string]Status {
+	byName := make(map[string]Status)
+	for _, check := range report.Checks {
+		byName[check.Name] = check.Status
+	}
+	return byName
+}
+
+func TestReady(t *testing.T) {
+	logins := setupRoll20(t, http.StatusOK, "assets/sample_campaign_page.html")
+	t.Setenv("HEALTH_CANARY_CAMPAIGN", "5632681")
+	c, now := testChecker()
+
+	report := c.Ready()
+	assert.Equal(t, Pass, report.Status)
+	assert.Equal(t, map[string]Status{ConfigCheck: Pass, LoginCheck: Pass, CanaryCampaignCheck: Pass}, statuses(report))
+	assert.Equal(t, []string{ConfigCheck, LoginCheck, CanaryCampaignCheck}, []string{report.Checks[0].Name, report.Checks[1].Name, report.Checks[2].Name})
+	assert.Equal(t, int32(1), atomic.LoadInt32(logins))
+
+	// Probes within the TTL reuse the outcome
+	*now = now.Add(time.Minute)
+	report = c.Ready()
+	assert.Equal(t, Pass, report.Status)
+	assert.Equal(t, int32(1), atomic.LoadInt32(logins))
+	assert.Equal(t, t0, report.Checks[1].CheckedAt)
+	assert.Equal(t, t0.Add(time.Minute), report.Checks[0].CheckedAt)
+
+	*now = now.Add(DEFAULT_CACHE_TTL)
+	c.Ready()
+	assert.Equal(t, int32(2), atomic.LoadInt32(logins))
+
+	// So does a change of configuration
+	t.Setenv("HEALTH_CANARY_CAMPAIGN", "1")
+	c.Ready()
+	assert.Equal(t, int32(3), atomic.LoadInt32(logins))
+}
+
+func TestReadyWithoutCanary(t *testing.T) {
+	setupRoll20(t, http.StatusOK, "assets/sample_campaign_page.html")
+	c, _ := testChecker()
+	report := c.Ready()
+	assert.Equal(t, Pass, report.Status)
+	assert.Equal(t, Skip, statuses(report)[CanaryCampaignCheck])
+}
+
+// Failed logins are cached as well, not to get the bot account locked by the probes
+func TestReadyLoginFailed(t *testing.T) {
+	logins := setupRoll20(t, http.StatusUnauthorized, "assets/sample_campaign_page.html")
+	t.Setenv("HEALTH_CANARY_CAMPAIGN", "5632681")
+	c, _ := testChecker()
+
+	report := c.Ready()
+	assert.Equal(t, Fail, report.Status)
+	assert.Equal(t, map[string]Status{ConfigCheck: Pass, LoginCheck: Fail, CanaryCampaignCheck: Skip}, statuses(report))
+	// The credentials aren't disclosed
+	assert.NotContains(t, report.Checks[1].Detail, "mock")
+	c.Ready()
+	assert.Equal(t, int32(1), atomic.LoadInt32(logins))
+}
+
+func TestReadyLayoutChanged(t *testing.T) {
+	setupRoll20(t, http.StatusOK, "assets/sample_user_profile.html")
+	t.Setenv("HEALTH_CANARY_CAMPAIGN", "5632681")
+	c, _ := testChecker()
+
+	report := c.Ready()
+	assert.Equal(t, Fail, report.Status)
+	assert.Equal(t, Fail, statuses(report)[CanaryCampaignCheck])
+	assert.Contains(t, report.Checks[2].Detail, ".playerlisting")
+}
+
+func TestReadyInvalidConfig(t *testing.T) {
+	logins := setupRoll20(t, http.StatusOK, "assets/sample_campaign_page.html")
+	for name, value := range map[string]string{
+		"ROLL20_BASE_URL":        "app.roll20.net",
+		"ROLL20_PASSWORD":        "",
+		"HEALTH_CACHE_TTL":       "often",
+		"HEALTH_CANARY_CAMPAIGN": "../1",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			c, _ := testChecker()
+			report := c.Ready()
+			assert.Equal(t, Fail, report.Status)
+			assert.Equal(t, map[string]Status{ConfigCheck: Fail, LoginCheck: Skip, CanaryCampaignCheck: Skip}, statuses(report))
+		})
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(logins))
+}
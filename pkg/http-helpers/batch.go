@@ -0,0 +1,64 @@
+package http_helpers
+
+import (
+	"net/http"
+	"sort"
+)
+
+// swagger:model CampaignResult
+//CampaignResult Outcome for one of the campaigns of a multi-campaign request
+type CampaignResult struct {
+	// Status this campaign would have been answered with if requested alone
+	// required: true
+	Status int `json:"status"`
+	// What this campaign would have been answered with if requested alone. Missing on failure
+	Data interface{} `json:"data,omitempty"`
+	// Why this campaign couldn't be scrapped
+	Error *ErrorTemplate `json:"error,omitempty"`
+}
+
+// Batch Outcomes of a multi-campaign request, keyed by campaign ID
+type Batch map[string]*CampaignResult
+
+// NewBatch A batch with an entry for each campaign.
+// Entries being allocated beforehand, they can be Set concurrently
+func NewBatch(campaignIds []string) Batch {
+	batch := make(Batch, len(campaignIds))
+	for _, id := range campaignIds {
+		batch[id] = &CampaignResult{}
+	}
+	return batch
+}
+
+// Set Record the outcome of a campaign. Safe to call concurrently for distinct campaigns
+func (b Batch) Set(campaignId string, result *CampaignResult) {
+	*b[campaignId] = *result
+}
+
+// Status Status of the whole batch, 200 if every campaign was fully scrapped, 207 otherwise
+func (b Batch) Status() int {
+	for _, result := range b {
+		if result.Status != http.StatusOK {
+			return http.StatusMultiStatus
+		}
+	}
+	return http.StatusOK
+}
+
+// A campaign outcome, as written by row-oriented formats
+type batchRow struct {
+	GameId string         `json:"gameId"`
+	Status int            `json:"status"`
+	Data   interface{}    `json:"data,omitempty"`
+	Error  *ErrorTemplate `json:"error,omitempty"`
+}
+
+// Items A row per campaign, ordered by campaign ID
+func (b Batch) Items() interface{} {
+	rows := make([]batchRow, 0, len(b))
+	for id, result := range b {
+		rows = append(rows, batchRow{GameId: id, Status: result.Status, Data: result.Data, Error: result.Error})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].GameId < rows[j].GameId })
+	return rows
+}
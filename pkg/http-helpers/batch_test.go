@@ -0,0 +1,51 @@
+package http_helpers
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	batch := NewBatch([]string{"2", "1", "3"})
+	var wg sync.WaitGroup
+	for _, id := range []string{"1", "2", "3"} {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			batch.Set(id, &CampaignResult{Status: http.StatusOK, Data: []string{id}})
+		}(id)
+	}
+	wg.Wait()
+	assert.Equal(t, http.StatusOK, batch.Status())
+
+	batch.Set("3", &CampaignResult{Status: http.StatusInternalServerError, Error: Problem("/get-players", http.StatusInternalServerError, ScrappingFailed, "meh")})
+	assert.Equal(t, http.StatusMultiStatus, batch.Status())
+
+	body, err := json.Marshal(batch)
+	assert.Nil(t, err)
+	var decoded map[string]CampaignResult
+	assert.Nil(t, json.Unmarshal(body, &decoded))
+	assert.Len(t, decoded, 3)
+	assert.Equal(t, ScrappingFailed, decoded["3"].Error.Code)
+	assert.Nil(t, decoded["3"].Data)
+}
+
+// A line per campaign, ordered by ID
+func TestBatchRows(t *testing.T) {
+	batch := NewBatch([]string{"2", "1"})
+	batch.Set("1", &CampaignResult{Status: http.StatusOK, Data: []string{"a"}})
+	batch.Set("2", &CampaignResult{Status: http.StatusNotFound, Error: Problem("/get-players", http.StatusNotFound, GameNotJoined, "meh")})
+	res, err := NewDataResponse(batch.Status(), NDJSON, batch)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"gameId":"1","status":200,"data":["a"]}`+"\n"+
+		`{"gameId":"2","status":404,"error":{"type":"urn:roll20-scrapper:problem:game-not-joined","title":"Game not joined","status":404,"detail":"meh","instance":"/get-players","code":"game-not-joined"}}`+"\n", string(res.Body))
+
+	res, err = NewDataResponse(batch.Status(), CSV, batch)
+	assert.Nil(t, err)
+	assert.Equal(t, "gameId,status,data,error.type,error.title,error.status,error.detail,error.instance,error.code,error.requestId,error.errors\n"+
+		"1,200,\"[\"\"a\"\"]\",,,,,,,,\n"+
+		"2,404,,urn:roll20-scrapper:problem:game-not-joined,Game not joined,404,meh,/get-players,game-not-joined,,\n", string(res.Body))
+}
@@ -0,0 +1,254 @@
+package http_helpers
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// swagger:model FieldError
+//FieldError A query parameter that couldn't be bound
+type FieldError struct {
+	// Name of the query parameter
+	// required: true
+	Field string `json:"field"`
+	// What's wrong with the provided value
+	// required: true
+	Message string `json:"message"`
+}
+
+// ValidationError All invalid query parameters of a request
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (v *ValidationError) Error() string {
+	details := make([]string, 0, len(v.Errors))
+	for _, fe := range v.Errors {
+		details = append(details, fmt.Sprintf("%s (%s)", fe.Field, fe.Message))
+	}
+	return "The following query parameters are invalid : " + strings.Join(details, ", ")
+}
+
+// Add Report an invalid query parameter
+func (v *ValidationError) Add(field string, message string) {
+	v.Errors = append(v.Errors, FieldError{Field: field, Message: message})
+}
+
+// Validator Implemented by query structs needing checks spanning multiple fields.
+// Validate is called once every field has been bound, even if some of them were invalid
+type Validator interface {
+	Validate(errs *ValidationError)
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// BindQuery Map a raw query string onto target, a pointer to a struct.
+// Each field is bound to the query parameter named by its `qs` tag. The following tags are also supported :
+//   - default : value used when the parameter is missing
+//   - required : "true" if the parameter can't be missing
+//   - min, max : bounds of numeric parameters
+//   - oneof : space separated list of accepted values
+//   - alias : former name of the parameter, still accepted when the parameter itself is missing
+//
+// Supported types are strings, booleans, integers, time.Time (RFC 3339), time.Duration, and pointers or slices of these.
+// A pointer or slice field stays nil when its parameter is missing and has no default.
+// Slices are given either by repeating the parameter or as a comma separated list.
+// Embedded structs are bound as if their fields were declared in target.
+// Every invalid parameter is reported at once in a *ValidationError. Any other error is a mistake in the target declaration
+func BindQuery(rawQuery string, target interface{}) error {
+	value := reflect.ValueOf(target)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("The query can only be bound to a pointer to a struct, got %T", target)
+	}
+	errs := &ValidationError{}
+	qs, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		errs.Add("query", fmt.Sprintf("Malformed query string : %s", err))
+		return errs
+	}
+	if err = bindStruct(qs, value.Elem(), errs); err != nil {
+		return err
+	}
+	if validator, ok := target.(Validator); ok {
+		validator.Validate(errs)
+	}
+	if len(errs.Errors) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func bindStruct(qs url.Values, value reflect.Value, errs *ValidationError) error {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		name, tagged := field.Tag.Lookup("qs")
+		if !tagged && field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if err := bindStruct(qs, value.Field(i), errs); err != nil {
+				return err
+			}
+			continue
+		}
+		if !tagged || name == "-" {
+			continue
+		}
+		if !isSupported(field.Type) {
+			return fmt.Errorf("Field %s can't be bound, type %s isn't supported", field.Name, field.Type)
+		}
+
+		raw, isSet := qs[name]
+		if alias, hasAlias := field.Tag.Lookup("alias"); hasAlias && !isSet {
+			if raw, isSet = qs[alias]; isSet {
+				name = alias
+			}
+		}
+		if !isSet {
+			if field.Tag.Get("required") == "true" {
+				errs.Add(name, "This parameter is required")
+				continue
+			}
+			byDefault, hasDefault := field.Tag.Lookup("default")
+			if !hasDefault {
+				continue
+			}
+			if message := bindValues([]string{byDefault}, value.Field(i), field); message != "" {
+				return fmt.Errorf("Invalid default value of field %s : %s", field.Name, message)
+			}
+			continue
+		}
+		if message := bindValues(raw, value.Field(i), field); message != "" {
+			errs.Add(name, message)
+		}
+	}
+	return nil
+}
+
+// Parse and check the values of a parameter. Only the first one is kept, unless target is a slice.
+// Slices are given either by repeating the parameter or as a comma separated list
+func bindValues(raw []string, target reflect.Value, field reflect.StructField) string {
+	if target.Kind() != reflect.Slice {
+		return bindValue(raw[0], target, field)
+	}
+	items := reflect.MakeSlice(target.Type(), 0, len(raw))
+	for _, list := range raw {
+		for _, item := range strings.Split(list, ",") {
+			if item = strings.TrimSpace(item); len(item) == 0 {
+				continue
+			}
+			parsed := reflect.New(target.Type().Elem()).Elem()
+			if message := bindValue(item, parsed, field); message != "" {
+				return message
+			}
+			items = reflect.Append(items, parsed)
+		}
+	}
+	if items.Len() != 0 {
+		target.Set(items)
+	}
+	return ""
+}
+
+// Parse and check a single value. Returns what's wrong with it, if anything
+func bindValue(raw string, target reflect.Value, field reflect.StructField) string {
+	if target.Kind() == reflect.Ptr {
+		allocated := reflect.New(target.Type().Elem())
+		if message := bindValue(raw, allocated.Elem(), field); message != "" {
+			return message
+		}
+		target.Set(allocated)
+		return ""
+	}
+
+	switch {
+	case target.Type() == timeType:
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return fmt.Sprintf("Invalid value %q. Should be a RFC 3339 date such as 2022-05-01T20:00:00Z", raw)
+		}
+		target.Set(reflect.ValueOf(parsed))
+		return ""
+	case target.Type() == durationType:
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Sprintf("Invalid value %q. Should be a duration such as 30s or 5m", raw)
+		}
+		target.SetInt(int64(parsed))
+		return ""
+	}
+
+	switch target.Kind() {
+	case reflect.String:
+		if oneOf, ok := field.Tag.Lookup("oneof"); ok && !contains(strings.Fields(oneOf), raw) {
+			return fmt.Sprintf("Invalid value %q. Should be one of %s", raw, strings.Join(strings.Fields(oneOf), ", "))
+		}
+		target.SetString(raw)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Sprintf("Invalid value %q. Should be either true or false", raw)
+		}
+		target.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(raw, 10, target.Type().Bits())
+		if err != nil {
+			return fmt.Sprintf("Invalid value %q. Should be an integer", raw)
+		}
+		if message := checkBounds(raw, float64(parsed), field); message != "" {
+			return message
+		}
+		target.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(raw, 10, target.Type().Bits())
+		if err != nil {
+			return fmt.Sprintf("Invalid value %q. Should be a positive integer", raw)
+		}
+		if message := checkBounds(raw, float64(parsed), field); message != "" {
+			return message
+		}
+		target.SetUint(parsed)
+	}
+	return ""
+}
+
+func isSupported(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t == timeType || t == durationType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func checkBounds(raw string, parsed float64, field reflect.StructField) string {
+	if min, ok := field.Tag.Lookup("min"); ok {
+		if bound, err := strconv.ParseFloat(min, 64); err == nil && parsed < bound {
+			return fmt.Sprintf("Invalid value %q. Should be at least %s", raw, min)
+		}
+	}
+	if max, ok := field.Tag.Lookup("max"); ok {
+		if bound, err := strconv.ParseFloat(max, 64); err == nil && parsed > bound {
+			return fmt.Sprintf("Invalid value %q. Should be at most %s", raw, max)
+		}
+	}
+	return ""
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package http_helpers
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type embedded struct {
+	GameId string `qs:"gameId" required:"true"`
+}
+
+type sampleQuery struct {
+	embedded
+	Name     string        `qs:"name" oneof:"foo bar"`
+	Enabled  bool          `qs:"enabled" default:"true"`
+	Count    int           `qs:"count" min:"-2" max:"10"`
+	Limit    *uint         `qs:"limit" max:"100"`
+	Since    time.Time     `qs:"since"`
+	Timeout  time.Duration `qs:"timeout" default:"30s"`
+	Until    *time.Time    `qs:"until"`
+	Ignored  string        `qs:"-"`
+	internal string
+}
+
+// Cross-field check
+type validatedQuery struct {
+	From int `qs:"from"`
+	To   int `qs:"to"`
+}
+
+func (q *validatedQuery) Validate(errs *ValidationError) {
+	if q.From > q.To {
+		errs.Add("from", "Should not be greater than to")
+	}
+}
+
+func TestBindQuery(t *testing.T) {
+	var query sampleQuery
+	err := BindQuery("gameId=1&name=bar&enabled=false&count=-2&limit=100&since=2022-05-01T20:00:00Z&timeout=1m&Ignored=a", &query)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", query.GameId)
+	assert.Equal(t, "bar", query.Name)
+	assert.False(t, query.Enabled)
+	assert.Equal(t, -2, query.Count)
+	assert.Equal(t, uint(100), *query.Limit)
+	assert.Equal(t, time.Date(2022, 5, 1, 20, 0, 0, 0, time.UTC), query.Since)
+	assert.Equal(t, time.Minute, query.Timeout)
+	assert.Nil(t, query.Until)
+	assert.Empty(t, query.Ignored)
+}
+
+// A renamed parameter, still accepted under its former name
+type renamedQuery struct {
+	IncludeChat bool `qs:"includeChat" alias:"includeChats" default:"true"`
+}
+
+func TestBindQueryAlias(t *testing.T) {
+	var tests = []struct {
+		qs       string
+		expected bool
+	}{
+		{"", true},
+		{"includeChat=false", false},
+		{"includeChats=false", false},
+		// The current name wins
+		{"includeChat=true&includeChats=false", true},
+	}
+	for _, tt := range tests {
+		var query renamedQuery
+		assert.Nil(t, BindQuery(tt.qs, &query), tt.qs)
+		assert.Equal(t, tt.expected, query.IncludeChat, tt.qs)
+	}
+	// Errors are reported under the name the caller used
+	var query renamedQuery
+	err := BindQuery("includeChats=dd", &query)
+	assert.Equal(t, "includeChats", err.(*ValidationError).Errors[0].Field)
+}
+
+// Lists of values
+type listQuery struct {
+	Ids    []int    `qs:"id" min:"1"`
+	Names  []string `qs:"name" default:"foo,bar"`
+	Absent []string `qs:"absent"`
+}
+
+func TestBindQuerySlices(t *testing.T) {
+	var query listQuery
+	err := BindQuery("id=1&id=2,3&id=&name=baz", &query)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 2, 3}, query.Ids)
+	assert.Equal(t, []string{"baz"}, query.Names)
+	assert.Nil(t, query.Absent)
+
+	var defaults listQuery
+	err = BindQuery("", &defaults)
+	assert.Nil(t, err)
+	assert.Nil(t, defaults.Ids)
+	assert.Equal(t, []string{"foo", "bar"}, defaults.Names)
+
+	var invalid listQuery
+	err = BindQuery("id=1,0,a", &invalid)
+	assert.IsType(t, &ValidationError{}, err)
+	assert.Equal(t, []FieldError{{Field: "id", Message: "Invalid value \"0\". Should be at least 1"}}, err.(*ValidationError).Errors)
+}
+
+func TestBindQueryDefaults(t *testing.T) {
+	var query sampleQuery
+	err := BindQuery("gameId=1", &query)
+	assert.Nil(t, err)
+	assert.Empty(t, query.Name)
+	assert.True(t, query.Enabled)
+	assert.Equal(t, 0, query.Count)
+	assert.Nil(t, query.Limit)
+	assert.True(t, query.Since.IsZero())
+	assert.Equal(t, 30*time.Second, query.Timeout)
+}
+
+// Every invalid parameter is reported, not only the first one
+func TestBindQueryAllErrors(t *testing.T) {
+	var query sampleQuery
+	err := BindQuery("name=baz&enabled=eitehr&count=11&limit=-1&since=yesterday&timeout=long&until=", &query)
+	ve, ok := err.(*ValidationError)
+	assert.True(t, ok)
+	assert.ElementsMatch(t, []FieldError{
+		{Field: "gameId", Message: "This parameter is required"},
+		{Field: "name", Message: "Invalid value \"baz\". Should be one of foo, bar"},
+		{Field: "enabled", Message: "Invalid value \"eitehr\". Should be either true or false"},
+		{Field: "count", Message: "Invalid value \"11\". Should be at most 10"},
+		{Field: "limit", Message: "Invalid value \"-1\". Should be a positive integer"},
+		{Field: "since", Message: "Invalid value \"yesterday\". Should be a RFC 3339 date such as 2022-05-01T20:00:00Z"},
+		{Field: "timeout", Message: "Invalid value \"long\". Should be a duration such as 30s or 5m"},
+		{Field: "until", Message: "Invalid value \"\". Should be a RFC 3339 date such as 2022-05-01T20:00:00Z"},
+	}, ve.Errors)
+	assert.Contains(t, ve.Error(), "enabled (Invalid value \"eitehr\". Should be either true or false)")
+}
+
+func TestBindQueryBounds(t *testing.T) {
+	var query sampleQuery
+	err := BindQuery("gameId=1&count=-3&limit=101", &query)
+	ve, ok := err.(*ValidationError)
+	assert.True(t, ok)
+	assert.Equal(t, []FieldError{
+		{Field: "count", Message: "Invalid value \"-3\". Should be at least -2"},
+		{Field: "limit", Message: "Invalid value \"101\". Should be at most 100"},
+	}, ve.Errors)
+}
+
+func TestBindQueryMalformed(t *testing.T) {
+	var query sampleQuery
+	err := BindQuery("wrong=;;;", &query)
+	ve, ok := err.(*ValidationError)
+	assert.True(t, ok)
+	assert.Len(t, ve.Errors, 1)
+	assert.Equal(t, "query", ve.Errors[0].Field)
+}
+
+func TestBindQueryValidator(t *testing.T) {
+	var query validatedQuery
+	assert.Nil(t, BindQuery("from=1&to=2", &query))
+	err := BindQuery("from=3&to=2", &query)
+	ve, ok := err.(*ValidationError)
+	assert.True(t, ok)
+	assert.Equal(t, []FieldError{{Field: "from", Message: "Should not be greater than to"}}, ve.Errors)
+}
+
+// Mistakes in the query declaration are not the client's fault
+func TestBindQueryInvalidTarget(t *testing.T) {
+	var query sampleQuery
+	err := BindQuery("gameId=1", query)
+	assert.Error(t, err)
+	_, ok := err.(*ValidationError)
+	assert.False(t, ok)
+
+	var unsupported struct {
+		Values map[string]string `qs:"values"`
+	}
+	err = BindQuery("values=a", &unsupported)
+	assert.Error(t, err)
+	_, ok = err.(*ValidationError)
+	assert.False(t, ok)
+
+	var badDefault struct {
+		Count int `qs:"count" default:"many"`
+	}
+	err = BindQuery("", &badDefault)
+	assert.Error(t, err)
+	_, ok = err.(*ValidationError)
+	assert.False(t, ok)
+}
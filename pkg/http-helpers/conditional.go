@@ -0,0 +1,70 @@
+package http_helpers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ETag Strong entity tag of data once written in format. It is computed over the canonical JSON of data,
+// the format being appended for other representations, as a strong tag can't be shared by different bodies
+func ETag(format Format, data interface{}) (string, error) {
+	canonical, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(canonical)
+	tag := hex.EncodeToString(hash[:16])
+	if len(format) != 0 && format != JSON {
+		tag += "-" + string(format)
+	}
+	return `"` + tag + `"`, nil
+}
+
+// NewConditionalResponse Same as NewDataResponse, tagging the response with an ETag header, and a Last-Modified one
+// when lastModified isn't zero. If the caller already holds this representation, as told by If-None-Match or,
+// without it, If-Modified-Since, a 304 without body is answered instead
+func NewConditionalResponse(req handler2.Request, status int, format Format, data interface{}, lastModified time.Time) (handler2.Response, error) {
+	etag, err := ETag(format, data)
+	if err != nil {
+		return handler2.Response{}, err
+	}
+	// The tag being computed per format, a 304 must tell caches which representation it validates
+	header := map[string][]string{"ETag": {etag}, "Vary": {"Accept"}}
+	if !lastModified.IsZero() {
+		header["Last-Modified"] = []string{lastModified.UTC().Format(http.TimeFormat)}
+	}
+	// Only successful responses can be validated
+	if status >= 200 && status < 300 && isNotModified(req, etag, lastModified) {
+		return handler2.Response{StatusCode: http.StatusNotModified, Header: header}, nil
+	}
+	res, err := NewDataResponse(status, format, data)
+	for key, values := range header {
+		res.Header[key] = values
+	}
+	return res, err
+}
+
+// Whether the representation tagged etag, last modified at lastModified, is the one the caller holds
+func isNotModified(req handler2.Request, etag string, lastModified time.Time) bool {
+	if values := req.Header.Values("If-None-Match"); len(values) != 0 {
+		for _, candidate := range strings.Split(strings.Join(values, ","), ",") {
+			candidate = strings.TrimSpace(candidate)
+			// If-None-Match uses the weak comparison
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil || lastModified.IsZero() {
+		return false
+	}
+	// HTTP dates only have a one second precision
+	return !lastModified.Truncate(time.Second).After(since)
+}
@@ -0,0 +1,76 @@
+package http_helpers
+
+import (
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestETag(t *testing.T) {
+	tag, err := ETag(JSON, sampleRows())
+	assert.Nil(t, err)
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, tag)
+
+	// Stable for the same data, whatever the format
+	same, _ := ETag("", sampleRows())
+	assert.Equal(t, tag, same)
+	csvTag, _ := ETag(CSV, sampleRows())
+	assert.Equal(t, tag[:len(tag)-1]+`-csv"`, csvTag)
+
+	other, _ := ETag(JSON, sampleRows()[1:])
+	assert.NotEqual(t, tag, other)
+
+	_, err = ETag(JSON, func() {})
+	assert.Error(t, err)
+}
+
+func TestConditionalResponse(t *testing.T) {
+	lastModified := time.Date(2022, 5, 1, 20, 0, 0, 500, time.UTC)
+	res, err := NewConditionalResponse(handler2.Request{}, http.StatusOK, JSON, sampleRows(), lastModified)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.NotEmpty(t, res.Body)
+	assert.Equal(t, []string{"application/json"}, res.Header["Content-type"])
+	assert.Equal(t, []string{"Sun, 01 May 2022 20:00:00 GMT"}, res.Header["Last-Modified"])
+	etag := res.Header["ETag"][0]
+
+	var tests = []struct {
+		name        string
+		header      http.Header
+		status      int
+		notModified bool
+	}{
+		{"matching tag", http.Header{"If-None-Match": {`"other", ` + etag}}, http.StatusOK, true},
+		{"weak tag", http.Header{"If-None-Match": {"W/" + etag}}, http.StatusOK, true},
+		{"any tag", http.Header{"If-None-Match": {"*"}}, http.StatusOK, true},
+		{"other tag", http.Header{"If-None-Match": {`"other"`}}, http.StatusOK, false},
+		{"partial content", http.Header{"If-None-Match": {etag}}, http.StatusMultiStatus, true},
+		{"unmodified", http.Header{"If-Modified-Since": {"Sun, 01 May 2022 20:00:00 GMT"}}, http.StatusOK, true},
+		{"modified", http.Header{"If-Modified-Since": {"Sun, 01 May 2022 19:59:59 GMT"}}, http.StatusOK, false},
+		{"tags win over dates", http.Header{"If-None-Match": {`"other"`}, "If-Modified-Since": {"Sun, 01 May 2022 20:00:00 GMT"}}, http.StatusOK, false},
+		{"invalid date", http.Header{"If-Modified-Since": {"yesterday"}}, http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := NewConditionalResponse(handler2.Request{Header: tt.header}, tt.status, JSON, sampleRows(), lastModified)
+			assert.Nil(t, err)
+			assert.Equal(t, []string{etag}, res.Header["ETag"])
+			assert.Equal(t, []string{"Accept"}, res.Header["Vary"])
+			if tt.notModified {
+				assert.Equal(t, http.StatusNotModified, res.StatusCode)
+				assert.Empty(t, res.Body)
+			} else {
+				assert.Equal(t, tt.status, res.StatusCode)
+				assert.NotEmpty(t, res.Body)
+			}
+		})
+	}
+
+	// Without a modification date, If-Modified-Since is ignored
+	res, _ = NewConditionalResponse(handler2.Request{Header: http.Header{"If-Modified-Since": {"Sun, 01 May 2022 20:00:00 GMT"}}}, http.StatusOK, CSV, sampleRows(), time.Time{})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Empty(t, res.Header["Last-Modified"])
+	assert.Equal(t, []string{"text/csv; charset=utf-8"}, res.Header["Content-type"])
+}
@@ -0,0 +1,325 @@
+package http_helpers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"gopkg.in/yaml.v3"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format Representation of a response body
+type Format string
+
+const (
+	JSON   Format = "json"
+	NDJSON Format = "ndjson"
+	CSV    Format = "csv"
+	YAML   Format = "yaml"
+)
+
+var formatContentTypes = map[Format]string{
+	JSON:   "application/json",
+	NDJSON: "application/x-ndjson",
+	CSV:    "text/csv; charset=utf-8",
+	YAML:   "application/yaml",
+}
+
+// Media types of the Accept header, and the format they designate
+var acceptedMediaTypes = map[string]Format{
+	"*/*":                  JSON,
+	"application/*":        JSON,
+	"application/json":     JSON,
+	"application/x-ndjson": NDJSON,
+	"application/ndjson":   NDJSON,
+	"text/*":               CSV,
+	"text/csv":             CSV,
+	"application/yaml":     YAML,
+	"application/x-yaml":   YAML,
+	"text/yaml":            YAML,
+	"text/x-yaml":          YAML,
+}
+
+// FormatQuery Query parameter overriding the Accept header. To be embedded in the query struct of handlers
+type FormatQuery struct {
+	Format string `qs:"format" oneof:"json ndjson csv yaml"`
+}
+
+// NegotiateFormat The format to answer req with. The format parameter, if any, wins over the Accept header.
+// Without both, JSON is used. False is returned if none of the accepted media types can be produced
+func NegotiateFormat(req handler2.Request, format string) (Format, bool) {
+	if len(format) != 0 {
+		return Format(format), true
+	}
+	accept := strings.Join(req.Header.Values("Accept"), ",")
+	if len(strings.TrimSpace(accept)) == 0 {
+		return JSON, true
+	}
+	type mediaRange struct {
+		mediaType string
+		quality   float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if quality > 0 {
+			ranges = append(ranges, mediaRange{mediaType, quality})
+		}
+	}
+	// Most preferred first. On ties, the order of the header is kept
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].quality > ranges[j].quality })
+	for _, r := range ranges {
+		if f, ok := acceptedMediaTypes[r.mediaType]; ok {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// NewNotAcceptableProblem Build the error response to req when NegotiateFormat failed
+func NewNotAcceptableProblem(req handler2.Request, instance string) handler2.Response {
+	res := NewProblem(req, instance, http.StatusNotAcceptable, NotAcceptable, "None of the accepted media types can be produced. Supported ones are application/json, application/x-ndjson, text/csv and application/yaml")
+	res.Header["Vary"] = []string{"Accept"}
+	return res
+}
+
+// Envelope Implemented by data wrapping a list along with metadata, such as a page of results.
+// Row-oriented formats only write the items, the metadata has to be carried by headers
+type Envelope interface {
+	Items() interface{}
+}
+
+// NewDataResponse Build a successful response carrying data in the given format.
+// The format being negotiated, the response tells shared caches it varies with the Accept header
+func NewDataResponse(status int, format Format, data interface{}) (handler2.Response, error) {
+	var body []byte
+	var err error
+	if envelope, ok := data.(Envelope); ok && (format == NDJSON || format == CSV) {
+		data = envelope.Items()
+	}
+	switch format {
+	case NDJSON:
+		body, err = encodeNDJSON(data)
+	case CSV:
+		body, err = encodeCSV(data)
+	case YAML:
+		body, err = encodeYAML(data)
+	default:
+		format = JSON
+		body, err = json.Marshal(data)
+	}
+	return handler2.Response{
+		StatusCode: status,
+		Body:       body,
+		Header: map[string][]string{
+			"Content-type": {formatContentTypes[format]},
+			"Vary":         {"Accept"},
+		},
+	}, err
+}
+
+// One JSON document per line. A slice gives a line per element, anything else a single line
+func encodeNDJSON(data interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	value := reflect.Indirect(reflect.ValueOf(data))
+	if value.Kind() != reflect.Slice {
+		err := encoder.Encode(data)
+		return buf.Bytes(), err
+	}
+	for i := 0; i < value.Len(); i++ {
+		if err := encoder.Encode(value.Index(i).Interface()); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// YAML with the same keys, in the same order, as the JSON representation
+func encodeYAML(data interface{}) ([]byte, error) {
+	asJson, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	// JSON being valid YAML, it can be parsed as is. The flow style is then dropped for a readable output
+	var node yaml.Node
+	if err = yaml.Unmarshal(asJson, &node); err != nil {
+		return nil, err
+	}
+	resetStyle(&node)
+	return yaml.Marshal(&node)
+}
+
+func resetStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		resetStyle(child)
+	}
+}
+
+// A CSV header, then a row per element of a slice, or a single row for anything else.
+// Columns follow the declaration order of the fields, and are named after their JSON key.
+// Nested structs are flattened into "parent.child" columns, lists and maps are written as JSON
+func encodeCSV(data interface{}) ([]byte, error) {
+	value := reflect.Indirect(reflect.ValueOf(data))
+	elemType := reflect.TypeOf(data)
+	for elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	rows := []reflect.Value{value}
+	if elemType.Kind() == reflect.Slice {
+		elemType = elemType.Elem()
+		rows = rows[:0]
+		for i := 0; value.IsValid() && i < value.Len(); i++ {
+			rows = append(rows, value.Index(i))
+		}
+	}
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if err := writer.Write(csvHeader(elemType, "")); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		record, err := csvRecord(row, elemType)
+		if err != nil {
+			return nil, err
+		}
+		if err = writer.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+// Whether t is written as a group of columns
+func isFlattened(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && t != timeType
+}
+
+// JSON key of a struct field, or false if it isn't serialized
+func csvName(field reflect.StructField) (string, bool) {
+	if len(field.PkgPath) != 0 {
+		return "", false
+	}
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return "", false
+	}
+	if len(name) == 0 {
+		name = field.Name
+	}
+	return name, true
+}
+
+func csvHeader(t reflect.Type, prefix string) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if !isFlattened(t) {
+		if len(prefix) == 0 {
+			return []string{"value"}
+		}
+		return []string{prefix}
+	}
+	var header []string
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := csvName(t.Field(i))
+		if !ok {
+			continue
+		}
+		if len(prefix) != 0 {
+			name = prefix + "." + name
+		}
+		if isFlattened(t.Field(i).Type) {
+			header = append(header, csvHeader(t.Field(i).Type, name)...)
+		} else {
+			header = append(header, name)
+		}
+	}
+	return header
+}
+
+// Cells of value, of type t. A nil value still fills its columns, with empty cells
+func csvRecord(value reflect.Value, t reflect.Type) ([]string, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for value.IsValid() && value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			value = reflect.Value{}
+		} else {
+			value = value.Elem()
+		}
+	}
+	if !isFlattened(t) {
+		cell, err := csvCell(value)
+		return []string{cell}, err
+	}
+	var record []string
+	for i := 0; i < t.NumField(); i++ {
+		if _, ok := csvName(t.Field(i)); !ok {
+			continue
+		}
+		var field reflect.Value
+		if value.IsValid() {
+			field = value.Field(i)
+		}
+		cells, err := csvRecord(field, t.Field(i).Type)
+		if err != nil {
+			return nil, err
+		}
+		record = append(record, cells...)
+	}
+	return record, nil
+}
+
+func csvCell(value reflect.Value) (string, error) {
+	if !value.IsValid() {
+		return "", nil
+	}
+	if value.Type() == timeType {
+		return value.Interface().(time.Time).Format(time.RFC3339), nil
+	}
+	switch value.Kind() {
+	case reflect.String:
+		return value.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(value.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(value.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(value.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(value.Float(), 'f', -1, 64), nil
+	case reflect.Slice, reflect.Map, reflect.Interface:
+		if value.IsNil() {
+			return "", nil
+		}
+	}
+	cell, err := json.Marshal(value.Interface())
+	if err != nil {
+		return "", fmt.Errorf("Couldn't write a CSV cell : %w", err)
+	}
+	return string(cell), nil
+}
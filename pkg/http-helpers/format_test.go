@@ -0,0 +1,167 @@
+package http_helpers
+
+import (
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type sampleInner struct {
+	About *string    `json:"about"`
+	Since *time.Time `json:"since"`
+}
+
+type sampleRow struct {
+	Name    string       `json:"name"`
+	Score   float64      `json:".score"`
+	IsGm    bool         `json:"isGm"`
+	Tags    []string     `json:"tags,omitempty"`
+	Inner   *sampleInner `json:"inner,omitempty"`
+	Ignored string       `json:"-"`
+	hidden  string
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	var tests = []struct {
+		accept string
+		format string
+		want   Format
+		ok     bool
+	}{
+		{"", "", JSON, true},
+		{"*/*", "", JSON, true},
+		{"text/csv", "", CSV, true},
+		{"application/x-ndjson", "", NDJSON, true},
+		{"application/yaml", "", YAML, true},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", "", JSON, true},
+		{"application/json;q=0.5, text/csv", "", CSV, true},
+		{"text/csv;q=0, application/x-yaml", "", YAML, true},
+		{"text/html", "", "", false},
+		{"text/html", "ndjson", NDJSON, true},
+		{"text/csv", "json", JSON, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept+"|"+tt.format, func(t *testing.T) {
+			req := handler2.Request{Header: http.Header{}}
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			got, ok := NegotiateFormat(req, tt.format)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFormatQuery(t *testing.T) {
+	var query FormatQuery
+	assert.Nil(t, BindQuery("format=csv", &query))
+	assert.Equal(t, "csv", query.Format)
+	var invalid FormatQuery
+	assert.Error(t, BindQuery("format=xml", &invalid))
+}
+
+func sampleRows() []sampleRow {
+	about := "Hi, \"me\""
+	since := time.Date(2022, 4, 1, 10, 0, 0, 0, time.UTC)
+	return []sampleRow{
+		{Name: "a", Score: 1.5, IsGm: true, Tags: []string{"x", "y"}, Inner: &sampleInner{About: &about, Since: &since}, Ignored: "no"},
+		{Name: "b", Score: 1234567890123},
+	}
+}
+
+func TestNewDataResponseCSV(t *testing.T) {
+	res, err := NewDataResponse(http.StatusOK, CSV, sampleRows())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"text/csv; charset=utf-8"}, res.Header["Content-type"])
+	assert.Equal(t, "name,.score,isGm,tags,inner.about,inner.since\n"+
+		"a,1.5,true,\"[\"\"x\"\",\"\"y\"\"]\",\"Hi, \"\"me\"\"\",2022-04-01T10:00:00Z\n"+
+		"b,1234567890123,false,,,\n", string(res.Body))
+
+	// Columns don't depend on the data
+	res, err = NewDataResponse(http.StatusOK, CSV, &[]sampleRow{})
+	assert.Nil(t, err)
+	assert.Equal(t, "name,.score,isGm,tags,inner.about,inner.since\n", string(res.Body))
+
+	// A single object is a single row
+	res, err = NewDataResponse(http.StatusOK, CSV, &sampleRow{Name: "c"})
+	assert.Nil(t, err)
+	assert.Equal(t, "name,.score,isGm,tags,inner.about,inner.since\nc,0,false,,,\n", string(res.Body))
+}
+
+func TestNewDataResponseNDJSON(t *testing.T) {
+	res, err := NewDataResponse(http.StatusMultiStatus, NDJSON, sampleRows())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusMultiStatus, res.StatusCode)
+	assert.Equal(t, []string{"application/x-ndjson"}, res.Header["Content-type"])
+	assert.Equal(t, `{"name":"a",".score":1.5,"isGm":true,"tags":["x","y"],"inner":{"about":"Hi, \"me\"","since":"2022-04-01T10:00:00Z"}}`+"\n"+
+		`{"name":"b",".score":1234567890123,"isGm":false}`+"\n", string(res.Body))
+
+	res, err = NewDataResponse(http.StatusOK, NDJSON, &sampleRow{Name: "c"})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"name":"c",".score":0,"isGm":false}`+"\n", string(res.Body))
+}
+
+func TestNewDataResponseYAML(t *testing.T) {
+	res, err := NewDataResponse(http.StatusOK, YAML, sampleRows())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"application/yaml"}, res.Header["Content-type"])
+	assert.Equal(t, `- name: a
+  .score: 1.5
+  isGm: true
+  tags:
+    - x
+    - y
+  inner:
+      about: Hi, "me"
+      since: "2022-04-01T10:00:00Z"
+- name: b
+  .score: 1234567890123
+  isGm: false
+`, string(res.Body))
+}
+
+func TestNewDataResponseJSON(t *testing.T) {
+	res, err := NewDataResponse(http.StatusOK, JSON, &sampleRow{Name: "c"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"application/json"}, res.Header["Content-type"])
+	assert.Equal(t, `{"name":"c",".score":0,"isGm":false}`, string(res.Body))
+}
+
+// Shared caches mustn't answer a CSV client with a cached JSON body
+func TestNewDataResponseVary(t *testing.T) {
+	for _, format := range []Format{JSON, NDJSON, CSV, YAML} {
+		res, err := NewDataResponse(http.StatusOK, format, &sampleRow{Name: "c"})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"Accept"}, res.Header["Vary"], format)
+	}
+	req := handler2.Request{Header: http.Header{}}
+	req.Header.Set("Accept", "image/png")
+	res := NewNotAcceptableProblem(req, "/get-players")
+	assert.Equal(t, []string{"Accept"}, res.Header["Vary"])
+}
+
+type sampleEnvelope struct {
+	Rows []sampleRow `json:"rows"`
+	Next string      `json:"next"`
+}
+
+func (e *sampleEnvelope) Items() interface{} {
+	return e.Rows
+}
+
+// Row-oriented formats only write the items of an envelope
+func TestNewDataResponseEnvelope(t *testing.T) {
+	envelope := &sampleEnvelope{Rows: []sampleRow{{Name: "c"}}, Next: "abc"}
+	res, err := NewDataResponse(http.StatusOK, NDJSON, envelope)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"name":"c",".score":0,"isGm":false}`+"\n", string(res.Body))
+	res, err = NewDataResponse(http.StatusOK, CSV, envelope)
+	assert.Nil(t, err)
+	assert.Equal(t, "name,.score,isGm,tags,inner.about,inner.since\nc,0,false,,,\n", string(res.Body))
+	res, err = NewDataResponse(http.StatusOK, JSON, envelope)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"rows":[{"name":"c",".score":0,"isGm":false}],"next":"abc"}`, string(res.Body))
+}
@@ -1,19 +1,144 @@
 package http_helpers
 
-import "encoding/json"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"net/http"
+)
+
+// Content type of all error responses, see RFC 7807
+const ProblemContentType = "application/problem+json"
+
+// Headers carrying the ID of a request. The OpenFaaS gateway sets X-Call-Id on every call
+const RequestIdHeader = "X-Request-Id"
+const callIdHeader = "X-Call-Id"
+
+// Problem types are URNs, as they aren't meant to be dereferenced
+const problemTypePrefix = "urn:roll20-scrapper:problem:"
+
+// ErrorCode Machine-readable reason of an error, stable across versions
+type ErrorCode string
+
+const (
+	InvalidQuery         ErrorCode = "invalid-query"
+	MissingConfiguration ErrorCode = "missing-configuration"
+	Roll20LoginFailed    ErrorCode = "roll20-login-failed"
+	ScrappingFailed      ErrorCode = "scrapping-failed"
+	GameNotJoined        ErrorCode = "game-not-joined"
+	JoinRefused          ErrorCode = "join-refused"
+	LeaveFailed          ErrorCode = "leave-failed"
+	StorageFailed        ErrorCode = "storage-failed"
+	NotAcceptable        ErrorCode = "not-acceptable"
+	Unauthenticated      ErrorCode = "unauthenticated"
+	Forbidden            ErrorCode = "forbidden"
+	JobNotFound          ErrorCode = "job-not-found"
+	JobNotFinished       ErrorCode = "job-not-finished"
+	InternalError        ErrorCode = "internal-error"
+	CallbackRefused      ErrorCode = "callback-refused"
+)
+
+var problemTitles = map[ErrorCode]string{
+	InvalidQuery:         "Invalid query parameters",
+	MissingConfiguration: "Function not configured",
+	Roll20LoginFailed:    "Roll20 login failed",
+	ScrappingFailed:      "Roll20 scrapping failed",
+	GameNotJoined:        "Game not joined",
+	JoinRefused:          "Game join refused",
+	LeaveFailed:          "Game leave failed",
+	StorageFailed:        "Storage failed",
+	NotAcceptable:        "Media type not supported",
+	Unauthenticated:      "Authentication required",
+	Forbidden:            "Access denied",
+	JobNotFound:          "Job not found",
+	JobNotFinished:       "Job not finished",
+	InternalError:        "Internal error",
+	CallbackRefused:      "Callback refused",
+}
 
 // swagger:model ErrorTemplate
+//ErrorTemplate An error, as a RFC 7807 problem details object
 type ErrorTemplate struct {
-	Message string `json:"message"`
+	// URI identifying the kind of error. Ex urn:roll20-scrapper:problem:invalid-query
+	// required: true
+	Type string `json:"type"`
+	// Short summary of the kind of error
+	// required: true
+	Title string `json:"title"`
+	// HTTP status code of the response
+	// required: true
+	Status int `json:"status"`
+	// Explanation specific to this occurrence of the error
+	Detail string `json:"detail,omitempty"`
+	// Path of the function that failed
+	Instance string `json:"instance,omitempty"`
+	// Machine-readable reason of the error, the last part of type
+	// required: true
+	Code ErrorCode `json:"code"`
+	// ID of the failed request, to be given when reporting an issue
+	RequestId string `json:"requestId,omitempty"`
+	// Invalid query parameters, if that's what went wrong
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// NewProblem Build an application/problem+json error response to req
+func NewProblem(req handler2.Request, instance string, status int, code ErrorCode, detail string) handler2.Response {
+	return NewProblemResponse(req, Problem(instance, status, code, detail))
+}
+
+// Problem The details of an error, to be answered later or embedded in a larger response
+func Problem(instance string, status int, code ErrorCode, detail string) *ErrorTemplate {
+	return &ErrorTemplate{
+		Type:     problemTypePrefix + string(code),
+		Title:    problemTitles[code],
+		Status:   status,
+		Detail:   detail,
+		Instance: instance,
+		Code:     code,
+	}
+}
+
+// NewBindingProblem Build the error response to req when BindQuery failed.
+// Invalid query parameters are the client fault, anything else is ours
+func NewBindingProblem(req handler2.Request, instance string, err error) handler2.Response {
+	ve, ok := err.(*ValidationError)
+	if !ok {
+		return NewProblem(req, instance, http.StatusInternalServerError, InternalError, "Unexpected error while parsing the query")
+	}
+	problem := &ErrorTemplate{
+		Type:     problemTypePrefix + string(InvalidQuery),
+		Title:    problemTitles[InvalidQuery],
+		Status:   http.StatusBadRequest,
+		Detail:   ve.Error(),
+		Instance: instance,
+		Code:     InvalidQuery,
+		Errors:   ve.Errors,
+	}
+	return NewProblemResponse(req, problem)
 }
 
-func NewError(message string) *ErrorTemplate {
-	return &ErrorTemplate{Message: message}
+// RequestId The ID of req, as given by the caller or the OpenFaaS gateway. A new one is generated if there is none
+func RequestId(req handler2.Request) string {
+	for _, header := range []string{RequestIdHeader, callIdHeader} {
+		if id := req.Header.Get(header); len(id) != 0 {
+			return id
+		}
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
 }
 
-// FormatError Return a JSON stringified error
-func FormatError(message string) []byte {
-	et := NewError(message)
-	formatted, _ := json.Marshal(et)
-	return formatted
+// NewProblemResponse Build an application/problem+json error response to req, the request ID being filled in
+func NewProblemResponse(req handler2.Request, problem *ErrorTemplate) handler2.Response {
+	problem.RequestId = RequestId(req)
+	body, _ := json.Marshal(problem)
+	header := map[string][]string{"Content-type": {ProblemContentType}}
+	if len(problem.RequestId) != 0 {
+		header[RequestIdHeader] = []string{problem.RequestId}
+	}
+	return handler2.Response{StatusCode: problem.Status, Body: body, Header: header}
 }
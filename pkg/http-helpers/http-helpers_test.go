@@ -2,17 +2,75 @@ package http_helpers
 
 import (
 	"encoding/json"
+	"fmt"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
 	"github.com/stretchr/testify/assert"
+	"net/http"
 	"testing"
 )
 
-// This is only useful is the error object evolves
-func TestFormatError(t *testing.T) {
-	const MESSAGE = "meh"
-	messageBytes := FormatError(MESSAGE)
+func TestNewProblem(t *testing.T) {
+	req := handler2.Request{Header: http.Header{"X-Request-Id": {"abc"}}}
+	res := NewProblem(req, "/get-players", http.StatusNotFound, GameNotJoined, "meh")
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	assert.Equal(t, []string{ProblemContentType}, res.Header["Content-type"])
+	assert.Equal(t, []string{"abc"}, res.Header[RequestIdHeader])
 	et := ErrorTemplate{}
-	err := json.Unmarshal(messageBytes, &et)
+	err := json.Unmarshal(res.Body, &et)
 	assert.Nil(t, err)
-	assert.Equal(t, MESSAGE, et.Message)
+	assert.Equal(t, ErrorTemplate{
+		Type:      "urn:roll20-scrapper:problem:game-not-joined",
+		Title:     "Game not joined",
+		Status:    http.StatusNotFound,
+		Detail:    "meh",
+		Instance:  "/get-players",
+		Code:      GameNotJoined,
+		RequestId: "abc",
+	}, et)
+}
+
+// Every code must have a title
+func TestProblemTitles(t *testing.T) {
+	codes := []ErrorCode{InvalidQuery, MissingConfiguration, Roll20LoginFailed, ScrappingFailed, GameNotJoined, JoinRefused, LeaveFailed, StorageFailed, NotAcceptable, Unauthenticated, Forbidden, JobNotFound, JobNotFinished, InternalError, CallbackRefused}
+	for _, code := range codes {
+		assert.NotEmpty(t, problemTitles[code], code)
+	}
+}
+
+func TestNewBindingProblem(t *testing.T) {
+	ve := &ValidationError{}
+	ve.Add("limit", "Should be a positive integer")
+	res := NewBindingProblem(handler2.Request{}, "/get-messages", ve)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	et := ErrorTemplate{}
+	err := json.Unmarshal(res.Body, &et)
+	assert.Nil(t, err)
+	assert.Equal(t, InvalidQuery, et.Code)
+	assert.Equal(t, ve.Error(), et.Detail)
+	assert.Equal(t, []FieldError{{Field: "limit", Message: "Should be a positive integer"}}, et.Errors)
+
+	// Anything else is a server error
+	res = NewBindingProblem(handler2.Request{}, "/get-messages", fmt.Errorf("meh"))
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	err = json.Unmarshal(res.Body, &et)
+	assert.Nil(t, err)
+	assert.Equal(t, InternalError, et.Code)
+}
 
+func TestRequestId(t *testing.T) {
+	var tests = []struct {
+		header http.Header
+		want   string
+	}{
+		{http.Header{"X-Request-Id": {"abc"}}, "abc"},
+		{http.Header{"X-Call-Id": {"def"}}, "def"},
+		{http.Header{"X-Request-Id": {"abc"}, "X-Call-Id": {"def"}}, "abc"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, RequestId(handler2.Request{Header: tt.header}))
+	}
+	// Without any header, an ID is generated each time
+	first := RequestId(handler2.Request{})
+	assert.Len(t, first, 32)
+	assert.NotEqual(t, first, RequestId(handler2.Request{}))
 }
@@ -0,0 +1,23 @@
+package http_helpers
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// PageLinks A RFC 8288 Link header value pointing to the next and previous pages of a request.
+// Each link is the request query, with param set to the cursor of the page. Empty cursors are left out.
+// References are relative to the request path, so that they stay valid behind any gateway prefix
+func PageLinks(rawQuery string, param string, next string, prev string) string {
+	var links []string
+	for _, link := range []struct{ rel, cursor string }{{"next", next}, {"prev", prev}} {
+		if len(link.cursor) == 0 {
+			continue
+		}
+		query, _ := url.ParseQuery(rawQuery)
+		query.Set(param, link.cursor)
+		links = append(links, fmt.Sprintf("<?%s>; rel=\"%s\"", query.Encode(), link.rel))
+	}
+	return strings.Join(links, ", ")
+}
@@ -0,0 +1,25 @@
+package http_helpers
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPageLinks(t *testing.T) {
+	var tests = []struct {
+		rawQuery string
+		next     string
+		prev     string
+		want     string
+	}{
+		{"gameId=1&pageSize=10", "abc", "", `<?cursor=abc&gameId=1&pageSize=10>; rel="next"`},
+		{"gameId=1&cursor=old", "abc", "def", `<?cursor=abc&gameId=1>; rel="next", <?cursor=def&gameId=1>; rel="prev"`},
+		{"gameId=1", "", "def", `<?cursor=def&gameId=1>; rel="prev"`},
+		{"gameId=1", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rawQuery, func(t *testing.T) {
+			assert.Equal(t, tt.want, PageLinks(tt.rawQuery, "cursor", tt.next, tt.prev))
+		})
+	}
+}
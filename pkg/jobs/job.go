@@ -0,0 +1,169 @@
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	http_helpers "handler/function/pkg/http-helpers"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Kind What a job scraps
+type Kind string
+
+const (
+	// All messages of a game, as get-messages would answer them
+	MessagesJob Kind = "messages"
+)
+
+// Status Stage of a job
+type Status string
+
+const (
+	// Saved, not started yet
+	Pending Status = "pending"
+	// Scrapping
+	Running Status = "running"
+	// Done, its result can be retrieved
+	Succeeded Status = "succeeded"
+	// Done, its error tells why
+	Failed Status = "failed"
+)
+
+// swagger:model JobProgress
+//JobProgress How far a job has gone
+type JobProgress struct {
+	// Number of pages scrapped so far
+	// required: true
+	PagesDone int `json:"pagesDone"`
+	// Total number of pages to scrap, 0 until the first one has been scrapped
+	// required: true
+	PagesTotal int `json:"pagesTotal"`
+}
+
+// swagger:model Job
+//Job A scrapping running in the background
+type Job struct {
+	// ID of the job, to be given to job-status and job-result
+	// required: true
+	Id string `json:"id"`
+	// What is scrapped
+	// required: true
+	Kind Kind `json:"kind"`
+	// Roll20 ID of the scrapped game
+	// required: true
+	GameId string `json:"gameId"`
+	// Either pending, running, succeeded or failed
+	// required: true
+	Status Status `json:"status"`
+	// How far the job has gone
+	// required: true
+	Progress JobProgress `json:"progress"`
+	// When the job was started
+	// required: true
+	CreatedAt time.Time `json:"createdAt"`
+	// When the job ended, either way
+	FinishedAt *time.Time `json:"finishedAt"`
+	// Why the job failed
+	Error *http_helpers.ErrorTemplate `json:"error,omitempty"`
+	// Name of the caller having started the job, the only one able to read it. Empty without authentication
+	Owner string `json:"owner,omitempty"`
+}
+
+// Job IDs end up in file names, they must not be able to escape the store directory
+var validJobId = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// IsValidId Whether id could have been generated by NewJob
+func IsValidId(id string) bool {
+	return validJobId.MatchString(id)
+}
+
+// NewJob A pending job, with a random ID
+func NewJob(kind Kind, gameId string, owner string) (*Job, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &Job{Id: hex.EncodeToString(id), Kind: kind, GameId: gameId, Status: Pending, CreatedAt: time.Now().UTC(), Owner: owner}, nil
+}
+
+// Scrap The work of a job. progress is to be called as pages are scrapped.
+// Either the result or the error to be reported is returned
+type Scrap func(progress func(pagesDone int, pagesTotal int)) (interface{}, *http_helpers.ErrorTemplate)
+
+// Start Save job, then run scrap in the background. The job is updated as it progresses, then saved along with
+// its result once done. Only saving the job beforehand can fail, later storage errors are logged
+func Start(store Store, job *Job, scrap Scrap) error {
+	if err := store.Save(job); err != nil {
+		return err
+	}
+	go run(store, *job, scrap)
+	return nil
+}
+
+func run(store Store, job Job, scrap Scrap) {
+	// Progress may be reported from other goroutines
+	var mutex sync.Mutex
+	save := func() {
+		if err := store.Save(&job); err != nil {
+			log.Printf("Couldn't save job %s : %s\n", job.Id, err)
+		}
+	}
+	mutex.Lock()
+	job.Status = Running
+	save()
+	mutex.Unlock()
+
+	result, problem := scrap(func(pagesDone int, pagesTotal int) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		job.Progress = JobProgress{PagesDone: pagesDone, PagesTotal: pagesTotal}
+		save()
+	})
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	finishedAt := time.Now().UTC()
+	job.FinishedAt = &finishedAt
+	if problem == nil {
+		problem = saveResult(store, job.Id, result)
+	}
+	if problem != nil {
+		job.Status = Failed
+		job.Error = problem
+		log.Printf("Job %s failed : %s\n", job.Id, problem.Detail)
+	} else {
+		job.Status = Succeeded
+		log.Printf("Job %s succeeded\n", job.Id)
+	}
+	save()
+}
+
+func saveResult(store Store, id string, result interface{}) *http_helpers.ErrorTemplate {
+	data, err := json.Marshal(result)
+	if err == nil {
+		err = store.SaveResult(id, data)
+	}
+	if err != nil {
+		log.Printf("Couldn't save the result of job %s : %s\n", id, err)
+		return http_helpers.Problem("", http.StatusInternalServerError, http_helpers.StorageFailed, "The result of the job couldn't be saved")
+	}
+	return nil
+}
+
+// Find Retrieve a job started by owner, or nil if there is none.
+// Jobs of other callers are reported as missing, not to tell they exist
+func Find(store Store, id string, owner string) (*Job, error) {
+	job, err := store.Get(id)
+	if err != nil || job == nil {
+		return nil, err
+	}
+	if job.Owner != owner {
+		return nil, nil
+	}
+	return job, nil
+}
@@ -0,0 +1,107 @@
+package jobs
+
+import (
+	"github.com/stretchr/testify/assert"
+	http_helpers "handler/function/pkg/http-helpers"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Wait for a job to be done, either way
+func waitFor(t *testing.T, store Store, id string) *Job {
+	var job *Job
+	assert.Eventually(t, func() bool {
+		job, _ = store.Get(id)
+		return job.Status == Succeeded || job.Status == Failed
+	}, time.Second, 5*time.Millisecond)
+	return job
+}
+
+func TestNewJob(t *testing.T) {
+	job, err := NewJob(MessagesJob, "1", "team-a")
+	assert.Nil(t, err)
+	assert.True(t, IsValidId(job.Id))
+	assert.Equal(t, Pending, job.Status)
+	assert.Equal(t, "team-a", job.Owner)
+
+	other, _ := NewJob(MessagesJob, "1", "team-a")
+	assert.NotEqual(t, job.Id, other.Id)
+	assert.False(t, IsValidId("../../etc/passwd"))
+}
+
+func TestStart(t *testing.T) {
+	store := NewMemoryStore()
+	job, _ := NewJob(MessagesJob, "1", "")
+	proceed := make(chan struct{})
+	err := Start(store, job, func(progress func(int, int)) (interface{}, *http_helpers.ErrorTemplate) {
+		progress(1, 2)
+		<-proceed
+		progress(2, 2)
+		return []string{"a", "b"}, nil
+	})
+	assert.Nil(t, err)
+
+	// Progress is visible while the job runs
+	assert.Eventually(t, func() bool {
+		running, _ := store.Get(job.Id)
+		return running.Progress.PagesDone == 1
+	}, time.Second, 5*time.Millisecond)
+	running, _ := store.Get(job.Id)
+	assert.Equal(t, Running, running.Status)
+	assert.Equal(t, JobProgress{PagesDone: 1, PagesTotal: 2}, running.Progress)
+	assert.Nil(t, running.FinishedAt)
+	result, _ := store.GetResult(job.Id)
+	assert.Nil(t, result)
+	close(proceed)
+
+	done := waitFor(t, store, job.Id)
+	assert.Equal(t, Succeeded, done.Status)
+	assert.Equal(t, JobProgress{PagesDone: 2, PagesTotal: 2}, done.Progress)
+	assert.NotNil(t, done.FinishedAt)
+	assert.Nil(t, done.Error)
+	result, _ = store.GetResult(job.Id)
+	assert.JSONEq(t, `["a","b"]`, string(result))
+}
+
+func TestStartFailure(t *testing.T) {
+	store := NewMemoryStore()
+	job, _ := NewJob(MessagesJob, "1", "")
+	err := Start(store, job, func(progress func(int, int)) (interface{}, *http_helpers.ErrorTemplate) {
+		return nil, http_helpers.Problem("/start-scrape", http.StatusInternalServerError, http_helpers.ScrappingFailed, "Roll20 couldn't be scrapped for game 1")
+	})
+	assert.Nil(t, err)
+	done := waitFor(t, store, job.Id)
+	assert.Equal(t, Failed, done.Status)
+	assert.Equal(t, http_helpers.ScrappingFailed, done.Error.Code)
+	result, _ := store.GetResult(job.Id)
+	assert.Nil(t, result)
+}
+
+// The job isn't started if it couldn't be saved
+func TestStartStorageError(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	assert.Nil(t, err)
+	job := &Job{Id: "not an id"}
+	err = Start(store, job, func(progress func(int, int)) (interface{}, *http_helpers.ErrorTemplate) {
+		t.Fail()
+		return nil, nil
+	})
+	assert.Error(t, err)
+}
+
+func TestFind(t *testing.T) {
+	store := NewMemoryStore()
+	job, _ := NewJob(MessagesJob, "1", "team-a")
+	store.Save(job)
+	found, err := Find(store, job.Id, "team-a")
+	assert.Nil(t, err)
+	assert.Equal(t, job.Id, found.Id)
+
+	found, err = Find(store, job.Id, "team-b")
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+	found, err = Find(store, job.Id, "")
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+}
@@ -0,0 +1,251 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store Keeps jobs and their results
+type Store interface {
+	// Get Retrieve a job, or nil if it doesn't exist
+	Get(id string) (*Job, error)
+	// Save Create or update a job
+	Save(job *Job) error
+	// GetResult Retrieve the JSON result of a job, or nil if there is none
+	GetResult(id string) ([]byte, error)
+	// SaveResult Store the JSON result of a job
+	SaveResult(id string, result []byte) error
+	// Expire Delete the jobs finished before the given time, along with their results. The number of jobs deleted
+	// is returned
+	Expire(finishedBefore time.Time) (int, error)
+}
+
+// Where jobs are stored when JOB_STORE_DIR isn't defined
+const DEFAULT_STORE_DIR = "/tmp/jobs"
+
+// How long a finished job is kept when JOB_TTL isn't defined
+const DEFAULT_TTL = 24 * time.Hour
+
+// Shared by all invocations of the function, as the process outlives them
+var memoryStore = NewMemoryStore()
+
+// FromEnv Build the job store. JOB_STORE is either "file", the default, or "memory".
+// The "file" store keeps jobs in JOB_STORE_DIR. As start-scrape, job-status and job-result are distinct processes
+// behind OpenFaaS, they must share this directory. The "memory" store only works when they share a process,
+// as with the standalone server.
+// Jobs finished for longer than JOB_TTL are deleted, along with their results, before the store is handed out.
+// Nothing else would ever delete them
+func FromEnv() (Store, error) {
+	ttl := DEFAULT_TTL
+	if value, isSet := os.LookupEnv("JOB_TTL"); isSet {
+		var err error
+		if ttl, err = time.ParseDuration(value); err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("Invalid JOB_TTL %q. Should be a duration such as 1h or 30m", value)
+		}
+	}
+	backend, isSet := os.LookupEnv("JOB_STORE")
+	if !isSet || len(backend) == 0 {
+		backend = "file"
+	}
+	var store Store
+	switch backend {
+	case "memory":
+		store = memoryStore
+	case "file":
+		dir, isSet := os.LookupEnv("JOB_STORE_DIR")
+		if !isSet {
+			dir = DEFAULT_STORE_DIR
+		}
+		fileStore, err := NewFileStore(dir)
+		if err != nil {
+			return nil, err
+		}
+		store = fileStore
+	default:
+		return nil, fmt.Errorf("Invalid JOB_STORE %q. Should be either memory or file", backend)
+	}
+	// A store still works with expired jobs in it, failing to delete them only delays it to the next call
+	if expired, err := store.Expire(time.Now().Add(-ttl)); err != nil {
+		log.Printf("Couldn't delete expired jobs : %s\n", err)
+	} else if expired > 0 {
+		log.Printf("%d expired jobs have been deleted\n", expired)
+	}
+	return store, nil
+}
+
+// MemoryStore Store keeping jobs in the memory of the process
+type MemoryStore struct {
+	jobs    map[string]Job
+	results map[string][]byte
+	mutex   sync.Mutex
+}
+
+// NewMemoryStore Build an empty memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{jobs: make(map[string]Job), results: make(map[string][]byte)}
+}
+
+func (ms *MemoryStore) Get(id string) (*Job, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	// Copies are handed out, jobs are updated concurrently
+	job, exists := ms.jobs[id]
+	if !exists {
+		return nil, nil
+	}
+	return &job, nil
+}
+
+func (ms *MemoryStore) Save(job *Job) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.jobs[job.Id] = *job
+	return nil
+}
+
+func (ms *MemoryStore) GetResult(id string) ([]byte, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	return ms.results[id], nil
+}
+
+func (ms *MemoryStore) SaveResult(id string, result []byte) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.results[id] = result
+	return nil
+}
+
+func (ms *MemoryStore) Expire(finishedBefore time.Time) (int, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	expired := 0
+	for id, job := range ms.jobs {
+		if isExpired(&job, finishedBefore) {
+			delete(ms.jobs, id)
+			delete(ms.results, id)
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+// Whether job was finished before the given time. Unfinished jobs never expire
+func isExpired(job *Job, finishedBefore time.Time) bool {
+	return job.FinishedAt != nil && job.FinishedAt.Before(finishedBefore)
+}
+
+// FileStore Store keeping each job, and each result, as a JSON file in a local directory
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore Build a store in the given directory, creating it if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("Unable to create the job store directory %s : %s", dir, err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (fs *FileStore) Get(id string) (*Job, error) {
+	data, err := fs.read(id, ".json")
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var job Job
+	if err = json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("Job %s is corrupted : %s", id, err)
+	}
+	return &job, nil
+}
+
+func (fs *FileStore) Save(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return fs.write(job.Id, ".json", data)
+}
+
+func (fs *FileStore) GetResult(id string) ([]byte, error) {
+	return fs.read(id, ".result.json")
+}
+
+func (fs *FileStore) SaveResult(id string, result []byte) error {
+	return fs.write(id, ".result.json", result)
+}
+
+func (fs *FileStore) Expire(finishedBefore time.Time) (int, error) {
+	files, err := ioutil.ReadDir(fs.dir)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to list the jobs : %s", err)
+	}
+	expired := 0
+	for _, file := range files {
+		id := strings.TrimSuffix(file.Name(), ".json")
+		// A job file is last written when the job finishes, younger ones can't have expired
+		if !IsValidId(id) || !file.ModTime().Before(finishedBefore) {
+			continue
+		}
+		job, err := fs.Get(id)
+		if err != nil {
+			return expired, err
+		}
+		if job == nil || !isExpired(job, finishedBefore) {
+			continue
+		}
+		// The result goes first. Were the job file deleted first, a result failing to be deleted would never be found again
+		for _, suffix := range []string{".result.json", ".json"} {
+			if err = os.Remove(filepath.Join(fs.dir, id+suffix)); err != nil && !os.IsNotExist(err) {
+				return expired, fmt.Errorf("Unable to delete job %s : %s", id, err)
+			}
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+func (fs *FileStore) read(id string, suffix string) ([]byte, error) {
+	if !IsValidId(id) {
+		return nil, fmt.Errorf("Invalid job id %s", id)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(fs.dir, id+suffix))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read job %s : %s", id, err)
+	}
+	return data, nil
+}
+
+// Write then rename, job-status must never read a partial file
+func (fs *FileStore) write(id string, suffix string, data []byte) error {
+	if !IsValidId(id) {
+		return fmt.Errorf("Invalid job id %s", id)
+	}
+	tmp, err := ioutil.TempFile(fs.dir, id+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("Unable to save job %s : %s", id, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Unable to save job %s : %s", id, err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("Unable to save job %s : %s", id, err)
+	}
+	if err = os.Rename(tmp.Name(), filepath.Join(fs.dir, id+suffix)); err != nil {
+		return fmt.Errorf("Unable to save job %s : %s", id, err)
+	}
+	return nil
+}
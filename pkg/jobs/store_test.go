@@ -0,0 +1,167 @@
+package jobs
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Both stores behave the same
+func testStore(t *testing.T, store Store) {
+	job, _ := NewJob(MessagesJob, "1", "team-a")
+	saved, err := store.Get(job.Id)
+	assert.Nil(t, err)
+	assert.Nil(t, saved)
+
+	assert.Nil(t, store.Save(job))
+	job.Status = Running
+	job.Progress = JobProgress{PagesDone: 1, PagesTotal: 3}
+	assert.Nil(t, store.Save(job))
+	saved, err = store.Get(job.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, Running, saved.Status)
+	assert.Equal(t, JobProgress{PagesDone: 1, PagesTotal: 3}, saved.Progress)
+	assert.True(t, job.CreatedAt.Equal(saved.CreatedAt))
+
+	result, err := store.GetResult(job.Id)
+	assert.Nil(t, err)
+	assert.Nil(t, result)
+	assert.Nil(t, store.SaveResult(job.Id, []byte(`[]`)))
+	result, err = store.GetResult(job.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(`[]`), result)
+}
+
+// Only the jobs finished before the given time are deleted, results included
+func testExpire(t *testing.T, store Store) (finished *Job, running *Job) {
+	finished, _ = NewJob(MessagesJob, "1", "")
+	finishedAt := time.Now().Add(-2 * time.Hour).UTC()
+	finished.Status, finished.FinishedAt = Succeeded, &finishedAt
+	assert.Nil(t, store.Save(finished))
+	assert.Nil(t, store.SaveResult(finished.Id, []byte(`[]`)))
+	running, _ = NewJob(MessagesJob, "1", "")
+	running.CreatedAt = running.CreatedAt.Add(-48 * time.Hour)
+	assert.Nil(t, store.Save(running))
+
+	// Finished since
+	expired, err := store.Expire(time.Now().Add(-3 * time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, expired)
+
+	expired, err = store.Expire(time.Now().Add(-time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, expired)
+	saved, err := store.Get(finished.Id)
+	assert.Nil(t, err)
+	assert.Nil(t, saved)
+	result, err := store.GetResult(finished.Id)
+	assert.Nil(t, err)
+	assert.Nil(t, result)
+	// However old, an unfinished job is kept
+	saved, err = store.Get(running.Id)
+	assert.Nil(t, err)
+	assert.NotNil(t, saved)
+	return finished, running
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	assert.Nil(t, err)
+	testStore(t, store)
+	// A job and its result, no temporary file left behind
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 2)
+
+	_, err = store.Get("../secret")
+	assert.Error(t, err)
+	assert.Error(t, store.SaveResult("../secret", []byte(`[]`)))
+}
+
+func TestMemoryStoreExpire(t *testing.T) {
+	testExpire(t, NewMemoryStore())
+}
+
+func TestFileStoreExpire(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	assert.Nil(t, err)
+	// Files are as old as the jobs they hold
+	aged := &agingStore{FileStore: store, dir: dir, age: 2 * time.Hour}
+	_, running := testExpire(t, aged)
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 1)
+	assert.Equal(t, running.Id+".json", files[0].Name())
+}
+
+// File store backdating the files it writes
+type agingStore struct {
+	*FileStore
+	dir string
+	age time.Duration
+}
+
+func (as *agingStore) Save(job *Job) error {
+	if err := as.FileStore.Save(job); err != nil {
+		return err
+	}
+	past := time.Now().Add(-as.age)
+	return os.Chtimes(filepath.Join(as.dir, job.Id+".json"), past, past)
+}
+
+func TestFileStoreCorrupted(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := NewFileStore(dir)
+	job, _ := NewJob(MessagesJob, "1", "")
+	ioutil.WriteFile(filepath.Join(dir, job.Id+".json"), []byte("{"), 0o644)
+	_, err := store.Get(job.Id)
+	assert.Error(t, err)
+}
+
+func TestFromEnv(t *testing.T) {
+	defer os.Unsetenv("JOB_STORE")
+	defer os.Unsetenv("JOB_STORE_DIR")
+	os.Setenv("JOB_STORE_DIR", t.TempDir())
+	store, err := FromEnv()
+	assert.Nil(t, err)
+	assert.IsType(t, &FileStore{}, store)
+
+	os.Setenv("JOB_STORE", "memory")
+	store, err = FromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, memoryStore, store)
+
+	os.Setenv("JOB_STORE", "redis")
+	_, err = FromEnv()
+	assert.Error(t, err)
+}
+
+// Expired jobs are deleted as the store is built
+func TestFromEnvExpire(t *testing.T) {
+	t.Setenv("JOB_STORE", "memory")
+	t.Setenv("JOB_TTL", "1h")
+	defer func() { memoryStore = NewMemoryStore() }()
+	job, _ := NewJob(MessagesJob, "1", "")
+	finishedAt := time.Now().Add(-2 * time.Hour)
+	job.Status, job.FinishedAt = Succeeded, &finishedAt
+	assert.Nil(t, memoryStore.Save(job))
+
+	store, err := FromEnv()
+	assert.Nil(t, err)
+	saved, err := store.Get(job.Id)
+	assert.Nil(t, err)
+	assert.Nil(t, saved)
+
+	for _, invalid := range []string{"forever", "0s", "-1h"} {
+		t.Setenv("JOB_TTL", invalid)
+		_, err = FromEnv()
+		assert.Error(t, err, invalid)
+	}
+}
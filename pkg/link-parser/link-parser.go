@@ -0,0 +1,200 @@
+package link_parser
+
+import (
+	"fmt"
+	http_helpers "handler/function/pkg/http-helpers"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// A Roll20 game, as designated by a link
+type GameLink struct {
+	// Roll20 ID of the game
+	GameId string
+	// Code needed to join the game. Only join links provide one
+	GameCode string
+}
+
+// Parse Retrieve the game designated by either a join link (https://app.roll20.net/join/1/59lzQg),
+// a campaign details or editor URL, or a bare game ID
+func Parse(link string) (*GameLink, error) {
+	link = strings.TrimSpace(link)
+	if len(link) == 0 {
+		return nil, fmt.Errorf("The provided link is empty")
+	}
+	if isGameId(link) {
+		return &GameLink{GameId: link}, nil
+	}
+
+	// Links copied from the address bar may lack their scheme
+	rawLink := link
+	if !strings.Contains(link, "://") {
+		rawLink = "https://" + link
+	}
+	parsedUrl, err := url.Parse(rawLink)
+	if err != nil {
+		return nil, fmt.Errorf("The provided link %s isn't a valid URL : %s", link, err)
+	}
+	host := strings.ToLower(parsedUrl.Hostname())
+	if host != "roll20.net" && !strings.HasSuffix(host, ".roll20.net") {
+		return nil, fmt.Errorf("The provided link %s doesn't point to Roll20", link)
+	}
+
+	segments := strings.Split(strings.Trim(parsedUrl.Path, "/"), "/")
+	game := &GameLink{}
+	switch {
+	// https://app.roll20.net/join/1/59lzQg
+	case segments[0] == "join":
+		if len(segments) < 3 || len(segments[2]) == 0 {
+			return nil, fmt.Errorf("The provided join link %s has no join code. Join links look like https://app.roll20.net/join/1/59lzQg", link)
+		}
+		game.GameId, game.GameCode = segments[1], segments[2]
+	// https://app.roll20.net/editor/setcampaign/1
+	case len(segments) >= 3 && segments[0] == "editor" && segments[1] == "setcampaign":
+		game.GameId = segments[2]
+	// https://app.roll20.net/campaigns/details/1/campaign-name
+	case len(segments) >= 3 && segments[0] == "campaigns" && (segments[1] == "details" || segments[1] == "editor"):
+		game.GameId = segments[2]
+	default:
+		return nil, fmt.Errorf("The provided link %s isn't supported. Either a join link, a campaign details or editor URL, or a game ID is expected", link)
+	}
+
+	if !isGameId(game.GameId) {
+		return nil, fmt.Errorf("The provided link %s doesn't contain a valid game ID", link)
+	}
+	return game, nil
+}
+
+// Resolve Retrieve the requested game from the values of the handlers query string.
+// The game can be given either as a link, or as a game ID (and join code). If both are given, they must agree
+func Resolve(gameId string, gameCode string, link string) (*GameLink, error) {
+	if len(link) == 0 {
+		if !isGameId(gameId) {
+			return nil, fmt.Errorf("The provided gameId is invalid %s. Either a numeric gameId or a link must be provided", gameId)
+		}
+		return &GameLink{GameId: gameId, GameCode: gameCode}, nil
+	}
+
+	game, err := Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	if len(gameId) != 0 && gameId != game.GameId {
+		return nil, fmt.Errorf("The provided gameId %s doesn't match game %s of link %s", gameId, game.GameId, link)
+	}
+	if len(gameCode) != 0 {
+		if len(game.GameCode) != 0 && gameCode != game.GameCode {
+			return nil, fmt.Errorf("The provided gameCode %s doesn't match join code %s of link %s", gameCode, game.GameCode, link)
+		}
+		game.GameCode = gameCode
+	}
+	return game, nil
+}
+
+// GameQuery Query parameters designating a game. To be embedded in the query struct of handlers
+type GameQuery struct {
+	GameId string `qs:"gameId"`
+	Link   string `qs:"link"`
+	game   *GameLink
+}
+
+// Validate Resolve the designated game once the query has been bound
+func (q *GameQuery) Validate(errs *http_helpers.ValidationError) {
+	game, err := Resolve(q.GameId, "", q.Link)
+	if err != nil {
+		field := "gameId"
+		if len(q.Link) != 0 {
+			field = "link"
+		}
+		errs.Add(field, err.Error())
+		return
+	}
+	q.game = game
+}
+
+// Game The game designated by the query. Only available after a successful validation
+func (q *GameQuery) Game() *GameLink {
+	return q.game
+}
+
+// Max number of games a single request can designate
+const MAX_GAMES = 20
+
+// GamesQuery Query parameters designating one or several games. To be embedded in the query struct of handlers.
+// Games are given by repeating gameId or link, or as comma separated lists
+type GamesQuery struct {
+	GameIds []string `qs:"gameId"`
+	Links   []string `qs:"link"`
+	games   []*GameLink
+}
+
+// Validate Resolve the designated games once the query has been bound.
+// A single gameId and a single link are regarded as the same game, as with GameQuery
+func (q *GamesQuery) Validate(errs *http_helpers.ValidationError) {
+	if len(q.GameIds) <= 1 && len(q.Links) <= 1 {
+		single := GameQuery{}
+		if len(q.GameIds) == 1 {
+			single.GameId = q.GameIds[0]
+		}
+		if len(q.Links) == 1 {
+			single.Link = q.Links[0]
+		}
+		failed := len(errs.Errors)
+		single.Validate(errs)
+		if len(errs.Errors) == failed {
+			q.games = []*GameLink{single.Game()}
+		}
+		return
+	}
+
+	seen := make(map[string]bool)
+	var games []*GameLink
+	add := func(field string, gameId string, link string) {
+		game, err := Resolve(gameId, "", link)
+		if err != nil {
+			errs.Add(field, err.Error())
+			return
+		}
+		if !seen[game.GameId] {
+			seen[game.GameId] = true
+			games = append(games, game)
+		}
+	}
+	for _, gameId := range q.GameIds {
+		add("gameId", gameId, "")
+	}
+	for _, link := range q.Links {
+		add("link", "", link)
+	}
+	if len(games) > MAX_GAMES {
+		errs.Add("gameId", fmt.Sprintf("At most %d games can be requested at once", MAX_GAMES))
+		return
+	}
+	q.games = games
+}
+
+// Games The games designated by the query, without duplicates. Only available after a successful validation
+func (q *GamesQuery) Games() []*GameLink {
+	return q.games
+}
+
+// Ids The IDs of the designated games
+func (q *GamesQuery) Ids() []string {
+	ids := make([]string, 0, len(q.games))
+	for _, game := range q.games {
+		ids = append(ids, game.GameId)
+	}
+	return ids
+}
+
+// IsBatch Whether several games are designated
+func (q *GamesQuery) IsBatch() bool {
+	return len(q.games) > 1
+}
+
+// Game IDs are positive numbers
+func isGameId(value string) bool {
+	id, err := strconv.Atoi(value)
+	return err == nil && id > 0
+}
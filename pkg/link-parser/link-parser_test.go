@@ -0,0 +1,163 @@
+package link_parser
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	http_helpers "handler/function/pkg/http-helpers"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		link, gameId, gameCode string
+	}{
+		{"1", "1", ""},
+		{" 5632681 ", "5632681", ""},
+		{"https://app.roll20.net/join/1/59lzQg", "1", "59lzQg"},
+		{"https://app.roll20.net/join/1/59lzQg/", "1", "59lzQg"},
+		{"app.roll20.net/join/1/59lzQg", "1", "59lzQg"},
+		{"https://app.roll20.net/editor/setcampaign/5632681", "5632681", ""},
+		{"https://app.roll20.net/campaigns/details/5632681/my-campaign", "5632681", ""},
+		{"https://app.roll20.net/campaigns/details/5632681", "5632681", ""},
+		{"https://app.roll20.net/campaigns/editor/5632681?foo=bar", "5632681", ""},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("\n Link : %s\n", tt.link), func(t *testing.T) {
+			game, err := Parse(tt.link)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.gameId, game.GameId)
+			assert.Equal(t, tt.gameCode, game.GameCode)
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	var tests = []string{
+		"",
+		"gg",
+		"-1",
+		"https://example.com/join/1/59lzQg",
+		"https://app.roll20.net/join/1",
+		"https://app.roll20.net/join/gg/59lzQg",
+		"https://app.roll20.net/campaigns/search/",
+		"https://app.roll20.net/users/2",
+		"%%%",
+	}
+	for _, link := range tests {
+		t.Run(fmt.Sprintf("\n Link : %s\n", link), func(t *testing.T) {
+			game, err := Parse(link)
+			assert.Error(t, err)
+			assert.Nil(t, game)
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	var tests = []struct {
+		gameId, gameCode, link string
+		expectedId             string
+		expectedCode           string
+	}{
+		{"1", "", "", "1", ""},
+		{"1", "59lzQg", "", "1", "59lzQg"},
+		{"", "", "https://app.roll20.net/join/1/59lzQg", "1", "59lzQg"},
+		{"1", "59lzQg", "https://app.roll20.net/join/1/59lzQg", "1", "59lzQg"},
+		{"", "59lzQg", "https://app.roll20.net/campaigns/details/1/my-campaign", "1", "59lzQg"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("\n Values : %s,%s,%s\n", tt.gameId, tt.gameCode, tt.link), func(t *testing.T) {
+			game, err := Resolve(tt.gameId, tt.gameCode, tt.link)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expectedId, game.GameId)
+			assert.Equal(t, tt.expectedCode, game.GameCode)
+		})
+	}
+}
+
+func TestResolveInvalid(t *testing.T) {
+	var tests = []struct {
+		gameId, gameCode, link string
+	}{
+		// Nothing provided
+		{"", "", ""},
+		{"gg", "", ""},
+		{"", "", "gg"},
+		// Conflicting values
+		{"2", "", "https://app.roll20.net/join/1/59lzQg"},
+		{"1", "other", "https://app.roll20.net/join/1/59lzQg"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("\n Values : %s,%s,%s\n", tt.gameId, tt.gameCode, tt.link), func(t *testing.T) {
+			game, err := Resolve(tt.gameId, tt.gameCode, tt.link)
+			assert.Error(t, err)
+			assert.Nil(t, game)
+		})
+	}
+}
+
+func TestGameQuery(t *testing.T) {
+	var query GameQuery
+	err := http_helpers.BindQuery("link=https://app.roll20.net/join/1/59lzQg", &query)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", query.Game().GameId)
+
+	var conflicting GameQuery
+	err = http_helpers.BindQuery("gameId=2&link=https://app.roll20.net/join/1/59lzQg", &conflicting)
+	ve, ok := err.(*http_helpers.ValidationError)
+	assert.True(t, ok)
+	assert.Equal(t, "link", ve.Errors[0].Field)
+
+	var missing GameQuery
+	err = http_helpers.BindQuery("", &missing)
+	ve, ok = err.(*http_helpers.ValidationError)
+	assert.True(t, ok)
+	assert.Equal(t, "gameId", ve.Errors[0].Field)
+}
+
+func TestGamesQuery(t *testing.T) {
+	var tests = []struct {
+		qs    string
+		ids   []string
+		batch bool
+	}{
+		{"gameId=1", []string{"1"}, false},
+		{"gameId=1&link=https://app.roll20.net/join/1/59lzQg", []string{"1"}, false},
+		{"gameId=1,2&gameId=3", []string{"1", "2", "3"}, true},
+		{"gameId=1&gameId=2&link=https://app.roll20.net/join/1/59lzQg&link=https://app.roll20.net/campaigns/details/4/name", []string{"1", "2", "4"}, true},
+		{"gameId=1,1", []string{"1"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.qs, func(t *testing.T) {
+			var query GamesQuery
+			err := http_helpers.BindQuery(tt.qs, &query)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.ids, query.Ids())
+			assert.Equal(t, tt.batch, query.IsBatch())
+		})
+	}
+}
+
+func TestInvalidGamesQuery(t *testing.T) {
+	var tests = []struct {
+		qs     string
+		fields []string
+	}{
+		{"", []string{"gameId"}},
+		{"gameId=2&link=https://app.roll20.net/join/1/59lzQg", []string{"link"}},
+		{"gameId=1,a&link=https://example.com/join/1/a&link=https://app.roll20.net/join/1/59lzQg", []string{"gameId", "link"}},
+		{"gameId=1,2,3,4,5,6,7,8,9,10,11,12,13,14,15,16,17,18,19,20,21", []string{"gameId"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.qs, func(t *testing.T) {
+			var query GamesQuery
+			err := http_helpers.BindQuery(tt.qs, &query)
+			ve, ok := err.(*http_helpers.ValidationError)
+			assert.True(t, ok)
+			var fields []string
+			for _, fe := range ve.Errors {
+				fields = append(fields, fe.Field)
+			}
+			assert.Equal(t, tt.fields, fields)
+		})
+	}
+}
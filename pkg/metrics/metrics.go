@@ -0,0 +1,279 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Media type of the Prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Upper bounds of the latency buckets, in seconds. Scrapping a whole archive can take minutes
+var DEFAULT_BUCKETS = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// A metric the registry can write
+type collector interface {
+	write(w io.Writer) error
+}
+
+// Registry Metrics exposed together
+type Registry struct {
+	mutex      sync.Mutex
+	collectors []collector
+}
+
+// Metrics of the process, shared by every package
+var Default = NewRegistry()
+
+// NewRegistry Registry without any metric
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Write Write every metric in the Prometheus text format, in registration order
+func (r *Registry) Write(w io.Writer) error {
+	r.mutex.Lock()
+	collectors := append([]collector{}, r.collectors...)
+	r.mutex.Unlock()
+	for _, c := range collectors {
+		if err := c.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler Serve the metrics of r to Prometheus
+func Handler(r *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		if err := r.Write(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// Name, help and label names shared by all kinds of metrics
+type desc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (d *desc) writeHeader(w io.Writer, kind string) error {
+	help := strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help)
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, help, d.name, kind)
+	return err
+}
+
+// The key of a series, and its labels as written
+func (d *desc) series(values []string) (string, string) {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff"), formatLabels(d.labels, values)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Labels as written after the name of a sample. Empty without labels
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(names))
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[i])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// CounterVec Counters sharing a name, one per combination of label values
+type CounterVec struct {
+	desc
+	mutex  sync.Mutex
+	values map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labels string
+	value  float64
+}
+
+// NewCounterVec Register a counter, split by the given labels
+func (r *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{desc: desc{name: name, help: help, labels: labels}, values: make(map[string]*counterSeries)}
+	r.register(c)
+	return c
+}
+
+// Inc Add 1 to the counter of the given label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add Add v, which must not be negative, to the counter of the given label values
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("counter %s can't decrease", c.name))
+	}
+	key, labels := c.series(labelValues)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	s, ok := c.values[key]
+	if !ok {
+		s = &counterSeries{labels: labels}
+		c.values[key] = s
+	}
+	s.value += v
+}
+
+// Value The current value of the counter of the given label values
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	key, _ := c.series(labelValues)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if s, ok := c.values[key]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (c *CounterVec) write(w io.Writer) error {
+	if err := c.writeHeader(w, "counter"); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	// A counter without labels always has a value
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		_, err := fmt.Fprintf(w, "%s 0\n", c.name)
+		return err
+	}
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	// Series are written in a stable order
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := c.values[key]
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", c.name, s.labels, formatFloat(s.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HistogramVec Histograms sharing a name and buckets, one per combination of label values
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mutex   sync.Mutex
+	values  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	// Observations per bucket, not cumulated
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec Register a histogram with the given bucket upper bounds, split by the given labels
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	sorted := make([]float64, len(buckets))
+	copy(sorted, buckets)
+	sort.Float64s(sorted)
+	h := &HistogramVec{desc: desc{name: name, help: help, labels: labels}, buckets: sorted, values: make(map[string]*histogramSeries)}
+	r.register(h)
+	return h
+}
+
+// Observe Record v in the histogram of the given label values
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key, _ := h.series(labelValues)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	s, ok := h.values[key]
+	if !ok {
+		s = &histogramSeries{labelValues: append([]string{}, labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.values[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+// Count The number of observations in the histogram of the given label values
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	key, _ := h.series(labelValues)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if s, ok := h.values[key]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) write(w io.Writer) error {
+	if err := h.writeHeader(w, "histogram"); err != nil {
+		return err
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	bucketLabels := append(append([]string{}, h.labels...), "le")
+	// The +Inf bucket holds every observation
+	bounds := append(append([]float64{}, h.buckets...), math.Inf(1))
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.values[key]
+		var cumulated uint64
+		for i, bound := range bounds {
+			if i < len(s.counts) {
+				cumulated += s.counts[i]
+			} else {
+				cumulated = s.count
+			}
+			labels := formatLabels(bucketLabels, append(append([]string{}, s.labelValues...), formatFloat(bound)))
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, cumulated); err != nil {
+				return err
+			}
+		}
+		labels := formatLabels(h.labels, s.labelValues)
+		if _, err := fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", h.name, labels, formatFloat(s.sum), h.name, labels, s.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestCounterVec(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("roll20_requests_total", "Requests sent to Roll20", "route", "status")
+	logins := r.NewCounterVec("roll20_logins_total", "Logins\nto Roll20")
+	requests.Inc("/campaigns/details/:id", "200")
+	requests.Add(2, "/campaigns/details/:id", "200")
+	requests.Inc(`/odd"route\`, "error")
+
+	assert.Equal(t, float64(3), requests.Value("/campaigns/details/:id", "200"))
+	assert.Equal(t, float64(0), requests.Value("/campaigns/details/:id", "500"))
+	var buf bytes.Buffer
+	assert.Nil(t, r.Write(&buf))
+	assert.Equal(t, `# HELP roll20_requests_total Requests sent to Roll20
+# TYPE roll20_requests_total counter
+roll20_requests_total{route="/campaigns/details/:id",status="200"} 3
+roll20_requests_total{route="/odd\"route\\",status="error"} 1
+# HELP roll20_logins_total Logins\nto Roll20
+# TYPE roll20_logins_total counter
+roll20_logins_total 0
+`, buf.String())
+
+	assert.Panics(t, func() { requests.Inc("/campaigns/details/:id") })
+	assert.Panics(t, func() { logins.Add(-1) })
+}
+
+func TestHistogramVec(t *testing.T) {
+	r := NewRegistry()
+	latency := r.NewHistogramVec("http_request_duration_seconds", "Latency", []float64{1, 0.1}, "handler")
+	latency.Observe(0.05, "/get-players")
+	latency.Observe(0.1, "/get-players")
+	latency.Observe(0.5, "/get-players")
+	latency.Observe(3, "/get-players")
+
+	assert.Equal(t, uint64(4), latency.Count("/get-players"))
+	var buf bytes.Buffer
+	assert.Nil(t, r.Write(&buf))
+	assert.Equal(t, `# HELP http_request_duration_seconds Latency
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{handler="/get-players",le="0.1"} 2
+http_request_duration_seconds_bucket{handler="/get-players",le="1"} 3
+http_request_duration_seconds_bucket{handler="/get-players",le="+Inf"} 4
+http_request_duration_seconds_sum{handler="/get-players"} 3.65
+http_request_duration_seconds_count{handler="/get-players"} 4
+`, buf.String())
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	r := NewRegistry()
+	pages := r.NewCounterVec("roll20_archive_pages_fetched_total", "Pages")
+	latency := r.NewHistogramVec("roll20_request_duration_seconds", "Latency", DEFAULT_BUCKETS, "route")
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pages.Inc()
+			latency.Observe(0.2, "/sessions/create")
+			r.Write(&bytes.Buffer{})
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, float64(50), pages.Value())
+	assert.Equal(t, uint64(50), latency.Count("/sessions/create"))
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("roll20_logins_total", "Logins", "outcome").Inc("success")
+	w := httptest.NewRecorder()
+	Handler(r).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `roll20_logins_total{outcome="success"} 1`)
+}
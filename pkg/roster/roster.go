@@ -0,0 +1,150 @@
+package roster
+
+import (
+	"handler/function/pkg/scrapper"
+	"sort"
+	"time"
+)
+
+type EventType string
+
+const (
+	PlayerJoined  EventType = "joined"
+	PlayerLeft    EventType = "left"
+	GmGranted     EventType = "gm-granted"
+	GmRevoked     EventType = "gm-revoked"
+	Renamed       EventType = "renamed"
+	AvatarChanged EventType = "avatar-changed"
+)
+
+//Snapshot The players of a campaign at a given time
+type Snapshot struct {
+	// Time at which the players have been retrieved
+	// required: true
+	TakenAt time.Time `json:"takenAt"`
+	// All players of the campaign at this time
+	// required: true
+	Players []scrapper.Player `json:"players"`
+}
+
+// swagger:model RosterEvent
+//Event A change in the players of a campaign between two snapshots
+type Event struct {
+	// Kind of change, either joined, left, gm-granted, gm-revoked, renamed or avatar-changed
+	// required: true
+	Type EventType `json:"type"`
+	// Roll20 ID of the player concerned
+	// required: true
+	Roll20Id int `json:"roll20Id"`
+	// Username of the player concerned, as of the latest snapshot listing them
+	// required: true
+	Username string `json:"username"`
+	// Value before the change. Only set for renamed and avatar-changed events
+	Previous string `json:"previous,omitempty"`
+	// Value after the change. Only set for renamed and avatar-changed events
+	Current string `json:"current,omitempty"`
+	// Time of the first snapshot in which the change has been seen
+	// required: true
+	SeenAt time.Time `json:"seenAt"`
+}
+
+// Diff Compute all changes between two successive lists of players of a campaign.
+// Players are matched on their Roll20 ID, events are sorted by player ID
+func Diff(previous []scrapper.Player, current []scrapper.Player, seenAt time.Time) []Event {
+	events := []Event{}
+	before := indexPlayers(previous)
+	after := indexPlayers(current)
+
+	for id, player := range after {
+		old, ok := before[id]
+		if !ok {
+			events = append(events, Event{Type: PlayerJoined, Roll20Id: id, Username: player.Username, SeenAt: seenAt})
+			continue
+		}
+		if !old.IsGm && player.IsGm {
+			events = append(events, Event{Type: GmGranted, Roll20Id: id, Username: player.Username, SeenAt: seenAt})
+		}
+		if old.IsGm && !player.IsGm {
+			events = append(events, Event{Type: GmRevoked, Roll20Id: id, Username: player.Username, SeenAt: seenAt})
+		}
+		if old.Username != player.Username {
+			events = append(events, Event{Type: Renamed, Roll20Id: id, Username: player.Username, Previous: old.Username, Current: player.Username, SeenAt: seenAt})
+		}
+		if old.AvatarUrl != player.AvatarUrl {
+			events = append(events, Event{Type: AvatarChanged, Roll20Id: id, Username: player.Username, Previous: old.AvatarUrl, Current: player.AvatarUrl, SeenAt: seenAt})
+		}
+	}
+	for id, player := range before {
+		if _, ok := after[id]; !ok {
+			events = append(events, Event{Type: PlayerLeft, Roll20Id: id, Username: player.Username, SeenAt: seenAt})
+		}
+	}
+
+	// Map iteration order is random, events must still be stable across calls
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Roll20Id != events[j].Roll20Id {
+			return events[i].Roll20Id < events[j].Roll20Id
+		}
+		return events[i].Type < events[j].Type
+	})
+	return events
+}
+
+// History Compute all changes across successive snapshots of a campaign, oldest first.
+// The first snapshot is the baseline and doesn't yield any event
+func History(snapshots []Snapshot) []Event {
+	events := []Event{}
+	for i := 1; i < len(snapshots); i++ {
+		events = append(events, Diff(snapshots[i-1].Players, snapshots[i].Players, snapshots[i].TakenAt)...)
+	}
+	return events
+}
+
+// Record Store the current players of a campaign if they changed since the last stored snapshot.
+// Returns the changes since this last snapshot, none for the first one
+func Record(store Store, campaignId string, players []scrapper.Player, takenAt time.Time) ([]Event, error) {
+	snapshots, err := store.Load(campaignId)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) == 0 {
+		return []Event{}, store.Save(campaignId, Snapshot{TakenAt: takenAt, Players: players})
+	}
+	events := Diff(snapshots[len(snapshots)-1].Players, players, takenAt)
+	// Nothing changed, no need to grow the history
+	if len(events) == 0 {
+		return events, nil
+	}
+	return events, store.Save(campaignId, Snapshot{TakenAt: takenAt, Players: players})
+}
+
+// Players matched by Roll20 ID. A player listed twice (GM and player) is merged into a single GM
+func indexPlayers(players []scrapper.Player) map[int]scrapper.Player {
+	index := make(map[int]scrapper.Player, len(players))
+	for _, player := range players {
+		if existing, ok := index[player.Roll20Id]; ok {
+			player.IsGm = player.IsGm || existing.IsGm
+			if len(player.AvatarUrl) == 0 {
+				player.AvatarUrl = existing.AvatarUrl
+			}
+		}
+		index[player.Roll20Id] = player
+	}
+	return index
+}
+
+// swagger:model RosterHistory
+//RosterHistory All recorded changes in the players of a campaign
+type RosterHistory struct {
+	// Roll20 ID of the campaign
+	// required: true
+	GameId string `json:"gameId"`
+	// Time of the first recorded snapshot. Null if the campaign has never been recorded
+	TrackedSince *time.Time `json:"trackedSince"`
+	// Current players of the campaign
+	// required: true
+	Players []scrapper.Player `json:"players"`
+	// All changes since the campaign is tracked, oldest first
+	// required: true
+	Events []Event `json:"events"`
+}
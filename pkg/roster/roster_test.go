@@ -0,0 +1,98 @@
+package roster
+
+import (
+	"github.com/stretchr/testify/assert"
+	"handler/function/pkg/scrapper"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2022, 5, 1, 20, 0, 0, 0, time.UTC)
+var t1 = t0.Add(24 * time.Hour)
+var t2 = t1.Add(24 * time.Hour)
+
+func basePlayers() []scrapper.Player {
+	return []scrapper.Player{
+		{Roll20Id: 1, Username: "GM", IsGm: true, AvatarUrl: "https://a/1.png"},
+		{Roll20Id: 2, Username: "Player 1", AvatarUrl: "https://a/2.png"},
+		{Roll20Id: 3, Username: "Player 2", AvatarUrl: "https://a/3.png"},
+	}
+}
+
+func TestDiffNoChange(t *testing.T) {
+	events := Diff(basePlayers(), basePlayers(), t1)
+	assert.Empty(t, events)
+}
+
+func TestDiffAllEvents(t *testing.T) {
+	current := basePlayers()
+	// 1 is no longer GM, 2 becomes one and is renamed, 3 left, 4 joined, 1 changed avatar
+	current[0].IsGm = false
+	current[0].AvatarUrl = "https://a/1-new.png"
+	current[1].IsGm = true
+	current[1].Username = "Player One"
+	current = append(current[:2], scrapper.Player{Roll20Id: 4, Username: "Newcomer"})
+
+	events := Diff(basePlayers(), current, t1)
+	assert.Equal(t, []Event{
+		{Type: AvatarChanged, Roll20Id: 1, Username: "GM", Previous: "https://a/1.png", Current: "https://a/1-new.png", SeenAt: t1},
+		{Type: GmRevoked, Roll20Id: 1, Username: "GM", SeenAt: t1},
+		{Type: GmGranted, Roll20Id: 2, Username: "Player One", SeenAt: t1},
+		{Type: Renamed, Roll20Id: 2, Username: "Player One", Previous: "Player 1", Current: "Player One", SeenAt: t1},
+		{Type: PlayerLeft, Roll20Id: 3, Username: "Player 2", SeenAt: t1},
+		{Type: PlayerJoined, Roll20Id: 4, Username: "Newcomer", SeenAt: t1},
+	}, events)
+}
+
+// A GM also listed as a player must not be seen as revoked
+func TestDiffDuplicatedGm(t *testing.T) {
+	current := append(basePlayers(), scrapper.Player{Roll20Id: 1, Username: "GM", IsGm: false, AvatarUrl: "https://a/1.png"})
+	events := Diff(basePlayers(), current, t1)
+	assert.Empty(t, events)
+}
+
+func TestHistory(t *testing.T) {
+	second := append(basePlayers(), scrapper.Player{Roll20Id: 4, Username: "Newcomer"})
+	third := second[1:]
+	events := History([]Snapshot{
+		{TakenAt: t0, Players: basePlayers()},
+		{TakenAt: t1, Players: second},
+		{TakenAt: t2, Players: third},
+	})
+	assert.Equal(t, []Event{
+		{Type: PlayerJoined, Roll20Id: 4, Username: "Newcomer", SeenAt: t1},
+		{Type: PlayerLeft, Roll20Id: 1, Username: "GM", SeenAt: t2},
+	}, events)
+}
+
+func TestHistoryEmpty(t *testing.T) {
+	assert.Empty(t, History(nil))
+	assert.Empty(t, History([]Snapshot{{TakenAt: t0, Players: basePlayers()}}))
+}
+
+func TestRecord(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	assert.Nil(t, err)
+
+	// First snapshot is the baseline
+	events, err := Record(store, "1", basePlayers(), t0)
+	assert.Nil(t, err)
+	assert.Empty(t, events)
+
+	// Unchanged players are not stored again
+	events, err = Record(store, "1", basePlayers(), t1)
+	assert.Nil(t, err)
+	assert.Empty(t, events)
+	snapshots, err := store.Load("1")
+	assert.Nil(t, err)
+	assert.Len(t, snapshots, 1)
+
+	events, err = Record(store, "1", basePlayers()[1:], t2)
+	assert.Nil(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, PlayerLeft, events[0].Type)
+	snapshots, err = store.Load("1")
+	assert.Nil(t, err)
+	assert.Len(t, snapshots, 2)
+	assert.Len(t, History(snapshots), 1)
+}
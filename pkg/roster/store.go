@@ -0,0 +1,96 @@
+package roster
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// Store Persists the successive snapshots of each campaign
+type Store interface {
+	// Load Retrieve all snapshots of a campaign, oldest first. An unknown campaign has no snapshot
+	Load(campaignId string) ([]Snapshot, error)
+	// Save Append a snapshot to the history of a campaign
+	Save(campaignId string, snapshot Snapshot) error
+}
+
+// Campaign IDs end up in file names, they must not be able to escape the store directory
+var validCampaignId = regexp.MustCompile(`^[0-9]+$`)
+
+// FileStore Store keeping the history of each campaign as a JSON file in a local directory
+type FileStore struct {
+	dir   string
+	mutex sync.Mutex
+}
+
+// NewFileStore Build a store in the given directory, creating it if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("Unable to create the roster store directory %s : %s", dir, err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (fs *FileStore) Load(campaignId string) ([]Snapshot, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	return fs.load(campaignId)
+}
+
+func (fs *FileStore) Save(campaignId string, snapshot Snapshot) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	snapshots, err := fs.load(campaignId)
+	if err != nil {
+		return err
+	}
+	snapshots = append(snapshots, snapshot)
+	data, err := json.Marshal(snapshots)
+	if err != nil {
+		return err
+	}
+
+	// Write then rename, a crash mid-write must not corrupt the whole history
+	tmp, err := ioutil.TempFile(fs.dir, campaignId+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("Unable to save the roster of campaign %s : %s", campaignId, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Unable to save the roster of campaign %s : %s", campaignId, err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("Unable to save the roster of campaign %s : %s", campaignId, err)
+	}
+	if err = os.Rename(tmp.Name(), fs.path(campaignId)); err != nil {
+		return fmt.Errorf("Unable to save the roster of campaign %s : %s", campaignId, err)
+	}
+	return nil
+}
+
+func (fs *FileStore) load(campaignId string) ([]Snapshot, error) {
+	if !validCampaignId.MatchString(campaignId) {
+		return nil, fmt.Errorf("Invalid campaign id %s", campaignId)
+	}
+	data, err := ioutil.ReadFile(fs.path(campaignId))
+	if os.IsNotExist(err) {
+		return []Snapshot{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the roster of campaign %s : %s", campaignId, err)
+	}
+	var snapshots []Snapshot
+	if err = json.Unmarshal(data, &snapshots); err != nil {
+		return nil, fmt.Errorf("The roster of campaign %s is corrupted : %s", campaignId, err)
+	}
+	return snapshots, nil
+}
+
+func (fs *FileStore) path(campaignId string) string {
+	return filepath.Join(fs.dir, campaignId+".json")
+}
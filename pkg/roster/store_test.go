@@ -0,0 +1,67 @@
+package roster
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStoreUnknownCampaign(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	assert.Nil(t, err)
+	snapshots, err := store.Load("1")
+	assert.Nil(t, err)
+	assert.Empty(t, snapshots)
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, store.Save("1", Snapshot{TakenAt: t0, Players: basePlayers()}))
+	assert.Nil(t, store.Save("1", Snapshot{TakenAt: t1, Players: basePlayers()[1:]}))
+
+	// Another store on the same directory sees the same history
+	other, err := NewFileStore(dir)
+	assert.Nil(t, err)
+	snapshots, err := other.Load("1")
+	assert.Nil(t, err)
+	assert.Len(t, snapshots, 2)
+	assert.True(t, t0.Equal(snapshots[0].TakenAt))
+	assert.Equal(t, basePlayers(), snapshots[0].Players)
+
+	// Campaigns are kept apart
+	snapshots, err = store.Load("2")
+	assert.Nil(t, err)
+	assert.Empty(t, snapshots)
+}
+
+func TestFileStoreCreatesDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "nested", "roster")
+	store, err := NewFileStore(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, store.Save("1", Snapshot{TakenAt: t0, Players: basePlayers()}))
+	_, err = os.Stat(filepath.Join(dir, "1.json"))
+	assert.Nil(t, err)
+}
+
+// Campaign ids are used as file names
+func TestFileStoreInvalidCampaignId(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	assert.Nil(t, err)
+	_, err = store.Load("../1")
+	assert.Error(t, err)
+	err = store.Save("../1", Snapshot{TakenAt: t0})
+	assert.Error(t, err)
+}
+
+func TestFileStoreCorrupted(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "1.json"), []byte("{not json"), 0o644))
+	_, err = store.Load("1")
+	assert.Error(t, err)
+}
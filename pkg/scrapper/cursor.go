@@ -0,0 +1,42 @@
+package scrapper
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// MessageCursor A position in the chat archive of a campaign.
+// Positions map onto Roll20 archive pages, so that resuming from a cursor only fetches the pages it needs
+type MessageCursor struct {
+	// Campaign the cursor was issued for
+	CampaignId string `json:"g"`
+	// Archive page, starting at 1
+	Page int `json:"p"`
+	// Index of a message in the page, whether the message is filtered out or not
+	Offset int `json:"o"`
+	// Whether the messages before the position are requested, instead of the ones from it
+	Before bool `json:"b,omitempty"`
+}
+
+// Encode The opaque token handed to API callers
+func (c *MessageCursor) Encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// DecodeMessageCursor Parse a token built by Encode
+func DecodeMessageCursor(token string) (*MessageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("Malformed cursor : %s", err)
+	}
+	var cursor MessageCursor
+	if err = json.Unmarshal(raw, &cursor); err != nil {
+		return nil, fmt.Errorf("Malformed cursor : %s", err)
+	}
+	if cursor.Page < 1 || cursor.Offset < 0 {
+		return nil, fmt.Errorf("Cursor out of the archive")
+	}
+	return &cursor, nil
+}
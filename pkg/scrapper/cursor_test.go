@@ -0,0 +1,32 @@
+package scrapper
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMessageCursorRoundTrip(t *testing.T) {
+	cursor := &MessageCursor{CampaignId: "5632681", Page: 3, Offset: 42, Before: true}
+	token := cursor.Encode()
+	assert.NotContains(t, token, "=")
+	decoded, err := DecodeMessageCursor(token)
+	assert.Nil(t, err)
+	assert.Equal(t, cursor, decoded)
+}
+
+func TestDecodeInvalidMessageCursor(t *testing.T) {
+	var tests = []string{
+		"",
+		"!!!",
+		// Valid base64, but not JSON
+		"bWVo",
+		(&MessageCursor{CampaignId: "1", Page: 0}).Encode(),
+		(&MessageCursor{CampaignId: "1", Page: 1, Offset: -1}).Encode(),
+	}
+	for _, token := range tests {
+		t.Run(token, func(t *testing.T) {
+			_, err := DecodeMessageCursor(token)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -1,5 +1,7 @@
 package scrapper
 
+import "time"
+
 type MessageType string
 
 const (
@@ -9,13 +11,21 @@ const (
 	Whisper    MessageType = "whisper"
 )
 
+type CampaignRole string
+
+const (
+	PlayerRole  CampaignRole = "player"
+	GmRole      CampaignRole = "gm"
+	CreatorRole CampaignRole = "creator"
+)
+
 // swagger:model Message
 // Message A Message as sent on the Roll20 chat
 type Message struct {
 	// Link to the player avatar
 	Avatar string `json:"avatar"`
 	// Sent timestamp. I don't know why it's called priority
-	Priority float64 `json:".priority, string"`
+	Priority float64 `json:".priority"`
 	// No idea, not parsing
 	// Signature string
 	// Command having triggered the roll action. Ex 1d20
@@ -31,6 +41,23 @@ type Message struct {
 	Who string `json:"who"`
 }
 
+// swagger:model MessagesPage
+//MessagesPage A page of the chat archive, as returned when paginating messages
+type MessagesPage struct {
+	// Messages of the page, oldest first
+	// required: true
+	Messages []Message `json:"messages"`
+	// Cursor of the following page. Missing if there are no more messages
+	Next string `json:"next,omitempty"`
+	// Cursor of the previous page. Missing on the first page
+	Prev string `json:"prev,omitempty"`
+}
+
+// Items Messages of the page, written alone by row-oriented formats
+func (p *MessagesPage) Items() interface{} {
+	return p.Messages
+}
+
 // swagger:model Player
 //Player A Roll20 Player as listed on the campaign page
 type Player struct {
@@ -47,6 +74,32 @@ type Player struct {
 	// This player username (not character name in game, roll20 username)
 	// required: true
 	Username string `json:"username"`
+	// Infos from this player public profile. Only included if requested
+	Profile *Profile `json:"profile,omitempty"`
+}
+
+// swagger:model JoinResult
+//JoinResult Outcome of a join request
+type JoinResult struct {
+	// Roll20 ID of the joined game
+	// required: true
+	GameId string `json:"gameId"`
+	// Whether the bot account was already part of the game before the request
+	// required: true
+	AlreadyJoined bool `json:"alreadyJoined"`
+}
+
+// swagger:model Profile
+//Profile Public infos of a Roll20 user, as displayed on its profile page
+type Profile struct {
+	// Bio of the user. Null if there is none
+	About *string `json:"about"`
+	// Subscription level, either Free, Plus or Pro. Null if not displayed
+	Subscription *string `json:"subscription"`
+	// Time zone of the user. Null if not displayed
+	TimeZone *string `json:"timeZone"`
+	// Registration date of the user. Null if not displayed
+	MemberSince *time.Time `json:"memberSince"`
 }
 
 // swagger:model Summary
@@ -58,6 +111,70 @@ type Summary struct {
 	Id int `json:"id"`
 	// Campaign name
 	Name string `json:"name"`
+	// Campaign description, as written by the GM. Null if there is none
+	Description *string `json:"description"`
+	// Character sheet or game system used by the campaign. Null if none is displayed
+	System *string `json:"system"`
+	// Number of players, as displayed on the campaign page. Null if not displayed
+	PlayerCount *int `json:"playerCount"`
+	// Creation date of the campaign. Null if not displayed
+	CreatedAt *time.Time `json:"createdAt"`
+	// Last time the campaign has been played. Null if it never was
+	LastPlayed *time.Time `json:"lastPlayed"`
+	// Next scheduled session. Null if none is scheduled
+	NextSession *time.Time `json:"nextSession"`
+}
+
+// swagger:model Campaign
+//Campaign All the infos about a Roll20 campaign that can be parsed from its details page
+type Campaign struct {
+	// Basic infos about the campaign
+	// required: true
+	Summary Summary `json:"summary"`
+	// Every player of the campaign, GMs included
+	// required: true
+	Players []Player `json:"players"`
+	// Only the GMs of the campaign
+	// required: true
+	Gms []Player `json:"gms"`
+	// Latest messages sent to the chat. Only included if requested
+	Messages []Message `json:"messages,omitempty"`
+}
+
+// swagger:model JoinedCampaign
+//JoinedCampaign A campaign the bot account is part of, as listed on its games page
+type JoinedCampaign struct {
+	// Basic infos about the campaign. Only the ID, name and image are listed
+	// required: true
+	Summary Summary `json:"summary"`
+	// Role of the bot account in this campaign
+	// required: true
+	Role CampaignRole `json:"role"`
+}
+
+// swagger:model Character
+//Character A character played by a player, as seen in the chat archive
+type Character struct {
+	// Character name, as displayed in the chat
+	// required: true
+	Name string `json:"name"`
+	// Number of messages sent as this character
+	MessageCount uint `json:"messageCount"`
+	// Timestamp of the first message sent as this character
+	FirstSeen float64 `json:"firstSeen"`
+	// Timestamp of the last message sent as this character
+	LastSeen float64 `json:"lastSeen"`
+}
+
+// swagger:model PlayerCharacters
+//PlayerCharacters All the characters a single player has been speaking as
+type PlayerCharacters struct {
+	// Game specific ID of the player
+	// required: true
+	// unique: true
+	PlayerId string `json:"playerId"`
+	// Characters of this player, ordered by first appearance
+	Characters []Character `json:"characters"`
 }
 
 // A Roll20Account is a basic creds user in roll20
@@ -73,8 +190,19 @@ type Roll20Account struct {
 type Options struct {
 	// Should the bot account ignore itself when retrieving data ? Default : true
 	IgnoreSelf bool
+	// Max number of profiles fetched at the same time by a scrapper when enriching players, whatever the number of
+	// campaigns they belong to. Default : 4
+	ProfileConcurrency int
+	// Max number of campaigns scrapped at the same time by ForEachCampaign. Default : 4
+	CampaignConcurrency int
 }
 
+// Default max number of profiles fetched at the same time
+const defaultProfileConcurrency = 4
+
+// Default max number of campaigns scrapped at the same time
+const defaultCampaignConcurrency = 4
+
 // MessageOptions All available options when fetching messages
 type MessageOptions struct {
 	// Include the dice rolls
@@ -83,6 +211,9 @@ type MessageOptions struct {
 	IncludeChat bool
 	// Include whispers
 	IncludeWhispers bool
+	// Called by GetMessages once each page of the chat archive has been parsed,
+	// with the number of pages parsed so far and the total number of pages
+	OnPage func(pagesDone int, pageCount int)
 }
 
 // Build a new Message Options, by default, roll and chat messages are include but whispers messages are ignored
@@ -94,8 +225,8 @@ func NewMessageOptions() *MessageOptions {
 	}
 }
 
-// Check whether the message should be kept with these options
-func (options *MessageOptions) isAllowing(m Message) bool {
+// Allows Check whether the message should be kept with these options
+func (options *MessageOptions) Allows(m Message) bool {
 	isAllowedRoll := options.IncludeRolls && (m.Type == Roll || m.Type == InlineRoll)
 	isAllowedChat := options.IncludeChat && m.Type == Chat
 	isAllowedWhisper := options.IncludeWhispers && m.Type == Whisper
@@ -110,3 +241,21 @@ type IncompleteError struct {
 func (r *IncompleteError) Error() string {
 	return r.Err.Error()
 }
+
+// JoinRefusedError Roll20 didn't let the bot account in the requested campaign
+type JoinRefusedError struct {
+	Err error
+}
+
+func (r *JoinRefusedError) Error() string {
+	return r.Err.Error()
+}
+
+// NotJoinedError The bot account isn't part of the requested campaign
+type NotJoinedError struct {
+	Err error
+}
+
+func (r *NotJoinedError) Error() string {
+	return r.Err.Error()
+}
@@ -0,0 +1,26 @@
+package scrapper
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Roll20 sends .priority as a number. The former tag had a ", string" option with a space, which encoding/json
+// never recognized, so the priority has always been read and written as a number
+func TestMessagePriorityIsANumber(t *testing.T) {
+	var message Message
+	err := json.Unmarshal([]byte(`{"who": "Aldric", ".priority": 1612276451593.002}`), &message)
+	assert.Nil(t, err)
+	assert.Equal(t, 1612276451593.002, message.Priority)
+
+	asJson, err := json.Marshal(Message{Who: "Aldric", Priority: 1612276451593.002})
+	assert.Nil(t, err)
+	var fields map[string]interface{}
+	assert.Nil(t, json.Unmarshal(asJson, &fields))
+	assert.Equal(t, 1612276451593.002, fields[".priority"])
+
+	// A quoted priority, as the option would have required, is refused
+	err = json.Unmarshal([]byte(`{".priority": "1612276451593.002"}`), &message)
+	assert.NotNil(t, err)
+}
@@ -7,6 +7,9 @@ type roll20Routes struct {
 	loginRedirect    string
 	campaignDetails  func(id string) string
 	campaignArchives func(id string, page int) string
+	userProfile      func(id int) string
+	campaignListing  func(page int) string
+	campaignLeave    func(id string) string
 }
 
 func getRoutes() *roll20Routes {
@@ -19,5 +22,14 @@ func getRoutes() *roll20Routes {
 		campaignArchives: func(id string, page int) string {
 			return fmt.Sprintf("/campaigns/chatarchive/%s?p=%d&hiderollresults=true", id, page)
 		},
+		userProfile: func(id int) string {
+			return fmt.Sprintf("/users/%d", id)
+		},
+		campaignListing: func(page int) string {
+			return fmt.Sprintf("/campaigns/search/?p=%d", page)
+		},
+		campaignLeave: func(id string) string {
+			return "/campaigns/leave/" + id
+		},
 	}
 }
@@ -0,0 +1,63 @@
+package scrapper
+
+import (
+	"handler/function/pkg/metrics"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metrics of the scrapper, exposed by the metrics registry of the process
+var (
+	roll20Requests  = metrics.Default.NewCounterVec("roll20_requests_total", "Requests sent to Roll20, by route and status code. Network errors have the \"error\" status", "route", "status")
+	roll20Latency   = metrics.Default.NewHistogramVec("roll20_request_duration_seconds", "Duration of the requests sent to Roll20, by route", metrics.DEFAULT_BUCKETS, "route")
+	roll20Logins    = metrics.Default.NewCounterVec("roll20_logins_total", "Logins of the bot account to Roll20, by outcome. Either success, failure or error", "outcome")
+	archivePages    = metrics.Default.NewCounterVec("roll20_archive_pages_fetched_total", "Chat archive pages fetched from Roll20")
+	parsedMessages  = metrics.Default.NewCounterVec("roll20_messages_parsed_total", "Messages parsed from the chat archive pages")
+	droppedMessages = metrics.Default.NewCounterVec("roll20_messages_filtered_total", "Messages dropped by the filters of the caller, by message type", "type")
+	playersIgnored  = metrics.Default.NewCounterVec("roll20_ignored_players_total", "Players left out of a result or of its enrichment, as listed in an IncompleteError, by stage. Either details or profile", "stage")
+)
+
+// Counts and times every request to Roll20, redirects included
+type instrumentedTransport struct {
+	next http.RoundTripper
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	route := routeOf(req.URL.Path)
+	start := time.Now()
+	res, err := t.next.RoundTrip(req)
+	roll20Latency.Observe(time.Since(start).Seconds(), route)
+	if err != nil {
+		roll20Requests.Inc(route, "error")
+		return res, err
+	}
+	roll20Requests.Inc(route, strconv.Itoa(res.StatusCode))
+	return res, nil
+}
+
+// The route a Roll20 path belongs to. IDs and join codes are replaced, so that there are only a few routes
+func routeOf(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if len(segment) == 0 {
+			continue
+		}
+		if _, err := strconv.Atoi(segment); err == nil {
+			segments[i] = ":id"
+		} else if i == 3 && segments[1] == "join" {
+			segments[i] = ":code"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// Whether m is allowed by the options, counting the dropped messages
+func (options *MessageOptions) keep(m Message) bool {
+	if options.Allows(m) {
+		return true
+	}
+	droppedMessages.Inc(string(m.Type))
+	return false
+}
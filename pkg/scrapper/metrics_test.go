@@ -0,0 +1,75 @@
+package scrapper
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestRouteOf(t *testing.T) {
+	var tests = map[string]string{
+		"/sessions/create":             "/sessions/create",
+		"/campaigns/details/5632681":   "/campaigns/details/:id",
+		"/campaigns/chatarchive/1":     "/campaigns/chatarchive/:id",
+		"/users/42":                    "/users/:id",
+		"/join/5632681/59lzQg":         "/join/:id/:code",
+		"/campaigns/search/":           "/campaigns/search/",
+		"/campaigns/leave/5632681":     "/campaigns/leave/:id",
+		"/editor/setcampaign/5632681/": "/editor/setcampaign/:id/",
+	}
+	for path, route := range tests {
+		assert.Equal(t, route, routeOf(path), path)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	mockServer := SetupTestServer("./../../assets/sample_campaign_chat_archive.html", "/campaigns/chatarchive/")
+	defer mockServer.Close()
+	logins := roll20Logins.Value("success")
+	requests := roll20Requests.Value("/campaigns/chatarchive/:id", "200")
+	latencies := roll20Latency.Count("/campaigns/chatarchive/:id")
+	pages := archivePages.Value()
+	parsed := parsedMessages.Value()
+	whispers := droppedMessages.Value(string(Whisper))
+
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	_, err = scrapper.GetMessages("1", ^uint(0), NewMessageOptions())
+	assert.Nil(t, err)
+
+	assert.Equal(t, logins+1, roll20Logins.Value("success"))
+	// The sample is served as 3 virtual pages, the 4th one telling the archive is over
+	assert.Equal(t, requests+4, roll20Requests.Value("/campaigns/chatarchive/:id", "200"))
+	assert.Equal(t, latencies+4, roll20Latency.Count("/campaigns/chatarchive/:id"))
+	assert.Equal(t, pages+4, archivePages.Value())
+	assert.Equal(t, parsed+3*70, parsedMessages.Value())
+	assert.Equal(t, whispers+3*5, droppedMessages.Value(string(Whisper)))
+}
+
+func TestMetricsFailures(t *testing.T) {
+	mockServer := SetupConstantServer(http.StatusUnauthorized)
+	failures := roll20Logins.Value("failure")
+	_, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Error(t, err)
+	assert.Equal(t, failures+1, roll20Logins.Value("failure"))
+
+	// Roll20 being unreachable
+	mockServer.Close()
+	errors := roll20Logins.Value("error")
+	unreachable := roll20Requests.Value("/sessions/create", "error")
+	_, err = NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Error(t, err)
+	assert.Equal(t, errors+1, roll20Logins.Value("error"))
+	assert.Equal(t, unreachable+1, roll20Requests.Value("/sessions/create", "error"))
+
+	// Players left out of the details are counted
+	mockServer = SetupTestServer("./../../assets/sample_campaign_missing_id.html", "/campaigns/details/")
+	defer mockServer.Close()
+	ignored := playersIgnored.Value("details")
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	_, err = scrapper.GetPlayers("1")
+	assert.IsType(t, &IncompleteError{}, err)
+	assert.Equal(t, ignored+2, playersIgnored.Value("details"))
+}
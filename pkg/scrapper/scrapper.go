@@ -9,8 +9,10 @@ import (
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 type Scrapper struct {
@@ -19,6 +21,8 @@ type Scrapper struct {
 	client  *http.Client
 	account *Roll20Account
 	options *Options
+	// Each running profile fetch holds a slot, whichever campaign it is for
+	profileSlots chan struct{}
 }
 
 // NewScrapper Creates a new Roll20 Scrapper instance, login it in immediately
@@ -30,7 +34,19 @@ func NewScrapper(baseUrl string, account *Roll20Account, options *Options) (*Scr
 	if options == nil {
 		options = &Options{IgnoreSelf: true}
 	}
-	s := &Scrapper{baseUrl: baseUrl, routes: getRoutes(), client: &http.Client{Jar: jar}, account: account, options: options}
+	profileConcurrency := options.ProfileConcurrency
+	if profileConcurrency <= 0 {
+		profileConcurrency = defaultProfileConcurrency
+	}
+	client := &http.Client{Jar: jar, Transport: &instrumentedTransport{next: http.DefaultTransport}}
+	s := &Scrapper{
+		baseUrl:      baseUrl,
+		routes:       getRoutes(),
+		client:       client,
+		account:      account,
+		options:      options,
+		profileSlots: make(chan struct{}, profileConcurrency),
+	}
 	err = s.login()
 	if err != nil {
 		return nil, err
@@ -38,34 +54,546 @@ func NewScrapper(baseUrl string, account *Roll20Account, options *Options) (*Scr
 	return s, nil
 }
 
-// JoinGame Join a Roll 20 game instance given the campaign id and the joincode
-func (s *Scrapper) JoinGame(gameId string, gameCode string) error {
+// JoinGame Join a Roll 20 game instance given the campaign id and the joincode.
+// The campaign details page is checked before and after joining, as Roll20 answers 200 even for an expired code.
+// A JoinRefusedError is returned when the bot account couldn't get in
+func (s *Scrapper) JoinGame(gameId string, gameCode string) (*JoinResult, error) {
 	gameUrl, err := url.Parse(fmt.Sprintf("%s/join/%s/%s", s.baseUrl, gameId, gameCode))
 	if err != nil {
-		return fmt.Errorf("invalid parsed url: %s. Error info:  %s\n", gameUrl, err.Error())
+		return nil, fmt.Errorf("invalid parsed url: %s. Error info:  %s\n", gameUrl, err.Error())
+	}
+
+	// Joining an already joined game is a no-op.
+	// The details page of a game not joined yet is often out of reach, which only means it has to be joined
+	if doc, err := s.getCampaignDetails(gameId); err == nil {
+		joined, err := isListedIn(doc)
+		if err != nil {
+			return nil, err
+		}
+		if joined {
+			return &JoinResult{GameId: gameId, AlreadyJoined: true}, nil
+		}
 	}
+
 	res, err := s.client.Get(gameUrl.String())
+	if err != nil {
+		return nil, fmt.Errorf("Could not join game.: %s. Error info:  %s\n", gameUrl, err.Error())
+	}
 	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return nil, &JoinRefusedError{Err: fmt.Errorf("Could not join game. Status :  %d. Message:  %s\n", res.StatusCode, body)}
+	}
+
+	// Roll20 answers 200 even for an expired code, the bot must now be listed in the game
+	joined, err := s.isJoined(gameId)
+	if err != nil {
+		return nil, err
+	}
+	if !joined {
+		return nil, &JoinRefusedError{Err: fmt.Errorf("The bot account isn't listed in game %s after joining it. Is the join code %s still valid ?", gameId, gameCode)}
+	}
+	return &JoinResult{GameId: gameId, AlreadyJoined: false}, nil
+}
+
+// LeaveGame Leave a joined Roll20 game given the campaign id, as a player would with the "Leave Game" button.
+// The campaign listing is then checked to ensure the game has really been left
+func (s *Scrapper) LeaveGame(campaignId string) error {
+	doc, err := s.getCampaignDetails(campaignId)
 	if err != nil {
-		return fmt.Errorf("Could not join game.: %s. Error info:  %s\n", gameUrl, err.Error())
+		return err
+	}
+	if doc.Find(".playerlisting").Length() == 0 {
+		return &NotJoinedError{Err: fmt.Errorf("No players listed for campaign %s. Has the game been joined yet ?", campaignId)}
+	}
+	// The game creator cannot leave its own game
+	if doc.Find(".campaign_actions .leavecampaign").Length() == 0 {
+		return fmt.Errorf("Could not find how to leave campaign %s. The bot account may be its creator", campaignId)
+	}
+
+	leaveUrl, err := url.Parse(s.baseUrl + s.routes.campaignLeave(campaignId))
+	if err != nil {
+		return fmt.Errorf("invalid parsed url: %s. Error info:  %s\n", leaveUrl, err.Error())
+	}
+	r, err := http.NewRequest("POST", leaveUrl.String(), strings.NewReader(url.Values{}.Encode()))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Origin", strings.TrimSuffix(s.baseUrl, "/"))
+	res, err := s.client.Do(r)
+	if err != nil {
+		return fmt.Errorf("Could not leave game.: %s. Error info:  %s\n", leaveUrl, err.Error())
 	}
+	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
 		body, _ := ioutil.ReadAll(res.Body)
-		return fmt.Errorf("Could not join game. Status :  %d. Message:  %s\n", res.StatusCode, body)
+		return fmt.Errorf("Could not leave game. Status :  %d. Message:  %s\n", res.StatusCode, body)
+	}
+
+	// Roll20 answers 200 even when nothing happened, the campaign must be gone from the listing
+	campaigns, err := s.ListCampaigns()
+	if _, ok := err.(*IncompleteError); err != nil && !ok {
+		return fmt.Errorf("Could not confirm the game has been left. Error info : %s", err)
+	}
+	for _, campaign := range *campaigns {
+		if strconv.Itoa(campaign.Summary.Id) == campaignId {
+			return fmt.Errorf("Campaign %s is still listed after leaving it", campaignId)
+		}
 	}
 	return nil
 }
 
 // GetPlayers Retrieve all players of a Roll20 game given the id of a joined campaign
 func (s *Scrapper) GetPlayers(campaignId string) (*[]Player, error) {
+	doc, err := s.getCampaignDetails(campaignId)
+	if err != nil {
+		return nil, err
+	}
+	return s.getPlayersFromDetails(doc)
+}
+
+// GetSummary Retrieve a short overview of a Roll20 campaign
+func (s *Scrapper) GetSummary(campaignId string) (*Summary, error) {
+	doc, err := s.getCampaignDetails(campaignId)
+	if err != nil {
+		return nil, err
+	}
+	return getSummaryFromDetails(doc), nil
+}
+
+// GetCampaign Retrieve the summary, players and GMs of a Roll20 campaign at once, fetching
+// the campaign details page a single time.
+// If messagesLimit isn't 0, the latest messages of the chat are also included.
+// As with GetPlayers, an IncompleteError is returned alongside the campaign if some players were ignored
+func (s *Scrapper) GetCampaign(campaignId string, messagesLimit uint, options *MessageOptions) (*Campaign, error) {
+	doc, err := s.getCampaignDetails(campaignId)
+	if err != nil {
+		return nil, err
+	}
+	campaign := Campaign{Summary: *getSummaryFromDetails(doc)}
+
+	players, playersErr := s.getPlayersFromDetails(doc)
+	// An incomplete players list is still worth returning
+	if _, ok := playersErr.(*IncompleteError); playersErr != nil && !ok {
+		return nil, playersErr
+	}
+	campaign.Players = *players
+	campaign.Gms = []Player{}
+	for _, p := range campaign.Players {
+		if p.IsGm {
+			campaign.Gms = append(campaign.Gms, p)
+		}
+	}
+
+	if messagesLimit > 0 {
+		messages, err := s.getLatestMessages(campaignId, messagesLimit, options)
+		if err != nil {
+			return nil, err
+		}
+		campaign.Messages = messages
+	}
+	return &campaign, playersErr
+}
+
+// GetPlayerProfile Retrieve the public infos of a Roll20 user given its roll20 ID
+func (s *Scrapper) GetPlayerProfile(roll20Id int) (*Profile, error) {
+	route := s.routes.userProfile(roll20Id)
+	doc, err := s.getDomOfRoute(route)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve the DOM of %s : %s", route, err)
+	}
+	return getProfileFromDom(doc), nil
+}
+
+// EnrichPlayers Fetch the profile of each player concurrently, and attach it to the player.
+// The cap on concurrent fetches is shared by all the calls on this scrapper, so that enriching the players of several
+// campaigns at once doesn't multiply it.
+// Players whose profile couldn't be retrieved are left as is, and listed in an IncompleteError
+func (s *Scrapper) EnrichPlayers(players []Player) error {
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var ignoredPlayers []string
+	for i := range players {
+		wg.Add(1)
+		s.profileSlots <- struct{}{}
+		go func(player *Player) {
+			defer wg.Done()
+			defer func() { <-s.profileSlots }()
+			profile, err := s.GetPlayerProfile(player.Roll20Id)
+			if err != nil {
+				mutex.Lock()
+				ignoredPlayers = append(ignoredPlayers, player.Username)
+				mutex.Unlock()
+				return
+			}
+			player.Profile = profile
+		}(&players[i])
+	}
+	wg.Wait()
+
+	if len(ignoredPlayers) > 0 {
+		playersIgnored.Add(float64(len(ignoredPlayers)), "profile")
+		sort.Strings(ignoredPlayers)
+		return &IncompleteError{
+			Err: fmt.Errorf("The profile of the following players couldn't be retrieved : %s", strings.Join(ignoredPlayers, ",")),
+		}
+	}
+	return nil
+}
+
+// ForEachCampaign Call fn for each campaign, a few campaigns at a time, and wait for all of them.
+// All calls share this scrapper, and thus its Roll20 session
+func (s *Scrapper) ForEachCampaign(campaignIds []string, fn func(campaignId string)) {
+	forEachCampaign(s.options, campaignIds, fn)
+}
+
+// Call fn for each campaign, at most options.CampaignConcurrency at a time
+func forEachCampaign(options *Options, campaignIds []string, fn func(campaignId string)) {
+	concurrency := defaultCampaignConcurrency
+	if options != nil && options.CampaignConcurrency > 0 {
+		concurrency = options.CampaignConcurrency
+	}
+	var wg sync.WaitGroup
+	// Each running call holds a slot
+	slots := make(chan struct{}, concurrency)
+	for _, campaignId := range campaignIds {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(campaignId string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			fn(campaignId)
+		}(campaignId)
+	}
+	wg.Wait()
+}
+
+// ListCampaigns Retrieve all the campaigns the bot account has joined, along with its role in each of them
+// Campaigns that couldn't be parsed are listed in an IncompleteError
+func (s *Scrapper) ListCampaigns() (*[]JoinedCampaign, error) {
+	campaigns := []JoinedCampaign{}
+	var ignoredCampaigns []string
+	for page, pageCount := 1, 1; page <= pageCount; page++ {
+		route := s.routes.campaignListing(page)
+		doc, err := s.getDomOfRoute(route)
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve the DOM of %s : %s", route, err)
+		}
+		// Each page tells how many there are
+		pageCount, err = getPageCount(doc)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing page %d : %s", page, err)
+		}
+		doc.Find(".campaignlisting .listing").Each(func(_ int, campaignDom *goquery.Selection) {
+			campaign, err := getJoinedCampaignFromDom(campaignDom)
+			if err != nil {
+				ignoredCampaigns = append(ignoredCampaigns, err.Error())
+				return
+			}
+			campaigns = append(campaigns, *campaign)
+		})
+	}
+
+	var err error
+	if len(ignoredCampaigns) > 0 {
+		err = &IncompleteError{
+			Err: fmt.Errorf("The following campaigns have been ignored : %s", strings.Join(ignoredCampaigns, ",")),
+		}
+	}
+	return &campaigns, err
+}
+
+// GetMessages Retrieve all messages from the chat
+func (s *Scrapper) GetMessages(campaignId string, limit uint, options *MessageOptions) (*[]Message, error) {
+	var messages []Message
+
+	// Why ?
+	if limit == 0 {
+		return &messages, nil
+	}
+
+	if options == nil {
+		options = NewMessageOptions()
+	}
+
+	// Fetching all pages, only stopping when we ran up of messages to parse, or if we got to the requested limit
+	for currentPage, oldMessagesLen := 1, -1; uint(len(messages)) < limit && oldMessagesLen != len(messages); currentPage++ {
+		oldMessagesLen = len(messages)
+		var messageTemp []Message
+		pageCount, err := s.getMessagesOfPage(campaignId, currentPage, &messageTemp)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing page %d : %s", currentPage, err)
+		}
+		if options.OnPage != nil {
+			// The loop only stops after requesting a page past the last one
+			pagesDone := currentPage
+			if pagesDone > pageCount {
+				pagesDone = pageCount
+			}
+			options.OnPage(pagesDone, pageCount)
+		}
+		// Filter message with user inputs
+		for _, m := range messageTemp {
+			if options.keep(m) {
+				messages = append(messages, m)
+			}
+		}
+
+	}
+
+	// If too many result were parsed, truncate the array
+	if uint(len(messages)) > limit {
+		messages = messages[0:limit]
+	}
+
+	return &messages, nil
+}
+
+// GetMessagesPage Retrieve at most pageSize messages from the chat, starting at cursor.
+// A nil cursor starts at the beginning of the archive. Only the archive pages holding the returned messages are fetched
+func (s *Scrapper) GetMessagesPage(campaignId string, cursor *MessageCursor, pageSize uint, options *MessageOptions) (*MessagesPage, error) {
+	if options == nil {
+		options = NewMessageOptions()
+	}
+	start := MessageCursor{CampaignId: campaignId, Page: 1}
+	if cursor != nil {
+		start = *cursor
+	}
+	if start.Before {
+		return s.getMessagesBefore(start, pageSize, options)
+	}
+	messages := []Message{}
+	page, offset := start.Page, start.Offset
+	for {
+		var messageTemp []Message
+		pageCount, err := s.getMessagesOfPage(campaignId, page, &messageTemp)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing page %d : %s", page, err)
+		}
+		for ; offset < len(messageTemp) && uint(len(messages)) < pageSize; offset++ {
+			if options.keep(messageTemp[offset]) {
+				messages = append(messages, messageTemp[offset])
+			}
+		}
+		// The page has been read entirely, the following one is next
+		if offset >= len(messageTemp) {
+			page, offset = page+1, 0
+		}
+		if page > pageCount {
+			page = -1
+			break
+		}
+		if uint(len(messages)) >= pageSize {
+			break
+		}
+	}
+
+	result := &MessagesPage{Messages: messages}
+	if page != -1 {
+		result.Next = (&MessageCursor{CampaignId: campaignId, Page: page, Offset: offset}).Encode()
+	}
+	if start.Page > 1 || start.Offset > 0 {
+		result.Prev = (&MessageCursor{CampaignId: campaignId, Page: start.Page, Offset: start.Offset, Before: true}).Encode()
+	}
+	return result, nil
+}
+
+// Walk the archive backward from end, up to pageSize messages
+func (s *Scrapper) getMessagesBefore(end MessageCursor, pageSize uint, options *MessageOptions) (*MessagesPage, error) {
+	var reversed []Message
+	// An offset of -1 stands for the end of the page, as its length isn't known before fetching it
+	page, offset := end.Page, end.Offset
+	for page >= 1 && uint(len(reversed)) < pageSize {
+		if offset == 0 {
+			page, offset = page-1, -1
+			continue
+		}
+		var messageTemp []Message
+		if _, err := s.getMessagesOfPage(end.CampaignId, page, &messageTemp); err != nil {
+			return nil, fmt.Errorf("while parsing page %d : %s", page, err)
+		}
+		if offset < 0 || offset > len(messageTemp) {
+			offset = len(messageTemp)
+		}
+		for offset > 0 && uint(len(reversed)) < pageSize {
+			offset--
+			if options.keep(messageTemp[offset]) {
+				reversed = append(reversed, messageTemp[offset])
+			}
+		}
+	}
+
+	messages := make([]Message, 0, len(reversed))
+	for i := len(reversed) - 1; i >= 0; i-- {
+		messages = append(messages, reversed[i])
+	}
+	result := &MessagesPage{
+		Messages: messages,
+		Next:     (&MessageCursor{CampaignId: end.CampaignId, Page: end.Page, Offset: end.Offset}).Encode(),
+	}
+	if page > 1 || (page == 1 && offset > 0) {
+		result.Prev = (&MessageCursor{CampaignId: end.CampaignId, Page: page, Offset: offset, Before: true}).Encode()
+	}
+	return result, nil
+}
+
+// Retrieve the limit latest messages of the chat, oldest first. Archive pages go from the oldest to the newest,
+// so the archive is walked backward from the end of its last page
+func (s *Scrapper) getLatestMessages(campaignId string, limit uint, options *MessageOptions) ([]Message, error) {
+	if options == nil {
+		options = NewMessageOptions()
+	}
+	// Any page tells how many there are
+	var messageTemp []Message
+	pageCount, err := s.getMessagesOfPage(campaignId, 1, &messageTemp)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing page %d : %s", 1, err)
+	}
+	page, err := s.getMessagesBefore(MessageCursor{CampaignId: campaignId, Page: pageCount, Offset: -1}, limit, options)
+	if err != nil {
+		return nil, err
+	}
+	return page.Messages, nil
+}
+
+// GetNewMessages Retrieve the messages posted after since, along with the position of the end of the archive,
+// to be given back on the next call. A nil since only locates the end of the archive, no message being returned.
+// Positions count every message, so the end is the same whatever the options
+func (s *Scrapper) GetNewMessages(campaignId string, since *MessageCursor, options *MessageOptions) ([]Message, *MessageCursor, error) {
+	messages := []Message{}
+	end, err := s.ForEachNewMessage(campaignId, since, options, func(m Message, next MessageCursor) {
+		messages = append(messages, m)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return messages, end, nil
+}
+
+// ForEachNewMessage Call fn with each message posted after since, oldest first, along with the position following it.
+// The position of the end of the archive is returned, as GetNewMessages does
+func (s *Scrapper) ForEachNewMessage(campaignId string, since *MessageCursor, options *MessageOptions, fn func(m Message, next MessageCursor)) (*MessageCursor, error) {
+	if options == nil {
+		options = NewMessageOptions()
+	}
+	start := MessageCursor{CampaignId: campaignId, Page: 1}
+	if since != nil {
+		start = MessageCursor{CampaignId: campaignId, Page: since.Page, Offset: since.Offset}
+	}
+	end := start
+	for page := start.Page; ; page++ {
+		var messageTemp []Message
+		pageCount, err := s.getMessagesOfPage(campaignId, page, &messageTemp)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing page %d : %s", page, err)
+		}
+		// Without a starting point, only the last page is worth reading
+		if since == nil && page < pageCount {
+			page = pageCount - 1
+			continue
+		}
+		if page > pageCount {
+			break
+		}
+		offset := 0
+		if page == start.Page {
+			offset = start.Offset
+		}
+		for ; since != nil && offset < len(messageTemp); offset++ {
+			if options.keep(messageTemp[offset]) {
+				fn(messageTemp[offset], MessageCursor{CampaignId: campaignId, Page: page, Offset: offset + 1})
+			}
+		}
+		end = MessageCursor{CampaignId: campaignId, Page: page, Offset: len(messageTemp)}
+		if page == pageCount {
+			break
+		}
+	}
+	return &end, nil
+}
+
+// GetCharacters Retrieve all characters played in a campaign, grouped by player.
+// As there is no access to the journal, characters are deduced from the chat archive.
+// Whispers have a sender too, but are only counted when includeWhispers is true, as they tell who whispered and how often
+func (s *Scrapper) GetCharacters(campaignId string, includeWhispers bool) (*[]PlayerCharacters, error) {
+	options := &MessageOptions{IncludeRolls: true, IncludeChat: true, IncludeWhispers: includeWhispers}
+	messages, err := s.GetMessages(campaignId, ^uint(0), options)
+	if err != nil {
+		return nil, err
+	}
+	characters := getCharactersFromMessages(*messages)
+	return &characters, nil
+}
+
+// Retrieve the DOM of the details page of a campaign.
+// Players, GMs and summary are all parsed from this page
+func (s *Scrapper) getCampaignDetails(campaignId string) (*goquery.Document, error) {
 	route := s.routes.campaignDetails(campaignId)
 	doc, err := s.getDomOfRoute(route)
 	if err != nil {
 		return nil, fmt.Errorf("unable to retrieve the DOM of %s : %s", route, err)
 	}
+	return doc, nil
+}
+
+// Selectors of the campaign details page the scrapper depends on, for a campaign the bot account has joined
+var detailsSelectors = []string{
+	".campaign_details",
+	".campaign_details .campaignname span",
+	".campaign_details .meta",
+	".playerlisting",
+	".playerlisting .pclisting",
+	".playerlisting .profilemeta > .userprofile",
+	".playerlisting .well.gray h2",
+	".topbarlogin .simple a[href*=\"wishlists\"]",
+}
+
+// CheckCampaignDetails List the selectors the scrapper depends on which are missing from the details page of a campaign.
+// Missing selectors mean either that Roll20 changed its layout, or that the bot account isn't in the campaign anymore
+func (s *Scrapper) CheckCampaignDetails(campaignId string) ([]string, error) {
+	doc, err := s.getCampaignDetails(campaignId)
 	if err != nil {
 		return nil, err
 	}
+	missing := []string{}
+	for _, selector := range detailsSelectors {
+		if doc.Find(selector).Length() == 0 {
+			missing = append(missing, selector)
+		}
+	}
+	return missing, nil
+}
+
+// Whether the bot account is listed in the players of a campaign
+func (s *Scrapper) isJoined(campaignId string) (bool, error) {
+	doc, err := s.getCampaignDetails(campaignId)
+	if err != nil {
+		return false, err
+	}
+	return isListedIn(doc)
+}
+
+// Whether the bot account is listed in the players of a campaign details page.
+// The bot own ID is read from the page, its absence meaning the layout changed
+func isListedIn(doc *goquery.Document) (bool, error) {
+	ownId, err := retrieveOwnRoll20ID(doc)
+	if err != nil {
+		return false, err
+	}
+	listed := false
+	doc.Find(".playerlisting a[href*=\"/users/\"]").Each(func(_ int, link *goquery.Selection) {
+		href, _ := link.Attr("href")
+		if id, err := getPlayerIdFromURL(href); err == nil && id == ownId {
+			listed = true
+		}
+	})
+	return listed, nil
+}
+
+// Parse all players of a campaign from its details page
+func (s *Scrapper) getPlayersFromDetails(doc *goquery.Document) (*[]Player, error) {
+	var err error
 	var players []Player
 	var ignoredPlayers []string
 
@@ -133,6 +661,7 @@ func (s *Scrapper) GetPlayers(campaignId string) (*[]Player, error) {
 	}
 
 	if len(ignoredPlayers) > 0 {
+		playersIgnored.Add(float64(len(ignoredPlayers)), "details")
 		err = &IncompleteError{
 			Err: fmt.Errorf("The following players have been ignored : %s", strings.Join(ignoredPlayers, ",")),
 		}
@@ -140,16 +669,8 @@ func (s *Scrapper) GetPlayers(campaignId string) (*[]Player, error) {
 	return &players, err
 }
 
-// GetSummary Retrieve a short overview of a Roll20 campaign
-func (s *Scrapper) GetSummary(campaignId string) (*Summary, error) {
-	route := s.routes.campaignDetails(campaignId)
-	doc, err := s.getDomOfRoute(route)
-	if err != nil {
-		return nil, fmt.Errorf("unable to retrieve the DOM of %s : %s", route, err)
-	}
-	if err != nil {
-		return nil, err
-	}
+// Parse the campaign overview from its details page
+func getSummaryFromDetails(doc *goquery.Document) *Summary {
 	summary := Summary{}
 
 	doc.Find(".campaign_details").Each(func(_ int, campaignDetailsDiv *goquery.Selection) {
@@ -168,81 +689,70 @@ func (s *Scrapper) GetSummary(campaignId string) (*Summary, error) {
 		cName := strings.TrimSpace(campaignDetailsDiv.Find(".campaignname span").Text())
 		summary.Name = cName
 
-		// And finnaly the image
+		// The image
 		cImg, exists := campaignDetailsDiv.Find(".campaignicon img").Attr("src")
 		// We don't really mind if the image doesn't exist
-		if !exists {
-			return
+		if exists {
+			summary.Image = cImg
 		}
-		summary.Image = cImg
-
-	})
 
-	return &summary, nil
-}
-
-// GetMessages Retrieve all messages from the chat
-func (s *Scrapper) GetMessages(campaignId string, limit uint, options *MessageOptions) (*[]Message, error) {
-	var messages []Message
-
-	// Why ?
-	if limit == 0 {
-		return &messages, nil
-	}
-
-	if options == nil {
-		options = NewMessageOptions()
-	}
+		// The description is markdown rendered as paragraphs. As the description
+		// is itself a paragraph, all the rendered paragraphs end up being its siblings
+		descriptionDom := campaignDetailsDiv.Find(".description").First()
+		var paragraphs []string
+		descriptionDom.AddSelection(descriptionDom.NextUntil("hr")).Each(func(_ int, p *goquery.Selection) {
+			if text := strings.TrimSpace(p.Text()); len(text) > 0 {
+				paragraphs = append(paragraphs, text)
+			}
+		})
+		if len(paragraphs) > 0 {
+			description := strings.Join(paragraphs, "\n")
+			summary.Description = &description
+		}
 
-	// Fetching all pages, only stopping when we ran up of messages to parse, or if we got to the requested limit
-	for currentPage, oldMessagesLen := 1, -1; uint(len(messages)) < limit && oldMessagesLen != len(messages); currentPage++ {
-		oldMessagesLen = len(messages)
-		var messageTemp []Message
-		err := s.getMessagesOfPage(campaignId, currentPage, &messageTemp)
-		if err != nil {
-			return nil, fmt.Errorf("while parsing page %d : %s", currentPage, err)
+		// All metadata are optional, most of them are only displayed once set
+		meta := campaignDetailsDiv.Find(".meta")
+		if system := getLabelledValue(meta.Find(".charsheet").Text()); len(system) > 0 {
+			summary.System = &system
 		}
-		// Filter message with user inputs
-		for _, m := range messageTemp {
-			if options.isAllowing(m) {
-				messages = append(messages, m)
+		if createdAt, err := parseRoll20Date(getLabelledValue(meta.Find(".created").Text())); err == nil {
+			summary.CreatedAt = createdAt
+		}
+		if lastPlayed, err := parseRoll20Date(getLabelledValue(meta.Find(".lastplayed").Text())); err == nil {
+			summary.LastPlayed = lastPlayed
+		}
+		// The next session is displayed in the user timezone, the timestamp is the only reliable info
+		if timestamp, exists := campaignDetailsDiv.Find(".campaign_schedule .nextsession").Attr("data-timestamp"); exists {
+			if nextSession, err := parseUnixTimestamp(timestamp); err == nil {
+				summary.NextSession = nextSession
 			}
 		}
+	})
 
+	// Something like "7 Players"
+	if playerCount, err := getLeadingNumber(doc.Find(".playerlisting .well.gray h2").First().Text()); err == nil {
+		summary.PlayerCount = &playerCount
 	}
 
-	// If too many result were parsed, truncate the array
-	if uint(len(messages)) > limit {
-		messages = messages[0:limit]
-	}
-
-	return &messages, nil
+	return &summary
 }
 
-// getMessagesOfPage Retrieve all the messages from a specific page
-func (s *Scrapper) getMessagesOfPage(campaignId string, page int, messagesBuffer *[]Message) error {
+// getMessagesOfPage Retrieve all the messages from a specific page, in a stable order.
+// The number of pages of the archive is returned
+func (s *Scrapper) getMessagesOfPage(campaignId string, page int, messagesBuffer *[]Message) (int, error) {
 	route := s.routes.campaignArchives(campaignId, page)
 	doc, err := s.getDomOfRoute(route)
 	if err != nil || doc == nil {
-		return fmt.Errorf("unable to retrieve the DOM of %s : %s", route, err)
+		return -1, fmt.Errorf("unable to retrieve the DOM of %s : %s", route, err)
 	}
+	archivePages.Inc()
 	// Checking if we requested a non-existing page
-	// Something like "Page 1/100"
-	pageDiv := doc.Find(".pagination div")
-	if pageDiv == nil {
-		return fmt.Errorf("Unable to locate pagination div. Halting")
-	}
-	pageText := pageDiv.Text()
-	if !strings.HasPrefix(pageText, "Page") {
-		return fmt.Errorf("Unable to locate pagination text, got %s", pageText)
-	}
-	offsets := strings.Split(strings.TrimSpace(strings.Replace(pageText, "Page", "", 1)), "/")
-	pageUpperLimit, err := strconv.Atoi(offsets[1])
+	pageUpperLimit, err := getPageCount(doc)
 	if err != nil {
-		return fmt.Errorf("Unable to locate pagination text, got %s", pageText)
+		return -1, err
 	}
 	if page > pageUpperLimit {
-		return nil
+		return pageUpperLimit, nil
 	}
 	// Page is valid, let's parse
 
@@ -265,7 +775,7 @@ func (s *Scrapper) getMessagesOfPage(campaignId string, page int, messagesBuffer
 		return true
 	})
 	if chosenScript == nil {
-		return fmt.Errorf("Couldn't retrieve the msgdata variable")
+		return -1, fmt.Errorf("Couldn't retrieve the msgdata variable")
 	}
 	msgScript := chosenScript.Text()
 	// Remove variable declaration prefix
@@ -273,7 +783,7 @@ func (s *Scrapper) getMessagesOfPage(campaignId string, page int, messagesBuffer
 	const SUFFIX = "\";\nO"
 	lastIndex := strings.LastIndex(msgScript, SUFFIX)
 	if lastIndex == -1 {
-		return fmt.Errorf("msgdata variable isn't well formatted")
+		return -1, fmt.Errorf("msgdata variable isn't well formatted")
 	}
 	// Remove isolate the value of the msgData variable
 	// len(suffix) -1 is to keep the "==" but not the trailing '"'
@@ -281,7 +791,7 @@ func (s *Scrapper) getMessagesOfPage(campaignId string, page int, messagesBuffer
 
 	chatMessages, err := base64.StdEncoding.DecodeString(msgScript)
 	if err != nil {
-		return err
+		return -1, err
 	}
 	// Spatial complexity is at least 2N, N < 100 messages
 	// Raw JSON struct as returned by roll20
@@ -289,14 +799,21 @@ func (s *Scrapper) getMessagesOfPage(campaignId string, page int, messagesBuffer
 	// Actual isolated messages
 	err = json.Unmarshal(chatMessages, &mappedMessages)
 	if err != nil {
-		return err
+		return -1, err
 	}
 
-	for _, v := range mappedMessages[0] {
-		*messagesBuffer = append(*messagesBuffer, v)
+	// Message keys are chronological push IDs. Sorting them keeps positions in the page stable across calls
+	keys := make([]string, 0, len(mappedMessages[0]))
+	for k := range mappedMessages[0] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		*messagesBuffer = append(*messagesBuffer, mappedMessages[0][k])
 	}
+	parsedMessages.Add(float64(len(keys)))
 
-	return nil
+	return pageUpperLimit, nil
 }
 
 // Given a Roll20 relative url, retrieve the DOm as a goquery document
@@ -337,16 +854,41 @@ func (s *Scrapper) login() error {
 	r.Header.Set("Origin", strings.TrimSuffix(s.baseUrl, "/"))
 	res, err := s.client.Do(r)
 	if err != nil {
+		roll20Logins.Inc("error")
 		return err
 	}
 	if res.StatusCode != http.StatusOK {
+		roll20Logins.Inc("failure")
 		b, _ := ioutil.ReadAll(res.Body)
 		fmt.Printf(string(b))
 		return fmt.Errorf("invalid credentials provided. u : %s, p: %s", s.account.Login, s.account.Password)
 	}
+	roll20Logins.Inc("success")
 	return nil
 }
 
+// Retrieve the number of pages of a paginated Roll20 listing
+func getPageCount(doc *goquery.Document) (int, error) {
+	// Something like "Page 1/100"
+	pageDiv := doc.Find(".pagination div")
+	if pageDiv == nil {
+		return -1, fmt.Errorf("Unable to locate pagination div. Halting")
+	}
+	pageText := pageDiv.Text()
+	if !strings.HasPrefix(pageText, "Page") {
+		return -1, fmt.Errorf("Unable to locate pagination text, got %s", pageText)
+	}
+	offsets := strings.Split(strings.TrimSpace(strings.Replace(pageText, "Page", "", 1)), "/")
+	if len(offsets) != 2 {
+		return -1, fmt.Errorf("Unable to locate pagination text, got %s", pageText)
+	}
+	pageCount, err := strconv.Atoi(strings.TrimSpace(offsets[1]))
+	if err != nil {
+		return -1, fmt.Errorf("Unable to locate pagination text, got %s", pageText)
+	}
+	return pageCount, nil
+}
+
 // Fetch the bot account own ID, this is to allow the bot to ignore itself on the players fetching
 func retrieveOwnRoll20ID(doc *goquery.Document) (int, error) {
 	href, exist := doc.Find(".topbarlogin .simple a[href*=\"wishlists\"]").First().Attr("href")
@@ -361,6 +903,55 @@ func retrieveOwnRoll20ID(doc *goquery.Document) (int, error) {
 	return ownId, nil
 }
 
+// Parse the public infos of a user from its profile page
+func getProfileFromDom(doc *goquery.Document) *Profile {
+	profile := Profile{}
+	meta := doc.Find(".userprofile .profilemeta").First()
+
+	// Something like "Pro | Send a Gift Subscription"
+	if levels := strings.Split(meta.Find(".userlevel").Text(), "|"); len(strings.TrimSpace(levels[0])) > 0 {
+		subscription := strings.TrimSpace(levels[0])
+		profile.Subscription = &subscription
+	}
+	if memberSince, err := parseRoll20Date(getLabelledValue(meta.Find(".membersince").Text())); err == nil {
+		profile.MemberSince = memberSince
+	}
+	if timeZone := getLabelledValue(meta.Find(".timezone").Text()); len(timeZone) > 0 {
+		profile.TimeZone = &timeZone
+	}
+
+	var paragraphs []string
+	doc.Find(".aboutme p").Each(func(_ int, p *goquery.Selection) {
+		if text := strings.TrimSpace(p.Text()); len(text) > 0 {
+			paragraphs = append(paragraphs, text)
+		}
+	})
+	if len(paragraphs) > 0 {
+		about := strings.Join(paragraphs, "\n")
+		profile.About = &about
+	}
+	return &profile
+}
+
+// From a campaign listing division, parse a JoinedCampaign object.
+// As with players, if the ID can't be retrieved, only the name of the campaign is returned as an error
+func getJoinedCampaignFromDom(campaignDom *goquery.Selection) (*JoinedCampaign, error) {
+	name := strings.TrimSpace(campaignDom.Find(".campaignname").Text())
+	id, exists := campaignDom.Attr("data-campaignid")
+	if !exists {
+		return nil, fmt.Errorf(name)
+	}
+	idNumber, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, fmt.Errorf(name)
+	}
+	image, _ := campaignDom.Find(".campaignicon img").Attr("src")
+	return &JoinedCampaign{
+		Summary: Summary{Id: idNumber, Name: name, Image: image},
+		Role:    getCampaignRole(campaignDom.Find(".campaignrole").Text()),
+	}, nil
+}
+
 // From a player division, parse a Player object.
 // In cases in which some vital info could not be retrieved (such as roll20ID)
 // Ony the username of the player is returned as an error
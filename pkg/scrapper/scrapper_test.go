@@ -9,8 +9,11 @@ import (
 	"os"
 	"path"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func SetupTestServer(campaignDataPath string, urlMatch string) *httptest.Server {
@@ -32,6 +35,79 @@ func SetupTestServer(campaignDataPath string, urlMatch string) *httptest.Server
 
 }
 
+// Setup a server answering with a different sample for each url match
+func SetupMultiRoutesServer(samplesByUrlMatch map[string]string) *httptest.Server {
+	_, filename, _, _ := runtime.Caller(0)
+	dir := path.Dir(filename)
+	samples := make(map[string][]byte)
+	for urlMatch, campaignDataPath := range samplesByUrlMatch {
+		sample, _ := os.Open(path.Join(dir, campaignDataPath))
+		samples[urlMatch], _ = ioutil.ReadAll(sample)
+	}
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for urlMatch, sampleData := range samples {
+			if strings.Contains(r.URL.Path, urlMatch) {
+				w.Write(sampleData)
+				return
+			}
+		}
+		w.WriteHeader(200)
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	return mockServer
+}
+
+// Setup a server answering with the sample chat archive for every page, and recording the requested pages
+func SetupArchiveServer(fetchedPages *[]int) *httptest.Server {
+	_, filename, _, _ := runtime.Caller(0)
+	sampleData, _ := ioutil.ReadFile(path.Join(path.Dir(filename), "./../../assets/sample_campaign_chat_archive.html"))
+	var mutex sync.Mutex
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "/campaigns/chatarchive/") {
+			w.WriteHeader(200)
+			return
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("p"))
+		mutex.Lock()
+		*fetchedPages = append(*fetchedPages, page)
+		mutex.Unlock()
+		w.Write(sampleData)
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	return mockServer
+}
+
+// Setup a server answering with the sample games listing, one sample per page
+func SetupListingServer(pagesDataPath ...string) *httptest.Server {
+	_, filename, _, _ := runtime.Caller(0)
+	dir := path.Dir(filename)
+	var pages [][]byte
+	for _, pageDataPath := range pagesDataPath {
+		pageData, _ := ioutil.ReadFile(path.Join(dir, pageDataPath))
+		pages = append(pages, pageData)
+	}
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "/campaigns/search/") {
+			w.WriteHeader(200)
+			return
+		}
+		page, err := strconv.Atoi(r.URL.Query().Get("p"))
+		if err != nil || page < 1 || page > len(pages) {
+			w.WriteHeader(404)
+			return
+		}
+		w.Write(pages[page-1])
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	return mockServer
+}
+
 // Setup a server always answering the status code
 func SetupConstantServer(code int) *httptest.Server {
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -161,11 +237,61 @@ func TestMissingAvatar(t *testing.T) {
 
 // Simply joining a game as a player
 func TestJoinGame(t *testing.T) {
+	mockServer := SetupJoinServer(true)
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	result, err := scrapper.JoinGame("5632681", "59lzQg")
+	assert.Nil(t, err)
+	assert.Equal(t, "5632681", result.GameId)
+	assert.False(t, result.AlreadyJoined)
+	mockServer.Close()
+}
+
+// Joining a game the bot account is already listed in
+func TestJoinAlreadyJoinedGame(t *testing.T) {
 	mockServer := SetupTestServer("./../../assets/sample_campaign_page_multiple_gms.html", "/campaigns/details/")
 	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
 	assert.Nil(t, err)
-	err = scrapper.JoinGame("", "")
+	result, err := scrapper.JoinGame("5939283", "59lzQg")
+	assert.Nil(t, err)
+	assert.True(t, result.AlreadyJoined)
+	mockServer.Close()
+}
+
+// Roll20 answered 200, but the bot account isn't listed in the game (expired code...)
+func TestJoinGameExpiredCode(t *testing.T) {
+	mockServer := SetupJoinServer(false)
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	result, err := scrapper.JoinGame("5632681", "expired")
+	assert.Nil(t, result)
+	assert.IsType(t, &JoinRefusedError{}, err)
+	mockServer.Close()
+}
+
+// The details page of a game is out of reach until it has been joined
+func TestJoinGameDetailsForbiddenBeforeJoining(t *testing.T) {
+	mockServer := SetupAlteredJoinServer(func(doc string) string { return doc }, true)
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	result, err := scrapper.JoinGame("5632681", "59lzQg")
 	assert.Nil(t, err)
+	assert.False(t, result.AlreadyJoined)
+	mockServer.Close()
+}
+
+// A details page without the bot own ID means the layout changed, the join code isn't to blame
+func TestJoinGameLayoutChanged(t *testing.T) {
+	mockServer := SetupAlteredJoinServer(func(doc string) string {
+		return strings.Replace(doc, "wishlists", "giftlists", -1)
+	}, false)
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	result, err := scrapper.JoinGame("5632681", "59lzQg")
+	assert.Nil(t, result)
+	assert.Error(t, err)
+	_, refused := err.(*JoinRefusedError)
+	assert.False(t, refused)
 	mockServer.Close()
 }
 
@@ -174,7 +300,7 @@ func TestJoinWrongURL(t *testing.T) {
 	mockServer := SetupTestServer("./../../assets/sample_campaign_page_multiple_gms.html", "/campaigns/details/")
 	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
 	assert.Nil(t, err)
-	err = scrapper.JoinGame("%DSLSDLSMM", "##MMMD%%")
+	_, err = scrapper.JoinGame("%DSLSDLSMM", "##MMMD%%")
 	assert.Error(t, err)
 	mockServer.Close()
 }
@@ -190,7 +316,7 @@ func TestFailingJoiningGame(t *testing.T) {
 	// Swapping the good server for a failing one
 	mockServer = SetupConstantServer(500)
 	scrapper.baseUrl = mockServer.URL
-	err = scrapper.JoinGame("", "")
+	_, err = scrapper.JoinGame("", "")
 	assert.Error(t, err)
 	mockServer.Close()
 }
@@ -221,7 +347,8 @@ func TestGetMessagesOfValidPage(t *testing.T) {
 	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
 	assert.Nil(t, err)
 	var messages []Message
-	err = scrapper.getMessagesOfPage("", 1, &messages)
+	pageCount, err := scrapper.getMessagesOfPage("", 1, &messages)
+	assert.Equal(t, 3, pageCount)
 	assert.Nil(t, err)
 	assert.NotEqual(t, 0, len(messages))
 
@@ -235,7 +362,8 @@ func TestGetMessagesOfInvalidPage(t *testing.T) {
 	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
 	assert.Nil(t, err)
 	var messages []Message
-	err = scrapper.getMessagesOfPage("", 100, &messages)
+	pageCount, err := scrapper.getMessagesOfPage("", 100, &messages)
+	assert.Equal(t, 3, pageCount)
 	assert.Nil(t, err)
 	assert.Equal(t, 0, len(messages))
 	println(messages)
@@ -269,6 +397,152 @@ func TestGetDomEmptyBody(t *testing.T) {
 	mockServer.Close()
 }
 
+// Walk the whole archive, 3 pages of 65 messages without whispers, one page of results after the other
+func TestGetMessagesPage(t *testing.T) {
+	var fetchedPages []int
+	mockServer := SetupArchiveServer(&fetchedPages)
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+
+	var all []Message
+	var results []*MessagesPage
+	var cursor *MessageCursor
+	for {
+		fetchedPages = nil
+		result, err := scrapper.GetMessagesPage("1", cursor, 50, nil)
+		assert.Nil(t, err)
+		assert.LessOrEqual(t, len(result.Messages), 50)
+		// Only the pages from the cursor onward are fetched
+		if cursor != nil {
+			for _, page := range fetchedPages {
+				assert.GreaterOrEqual(t, page, cursor.Page)
+			}
+		}
+		all = append(all, result.Messages...)
+		results = append(results, result)
+		if len(result.Next) == 0 {
+			break
+		}
+		cursor, err = DecodeMessageCursor(result.Next)
+		assert.Nil(t, err)
+		assert.Equal(t, "1", cursor.CampaignId)
+	}
+	assert.Len(t, all, 195)
+	assert.Len(t, results, 4)
+	assert.Empty(t, results[0].Prev)
+
+	// Going back from the second page gives the first one again
+	prev, err := DecodeMessageCursor(results[1].Prev)
+	assert.Nil(t, err)
+	assert.True(t, prev.Before)
+	result, err := scrapper.GetMessagesPage("1", prev, 50, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, results[0].Messages, result.Messages)
+	assert.Empty(t, result.Prev)
+	assert.Equal(t, results[1].Prev, (&MessageCursor{CampaignId: "1", Page: prev.Page, Offset: prev.Offset, Before: true}).Encode())
+	assert.NotEmpty(t, result.Next)
+
+	// Going back from the last page
+	prev, err = DecodeMessageCursor(results[3].Prev)
+	assert.Nil(t, err)
+	result, err = scrapper.GetMessagesPage("1", prev, 50, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, results[2].Messages, result.Messages)
+	assert.NotEmpty(t, result.Prev)
+	mockServer.Close()
+}
+
+// Filtered messages are skipped, but still count in positions
+func TestGetMessagesPageFiltered(t *testing.T) {
+	var fetchedPages []int
+	mockServer := SetupArchiveServer(&fetchedPages)
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	options := &MessageOptions{IncludeRolls: false, IncludeChat: false, IncludeWhispers: true}
+	result, err := scrapper.GetMessagesPage("1", nil, 100, options)
+	assert.Nil(t, err)
+	// 5 whispers per page
+	assert.Len(t, result.Messages, 15)
+	assert.Empty(t, result.Next)
+	assert.Equal(t, []int{1, 2, 3}, fetchedPages)
+	for _, m := range result.Messages {
+		assert.Equal(t, Whisper, m.Type)
+	}
+	mockServer.Close()
+}
+
+// Only the messages after the given position are returned, along with the end of the archive
+func TestGetNewMessages(t *testing.T) {
+	var fetchedPages []int
+	mockServer := SetupArchiveServer(&fetchedPages)
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+
+	// Locating the end of the archive only reads the first and last pages
+	messages, end, err := scrapper.GetNewMessages("1", nil, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, messages)
+	assert.Equal(t, &MessageCursor{CampaignId: "1", Page: 3, Offset: 70}, end)
+	assert.Equal(t, []int{1, 3}, fetchedPages)
+
+	// Nothing new since the end
+	fetchedPages = nil
+	messages, next, err := scrapper.GetNewMessages("1", end, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, messages)
+	assert.Equal(t, end, next)
+	assert.Equal(t, []int{3}, fetchedPages)
+
+	// From the middle of the second page, the same messages a page from there would hold
+	fetchedPages = nil
+	since := &MessageCursor{CampaignId: "1", Page: 2, Offset: 60}
+	messages, next, err = scrapper.GetNewMessages("1", since, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, end, next)
+	assert.Equal(t, []int{2, 3}, fetchedPages)
+	page, err := scrapper.GetMessagesPage("1", since, 1000, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, page.Messages, messages)
+	mockServer.Close()
+}
+
+// Resuming from the position following a message gives the messages after it
+func TestForEachNewMessage(t *testing.T) {
+	var fetchedPages []int
+	mockServer := SetupArchiveServer(&fetchedPages)
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+
+	since := &MessageCursor{CampaignId: "1", Page: 2, Offset: 60}
+	var messages []Message
+	var positions []MessageCursor
+	end, err := scrapper.ForEachNewMessage("1", since, nil, func(m Message, next MessageCursor) {
+		messages = append(messages, m)
+		positions = append(positions, next)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, &MessageCursor{CampaignId: "1", Page: 3, Offset: 70}, end)
+	assert.NotEmpty(t, messages)
+	for i, position := range positions {
+		rest, _, err := scrapper.GetNewMessages("1", &position, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, messages[i+1:], rest)
+	}
+	mockServer.Close()
+}
+
+// Broken archive
+func TestGetMessagesPageError(t *testing.T) {
+	mockServer := SetupTestServer("./../../assets/sample_campaign_page.html", "/campaigns/chatarchive/")
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	_, err = scrapper.GetMessagesPage("1", nil, 10, nil)
+	assert.Error(t, err)
+	_, err = scrapper.GetMessagesPage("1", &MessageCursor{CampaignId: "1", Page: 2, Before: true}, 10, nil)
+	assert.Error(t, err)
+	mockServer.Close()
+}
+
 // Get messages with a set limit
 func TestGetMessagesWithLimit(t *testing.T) {
 	mockServer := SetupTestServer("./../../assets/sample_campaign_chat_archive.html", "/campaigns/chatarchive/")
@@ -309,6 +583,22 @@ func TestGetMessagesIgnoreWhispers(t *testing.T) {
 	mockServer.Close()
 }
 
+// Progress is reported after each page of the archive
+func TestGetMessagesProgress(t *testing.T) {
+	mockServer := SetupTestServer("./../../assets/sample_campaign_chat_archive.html", "/campaigns/chatarchive/")
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	options := NewMessageOptions()
+	var progress [][2]int
+	options.OnPage = func(pagesDone int, pageCount int) {
+		progress = append(progress, [2]int{pagesDone, pageCount})
+	}
+	_, err = scrapper.GetMessages("", ^uint(0), options)
+	assert.Nil(t, err)
+	assert.Equal(t, [][2]int{{1, 3}, {2, 3}, {3, 3}, {3, 3}}, progress)
+	mockServer.Close()
+}
+
 // Filtering to have only rolls
 func TestGetMessagesOnlyRolls(t *testing.T) {
 	mockServer := SetupTestServer("./../../assets/sample_campaign_chat_archive.html", "/campaigns/chatarchive/")
@@ -367,3 +657,577 @@ func TestGetSummaryNoImage(t *testing.T) {
 
 	mockServer.Close()
 }
+
+func TestCheckCampaignDetails(t *testing.T) {
+	mockServer := SetupTestServer("./../../assets/sample_campaign_page.html", "/campaigns/details/")
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	missing, err := scrapper.CheckCampaignDetails("1")
+	assert.Nil(t, err)
+	assert.Empty(t, missing)
+	mockServer.Close()
+
+	// A layout change, as seen on a page without any of the expected blocks
+	mockServer = SetupTestServer("./../../assets/sample_user_profile.html", "/campaigns/details/")
+	scrapper, err = NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	missing, err = scrapper.CheckCampaignDetails("1")
+	assert.Nil(t, err)
+	assert.Contains(t, missing, ".campaign_details")
+	assert.Contains(t, missing, ".playerlisting")
+	mockServer.Close()
+
+	mockServer = SetupConstantServer(http.StatusNotFound)
+	defer mockServer.Close()
+	scrapper = &Scrapper{baseUrl: mockServer.URL, routes: getRoutes(), client: http.DefaultClient}
+	_, err = scrapper.CheckCampaignDetails("1")
+	assert.Error(t, err)
+}
+
+func TestGetCharacters(t *testing.T) {
+	mockServer := SetupTestServer("./../../assets/sample_campaign_chat_archive.html", "/campaigns/chatarchive/")
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	players, err := scrapper.GetCharacters("", true)
+	assert.Nil(t, err)
+	// 3 players and a GM, whispers included
+	assert.Len(t, *players, 4)
+	gm := (*players)[0]
+	assert.Equal(t, "-Mgamemaster", gm.PlayerId)
+	assert.Len(t, gm.Characters, 3)
+	// The sample is served as 3 virtual pages
+	assert.Equal(t, "GM", gm.Characters[0].Name)
+	assert.Equal(t, uint(3*7), gm.Characters[0].MessageCount)
+	assert.Equal(t, float64(1609459380000), gm.Characters[0].FirstSeen)
+	assert.Equal(t, float64(1609462980000), gm.Characters[0].LastSeen)
+
+	// The GM whispers as GM 2 times a page
+	players, err = scrapper.GetCharacters("", false)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(3*5), (*players)[0].Characters[0].MessageCount)
+	mockServer.Close()
+}
+
+func TestGetCharactersScrappingError(t *testing.T) {
+	mockServer := SetupTestServer("./../../assets/sample_campaign_page.html", "/campaigns/details/")
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	players, err := scrapper.GetCharacters("", true)
+	assert.Error(t, err)
+	assert.Nil(t, players)
+	mockServer.Close()
+}
+
+// Summary, players and GMs from a single page
+func TestGetCampaign(t *testing.T) {
+	mockServer := SetupTestServer("./../../assets/sample_campaign_page_multiple_gms.html", "/campaigns/details/")
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	campaign, err := scrapper.GetCampaign("", 0, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 5939283, campaign.Summary.Id)
+	assert.Equal(t, uint8(2), countGMs(campaign.Players))
+	assert.Equal(t, uint8(8), countPlayers(campaign.Players))
+	assert.Len(t, campaign.Gms, 2)
+	for _, gm := range campaign.Gms {
+		assert.True(t, gm.IsGm)
+	}
+	// Messages weren't requested
+	assert.Nil(t, campaign.Messages)
+	mockServer.Close()
+}
+
+// The latest messages can be bundled with the campaign
+func TestGetCampaignWithMessages(t *testing.T) {
+	mockServer := SetupMultiRoutesServer(map[string]string{
+		"/campaigns/details/":     "./../../assets/sample_campaign_page.html",
+		"/campaigns/chatarchive/": "./../../assets/sample_campaign_chat_archive.html",
+	})
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	campaign, err := scrapper.GetCampaign("", 10, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "Les Contes du Continent", campaign.Summary.Name)
+	assert.Len(t, campaign.Players, 7)
+	assert.Len(t, campaign.Gms, 1)
+	assert.Len(t, campaign.Messages, 10)
+	// The latest messages are the last ones of the last page
+	all, err := scrapper.GetMessages("", ^uint(0), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, (*all)[len(*all)-10:], campaign.Messages)
+	assert.NotEqual(t, (*all)[:10], campaign.Messages)
+	mockServer.Close()
+}
+
+// Only the last pages are read for the latest messages
+func TestGetLatestMessages(t *testing.T) {
+	var fetchedPages []int
+	mockServer := SetupArchiveServer(&fetchedPages)
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	all, err := scrapper.GetMessages("1", ^uint(0), nil)
+	assert.Nil(t, err)
+
+	fetchedPages = nil
+	messages, err := scrapper.getLatestMessages("1", 10, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, (*all)[len(*all)-10:], messages)
+	assert.Equal(t, []int{1, 3}, fetchedPages)
+
+	// 70 messages per page, the previous page is needed too
+	fetchedPages = nil
+	messages, err = scrapper.getLatestMessages("1", 100, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, (*all)[len(*all)-100:], messages)
+	assert.Equal(t, []int{1, 3, 2}, fetchedPages)
+	mockServer.Close()
+}
+
+// Ignored players shouldn't prevent the campaign from being returned
+func TestGetCampaignMissingPlayers(t *testing.T) {
+	mockServer := SetupTestServer("./../../assets/sample_campaign_missing_id.html", "/campaigns/details/")
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	campaign, err := scrapper.GetCampaign("", 0, nil)
+	assert.IsType(t, &IncompleteError{}, err)
+	assert.NotNil(t, campaign)
+	assert.Len(t, campaign.Players, 6)
+	mockServer.Close()
+}
+
+// Without any GM, the game most likely hasn't been joined
+func TestGetCampaignMissingGM(t *testing.T) {
+	mockServer := SetupTestServer("./../../assets/sample_campaign_missing_gm.html", "/campaigns/details/")
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	campaign, err := scrapper.GetCampaign("", 0, nil)
+	assert.Error(t, err)
+	assert.Nil(t, campaign)
+	mockServer.Close()
+}
+
+// Messages were requested, but the chat archive can't be parsed
+func TestGetCampaignMessagesError(t *testing.T) {
+	mockServer := SetupTestServer("./../../assets/sample_campaign_page.html", "/campaigns/details/")
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	campaign, err := scrapper.GetCampaign("", 10, nil)
+	assert.Error(t, err)
+	assert.Nil(t, campaign)
+	mockServer.Close()
+}
+
+// Every optional field of the summary is displayed
+func TestGetSummaryFullPage(t *testing.T) {
+	mockServer := SetupTestServer("./../../assets/sample_campaign_page_full_summary.html", "/campaigns/details/")
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	summary, err := scrapper.GetSummary("")
+	assert.Nil(t, err)
+	assert.Equal(t, 5632681, summary.Id)
+	assert.Equal(t, "Campagne dans le monde de The Witcher\nLes sessions ont lieu un mardi sur deux.", *summary.Description)
+	assert.Equal(t, "The Witcher TRPG", *summary.System)
+	assert.Equal(t, 7, *summary.PlayerCount)
+	assert.Equal(t, time.Date(2020, time.January, 6, 0, 0, 0, 0, time.UTC), *summary.CreatedAt)
+	assert.Equal(t, time.Date(2022, time.June, 12, 0, 0, 0, 0, time.UTC), *summary.LastPlayed)
+	assert.Equal(t, time.Date(2022, time.June, 28, 19, 0, 0, 0, time.UTC), *summary.NextSession)
+	mockServer.Close()
+}
+
+// Most optional fields are missing or unreadable
+func TestGetSummaryPartialPage(t *testing.T) {
+	mockServer := SetupTestServer("./../../assets/sample_campaign_page_partial_summary.html", "/campaigns/details/")
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	summary, err := scrapper.GetSummary("")
+	assert.Nil(t, err)
+	assert.Equal(t, "Les Contes du Continent", summary.Name)
+	assert.Equal(t, "The Witcher TRPG", *summary.System)
+	assert.Nil(t, summary.Description)
+	assert.Nil(t, summary.PlayerCount)
+	assert.Nil(t, summary.CreatedAt)
+	assert.Nil(t, summary.LastPlayed)
+	assert.Nil(t, summary.NextSession)
+	mockServer.Close()
+}
+
+// The original sample only has a description and a player count
+func TestGetSummaryOptionalFields(t *testing.T) {
+	mockServer := SetupTestServer("./../../assets/sample_campaign_page.html", "/campaigns/details/")
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	summary, err := scrapper.GetSummary("")
+	assert.Nil(t, err)
+	assert.Equal(t, "Campagne dans le monde de The Witcher", *summary.Description)
+	assert.Equal(t, 7, *summary.PlayerCount)
+	assert.Nil(t, summary.System)
+	assert.Nil(t, summary.CreatedAt)
+	assert.Nil(t, summary.LastPlayed)
+	assert.Nil(t, summary.NextSession)
+	mockServer.Close()
+}
+
+// Every public info is displayed
+func TestGetPlayerProfile(t *testing.T) {
+	mockServer := SetupTestServer("./../../assets/sample_user_profile.html", "/users/")
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	profile, err := scrapper.GetPlayerProfile(2)
+	assert.Nil(t, err)
+	assert.Equal(t, "Playing since the 3.5 days.\nMostly online, on tuesdays.", *profile.About)
+	assert.Equal(t, "Pro", *profile.Subscription)
+	assert.Equal(t, "Europe/Paris", *profile.TimeZone)
+	assert.Equal(t, time.Date(2016, time.February, 18, 0, 0, 0, 0, time.UTC), *profile.MemberSince)
+	mockServer.Close()
+}
+
+// Nothing but the subscription is filled in
+func TestGetPlayerProfileMinimal(t *testing.T) {
+	mockServer := SetupTestServer("./../../assets/sample_user_profile_minimal.html", "/users/")
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	profile, err := scrapper.GetPlayerProfile(3)
+	assert.Nil(t, err)
+	assert.Equal(t, "Free", *profile.Subscription)
+	assert.Nil(t, profile.About)
+	assert.Nil(t, profile.TimeZone)
+	assert.Nil(t, profile.MemberSince)
+	mockServer.Close()
+}
+
+func TestGetPlayerProfileError(t *testing.T) {
+	mockServer := SetupTestServer("./../../assets/sample_user_profile.html", "/users/")
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	mockServer.Close()
+	profile, err := scrapper.GetPlayerProfile(2)
+	assert.Error(t, err)
+	assert.Nil(t, profile)
+}
+
+// Campaigns are handled a few at a time, all of them being handled
+func TestForEachCampaign(t *testing.T) {
+	mockServer := SetupConstantServer(200)
+	scrapper, err := NewScrapper(mockServer.URL, &Roll20Account{Login: "_", Password: "_"}, &Options{CampaignConcurrency: 2})
+	assert.Nil(t, err)
+	var mutex sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	var handled []string
+	scrapper.ForEachCampaign([]string{"1", "2", "3", "4", "5"}, func(campaignId string) {
+		mutex.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mutex.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mutex.Lock()
+		inFlight--
+		handled = append(handled, campaignId)
+		mutex.Unlock()
+	})
+	assert.ElementsMatch(t, []string{"1", "2", "3", "4", "5"}, handled)
+	assert.Equal(t, 2, maxInFlight)
+	mockServer.Close()
+}
+
+// Profiles are fetched concurrently, but never more than the configured cap at once
+func TestEnrichPlayers(t *testing.T) {
+	_, filename, _, _ := runtime.Caller(0)
+	sample, _ := os.Open(path.Join(path.Dir(filename), "./../../assets/sample_user_profile.html"))
+	sampleData, _ := ioutil.ReadAll(sample)
+	var mutex sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "/users/") {
+			w.WriteHeader(200)
+			return
+		}
+		mutex.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mutex.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mutex.Lock()
+		inFlight--
+		mutex.Unlock()
+		// This player profile is broken
+		if strings.HasSuffix(r.URL.Path, "/4") {
+			w.WriteHeader(500)
+			return
+		}
+		w.Write(sampleData)
+	}))
+	scrapper, err := NewScrapper(mockServer.URL, &Roll20Account{Login: "_", Password: "_"}, &Options{IgnoreSelf: true, ProfileConcurrency: 2})
+	assert.Nil(t, err)
+	players := []Player{
+		{Roll20Id: 1, Username: "Player 1"},
+		{Roll20Id: 2, Username: "Player 2"},
+		{Roll20Id: 3, Username: "Player 3"},
+		{Roll20Id: 4, Username: "Player 4"},
+		{Roll20Id: 5, Username: "Player 5"},
+	}
+	err = scrapper.EnrichPlayers(players)
+	assert.IsType(t, &IncompleteError{}, err)
+	assert.Contains(t, err.Error(), "Player 4")
+	for _, p := range players {
+		if p.Roll20Id == 4 {
+			assert.Nil(t, p.Profile)
+		} else {
+			assert.Equal(t, "Pro", *p.Profile.Subscription)
+		}
+	}
+	assert.LessOrEqual(t, maxInFlight, 2)
+	assert.Greater(t, maxInFlight, 1)
+	mockServer.Close()
+}
+
+// Enriching the players of several campaigns at once doesn't multiply the cap on concurrent profile fetches
+func TestEnrichPlayersOfSeveralCampaigns(t *testing.T) {
+	_, filename, _, _ := runtime.Caller(0)
+	sample, _ := os.Open(path.Join(path.Dir(filename), "./../../assets/sample_user_profile.html"))
+	sampleData, _ := ioutil.ReadAll(sample)
+	var mutex sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "/users/") {
+			w.WriteHeader(200)
+			return
+		}
+		mutex.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mutex.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mutex.Lock()
+		inFlight--
+		mutex.Unlock()
+		w.Write(sampleData)
+	}))
+	scrapper, err := NewScrapper(mockServer.URL, &Roll20Account{Login: "_", Password: "_"}, &Options{IgnoreSelf: true, ProfileConcurrency: 2, CampaignConcurrency: 3})
+	assert.Nil(t, err)
+	scrapper.ForEachCampaign([]string{"1", "2", "3"}, func(campaignId string) {
+		players := []Player{{Roll20Id: 1}, {Roll20Id: 2}, {Roll20Id: 3}}
+		assert.Nil(t, scrapper.EnrichPlayers(players))
+		for _, p := range players {
+			assert.NotNil(t, p.Profile)
+		}
+	})
+	assert.Equal(t, 2, maxInFlight)
+	mockServer.Close()
+}
+
+// All pages of the listing are parsed
+func TestListCampaigns(t *testing.T) {
+	mockServer := SetupListingServer("./../../assets/sample_campaign_listing_page_1.html", "./../../assets/sample_campaign_listing_page_2.html")
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	campaigns, err := scrapper.ListCampaigns()
+	assert.Nil(t, err)
+	assert.Len(t, *campaigns, 5)
+	first := (*campaigns)[0]
+	assert.Equal(t, 5632681, first.Summary.Id)
+	assert.Equal(t, "Les Contes du Continent", first.Summary.Name)
+	assert.Equal(t, "https://s3.amazonaws.com/files.d20.io/images/100983671/2sdfzQUlO7QmO2GVPgFNVA/max.jpg?1578310034275", first.Summary.Image)
+	assert.Equal(t, PlayerRole, first.Role)
+	assert.Equal(t, GmRole, (*campaigns)[1].Role)
+	assert.Equal(t, "", (*campaigns)[2].Summary.Image)
+	assert.Equal(t, CreatorRole, (*campaigns)[3].Role)
+	assert.Equal(t, 6234567, (*campaigns)[4].Summary.Id)
+	mockServer.Close()
+}
+
+// Campaigns without an ID are ignored
+func TestListCampaignsMissingId(t *testing.T) {
+	mockServer := SetupListingServer("./../../assets/sample_campaign_listing_missing_id.html")
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	campaigns, err := scrapper.ListCampaigns()
+	assert.IsType(t, &IncompleteError{}, err)
+	assert.Contains(t, err.Error(), "Ignored Campaign")
+	assert.Len(t, *campaigns, 1)
+	mockServer.Close()
+}
+
+// A missing page halts the listing
+func TestListCampaignsMissingPage(t *testing.T) {
+	mockServer := SetupListingServer("./../../assets/sample_campaign_listing_page_1.html")
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	campaigns, err := scrapper.ListCampaigns()
+	assert.Error(t, err)
+	assert.Nil(t, campaigns)
+	mockServer.Close()
+}
+
+// Not a listing at all
+func TestListCampaignsNoPagination(t *testing.T) {
+	mockServer := SetupListingServer("./../../assets/sample_campaign_page.html")
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	campaigns, err := scrapper.ListCampaigns()
+	assert.Error(t, err)
+	assert.Nil(t, campaigns)
+	mockServer.Close()
+}
+
+// Mock server listing the bot account in the campaign players only once the join link has been followed.
+// If honourJoin is false, the join link is answered with a 200 but nothing happens
+func SetupJoinServer(honourJoin bool) *httptest.Server {
+	_, filename, _, _ := runtime.Caller(0)
+	dir := path.Dir(filename)
+	after, _ := ioutil.ReadFile(path.Join(dir, "./../../assets/sample_campaign_page.html"))
+	// The bot account (ID 2) isn't part of the players yet
+	before := []byte(strings.Replace(string(after), `href="/users/2"`, `href="/users/20"`, 1))
+	var mutex sync.Mutex
+	joined := false
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch {
+		case strings.Contains(r.URL.Path, "/join/"):
+			joined = honourJoin
+			w.WriteHeader(200)
+		case strings.Contains(r.URL.Path, "/campaigns/details/") && joined:
+			w.Write(after)
+		case strings.Contains(r.URL.Path, "/campaigns/details/"):
+			w.Write(before)
+		default:
+			w.WriteHeader(200)
+		}
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	return mockServer
+}
+
+// Mock server serving an altered details page of campaign 5632681. If forbiddenBeforeJoin is true, the details page
+// is answered with a 403 until the join link has been followed
+func SetupAlteredJoinServer(alterDetails func(string) string, forbiddenBeforeJoin bool) *httptest.Server {
+	_, filename, _, _ := runtime.Caller(0)
+	dir := path.Dir(filename)
+	details, _ := ioutil.ReadFile(path.Join(dir, "./../../assets/sample_campaign_page.html"))
+	after := []byte(alterDetails(string(details)))
+	var mutex sync.Mutex
+	joined := false
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch {
+		case strings.Contains(r.URL.Path, "/join/"):
+			joined = true
+			w.WriteHeader(200)
+		case strings.Contains(r.URL.Path, "/campaigns/details/") && (joined || !forbiddenBeforeJoin):
+			w.Write(after)
+		case strings.Contains(r.URL.Path, "/campaigns/details/"):
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(200)
+		}
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	return mockServer
+}
+
+// Setup a server on which the bot account has joined campaign 5632681, and can leave it.
+// The details page can be altered, and the leave request can fail or be silently ignored
+func SetupLeaveServer(alterDetails func(string) string, leaveStatus int, honourLeave bool) *httptest.Server {
+	_, filename, _, _ := runtime.Caller(0)
+	dir := path.Dir(filename)
+	details, _ := ioutil.ReadFile(path.Join(dir, "./../../assets/sample_campaign_page.html"))
+	detailsData := []byte(alterDetails(string(details)))
+	before1, _ := ioutil.ReadFile(path.Join(dir, "./../../assets/sample_campaign_listing_page_1.html"))
+	before2, _ := ioutil.ReadFile(path.Join(dir, "./../../assets/sample_campaign_listing_page_2.html"))
+	after, _ := ioutil.ReadFile(path.Join(dir, "./../../assets/sample_campaign_listing_after_leave.html"))
+	var mutex sync.Mutex
+	left := false
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch {
+		case strings.Contains(r.URL.Path, "/campaigns/details/"):
+			w.Write(detailsData)
+		case strings.Contains(r.URL.Path, "/campaigns/leave/5632681") && r.Method == "POST":
+			left = honourLeave
+			w.WriteHeader(leaveStatus)
+		case strings.Contains(r.URL.Path, "/campaigns/search/") && left:
+			w.Write(after)
+		case strings.Contains(r.URL.Path, "/campaigns/search/") && r.URL.Query().Get("p") == "2":
+			w.Write(before2)
+		case strings.Contains(r.URL.Path, "/campaigns/search/"):
+			w.Write(before1)
+		default:
+			w.WriteHeader(200)
+		}
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	return mockServer
+}
+
+func unaltered(details string) string {
+	return details
+}
+
+// The game is left, and is gone from the listing
+func TestLeaveGame(t *testing.T) {
+	mockServer := SetupLeaveServer(unaltered, 200, true)
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	err = scrapper.LeaveGame("5632681")
+	assert.Nil(t, err)
+	mockServer.Close()
+}
+
+// Roll20 answered 200, but the game is still listed
+func TestLeaveGameStillListed(t *testing.T) {
+	mockServer := SetupLeaveServer(unaltered, 200, false)
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	err = scrapper.LeaveGame("5632681")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "still listed")
+	mockServer.Close()
+}
+
+func TestLeaveGameRefused(t *testing.T) {
+	mockServer := SetupLeaveServer(unaltered, 500, false)
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	err = scrapper.LeaveGame("5632681")
+	assert.Error(t, err)
+	mockServer.Close()
+}
+
+// The creator of a game has no way to leave it
+func TestLeaveGameAsCreator(t *testing.T) {
+	mockServer := SetupLeaveServer(func(details string) string {
+		return strings.ReplaceAll(details, "leavecampaign", "")
+	}, 200, true)
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	err = scrapper.LeaveGame("5632681")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "creator")
+	mockServer.Close()
+}
+
+// Without a players listing, the game hasn't been joined
+func TestLeaveNotJoinedGame(t *testing.T) {
+	mockServer := SetupLeaveServer(func(details string) string {
+		return strings.ReplaceAll(details, "playerlisting", "")
+	}, 200, true)
+	scrapper, err := NewScrapper(os.Getenv("ROLL20_BASE_URL"), &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	err = scrapper.LeaveGame("5632681")
+	assert.IsType(t, &NotJoinedError{}, err)
+	mockServer.Close()
+}
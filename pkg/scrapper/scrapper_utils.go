@@ -1,10 +1,16 @@
 package scrapper
 
 import (
+	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// Layout of the dates displayed on Roll20 pages, such as "Member since: 02/18/16"
+const roll20DateLayout = "01/02/06"
+
 // Resolves an image url. If the image is stored on the roll20 CDN
 // adds the roll20 prefix, if its stored elsewhere (AWS), return the URL as is
 func getAbsoluteUrlToImage(url string) string {
@@ -23,3 +29,119 @@ func getPlayerIdFromURL(url string) (int, error) {
 	}
 	return playerRoll20Id, nil
 }
+
+// Given a "Label: value" text, returns the trimmed value.
+// If there isn't any label, the whole trimmed text is returned
+func getLabelledValue(text string) string {
+	if index := strings.Index(text, ":"); index != -1 {
+		text = text[index+1:]
+	}
+	return strings.TrimSpace(text)
+}
+
+// Parse a date as displayed on Roll20 pages. Dates are assumed to be UTC
+func parseRoll20Date(date string) (*time.Time, error) {
+	parsed, err := time.Parse(roll20DateLayout, strings.TrimSpace(date))
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+// Parse a unix timestamp, in seconds
+func parseUnixTimestamp(timestamp string) (*time.Time, error) {
+	seconds, err := strconv.ParseInt(strings.TrimSpace(timestamp), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	parsed := time.Unix(seconds, 0).UTC()
+	return &parsed, nil
+}
+
+// Extract the number a text begins with, such as "7 Players"
+func getLeadingNumber(text string) (int, error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return -1, fmt.Errorf("no number found in empty text")
+	}
+	return strconv.Atoi(fields[0])
+}
+
+// Resolves the role of a user from its displayed label, such as "Game Master".
+// Anything unknown is regarded as a simple player
+func getCampaignRole(label string) CampaignRole {
+	label = strings.ToLower(strings.TrimSpace(label))
+	switch {
+	case strings.Contains(label, "creator"):
+		return CreatorRole
+	case strings.Contains(label, "gm") || strings.Contains(label, "game master"):
+		return GmRole
+	default:
+		return PlayerRole
+	}
+}
+
+// Aggregates the distinct senders of a list of messages, grouped by player ID.
+// Players are sorted by ID, and their characters by first appearance
+func getCharactersFromMessages(messages []Message) []PlayerCharacters {
+	// playerId -> character name -> character
+	byPlayer := make(map[string]map[string]*Character)
+	for _, m := range messages {
+		name := strings.TrimSpace(m.Who)
+		// Some system messages (API scripts...) aren't sent by anyone
+		if len(m.PlayerId) == 0 || len(name) == 0 {
+			continue
+		}
+		characters, exists := byPlayer[m.PlayerId]
+		if !exists {
+			characters = make(map[string]*Character)
+			byPlayer[m.PlayerId] = characters
+		}
+		c, exists := characters[name]
+		if !exists {
+			c = &Character{Name: name, FirstSeen: m.Priority, LastSeen: m.Priority}
+			characters[name] = c
+		}
+		c.MessageCount++
+		if m.Priority < c.FirstSeen {
+			c.FirstSeen = m.Priority
+		}
+		if m.Priority > c.LastSeen {
+			c.LastSeen = m.Priority
+		}
+	}
+
+	players := make([]PlayerCharacters, 0, len(byPlayer))
+	for playerId, characters := range byPlayer {
+		pc := PlayerCharacters{PlayerId: playerId, Characters: make([]Character, 0, len(characters))}
+		for _, c := range characters {
+			pc.Characters = append(pc.Characters, *c)
+		}
+		sort.Slice(pc.Characters, func(i, j int) bool {
+			if pc.Characters[i].FirstSeen == pc.Characters[j].FirstSeen {
+				return pc.Characters[i].Name < pc.Characters[j].Name
+			}
+			return pc.Characters[i].FirstSeen < pc.Characters[j].FirstSeen
+		})
+		players = append(players, pc)
+	}
+	sort.Slice(players, func(i, j int) bool {
+		return players[i].PlayerId < players[j].PlayerId
+	})
+	return players
+}
+
+// LastSentAt When the newest of messages was sent, or the zero time if there are none.
+// Timestamps being in milliseconds, so is the result
+func LastSentAt(messages []Message) time.Time {
+	var newest float64
+	for _, m := range messages {
+		if m.Priority > newest {
+			newest = m.Priority
+		}
+	}
+	if newest == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(int64(newest)).UTC()
+}
@@ -3,6 +3,7 @@ package scrapper
 import (
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestRoll20Image(t *testing.T) {
@@ -38,3 +39,79 @@ func TestGetPlayerIDWrongInput(t *testing.T) {
 	assert.Error(t, err)
 	assert.Equal(t, -1, id)
 }
+
+func TestGetCharactersFromMessages(t *testing.T) {
+	messages := []Message{
+		{PlayerId: "-p2", Who: "Brynn", Priority: 30},
+		{PlayerId: "-p1", Who: "Aldric", Priority: 20},
+		{PlayerId: "-p1", Who: "Narrator", Priority: 10},
+		{PlayerId: "-p1", Who: "Aldric ", Priority: 40},
+		{PlayerId: "-p1", Who: "Aldric", Priority: 5},
+		// No sender, should be ignored
+		{PlayerId: "", Who: "API", Priority: 50},
+		{PlayerId: "-p2", Who: "", Priority: 60},
+	}
+	players := getCharactersFromMessages(messages)
+	assert.Len(t, players, 2)
+	assert.Equal(t, "-p1", players[0].PlayerId)
+	assert.Equal(t, []Character{
+		{Name: "Aldric", MessageCount: 3, FirstSeen: 5, LastSeen: 40},
+		{Name: "Narrator", MessageCount: 1, FirstSeen: 10, LastSeen: 10},
+	}, players[0].Characters)
+	assert.Equal(t, "-p2", players[1].PlayerId)
+	assert.Equal(t, []Character{{Name: "Brynn", MessageCount: 1, FirstSeen: 30, LastSeen: 30}}, players[1].Characters)
+}
+
+func TestGetCharactersFromNoMessages(t *testing.T) {
+	players := getCharactersFromMessages([]Message{})
+	assert.NotNil(t, players)
+	assert.Len(t, players, 0)
+}
+
+func TestGetLabelledValue(t *testing.T) {
+	assert.Equal(t, "The Witcher TRPG", getLabelledValue(" Character Sheet:  The Witcher TRPG \n"))
+	assert.Equal(t, "No label", getLabelledValue(" No label "))
+	assert.Equal(t, "", getLabelledValue("Empty:"))
+}
+
+func TestParseRoll20Date(t *testing.T) {
+	date, err := parseRoll20Date(" 02/18/16 ")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2016, time.February, 18, 0, 0, 0, 0, time.UTC), *date)
+	date, err = parseRoll20Date("a while ago")
+	assert.Error(t, err)
+	assert.Nil(t, date)
+}
+
+func TestParseUnixTimestamp(t *testing.T) {
+	date, err := parseUnixTimestamp("1656442800")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2022, time.June, 28, 19, 0, 0, 0, time.UTC), *date)
+	date, err = parseUnixTimestamp("tomorrow")
+	assert.Error(t, err)
+	assert.Nil(t, date)
+}
+
+func TestGetLeadingNumber(t *testing.T) {
+	count, err := getLeadingNumber(" 7 Players")
+	assert.Nil(t, err)
+	assert.Equal(t, 7, count)
+	_, err = getLeadingNumber("Players")
+	assert.Error(t, err)
+	_, err = getLeadingNumber("  ")
+	assert.Error(t, err)
+}
+
+func TestGetCampaignRole(t *testing.T) {
+	assert.Equal(t, CreatorRole, getCampaignRole(" Creator "))
+	assert.Equal(t, GmRole, getCampaignRole("Game Master"))
+	assert.Equal(t, GmRole, getCampaignRole("GM"))
+	assert.Equal(t, PlayerRole, getCampaignRole("Player"))
+	assert.Equal(t, PlayerRole, getCampaignRole(""))
+}
+
+func TestLastSentAt(t *testing.T) {
+	messages := []Message{{Priority: 1609459260000}, {Priority: 1609459200000}}
+	assert.Equal(t, time.Date(2021, time.January, 1, 0, 1, 0, 0, time.UTC), LastSentAt(messages))
+	assert.True(t, LastSentAt(nil).IsZero())
+}
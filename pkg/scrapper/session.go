@@ -0,0 +1,33 @@
+package scrapper
+
+import "sync"
+
+// Session Roll20 session only logged in when first needed, so that results served from a cache don't cost a login.
+// The scrapper is shared by all its users, and a failed login is reported to all of them without being retried,
+// not to get the bot account locked out
+type Session struct {
+	baseUrl  string
+	account  *Roll20Account
+	options  *Options
+	once     sync.Once
+	scrapper *Scrapper
+	err      error
+}
+
+// NewSession Session logging in to Roll20 with account on its first use, see NewScrapper
+func NewSession(baseUrl string, account *Roll20Account, options *Options) *Session {
+	return &Session{baseUrl: baseUrl, account: account, options: options}
+}
+
+// Scrapper The logged in scrapper of the session, logging in on the first call
+func (session *Session) Scrapper() (*Scrapper, error) {
+	session.once.Do(func() {
+		session.scrapper, session.err = NewScrapper(session.baseUrl, session.account, session.options)
+	})
+	return session.scrapper, session.err
+}
+
+// ForEachCampaign Same as Scrapper.ForEachCampaign, without logging in beforehand
+func (session *Session) ForEachCampaign(campaignIds []string, fn func(campaignId string)) {
+	forEachCampaign(session.options, campaignIds, fn)
+}
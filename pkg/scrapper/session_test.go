@@ -0,0 +1,56 @@
+package scrapper
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Logging in only happens on the first use, once for all users
+func TestSession(t *testing.T) {
+	var logins int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			atomic.AddInt32(&logins, 1)
+		}
+		w.WriteHeader(200)
+	}))
+	defer mockServer.Close()
+	session := NewSession(mockServer.URL, &Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&logins))
+
+	var mutex sync.Mutex
+	var scrappers []*Scrapper
+	session.ForEachCampaign([]string{"1", "2", "3", "4", "5"}, func(campaignId string) {
+		s, err := session.Scrapper()
+		assert.Nil(t, err)
+		mutex.Lock()
+		scrappers = append(scrappers, s)
+		mutex.Unlock()
+	})
+	assert.Equal(t, int32(1), atomic.LoadInt32(&logins))
+	assert.Len(t, scrappers, 5)
+	for _, s := range scrappers {
+		assert.Same(t, scrappers[0], s)
+	}
+}
+
+// A failed login isn't retried
+func TestSessionLoginFailed(t *testing.T) {
+	var logins int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&logins, 1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer mockServer.Close()
+	session := NewSession(mockServer.URL, &Roll20Account{Login: "_", Password: "_"}, nil)
+	for i := 0; i < 2; i++ {
+		s, err := session.Scrapper()
+		assert.Nil(t, s)
+		assert.Error(t, err)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&logins))
+}
@@ -0,0 +1,54 @@
+package sse
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Media type of an event stream
+const ContentType = "text/event-stream"
+
+// Header EventSource clients reconnect with, carrying the ID of the last event they got
+const LastEventIdHeader = "Last-Event-ID"
+
+// Event A Server-Sent Event
+type Event struct {
+	// ID the client resumes from. An event with only an ID moves the position without dispatching anything
+	Id string
+	// Type of the event, "message" if empty
+	Type string
+	Data []byte
+}
+
+// Write Send event to w. Multi-line data is split over several data fields
+func Write(w io.Writer, event Event) error {
+	var buf bytes.Buffer
+	if len(event.Id) != 0 {
+		fmt.Fprintf(&buf, "id: %s\n", event.Id)
+	}
+	if len(event.Type) != 0 {
+		fmt.Fprintf(&buf, "event: %s\n", event.Type)
+	}
+	if event.Data != nil {
+		for _, line := range bytes.Split(event.Data, []byte("\n")) {
+			fmt.Fprintf(&buf, "data: %s\n", line)
+		}
+	}
+	buf.WriteByte('\n')
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteRetry Tell the client how long to wait before reconnecting, once the stream is closed
+func WriteRetry(w io.Writer, retry time.Duration) error {
+	_, err := fmt.Fprintf(w, "retry: %d\n\n", retry.Milliseconds())
+	return err
+}
+
+// WriteComment Send a comment, ignored by clients. Keeps idle connections from being closed by proxies
+func WriteComment(w io.Writer, comment string) error {
+	_, err := fmt.Fprintf(w, ": %s\n\n", comment)
+	return err
+}
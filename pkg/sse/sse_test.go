@@ -0,0 +1,23 @@
+package sse
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Nil(t, Write(&buf, Event{Id: "1", Type: "message", Data: []byte(`{"content":"Hello"}`)}))
+	assert.Nil(t, Write(&buf, Event{Data: []byte("first\nsecond")}))
+	// A checkpoint, moving the last event ID
+	assert.Nil(t, Write(&buf, Event{Id: "2"}))
+	assert.Nil(t, WriteRetry(&buf, 5*time.Second))
+	assert.Nil(t, WriteComment(&buf, "keep-alive"))
+	assert.Equal(t, "id: 1\nevent: message\ndata: {\"content\":\"Hello\"}\n\n"+
+		"data: first\ndata: second\n\n"+
+		"id: 2\n\n"+
+		"retry: 5000\n\n"+
+		": keep-alive\n\n", buf.String())
+}
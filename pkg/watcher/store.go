@@ -0,0 +1,139 @@
+package watcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"handler/function/pkg/scrapper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Store Persists how far the archive of each campaign has been watched
+type Store interface {
+	// Get The end of the archive when the campaign was last checked, nil if it never was
+	Get(campaignId string) (*scrapper.MessageCursor, error)
+	// Save Record the end of the archive of a campaign
+	Save(campaignId string, end *scrapper.MessageCursor) error
+}
+
+// Campaign IDs end up in file names, they must not be able to escape the store directory
+var validCampaignId = regexp.MustCompile(`^[0-9]+$`)
+
+// FileStore Store keeping the position of each campaign as a JSON file in a local directory
+type FileStore struct {
+	dir   string
+	mutex sync.Mutex
+}
+
+// NewFileStore Build a store in the given directory, creating it if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("Unable to create the watch store directory %s : %s", dir, err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (fs *FileStore) Get(campaignId string) (*scrapper.MessageCursor, error) {
+	if !validCampaignId.MatchString(campaignId) {
+		return nil, fmt.Errorf("Invalid campaign id %s", campaignId)
+	}
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	data, err := ioutil.ReadFile(fs.path(campaignId))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the position of campaign %s : %s", campaignId, err)
+	}
+	var end scrapper.MessageCursor
+	if err = json.Unmarshal(data, &end); err != nil {
+		return nil, fmt.Errorf("The position of campaign %s is corrupted : %s", campaignId, err)
+	}
+	return &end, nil
+}
+
+func (fs *FileStore) Save(campaignId string, end *scrapper.MessageCursor) error {
+	if !validCampaignId.MatchString(campaignId) {
+		return fmt.Errorf("Invalid campaign id %s", campaignId)
+	}
+	data, err := json.Marshal(end)
+	if err != nil {
+		return err
+	}
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	// Write then rename, a crash mid-write must not lose the position
+	tmp, err := ioutil.TempFile(fs.dir, campaignId+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("Unable to save the position of campaign %s : %s", campaignId, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Unable to save the position of campaign %s : %s", campaignId, err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("Unable to save the position of campaign %s : %s", campaignId, err)
+	}
+	if err = os.Rename(tmp.Name(), fs.path(campaignId)); err != nil {
+		return fmt.Errorf("Unable to save the position of campaign %s : %s", campaignId, err)
+	}
+	return nil
+}
+
+func (fs *FileStore) path(campaignId string) string {
+	return filepath.Join(fs.dir, campaignId+".json")
+}
+
+// DeadLetter A batch no webhook attempt could deliver
+type DeadLetter struct {
+	// When the last attempt failed
+	FailedAt time.Time `json:"failedAt"`
+	// URL of the webhook, without its credentials
+	Url string `json:"url"`
+	// Number of attempts made
+	Attempts int `json:"attempts"`
+	// Why the last attempt failed
+	Reason string `json:"reason"`
+	// Batch to deliver, as it would have been POSTed
+	Batch *MessageBatch `json:"batch"`
+}
+
+// DeadLetterLog Newline-delimited JSON file the dead letters are appended to, to be replayed by hand
+type DeadLetterLog struct {
+	path  string
+	mutex sync.Mutex
+}
+
+// NewDeadLetterLog Log appending to the file at path, its directory being created if needed
+func NewDeadLetterLog(path string) (*DeadLetterLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("Unable to create the directory of the dead-letter log %s : %s", path, err)
+	}
+	return &DeadLetterLog{path: path}, nil
+}
+
+// Append Write letter at the end of the log
+func (l *DeadLetterLog) Append(letter *DeadLetter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("Unable to open the dead-letter log : %s", err)
+	}
+	// A single write per line, for appends of concurrent processes not to interleave
+	if _, err = file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("Unable to write to the dead-letter log : %s", err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,66 @@
+package watcher
+
+import (
+	"github.com/stretchr/testify/assert"
+	"handler/function/pkg/scrapper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	assert.Nil(t, err)
+
+	end, err := store.Get("1")
+	assert.Nil(t, err)
+	assert.Nil(t, end)
+
+	cursor := &scrapper.MessageCursor{CampaignId: "1", Page: 3, Offset: 42}
+	assert.Nil(t, store.Save("1", cursor))
+	// Survives a restart
+	store, err = NewFileStore(dir)
+	assert.Nil(t, err)
+	end, err = store.Get("1")
+	assert.Nil(t, err)
+	assert.Equal(t, cursor, end)
+
+	_, err = store.Get("../1")
+	assert.Error(t, err)
+	assert.Error(t, store.Save("../1", cursor))
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "2.json"), []byte("{"), 0o600))
+	_, err = store.Get("2")
+	assert.Error(t, err)
+}
+
+// Concurrent appends each end up on their own line
+func TestDeadLetterLog(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "letters", DEFAULT_DEAD_LETTERS_FILE)
+	l, err := NewDeadLetterLog(logPath)
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			batch := &MessageBatch{Id: "1-3-60", GameId: "1", Messages: []scrapper.Message{{Content: strings.Repeat("a", 10000)}}}
+			assert.Nil(t, l.Append(&DeadLetter{FailedAt: time.Now(), Url: "https://example.com/hook", Attempts: 4, Reason: "timeout", Batch: batch}))
+		}()
+	}
+	wg.Wait()
+	letters := readDeadLetters(t, logPath)
+	assert.Len(t, letters, 10)
+	for _, letter := range letters {
+		assert.Equal(t, "1-3-60", letter.Batch.Id)
+	}
+	info, err := os.Stat(logPath)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
@@ -0,0 +1,316 @@
+package watcher
+
+import (
+	"encoding/json"
+	"fmt"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"gopkg.in/yaml.v3"
+	"handler/function/pkg/auth"
+	"handler/function/pkg/callback"
+	"handler/function/pkg/scrapper"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Secret listing the webhooks, as YAML or JSON
+const WEBHOOKS_SECRET = "webhooks"
+
+// Where positions are stored when WATCH_STORE_DIR isn't defined
+const DEFAULT_STORE_DIR = "/tmp/watch"
+
+// Name of the dead-letter log in the store directory, when WATCH_DEAD_LETTERS isn't defined
+const DEFAULT_DEAD_LETTERS_FILE = "dead-letters.ndjson"
+
+// Function the deliveries are made by, as told to the webhooks
+const FUNCTION_NAME = "/watch-messages"
+
+// Webhook A URL the new messages of some campaigns are POSTed to
+type Webhook struct {
+	Url string `yaml:"url"`
+	// IDs of the watched campaigns
+	Campaigns []string `yaml:"campaigns"`
+	// Key the deliveries are signed with. Unsigned if empty
+	Key string `yaml:"key"`
+	// Which messages are delivered. Rolls and chat messages are by default, whispers aren't
+	IncludeWhispers bool  `yaml:"includeWhispers"`
+	IncludeRolls    *bool `yaml:"includeRolls"`
+	IncludeChat     *bool `yaml:"includeChat"`
+	target          *url.URL
+	sender          *callback.Sender
+}
+
+// Which messages of the archive the webhook gets
+func (h *Webhook) options() *scrapper.MessageOptions {
+	options := scrapper.NewMessageOptions()
+	options.IncludeWhispers = h.IncludeWhispers
+	if h.IncludeRolls != nil {
+		options.IncludeRolls = *h.IncludeRolls
+	}
+	if h.IncludeChat != nil {
+		options.IncludeChat = *h.IncludeChat
+	}
+	return options
+}
+
+// swagger:model MessageBatch
+//MessageBatch New messages of a campaign, as POSTed to the webhooks
+type MessageBatch struct {
+	// Stable ID of the batch, the same on every delivery attempt
+	// required: true
+	Id string `json:"id"`
+	// Roll20 ID of the campaign
+	// required: true
+	GameId string `json:"gameId"`
+	// New messages, oldest first
+	// required: true
+	Messages []scrapper.Message `json:"messages"`
+	// When the messages were found
+	// required: true
+	FoundAt time.Time `json:"foundAt"`
+}
+
+// swagger:model CampaignWatch
+//CampaignWatch Outcome of a check of a single campaign
+type CampaignWatch struct {
+	// Roll20 ID of the campaign
+	// required: true
+	GameId string `json:"gameId"`
+	// Whether the campaign was checked for the first time. Only the end of its archive is then recorded
+	// required: true
+	Started bool `json:"started"`
+	// Number of messages posted since the last check
+	// required: true
+	NewMessages int `json:"newMessages"`
+	// Number of webhooks the new messages were delivered to
+	// required: true
+	Delivered int `json:"delivered"`
+	// Number of webhooks which kept failing, the batch being appended to the dead-letter log
+	// required: true
+	DeadLettered int `json:"deadLettered"`
+	// Why the campaign couldn't be checked. It will be checked from the same position next time
+	Error string `json:"error,omitempty"`
+}
+
+// Watcher Checks the archives of the watched campaigns, and POSTs their new messages to the webhooks
+type Watcher struct {
+	webhooks    []*Webhook
+	store       Store
+	deadLetters *DeadLetterLog
+	now         func() time.Time
+}
+
+// LoadWebhooks Read the webhooks secret, or nil if it doesn't exist.
+// Ex :
+//
+//	- url: https://example.com/hooks/roll20
+//	  campaigns: ["1", "2"]
+//	  key: 9d1e...
+//	  includeWhispers: true
+func LoadWebhooks() ([]*Webhook, error) {
+	content, err := ioutil.ReadFile(filepath.Join(auth.SecretsDir(), WEBHOOKS_SECRET))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the %s secret : %s", WEBHOOKS_SECRET, err)
+	}
+	var webhooks []*Webhook
+	if err = yaml.Unmarshal(content, &webhooks); err != nil {
+		return nil, fmt.Errorf("The %s secret is malformed : %s", WEBHOOKS_SECRET, err)
+	}
+	for i, hook := range webhooks {
+		if hook == nil {
+			return nil, fmt.Errorf("Webhook %d is empty in the %s secret", i+1, WEBHOOKS_SECRET)
+		}
+		hook.target, err = url.Parse(hook.Url)
+		if err != nil || (hook.target.Scheme != "http" && hook.target.Scheme != "https") || len(hook.target.Host) == 0 {
+			return nil, fmt.Errorf("Invalid URL for webhook %d in the %s secret. Should be an absolute http or https URL", i+1, WEBHOOKS_SECRET)
+		}
+		for _, campaignId := range hook.Campaigns {
+			if !validCampaignId.MatchString(campaignId) {
+				return nil, fmt.Errorf("Invalid campaign %q for webhook %d in the %s secret", campaignId, i+1, WEBHOOKS_SECRET)
+			}
+		}
+	}
+	return webhooks, nil
+}
+
+// FromEnv Build the watcher from the webhooks secret. Deliveries are retried as callbacks are,
+// see callback.SenderFromEnv. The following optional variables are also read :
+//   - WATCH_STORE_DIR : where the position of each campaign is stored
+//   - WATCH_DEAD_LETTERS : file the deliveries that kept failing are appended to
+func FromEnv() (*Watcher, error) {
+	webhooks, err := LoadWebhooks()
+	if err != nil {
+		return nil, err
+	}
+	for _, hook := range webhooks {
+		if hook.sender, err = callback.SenderFromEnv([]byte(hook.Key)); err != nil {
+			return nil, err
+		}
+	}
+	dir, isSet := os.LookupEnv("WATCH_STORE_DIR")
+	if !isSet {
+		dir = DEFAULT_STORE_DIR
+	}
+	store, err := NewFileStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	deadLettersPath, isSet := os.LookupEnv("WATCH_DEAD_LETTERS")
+	if !isSet {
+		deadLettersPath = filepath.Join(dir, DEFAULT_DEAD_LETTERS_FILE)
+	}
+	deadLetters, err := NewDeadLetterLog(deadLettersPath)
+	if err != nil {
+		return nil, err
+	}
+	return NewWatcher(webhooks, store, deadLetters), nil
+}
+
+// NewWatcher Watcher delivering to webhooks, the positions being kept in store
+func NewWatcher(webhooks []*Webhook, store Store, deadLetters *DeadLetterLog) *Watcher {
+	return &Watcher{webhooks: webhooks, store: store, deadLetters: deadLetters, now: time.Now}
+}
+
+// Campaigns IDs of the campaigns watched by at least a webhook, sorted
+func (w *Watcher) Campaigns() []string {
+	return w.campaigns(func(hook *Webhook) bool { return true })
+}
+
+// WhisperedCampaigns IDs of the campaigns whose whispers are delivered to at least a webhook, sorted
+func (w *Watcher) WhisperedCampaigns() []string {
+	return w.campaigns(func(hook *Webhook) bool { return hook.IncludeWhispers })
+}
+
+// IDs of the campaigns watched by the webhooks matching keep, sorted
+func (w *Watcher) campaigns(keep func(hook *Webhook) bool) []string {
+	seen := make(map[string]bool)
+	campaigns := []string{}
+	for _, hook := range w.webhooks {
+		if !keep(hook) {
+			continue
+		}
+		for _, campaignId := range hook.Campaigns {
+			if !seen[campaignId] {
+				seen[campaignId] = true
+				campaigns = append(campaigns, campaignId)
+			}
+		}
+	}
+	sort.Strings(campaigns)
+	return campaigns
+}
+
+// Check Look for new messages in every watched campaign, and deliver them.
+// The outcomes are in the same order as Campaigns
+func (w *Watcher) Check(s *scrapper.Scrapper) []CampaignWatch {
+	campaigns := w.Campaigns()
+	outcomes := make(map[string]CampaignWatch, len(campaigns))
+	var mutex sync.Mutex
+	s.ForEachCampaign(campaigns, func(campaignId string) {
+		outcome := w.checkCampaign(s, campaignId)
+		mutex.Lock()
+		outcomes[campaignId] = outcome
+		mutex.Unlock()
+	})
+	report := make([]CampaignWatch, 0, len(campaigns))
+	for _, campaignId := range campaigns {
+		report = append(report, outcomes[campaignId])
+	}
+	return report
+}
+
+func (w *Watcher) checkCampaign(s *scrapper.Scrapper, campaignId string) CampaignWatch {
+	outcome := CampaignWatch{GameId: campaignId}
+	since, err := w.store.Get(campaignId)
+	if err != nil {
+		log.Printf("Unexpected error : %s\n", err)
+		outcome.Error = fmt.Sprintf("The position of campaign %s couldn't be read", campaignId)
+		return outcome
+	}
+	// Every message is fetched, each webhook then only gets the ones it asked for
+	all := &scrapper.MessageOptions{IncludeRolls: true, IncludeChat: true, IncludeWhispers: true}
+	messages, end, err := s.GetNewMessages(campaignId, since, all)
+	if err != nil {
+		log.Printf("Unexpected error : %s\n", err)
+		outcome.Error = fmt.Sprintf("Roll20 couldn't be scrapped for game %s", campaignId)
+		return outcome
+	}
+	outcome.Started = since == nil
+	outcome.NewMessages = len(messages)
+	if len(messages) != 0 {
+		foundAt := w.now().UTC()
+		for _, hook := range w.webhooks {
+			if !watches(hook, campaignId) {
+				continue
+			}
+			batch := &MessageBatch{Id: batchId(campaignId, since), GameId: campaignId, Messages: []scrapper.Message{}, FoundAt: foundAt}
+			options := hook.options()
+			for _, m := range messages {
+				if options.Allows(m) {
+					batch.Messages = append(batch.Messages, m)
+				}
+			}
+			if len(batch.Messages) == 0 {
+				continue
+			}
+			if w.deliver(hook, batch) {
+				outcome.Delivered++
+			} else {
+				outcome.DeadLettered++
+			}
+		}
+	}
+	// Dead letters are in the log, they won't be delivered again
+	if err = w.store.Save(campaignId, end); err != nil {
+		log.Printf("Unexpected error : %s\n", err)
+		outcome.Error = fmt.Sprintf("The position of campaign %s couldn't be saved", campaignId)
+	}
+	return outcome
+}
+
+// POST batch to hook, appending it to the dead-letter log if it can't be delivered
+func (w *Watcher) deliver(hook *Webhook, batch *MessageBatch) bool {
+	body, err := json.Marshal(batch)
+	if err == nil {
+		res := handler2.Response{StatusCode: 200, Body: body, Header: map[string][]string{"Content-type": {"application/json"}}}
+		err = hook.sender.Deliver(&callback.Callback{Url: hook.target, CorrelationId: batch.Id, Function: FUNCTION_NAME}, res)
+	}
+	if err == nil {
+		log.Printf("%d messages of campaign %s delivered to %s\n", len(batch.Messages), batch.GameId, hook.target.Redacted())
+		return true
+	}
+	log.Printf("Delivery of batch %s to %s failed : %s\n", batch.Id, hook.target.Redacted(), err)
+	letter := &DeadLetter{FailedAt: w.now().UTC(), Url: hook.target.Redacted(), Reason: err.Error(), Batch: batch}
+	if de, ok := err.(*callback.DeliveryError); ok {
+		letter.Attempts, letter.Reason = de.Attempts, de.Reason
+	}
+	if err = w.deadLetters.Append(letter); err != nil {
+		// The batch is lost, at least keep it in the function logs
+		log.Printf("Batch %s couldn't be dead-lettered : %s. Batch : %s\n", batch.Id, err, body)
+	}
+	return false
+}
+
+// Whether hook watches the campaign
+func watches(hook *Webhook, campaignId string) bool {
+	for _, watched := range hook.Campaigns {
+		if watched == campaignId {
+			return true
+		}
+	}
+	return false
+}
+
+// The ID of the batch starting at since. A batch always starts where the previous one ended,
+// so receivers can tell a redelivered batch by its ID
+func batchId(campaignId string, since *scrapper.MessageCursor) string {
+	return fmt.Sprintf("%s-%d-%d", campaignId, since.Page, since.Offset)
+}
@@ -0,0 +1,213 @@
+package watcher
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"handler/function/pkg/auth"
+	"handler/function/pkg/scrapper"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Setup a Roll20 server answering with the sample chat archive for every page
+func SetupArchiveServer() *httptest.Server {
+	_, filename, _, _ := runtime.Caller(0)
+	sampleData, _ := ioutil.ReadFile(path.Join(path.Dir(filename), "./../../assets/sample_campaign_chat_archive.html"))
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/campaigns/chatarchive/") {
+			w.Write(sampleData)
+		} else {
+			w.WriteHeader(200)
+		}
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	return mockServer
+}
+
+// A webhook receiver, recording the deliveries and answering with status
+type receiver struct {
+	server  *httptest.Server
+	mutex   sync.Mutex
+	headers []http.Header
+	bodies  [][]byte
+}
+
+func setupReceiver(status int) *receiver {
+	rc := &receiver{}
+	rc.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		rc.mutex.Lock()
+		rc.headers = append(rc.headers, r.Header)
+		rc.bodies = append(rc.bodies, body)
+		rc.mutex.Unlock()
+		w.WriteHeader(status)
+	}))
+	return rc
+}
+
+// Mount the webhooks secret, and store positions in a temporary directory
+func setupWatcher(t *testing.T, webhooks string) *Watcher {
+	dir := t.TempDir()
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, WEBHOOKS_SECRET), []byte(webhooks), 0o600))
+	t.Setenv("AUTH_SECRETS_DIR", dir)
+	t.Setenv("WATCH_STORE_DIR", filepath.Join(dir, "watch"))
+	t.Setenv("CALLBACK_RETRIES", "1")
+	t.Setenv("CALLBACK_BACKOFF", "1ms")
+	w, err := FromEnv()
+	assert.Nil(t, err)
+	return w
+}
+
+func TestCheck(t *testing.T) {
+	mockServer := SetupArchiveServer()
+	defer mockServer.Close()
+	ok := setupReceiver(http.StatusNoContent)
+	defer ok.server.Close()
+	failing := setupReceiver(http.StatusBadGateway)
+	defer failing.server.Close()
+	w := setupWatcher(t, `
+- url: `+ok.server.URL+`/hooks/roll20
+  campaigns: ["1"]
+  key: s3cr3t
+- url: `+failing.server.URL+`
+  campaigns: ["1", "2"]
+  includeWhispers: true
+  includeRolls: false
+`)
+	assert.Equal(t, []string{"1", "2"}, w.Campaigns())
+	s, err := scrapper.NewScrapper(os.Getenv("ROLL20_BASE_URL"), &scrapper.Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+
+	// The first check only records where the archives end
+	report := w.Check(s)
+	assert.Equal(t, []CampaignWatch{{GameId: "1", Started: true}, {GameId: "2", Started: true}}, report)
+	assert.Empty(t, ok.bodies)
+	end, err := w.store.Get("1")
+	assert.Nil(t, err)
+	assert.Equal(t, &scrapper.MessageCursor{CampaignId: "1", Page: 3, Offset: 70}, end)
+
+	// Ten messages posted since
+	since := &scrapper.MessageCursor{CampaignId: "1", Page: 3, Offset: 60}
+	assert.Nil(t, w.store.Save("1", since))
+	report = w.Check(s)
+	assert.Equal(t, []CampaignWatch{{GameId: "1", NewMessages: 10, Delivered: 1, DeadLettered: 1}, {GameId: "2"}}, report)
+
+	assert.Len(t, ok.bodies, 1)
+	var batch MessageBatch
+	assert.Nil(t, json.Unmarshal(ok.bodies[0], &batch))
+	assert.Equal(t, "1-3-60", batch.Id)
+	assert.Equal(t, "1", batch.GameId)
+	expected, err := s.GetMessagesPage("1", since, 100, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, expected.Messages, batch.Messages)
+	header := ok.headers[0]
+	assert.Equal(t, "1-3-60", header.Get("X-Correlation-Id"))
+	signature := auth.Sign([]byte("s3cr3t"), http.MethodPost, "/hooks/roll20", "", header.Get(auth.TimestampHeader), ok.bodies[0])
+	assert.Equal(t, "sha256="+hex.EncodeToString(signature), header.Get(auth.SignatureHeader))
+
+	// The failing webhook has been retried, then dead-lettered with its own selection of messages
+	assert.Len(t, failing.bodies, 2)
+	assert.Empty(t, failing.headers[0].Get(auth.SignatureHeader))
+	letters := readDeadLetters(t, filepath.Join(os.Getenv("WATCH_STORE_DIR"), DEFAULT_DEAD_LETTERS_FILE))
+	assert.Len(t, letters, 1)
+	assert.Equal(t, failing.server.URL, letters[0].Url)
+	assert.Equal(t, 2, letters[0].Attempts)
+	assert.Equal(t, "the receiver answered 502", letters[0].Reason)
+	assert.Equal(t, "1-3-60", letters[0].Batch.Id)
+	for _, m := range letters[0].Batch.Messages {
+		assert.NotEqual(t, scrapper.Roll, m.Type)
+		assert.NotEqual(t, scrapper.InlineRoll, m.Type)
+	}
+
+	// Nothing new since
+	report = w.Check(s)
+	assert.Equal(t, []CampaignWatch{{GameId: "1"}, {GameId: "2"}}, report)
+	assert.Len(t, ok.bodies, 1)
+	assert.Len(t, failing.bodies, 2)
+}
+
+// A campaign which can't be scrapped is checked from the same position next time
+// Only the campaigns some webhooks get the whispers of
+func TestWhisperedCampaigns(t *testing.T) {
+	w := setupWatcher(t, `
+- url: https://example.com/a
+  campaigns: ["1", "2"]
+- url: https://example.com/b
+  campaigns: ["3", "2"]
+  includeWhispers: true
+`)
+	assert.Equal(t, []string{"1", "2", "3"}, w.Campaigns())
+	assert.Equal(t, []string{"2", "3"}, w.WhisperedCampaigns())
+}
+
+func TestCheckScrappingError(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/campaigns/chatarchive/") {
+			w.WriteHeader(500)
+		}
+	}))
+	defer mockServer.Close()
+	w := setupWatcher(t, `[{"url": "https://example.com/hook", "campaigns": ["1"]}]`)
+	since := &scrapper.MessageCursor{CampaignId: "1", Page: 2, Offset: 10}
+	assert.Nil(t, w.store.Save("1", since))
+	s, err := scrapper.NewScrapper(mockServer.URL, &scrapper.Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+
+	report := w.Check(s)
+	assert.Equal(t, []CampaignWatch{{GameId: "1", Error: "Roll20 couldn't be scrapped for game 1"}}, report)
+	end, err := w.store.Get("1")
+	assert.Nil(t, err)
+	assert.Equal(t, since, end)
+}
+
+func TestLoadWebhooks(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AUTH_SECRETS_DIR", dir)
+	webhooks, err := LoadWebhooks()
+	assert.Nil(t, err)
+	assert.Nil(t, webhooks)
+
+	for _, invalid := range []string{
+		"url: https://example.com",
+		"- url: example.com/hook\n  campaigns: [\"1\"]",
+		"- url: https://example.com/hook\n  campaigns: [\"../1\"]",
+		"- ",
+	} {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, WEBHOOKS_SECRET), []byte(invalid), 0o600))
+		_, err = LoadWebhooks()
+		assert.Error(t, err, invalid)
+	}
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, WEBHOOKS_SECRET), []byte(`[{"url": "https://example.com/hook", "campaigns": ["1"], "includeChat": false}]`), 0o600))
+	webhooks, err = LoadWebhooks()
+	assert.Nil(t, err)
+	assert.Len(t, webhooks, 1)
+	assert.Equal(t, &scrapper.MessageOptions{IncludeRolls: true, IncludeChat: false, IncludeWhispers: false}, webhooks[0].options())
+}
+
+func readDeadLetters(t *testing.T, path string) []DeadLetter {
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	var letters []DeadLetter
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var letter DeadLetter
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &letter))
+		letters = append(letters, letter)
+	}
+	return letters
+}
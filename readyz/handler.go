@@ -0,0 +1,58 @@
+package function
+
+import (
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"handler/function/pkg/health"
+	http_helpers "handler/function/pkg/http-helpers"
+	"log"
+	"net/http"
+)
+
+// Path of the function, used as the instance of its errors
+const FUNCTION_PATH = "/readyz"
+
+// swagger:route GET /readyz Health readyz
+//
+// Tell whether the function can serve requests.
+//
+// Meant for readiness probes, so it isn't authenticated. Checks that the environment variables are valid,
+// that the bot account can log in to Roll20, and that the details page of the HEALTH_CANARY_CAMPAIGN campaign still
+// has everything the scrapper depends on. The Roll20 checks are cached for HEALTH_CACHE_TTL, failed ones included
+//     Produces:
+//     - application/json
+//     - application/x-ndjson
+//     - text/csv
+//     - application/yaml
+//     - application/problem+json
+//     Parameters:
+//       + name: format
+//         in: query
+//         description: Format of the response, overriding the Accept header. One of "json", "ndjson", "csv" or "yaml". Default is "json"
+//         required: false
+//         type: string
+// responses:
+//  200: Readiness Every check passed or was skipped
+//  400: ErrorTemplate Invalid format provided
+//  406: ErrorTemplate None of the accepted media types is supported
+//  503: Readiness Some checks failed
+func Handle(req handler2.Request) (handler2.Response, error) {
+	var err error
+	var query http_helpers.FormatQuery
+	if err = http_helpers.BindQuery(req.QueryString, &query); err != nil {
+		log.Printf("Invalid QS : %s\n", err)
+		return http_helpers.NewBindingProblem(req, FUNCTION_PATH, err), err
+	}
+	format, ok := http_helpers.NegotiateFormat(req, query.Format)
+	if !ok {
+		return http_helpers.NewNotAcceptableProblem(req, FUNCTION_PATH), nil
+	}
+	report := health.Ready()
+	status := http.StatusOK
+	if report.Status == health.Fail {
+		log.Println("Readiness checks failed")
+		status = http.StatusServiceUnavailable
+	}
+	res, err := http_helpers.NewDataResponse(status, format, report)
+	res.Header["Cache-Control"] = []string{"no-store"}
+	return res, err
+}
@@ -0,0 +1,88 @@
+package function
+
+import (
+	"encoding/json"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"github.com/stretchr/testify/assert"
+	"handler/function/pkg/health"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Mock Roll20, answering the campaign details with the given sample
+func SetupTestServer(t *testing.T, campaignDataPath string) {
+	_, filename, _, _ := runtime.Caller(0)
+	sampleData, _ := ioutil.ReadFile(path.Join(path.Dir(filename), "..", campaignDataPath))
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/campaigns/details/") {
+			w.Write(sampleData)
+		} else {
+			w.WriteHeader(200)
+		}
+	}))
+	t.Cleanup(mockServer.Close)
+	t.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	t.Setenv("ROLL20_USERNAME", "mock")
+	t.Setenv("ROLL20_PASSWORD", "mock")
+}
+
+func TestReadyz(t *testing.T) {
+	SetupTestServer(t, "assets/sample_campaign_page.html")
+	t.Setenv("HEALTH_CANARY_CAMPAIGN", "5632681")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var report health.Report
+	assert.Nil(t, json.Unmarshal(res.Body, &report))
+	assert.Equal(t, health.Pass, report.Status)
+	assert.Len(t, report.Checks, 3)
+
+	// Row-oriented formats list the checks
+	req.QueryString = "format=csv"
+	res, err = Handle(req)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(res.Body), "name,status,detail,checkedAt\n"), string(res.Body))
+}
+
+func TestReadyzFailing(t *testing.T) {
+	SetupTestServer(t, "assets/sample_user_profile.html")
+	t.Setenv("HEALTH_CANARY_CAMPAIGN", "5632681")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	var report health.Report
+	assert.Nil(t, json.Unmarshal(res.Body, &report))
+	assert.Equal(t, health.Fail, report.Status)
+}
+
+func TestReadyzInvalidFormat(t *testing.T) {
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "format=xml",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
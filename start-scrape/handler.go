@@ -0,0 +1,168 @@
+package function
+
+import (
+	"fmt"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"handler/function/pkg/auth"
+	config_parser "handler/function/pkg/config-parser"
+	http_helpers "handler/function/pkg/http-helpers"
+	"handler/function/pkg/jobs"
+	link_parser "handler/function/pkg/link-parser"
+	"handler/function/pkg/scrapper"
+	"log"
+	"net/http"
+)
+
+// Query parameters of start-scrape. Defaults are the ones of scrapper.NewMessageOptions
+type scrapeQuery struct {
+	link_parser.GameQuery
+	http_helpers.FormatQuery
+	Limit           *uint `qs:"limit"`
+	IncludeWhispers bool  `qs:"includeWhispers" default:"false"`
+	IncludeRolls    bool  `qs:"includeRolls" default:"true"`
+	IncludeChat     bool  `qs:"includeChat" default:"true"`
+}
+
+// Path of the function, used as the instance of its errors
+const FUNCTION_PATH = "/start-scrape"
+
+// swagger:route POST /start-scrape Jobs start-scrape
+//
+// Start retrieving all messages of a roll20 game in the background
+//
+// Large chat archives take longer to scrap than the gateway timeout. The job is answered right away,
+// its progress can then be followed with job-status, and the messages retrieved with job-result
+//     Produces:
+//     - application/json
+//     - application/x-ndjson
+//     - text/csv
+//     - application/yaml
+//     - application/problem+json
+//     Parameters:
+//       + name: gameId
+//         in: query
+//         description: Roll20 ID of the game to parse. For link https://app.roll20.net/join/1/59lzQg --> Game ID is "1"
+//         required: false
+//         type: integer
+//         format: int32
+//       + name: link
+//         in: query
+//         description: Roll20 link of the game, accepted instead of gameId. Either a join link (https://app.roll20.net/join/1/59lzQg), a campaign details or editor URL, or a bare game ID
+//         required: false
+//         type: string
+//       + name: limit
+//         in: query
+//         description: Max number of messages to parse. Default is all available
+//         required: false
+//         type: integer
+//         format: uint
+//       + name: includeWhispers
+//         in: query
+//         description: Include whispers in messages. Default is false
+//         required: false
+//         type: boolean
+//       + name: includeRolls
+//         in: query
+//         description: Include rolls in messages. Default is true
+//         required: false
+//         type: boolean
+//       + name: includeChat
+//         in: query
+//         description: Include general chat messages. Default is true
+//         required: false
+//         type: boolean
+//       + name: format
+//         in: query
+//         description: Format of the response, overriding the Accept header. One of "json", "ndjson", "csv" or "yaml". Default is "json"
+//         required: false
+//         type: string
+// responses:
+//  202: Job The job has been started. Its status is linked in the Location header
+//	400: ErrorTemplate Missing or invalid QS provided
+//  401: ErrorTemplate Authentication is enabled, and the caller didn't provide valid credentials
+//  403: ErrorTemplate The caller isn't allowed to read the messages of this game, or its whispers
+//  406: ErrorTemplate None of the accepted media types is supported
+//  500: ErrorTemplate Configuration error, either env variables missing, provided roll20 credentials invalid or the job store unavailable
+func Handle(req handler2.Request) (handler2.Response, error) {
+	log.Println("Start scrape handler has been woken up")
+	var err error
+	// Callers are authenticated before anything else
+	identity, err := auth.Authenticate(req, FUNCTION_PATH)
+	if err != nil {
+		log.Printf("Unauthenticated call : %s\n", err)
+		return auth.NewAuthProblem(req, FUNCTION_PATH, err), err
+	}
+	// Retrieve runtime values from env & QS
+	var keys = []string{"ROLL20_USERNAME", "ROLL20_PASSWORD", "ROLL20_BASE_URL"}
+	values, err := config_parser.ParseEnv(keys)
+	if err != nil {
+		log.Printf("Invalid env : %s\n", err)
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.MissingConfiguration, "Some environment variables are missing"), err
+	}
+	var query scrapeQuery
+	if err = http_helpers.BindQuery(req.QueryString, &query); err != nil {
+		log.Printf("Invalid QS : %s\n", err)
+		return http_helpers.NewBindingProblem(req, FUNCTION_PATH, err), err
+	}
+	format, ok := http_helpers.NegotiateFormat(req, query.Format)
+	if !ok {
+		return http_helpers.NewNotAcceptableProblem(req, FUNCTION_PATH), nil
+	}
+	gameId := query.Game().GameId
+	if err = auth.Authorize(identity, auth.Messages, gameId); err != nil {
+		log.Printf("Forbidden call : %s\n", err)
+		return auth.NewAuthProblem(req, FUNCTION_PATH, err), err
+	}
+	// Whispers are private, only trusted callers can read them
+	if query.IncludeWhispers {
+		if err = auth.Authorize(identity, auth.Whispers, gameId); err != nil {
+			log.Printf("Forbidden call : %s\n", err)
+			return auth.NewAuthProblem(req, FUNCTION_PATH, err), err
+		}
+	}
+	store, err := jobs.FromEnv()
+	if err != nil {
+		log.Printf("Invalid job store : %s\n", err)
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.MissingConfiguration, "The job store is misconfigured"), err
+	}
+	// This is an optional argument, default is UINT_MAX
+	limit := ^uint(0)
+	if query.Limit != nil {
+		limit = *query.Limit
+	}
+	opt := &scrapper.MessageOptions{IncludeRolls: query.IncludeRolls, IncludeChat: query.IncludeChat, IncludeWhispers: query.IncludeWhispers}
+
+	// Logging in is quick, a failure is better told right away than through the job
+	s, err := scrapper.NewScrapper(values["ROLL20_BASE_URL"], &scrapper.Roll20Account{Login: values["ROLL20_USERNAME"], Password: values["ROLL20_PASSWORD"]}, nil)
+	if err != nil {
+		log.Printf("The scrapper instance couldn't be initialized. Error %s\n", err)
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.Roll20LoginFailed, "The bot account couldn't log in to Roll20"), err
+	}
+	owner := ""
+	if identity != nil {
+		owner = identity.Name
+	}
+	job, err := jobs.NewJob(jobs.MessagesJob, gameId, owner)
+	if err != nil {
+		log.Printf("Unexpected error : %s\n", err)
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.InternalError, "The job couldn't be created"), err
+	}
+	err = jobs.Start(store, job, func(progress func(pagesDone int, pagesTotal int)) (interface{}, *http_helpers.ErrorTemplate) {
+		opt.OnPage = progress
+		messages, err := s.GetMessages(gameId, limit, opt)
+		if err != nil {
+			log.Printf("Unexpected error : %s\n", err.Error())
+			return nil, http_helpers.Problem(FUNCTION_PATH, http.StatusInternalServerError, http_helpers.ScrappingFailed, fmt.Sprintf("Roll20 couldn't be scrapped for game %s", gameId))
+		}
+		log.Printf("%d messages have been scrapped from campaign %s\n", len(*messages), gameId)
+		return messages, nil
+	})
+	if err != nil {
+		log.Printf("The job couldn't be saved : %s\n", err)
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.StorageFailed, "The job couldn't be saved"), err
+	}
+	log.Printf("Job %s started for campaign %s\n", job.Id, gameId)
+	res, err := http_helpers.NewDataResponse(http.StatusAccepted, format, job)
+	res.Header["Location"] = []string{"/job-status?jobId=" + job.Id}
+	return res, err
+}
@@ -0,0 +1,145 @@
+package function
+
+import (
+	"encoding/json"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"github.com/stretchr/testify/assert"
+	"handler/function/pkg/jobs"
+	"handler/function/pkg/scrapper"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func SetupTestServer(campaignDataPath string) *httptest.Server {
+	_, filename, _, _ := runtime.Caller(0)
+	dir := path.Dir(filename)
+	var sample *os.File
+	// Open provided path
+	sample, err := os.Open(path.Join(dir, campaignDataPath))
+	// On CI, the path may be wrong because the import path is different
+	if err != nil {
+		sample, err = os.Open(path.Join(dir, "../", campaignDataPath))
+	}
+	sampleData, _ := ioutil.ReadAll(sample)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/campaigns/chatarchive/") {
+			w.Write(sampleData)
+		} else {
+			w.WriteHeader(200)
+		}
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	return mockServer
+}
+
+// Store jobs in a temporary directory
+func SetupJobStore(t *testing.T) jobs.Store {
+	t.Setenv("JOB_STORE", "file")
+	t.Setenv("JOB_STORE_DIR", t.TempDir())
+	store, err := jobs.FromEnv()
+	assert.NoError(t, err)
+	return store
+}
+
+func TestStartScrape(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+	defer mockServer.Close()
+	store := SetupJobStore(t)
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "POST",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+	var job jobs.Job
+	assert.NoError(t, json.Unmarshal(res.Body, &job))
+	assert.True(t, jobs.IsValidId(job.Id))
+	assert.Equal(t, "1", job.GameId)
+	assert.Equal(t, jobs.MessagesJob, job.Kind)
+	assert.Equal(t, []string{"/job-status?jobId=" + job.Id}, res.Header["Location"])
+
+	// The job runs in the background, until all the pages have been scrapped
+	assert.Eventually(t, func() bool {
+		found, err := jobs.Find(store, job.Id, "")
+		return err == nil && found != nil && found.Status == jobs.Succeeded
+	}, 5*time.Second, 10*time.Millisecond)
+	found, _ := jobs.Find(store, job.Id, "")
+	assert.NotNil(t, found.FinishedAt)
+	assert.Equal(t, found.Progress.PagesTotal, found.Progress.PagesDone)
+	result, err := store.GetResult(job.Id)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, result)
+}
+
+// The filters are the ones of get-messages
+func TestStartScrapeFilters(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+	defer mockServer.Close()
+	store := SetupJobStore(t)
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1&includeChat=false&includeRolls=false",
+		Method:      "POST",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.NoError(t, err)
+	var job jobs.Job
+	assert.NoError(t, json.Unmarshal(res.Body, &job))
+	assert.Eventually(t, func() bool {
+		found, err := jobs.Find(store, job.Id, "")
+		return err == nil && found != nil && found.Status == jobs.Succeeded
+	}, 5*time.Second, 10*time.Millisecond)
+	result, err := store.GetResult(job.Id)
+	assert.NoError(t, err)
+	var messages []scrapper.Message
+	assert.NoError(t, json.Unmarshal(result, &messages))
+	assert.Empty(t, messages)
+}
+
+//No game provided argument
+func TestStartScrapeMissingGameID(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+	defer mockServer.Close()
+	SetupJobStore(t)
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "",
+		Method:      "POST",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestStartScrapeInvalidStore(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+	defer mockServer.Close()
+	t.Setenv("JOB_STORE", "redis")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "POST",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+}
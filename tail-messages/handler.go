@@ -0,0 +1,293 @@
+package function
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"handler/function/pkg/auth"
+	config_parser "handler/function/pkg/config-parser"
+	http_helpers "handler/function/pkg/http-helpers"
+	link_parser "handler/function/pkg/link-parser"
+	"handler/function/pkg/scrapper"
+	"handler/function/pkg/sse"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Query parameters of tail-messages. Filters are the ones of get-messages
+type tailQuery struct {
+	link_parser.GameQuery
+	IncludeWhispers bool   `qs:"includeWhispers" default:"false"`
+	IncludeRolls    bool   `qs:"includeRolls" default:"true"`
+	IncludeChat     bool   `qs:"includeChat" default:"true"`
+	LastEventId     string `qs:"lastEventId"`
+}
+
+// Interval between two polls of the archive when TAIL_POLL_INTERVAL isn't defined
+const DEFAULT_POLL_INTERVAL = 5 * time.Second
+
+// Path of the function, used as the instance of its errors
+const FUNCTION_PATH = "/tail-messages"
+
+// A client following the chat of a game
+type tail struct {
+	s        *scrapper.Scrapper
+	gameId   string
+	options  *scrapper.MessageOptions
+	interval time.Duration
+	// Position the client is at, nil until the end of the archive has been located for a new client
+	cursor *scrapper.MessageCursor
+}
+
+// Write the messages posted since the last poll as events, returning how many events were written.
+// Each event ID is the position following its message, so that a reconnecting client resumes right after it.
+// Filtered out messages move the position too, which is then sent as an event without data
+func (t *tail) poll(w io.Writer) (int, error) {
+	written := 0
+	var writeErr error
+	end, err := t.s.ForEachNewMessage(t.gameId, t.cursor, t.options, func(m scrapper.Message, next scrapper.MessageCursor) {
+		if writeErr != nil {
+			return
+		}
+		data, _ := json.Marshal(m)
+		if writeErr = sse.Write(w, sse.Event{Id: next.Encode(), Type: "message", Data: data}); writeErr == nil {
+			written++
+			t.cursor = &next
+		}
+	})
+	if writeErr != nil {
+		return written, writeErr
+	}
+	if err != nil {
+		return written, err
+	}
+	if t.cursor == nil || *t.cursor != *end {
+		if err = sse.Write(w, sse.Event{Id: end.Encode()}); err != nil {
+			return written, err
+		}
+		written++
+		t.cursor = end
+	}
+	return written, nil
+}
+
+// swagger:route GET /tail-messages Players tail-messages
+//
+// Follow the chat of a roll20 game as Server-Sent Events.
+//
+// Each new message is pushed as a "message" event whose data is a Message, and whose ID is the position following it.
+// A new client only gets the messages posted after it connected. A reconnecting EventSource sends the ID of the
+// last event it got in the Last-Event-ID header, and resumes right after it.
+// Behind the OpenFaaS gateway, a single poll of the archive is answered, the retry field telling the client when
+// to reconnect. The standalone server keeps the connection open, polling until the client disconnects
+//     Produces:
+//     - text/event-stream
+//     - application/problem+json
+//     Parameters:
+//       + name: gameId
+//         in: query
+//         description: Roll20 ID of the game to follow. For link https://app.roll20.net/join/1/59lzQg --> Game ID is "1"
+//         required: false
+//         type: integer
+//         format: int32
+//       + name: link
+//         in: query
+//         description: Roll20 link of the game, accepted instead of gameId. Either a join link (https://app.roll20.net/join/1/59lzQg), a campaign details or editor URL, or a bare game ID
+//         required: false
+//         type: string
+//       + name: includeWhispers
+//         in: query
+//         description: Include whispers in messages. Default is false
+//         required: false
+//         type: boolean
+//       + name: includeRolls
+//         in: query
+//         description: Include rolls in messages. Default is true
+//         required: false
+//         type: boolean
+//       + name: includeChat
+//         in: query
+//         description: Include general chat messages. Default is true
+//         required: false
+//         type: boolean
+//       + name: lastEventId
+//         in: query
+//         description: ID of the last event received, for clients which can't set the Last-Event-ID header. The header takes precedence
+//         required: false
+//         type: string
+//       + name: Last-Event-ID
+//         in: header
+//         description: ID of the last event received, set by EventSource when reconnecting
+//         required: false
+//         type: string
+// responses:
+//  200: description: Stream of message events. Events without data only move the position the client resumes from
+//	400: ErrorTemplate Missing or invalid QS or Last-Event-ID provided
+//  401: ErrorTemplate Authentication is enabled, and the caller didn't provide valid credentials
+//  403: ErrorTemplate The caller isn't allowed to read the messages of this game, or its whispers
+//  500: ErrorTemplate Configuration error, either env variables missing or provided roll20 credentials invalid
+func Handle(req handler2.Request) (handler2.Response, error) {
+	log.Println("Tail messages handler has been woken up")
+	t, res, err := prepare(req)
+	if t == nil {
+		return res, err
+	}
+	var body bytes.Buffer
+	sse.WriteRetry(&body, t.interval)
+	if _, err = t.poll(&body); err != nil {
+		log.Printf("Unexpected error : %s\n", err)
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.ScrappingFailed, fmt.Sprintf("Roll20 couldn't be scrapped for game %s", t.gameId)), err
+	}
+	return handler2.Response{StatusCode: http.StatusOK, Body: body.Bytes(), Header: streamHeader()}, nil
+}
+
+// Stream Follow the chat as Handle does, but over a single connection polling the archive until the client disconnects.
+// Only usable where responses can be flushed as they are written, such as by the standalone server
+func Stream(w http.ResponseWriter, r *http.Request) {
+	log.Println("Tail messages stream has been opened")
+	req := handler2.Request{Header: r.Header, QueryString: r.URL.RawQuery, Method: r.Method, Host: r.Host}
+	t, res, err := prepare(req)
+	if t == nil {
+		log.Printf("%s %s : %s\n", r.Method, r.URL.Path, err)
+		for key, values := range res.Header {
+			w.Header()[http.CanonicalHeaderKey(key)] = values
+		}
+		w.WriteHeader(res.StatusCode)
+		w.Write(res.Body)
+		return
+	}
+	for key, values := range streamHeader() {
+		w.Header()[http.CanonicalHeaderKey(key)] = values
+	}
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	sse.WriteRetry(w, t.interval)
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		written, err := t.poll(w)
+		if err != nil {
+			log.Printf("Unexpected error : %s\n", err)
+			// The status is already sent, the client is told through an event instead
+			problem := http_helpers.Problem(FUNCTION_PATH, http.StatusInternalServerError, http_helpers.ScrappingFailed, fmt.Sprintf("Roll20 couldn't be scrapped for game %s", t.gameId))
+			problem.RequestId = http_helpers.RequestId(req)
+			data, _ := json.Marshal(problem)
+			sse.Write(w, sse.Event{Type: "problem", Data: data})
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return
+		}
+		if written == 0 {
+			sse.WriteComment(w, "keep-alive")
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case <-r.Context().Done():
+			log.Printf("Tail of campaign %s closed\n", t.gameId)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Headers of an event stream, which must reach the client as soon as it is written
+func streamHeader() map[string][]string {
+	return map[string][]string{
+		"Content-type":      {sse.ContentType},
+		"Cache-Control":     {"no-cache"},
+		"X-Accel-Buffering": {"no"},
+	}
+}
+
+// Authenticate and authorize req, then log in to Roll20. On failure, the tail is nil and the error response is returned
+func prepare(req handler2.Request) (*tail, handler2.Response, error) {
+	var err error
+	// Callers are authenticated before anything else
+	identity, err := auth.Authenticate(req, FUNCTION_PATH)
+	if err != nil {
+		log.Printf("Unauthenticated call : %s\n", err)
+		return nil, auth.NewAuthProblem(req, FUNCTION_PATH, err), err
+	}
+	// Retrieve runtime values from env & QS
+	var keys = []string{"ROLL20_USERNAME", "ROLL20_PASSWORD", "ROLL20_BASE_URL"}
+	values, err := config_parser.ParseEnv(keys)
+	if err != nil {
+		log.Printf("Invalid env : %s\n", err)
+		return nil, http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.MissingConfiguration, "Some environment variables are missing"), err
+	}
+	interval, err := pollInterval()
+	if err != nil {
+		log.Printf("Invalid env : %s\n", err)
+		return nil, http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.MissingConfiguration, err.Error()), err
+	}
+	var query tailQuery
+	if err = http_helpers.BindQuery(req.QueryString, &query); err != nil {
+		log.Printf("Invalid QS : %s\n", err)
+		return nil, http_helpers.NewBindingProblem(req, FUNCTION_PATH, err), err
+	}
+	gameId := query.Game().GameId
+	cursor, err := resumeFrom(req, &query)
+	if err != nil {
+		log.Printf("Invalid last event ID : %s\n", err)
+		return nil, http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusBadRequest, http_helpers.InvalidQuery, err.Error()), err
+	}
+	if err = auth.Authorize(identity, auth.Messages, gameId); err != nil {
+		log.Printf("Forbidden call : %s\n", err)
+		return nil, auth.NewAuthProblem(req, FUNCTION_PATH, err), err
+	}
+	// Whispers are private, only trusted callers can read them
+	if query.IncludeWhispers {
+		if err = auth.Authorize(identity, auth.Whispers, gameId); err != nil {
+			log.Printf("Forbidden call : %s\n", err)
+			return nil, auth.NewAuthProblem(req, FUNCTION_PATH, err), err
+		}
+	}
+	opt := &scrapper.MessageOptions{IncludeRolls: query.IncludeRolls, IncludeChat: query.IncludeChat, IncludeWhispers: query.IncludeWhispers}
+
+	s, err := scrapper.NewScrapper(values["ROLL20_BASE_URL"], &scrapper.Roll20Account{Login: values["ROLL20_USERNAME"], Password: values["ROLL20_PASSWORD"]}, nil)
+	if err != nil {
+		log.Printf("The scrapper instance couldn't be initialized. Error %s\n", err)
+		return nil, http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.Roll20LoginFailed, "The bot account couldn't log in to Roll20"), err
+	}
+	log.Printf("Now following messages of campaign %s\n", gameId)
+	return &tail{s: s, gameId: gameId, options: opt, interval: interval, cursor: cursor}, handler2.Response{}, nil
+}
+
+// The position the client resumes from, nil for a new client. The Last-Event-ID header takes precedence over the QS
+func resumeFrom(req handler2.Request, query *tailQuery) (*scrapper.MessageCursor, error) {
+	lastEventId := req.Header.Get(sse.LastEventIdHeader)
+	if len(lastEventId) == 0 {
+		lastEventId = query.LastEventId
+	}
+	if len(lastEventId) == 0 {
+		return nil, nil
+	}
+	cursor, err := scrapper.DecodeMessageCursor(lastEventId)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid last event ID : %s", err)
+	}
+	if cursor.CampaignId != query.Game().GameId || cursor.Before {
+		return nil, fmt.Errorf("The last event ID wasn't issued for this game")
+	}
+	return cursor, nil
+}
+
+// The interval between two polls, read from TAIL_POLL_INTERVAL
+func pollInterval() (time.Duration, error) {
+	value, isSet := os.LookupEnv("TAIL_POLL_INTERVAL")
+	if !isSet {
+		return DEFAULT_POLL_INTERVAL, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("Invalid TAIL_POLL_INTERVAL %q. Should be a duration such as 500ms or 10s", value)
+	}
+	return interval, nil
+}
@@ -0,0 +1,222 @@
+package function
+
+import (
+	"bufio"
+	"encoding/json"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"github.com/stretchr/testify/assert"
+	http_helpers "handler/function/pkg/http-helpers"
+	"handler/function/pkg/scrapper"
+	"handler/function/pkg/sse"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func SetupTestServer(campaignDataPath string) *httptest.Server {
+	_, filename, _, _ := runtime.Caller(0)
+	dir := path.Dir(filename)
+	// Open provided path
+	sampleData, err := ioutil.ReadFile(path.Join(dir, campaignDataPath))
+	// On CI, the path may be wrong because the import path is different
+	if err != nil {
+		sampleData, _ = ioutil.ReadFile(path.Join(dir, "../", campaignDataPath))
+	}
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/campaigns/chatarchive/") {
+			w.Write(sampleData)
+		} else {
+			w.WriteHeader(200)
+		}
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	return mockServer
+}
+
+// Split a stream into its events, comments and retry fields included
+func parseEvents(stream string) []map[string]string {
+	var events []map[string]string
+	for _, block := range strings.Split(stream, "\n\n") {
+		if len(block) == 0 {
+			continue
+		}
+		event := make(map[string]string)
+		for _, line := range strings.Split(block, "\n") {
+			field := strings.SplitN(line, ": ", 2)
+			event[field[0]] = field[1]
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// The messages expected after since, with the default options
+func expectedMessages(t *testing.T, since *scrapper.MessageCursor) []scrapper.Message {
+	s, err := scrapper.NewScrapper(os.Getenv("ROLL20_BASE_URL"), &scrapper.Roll20Account{Login: "_", Password: "_"}, nil)
+	assert.Nil(t, err)
+	page, err := s.GetMessagesPage("1", since, 100, nil)
+	assert.Nil(t, err)
+	return page.Messages
+}
+
+func TestTail(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+	defer mockServer.Close()
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "GET",
+		Host:        "",
+	}
+	// A new client only gets the position of the end of the archive
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, sse.ContentType, res.Header["Content-type"][0])
+	end := &scrapper.MessageCursor{CampaignId: "1", Page: 3, Offset: 70}
+	assert.Equal(t, "retry: 5000\n\nid: "+end.Encode()+"\n\n", string(res.Body))
+
+	// A reconnecting one resumes from the last event it got
+	since := &scrapper.MessageCursor{CampaignId: "1", Page: 3, Offset: 60}
+	req.Header = http.Header{}
+	req.Header.Set(sse.LastEventIdHeader, since.Encode())
+	res, err = Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	events := parseEvents(string(res.Body))
+	var messages []scrapper.Message
+	for _, event := range events[1:] {
+		if event["event"] != "message" {
+			continue
+		}
+		var m scrapper.Message
+		assert.Nil(t, json.Unmarshal([]byte(event["data"]), &m))
+		messages = append(messages, m)
+		// Resuming from any event gives the messages following it
+		assert.NotEmpty(t, event["id"])
+	}
+	assert.NotEmpty(t, messages)
+	assert.Equal(t, expectedMessages(t, since), messages)
+	// The stream always ends at the end of the archive
+	assert.Equal(t, end.Encode(), events[len(events)-1]["id"])
+
+	// Same with the QS, for clients which can't set headers
+	req.Header = nil
+	req.QueryString = "gameId=1&lastEventId=" + since.Encode()
+	res, err = Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, parseEvents(string(res.Body)), events)
+
+	// Filters are the ones of get-messages
+	req.QueryString = "gameId=1&includeChat=false&includeRolls=false&lastEventId=" + since.Encode()
+	res, err = Handle(req)
+	assert.Nil(t, err)
+	for _, event := range parseEvents(string(res.Body)) {
+		assert.NotEqual(t, "message", event["event"])
+	}
+}
+
+func TestTailInvalidLastEventId(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+	defer mockServer.Close()
+	other := &scrapper.MessageCursor{CampaignId: "2", Page: 3, Offset: 60}
+	for _, lastEventId := range []string{"garbage", other.Encode()} {
+		header := http.Header{}
+		header.Set(sse.LastEventIdHeader, lastEventId)
+		req := handler2.Request{
+			Body:        nil,
+			Header:      header,
+			QueryString: "gameId=1",
+			Method:      "GET",
+			Host:        "",
+		}
+		res, err := Handle(req)
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	}
+}
+
+func TestTailMissingGame(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+	defer mockServer.Close()
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestTailInvalidInterval(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+	defer mockServer.Close()
+	t.Setenv("TAIL_POLL_INTERVAL", "often")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "gameId=1",
+		Method:      "GET",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+}
+
+// The connection is kept open, polling until the client leaves
+func TestStream(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+	defer mockServer.Close()
+	t.Setenv("TAIL_POLL_INTERVAL", "10ms")
+	server := httptest.NewServer(http.HandlerFunc(Stream))
+	defer server.Close()
+
+	since := &scrapper.MessageCursor{CampaignId: "1", Page: 3, Offset: 60}
+	req, _ := http.NewRequest("GET", server.URL+"?gameId=1", nil)
+	req.Header.Set(sse.LastEventIdHeader, since.Encode())
+	res, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, sse.ContentType, res.Header.Get("Content-Type"))
+
+	// Read until the archive has been polled again without anything new
+	var stream strings.Builder
+	reader := bufio.NewReader(res.Body)
+	for !strings.Contains(stream.String(), ": keep-alive\n\n") {
+		line, err := reader.ReadString('\n')
+		assert.Nil(t, err)
+		if err != nil {
+			break
+		}
+		stream.WriteString(line)
+	}
+	var messages []scrapper.Message
+	for _, event := range parseEvents(stream.String()) {
+		if event["event"] == "message" {
+			var m scrapper.Message
+			assert.Nil(t, json.Unmarshal([]byte(event["data"]), &m))
+			messages = append(messages, m)
+		}
+	}
+	assert.Equal(t, expectedMessages(t, since), messages)
+
+	// Errors are answered before the stream starts
+	res, err = http.Get(server.URL + "?gameId=sss")
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Equal(t, http_helpers.ProblemContentType, res.Header.Get("Content-Type"))
+}
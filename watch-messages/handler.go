@@ -0,0 +1,104 @@
+package function
+
+import (
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"handler/function/pkg/auth"
+	config_parser "handler/function/pkg/config-parser"
+	http_helpers "handler/function/pkg/http-helpers"
+	"handler/function/pkg/scrapper"
+	"handler/function/pkg/watcher"
+	"log"
+	"net/http"
+)
+
+// Path of the function, used as the instance of its errors
+const FUNCTION_PATH = watcher.FUNCTION_NAME
+
+// swagger:route POST /watch-messages Webhooks watch-messages
+//
+// Check the watched campaigns for new messages, and POST them to the webhooks
+//
+// Meant to be invoked on a schedule, such as by the OpenFaaS cron-connector. Webhooks and their campaigns are listed
+// in the webhooks secret. The first check of a campaign only records where its archive ends,
+// each following one delivers the messages posted since as a MessageBatch
+//     Produces:
+//     - application/json
+//     - application/x-ndjson
+//     - text/csv
+//     - application/yaml
+//     - application/problem+json
+//     Parameters:
+//       + name: format
+//         in: query
+//         description: Format of the response, overriding the Accept header. One of "json", "ndjson", "csv" or "yaml". Default is "json"
+//         required: false
+//         type: string
+// responses:
+//  200: []CampaignWatch Every watched campaign has been checked
+//  207: []CampaignWatch Some campaigns couldn't be checked. They will be checked from the same position next time
+//  400: ErrorTemplate Invalid format provided
+//  401: ErrorTemplate Authentication is enabled, and the caller didn't provide valid credentials
+//  403: ErrorTemplate The caller isn't allowed to read the messages of every watched campaign, or the whispers some webhooks get
+//  406: ErrorTemplate None of the accepted media types is supported
+//  500: ErrorTemplate Configuration error, either env variables missing, the webhooks secret invalid or provided roll20 credentials invalid
+func Handle(req handler2.Request) (handler2.Response, error) {
+	log.Println("Watch messages handler has been woken up")
+	// Callers are authenticated before anything else
+	identity, err := auth.Authenticate(req, FUNCTION_PATH)
+	if err != nil {
+		log.Printf("Unauthenticated call : %s\n", err)
+		return auth.NewAuthProblem(req, FUNCTION_PATH, err), err
+	}
+	// Retrieve runtime values from env
+	var keys = []string{"ROLL20_USERNAME", "ROLL20_PASSWORD", "ROLL20_BASE_URL"}
+	values, err := config_parser.ParseEnv(keys)
+	if err != nil {
+		log.Printf("Invalid env : %s\n", err)
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.MissingConfiguration, "Some environment variables are missing"), err
+	}
+	var query http_helpers.FormatQuery
+	if err = http_helpers.BindQuery(req.QueryString, &query); err != nil {
+		log.Printf("Invalid QS : %s\n", err)
+		return http_helpers.NewBindingProblem(req, FUNCTION_PATH, err), err
+	}
+	format, ok := http_helpers.NegotiateFormat(req, query.Format)
+	if !ok {
+		return http_helpers.NewNotAcceptableProblem(req, FUNCTION_PATH), nil
+	}
+	w, err := watcher.FromEnv()
+	if err != nil {
+		log.Printf("Invalid watcher configuration : %s\n", err)
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.MissingConfiguration, "The webhooks or their store are misconfigured"), err
+	}
+	// Checking scraps every watched campaign for every webhook, the caller must be able to read them all
+	if err = auth.Authorize(identity, auth.Messages, w.Campaigns()...); err != nil {
+		log.Printf("Forbidden call : %s\n", err)
+		return auth.NewAuthProblem(req, FUNCTION_PATH, err), err
+	}
+	if whispered := w.WhisperedCampaigns(); len(whispered) != 0 {
+		if err = auth.Authorize(identity, auth.Whispers, whispered...); err != nil {
+			log.Printf("Forbidden call : %s\n", err)
+			return auth.NewAuthProblem(req, FUNCTION_PATH, err), err
+		}
+	}
+	report := []watcher.CampaignWatch{}
+	if len(w.Campaigns()) == 0 {
+		log.Println("No campaign is watched")
+		return http_helpers.NewDataResponse(http.StatusOK, format, report)
+	}
+
+	s, err := scrapper.NewScrapper(values["ROLL20_BASE_URL"], &scrapper.Roll20Account{Login: values["ROLL20_USERNAME"], Password: values["ROLL20_PASSWORD"]}, nil)
+	if err != nil {
+		log.Printf("The scrapper instance couldn't be initialized. Error %s\n", err)
+		return http_helpers.NewProblem(req, FUNCTION_PATH, http.StatusInternalServerError, http_helpers.Roll20LoginFailed, "The bot account couldn't log in to Roll20"), err
+	}
+	statusCode := http.StatusOK
+	report = w.Check(s)
+	for _, outcome := range report {
+		if len(outcome.Error) != 0 {
+			statusCode = http.StatusMultiStatus
+		}
+	}
+	log.Printf("%d watched campaigns have been checked\n", len(report))
+	return http_helpers.NewDataResponse(statusCode, format, report)
+}
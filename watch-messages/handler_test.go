@@ -0,0 +1,167 @@
+package function
+
+import (
+	"encoding/json"
+	handler2 "github.com/openfaas/templates-sdk/go-http"
+	"github.com/stretchr/testify/assert"
+	"handler/function/pkg/scrapper"
+	"handler/function/pkg/watcher"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func SetupTestServer(campaignDataPath string) *httptest.Server {
+	_, filename, _, _ := runtime.Caller(0)
+	dir := path.Dir(filename)
+	// Open provided path
+	sampleData, err := ioutil.ReadFile(path.Join(dir, campaignDataPath))
+	// On CI, the path may be wrong because the import path is different
+	if err != nil {
+		sampleData, _ = ioutil.ReadFile(path.Join(dir, "../", campaignDataPath))
+	}
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/campaigns/chatarchive/") {
+			w.Write(sampleData)
+		} else {
+			w.WriteHeader(200)
+		}
+	}))
+	os.Setenv("ROLL20_BASE_URL", mockServer.URL)
+	os.Setenv("ROLL20_USERNAME", "mock")
+	os.Setenv("ROLL20_PASSWORD", "mock")
+	return mockServer
+}
+
+// Mount the webhooks secret, and store positions in a temporary directory
+func SetupWebhooks(t *testing.T, webhooks string) {
+	dir := t.TempDir()
+	if len(webhooks) != 0 {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, watcher.WEBHOOKS_SECRET), []byte(webhooks), 0o600))
+	}
+	t.Setenv("AUTH_SECRETS_DIR", dir)
+	t.Setenv("WATCH_STORE_DIR", filepath.Join(dir, "watch"))
+}
+
+func TestWatch(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+	defer mockServer.Close()
+	var batches []watcher.MessageBatch
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch watcher.MessageBatch
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&batch))
+		batches = append(batches, batch)
+	}))
+	defer receiver.Close()
+	SetupWebhooks(t, `[{"url": "`+receiver.URL+`", "campaigns": ["1"]}]`)
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "",
+		Method:      "POST",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var report []watcher.CampaignWatch
+	assert.Nil(t, json.Unmarshal(res.Body, &report))
+	assert.Equal(t, []watcher.CampaignWatch{{GameId: "1", Started: true}}, report)
+
+	// Rewind the position, as if messages had been posted since
+	store, err := watcher.NewFileStore(os.Getenv("WATCH_STORE_DIR"))
+	assert.Nil(t, err)
+	assert.Nil(t, store.Save("1", &scrapper.MessageCursor{CampaignId: "1", Page: 3, Offset: 65}))
+	res, err = Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Nil(t, json.Unmarshal(res.Body, &report))
+	assert.Equal(t, []watcher.CampaignWatch{{GameId: "1", NewMessages: 5, Delivered: 1}}, report)
+	assert.Len(t, batches, 1)
+	assert.Equal(t, "1-3-65", batches[0].Id)
+}
+
+// Without webhooks, there is nothing to check
+func TestWatchNothing(t *testing.T) {
+	SetupWebhooks(t, "")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "format=csv",
+		Method:      "POST",
+		Host:        "",
+	}
+	// Roll20 isn't even reached
+	t.Setenv("ROLL20_BASE_URL", "http://localhost:1")
+	t.Setenv("ROLL20_USERNAME", "mock")
+	t.Setenv("ROLL20_PASSWORD", "mock")
+	res, err := Handle(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+// Callers must be able to read every watched campaign, and the whispers delivered
+func TestAccessControl(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+	defer mockServer.Close()
+	delivered := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered++
+	}))
+	defer receiver.Close()
+	SetupWebhooks(t, `[{"url": "`+receiver.URL+`", "campaigns": ["1"]}, {"url": "`+receiver.URL+`", "campaigns": ["2"], "includeWhispers": true}]`)
+	dir := os.Getenv("AUTH_SECRETS_DIR")
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "api-keys"), []byte("nobody:key-n\nteam-a:key-a\nreader:key-r\ntrusted:key-t\n"), 0o600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "acl"), []byte(`{
+		"nobody": {"campaigns": ["*"], "capabilities": []},
+		"team-a": {"campaigns": ["1"], "capabilities": ["messages", "whispers"]},
+		"reader": {"campaigns": ["*"], "capabilities": ["messages"]},
+		"trusted": {"campaigns": ["1", "2"], "capabilities": ["messages", "whispers"]}
+	}`), 0o600))
+
+	var tests = []struct {
+		key    string
+		status int
+	}{
+		{"key-n", http.StatusForbidden},
+		// Campaign 2 is watched too
+		{"key-a", http.StatusForbidden},
+		// A webhook gets the whispers of campaign 2
+		{"key-r", http.StatusForbidden},
+		{"key-t", http.StatusOK},
+	}
+	store, err := watcher.NewFileStore(os.Getenv("WATCH_STORE_DIR"))
+	assert.Nil(t, err)
+	for _, tt := range tests {
+		req := handler2.Request{Header: http.Header{"X-Api-Key": {tt.key}}, Method: "POST"}
+		res, _ := Handle(req)
+		assert.Equal(t, tt.status, res.StatusCode, tt.key)
+		// Refused calls don't check anything
+		position, err := store.Get("1")
+		assert.Nil(t, err)
+		assert.Equal(t, tt.status == http.StatusOK, position != nil, tt.key)
+	}
+	assert.Equal(t, 0, delivered)
+}
+
+func TestWatchInvalidWebhooks(t *testing.T) {
+	mockServer := SetupTestServer("assets/sample_campaign_chat_archive.html")
+	defer mockServer.Close()
+	SetupWebhooks(t, "- url: ftp://example.com")
+	req := handler2.Request{
+		Body:        nil,
+		Header:      nil,
+		QueryString: "",
+		Method:      "POST",
+		Host:        "",
+	}
+	res, err := Handle(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+}